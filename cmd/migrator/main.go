@@ -1,50 +1,284 @@
 package main
 
 import (
+	"bufio"
 	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"xauth/internal/storage/migrator"
+	"xauth/migrations"
+)
 
-	// Migrations library
-	"github.com/golang-migrate/migrate/v4"
-	// Driver to complete SQLite 3 migrations
-	_ "github.com/golang-migrate/migrate/v4/database/sqlite3"
-	// Driver for getting migrations from files
-	_ "github.com/golang-migrate/migrate/v4/source/file"
+const (
+	exitOK    = 0
+	exitError = 1
+	exitUsage = 2
 )
 
+const usage = `usage: migrator [flags] <command> [argument]
+
+commands:
+  up [N]     apply all pending migrations, or the next N (default command)
+  down N     roll back the last N migrations
+  goto V     apply or roll back migrations until version V is the last one
+  version    print the current version
+  force V    record version V as applied and clear the dirty flag without
+             running anything (0: no version)
+  status     list migrations and whether they are applied
+
+flags:
+`
+
 func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
 	var storagePath, migrationsPath, migrationsTable string
+	var dryRun, yes bool
+
+	flags := flag.NewFlagSet("migrator", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprint(stderr, usage)
+		flags.PrintDefaults()
+	}
+
+	flags.StringVar(&storagePath, "storage-path", "", "path to storage")
+	flags.StringVar(&migrationsPath, "migrations-path", "", "path to migrations (default: the embedded ones)")
+	flags.StringVar(&migrationsTable, "migrations-table", "migrations", "name of migrations table")
+	flags.BoolVar(&dryRun, "dry-run", false, "print the migrations that would run and exit")
+	flags.BoolVar(&yes, "yes", false, "don't ask before rolling back migrations")
+
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
 
-	flag.StringVar(&storagePath, "storage-path", "", "path to storage")
-	flag.StringVar(&migrationsPath, "migrations-path", "", "path to migrations")
-	flag.StringVar(&migrationsTable, "migrations-table", "migrations", "name of migrations table")
-	flag.Parse()
+		return exitUsage
+	}
 
 	if storagePath == "" {
-		panic("storage-path is required")
+		return usageError(flags, "storage-path is required")
+	}
+
+	command, arg := "up", ""
+	switch flags.NArg() {
+	case 0:
+	case 1:
+		command = flags.Arg(0)
+	case 2:
+		command, arg = flags.Arg(0), flags.Arg(1)
+	default:
+		return usageError(flags, "too many arguments")
 	}
+
+	var m *migrator.Migrator
+	var err error
 	if migrationsPath == "" {
-		panic("migrations-path is required")
+		m, err = migrator.NewFS(migrations.FS, storagePath, migrationsTable, printer{stdout})
+	} else {
+		m, err = migrator.New("file://"+migrationsPath, storagePath,
+			migrationsTable, printer{stdout})
+	}
+	if err != nil {
+		return fail(stderr, err)
 	}
+	defer m.Close()
+
+	var steps []migrator.Step
+
+	switch command {
+	case "up":
+		n := 0
+		if arg != "" {
+			var convErr error
+			if n, convErr = strconv.Atoi(arg); convErr != nil || n < 1 {
+				return usageError(flags, "up takes a positive number of migrations")
+			}
+		}
+
+		steps, err = m.PlanUp(n)
+	case "down":
+		n, convErr := strconv.Atoi(arg)
+		if convErr != nil || n < 1 {
+			return usageError(flags, "down takes a positive number of migrations")
+		}
 
-	m, err := migrate.New(
-		"file://"+migrationsPath,
-		fmt.Sprintf("sqlite3://%s?x-migrations-table=%s", storagePath, migrationsTable),
-	)
+		steps, err = m.PlanDown(n)
+	case "goto":
+		v, convErr := strconv.ParseUint(arg, 10, 0)
+		if convErr != nil {
+			return usageError(flags, "goto takes a migration version")
+		}
+
+		steps, err = m.PlanGoto(uint(v))
+	case "force":
+		v, convErr := strconv.ParseUint(arg, 10, 0)
+		if convErr != nil {
+			return usageError(flags, "force takes a migration version")
+		}
+
+		return force(m, uint(v), dryRun, stdout, stderr)
+	case "version":
+		return version(m, stdout, stderr)
+	case "status":
+		return status(m, stdout, stderr)
+	default:
+		return usageError(flags, fmt.Sprintf("unknown command %q", command))
+	}
 	if err != nil {
-		panic(err)
+		return fail(stderr, err)
+	}
+
+	if len(steps) == 0 {
+		fmt.Fprintln(stdout, "no migrations to apply")
+
+		return exitOK
+	}
+
+	if dryRun {
+		fmt.Fprintln(stdout, "would run:")
+		printSteps(stdout, steps)
+
+		return exitOK
 	}
 
-	if err := m.Up(); err != nil {
-		if errors.Is(err, migrate.ErrNoChange) {
-			fmt.Println("no migrations to apply")
+	if steps[0].Down && !yes {
+		fmt.Fprintln(stderr, "this will roll back, possibly dropping data:")
+		printSteps(stderr, steps)
 
-			return
+		if !confirm(stdin, stderr) {
+			fmt.Fprintln(stderr, "aborted")
+
+			return exitError
 		}
+	}
+
+	if err := m.Apply(steps); err != nil {
+		return fail(stderr, err)
+	}
+
+	if steps[0].Down {
+		fmt.Fprintln(stdout, "migrations rolled back successfully")
+	} else {
+		fmt.Fprintln(stdout, "migrations applied successfully")
+	}
+
+	return exitOK
+}
 
-		panic(err)
+func version(m *migrator.Migrator, stdout, stderr io.Writer) int {
+	v, dirty, err := m.Version()
+	if err != nil {
+		return fail(stderr, err)
 	}
 
-	fmt.Println("migrations applied successfully")
+	switch {
+	case v == 0:
+		fmt.Fprintln(stdout, "no migrations applied")
+	case dirty:
+		fmt.Fprintf(stdout, "%d (dirty)\n", v)
+	default:
+		fmt.Fprintln(stdout, v)
+	}
+
+	return exitOK
+}
+
+func status(m *migrator.Migrator, stdout, stderr io.Writer) int {
+	st, err := m.Status()
+	if err != nil {
+		return fail(stderr, err)
+	}
+
+	for _, mig := range st.Migrations {
+		state := "pending"
+		switch {
+		case mig.Version == st.Version && st.Dirty:
+			state = "dirty"
+		case mig.Applied:
+			state = "applied"
+		}
+
+		fmt.Fprintf(stdout, "%6d  %-8s %s\n", mig.Version, state, mig.Name)
+	}
+
+	if st.Version > m.Latest() {
+		fmt.Fprintf(stdout, "database is at version %d, which is not in the migrations directory\n",
+			st.Version)
+	}
+	if st.Dirty {
+		fmt.Fprintf(stdout, "database is dirty: fix migration %d by hand, then run force\n",
+			st.Version)
+	}
+
+	return exitOK
+}
+
+func force(m *migrator.Migrator, v uint, dryRun bool, stdout, stderr io.Writer) int {
+	if dryRun {
+		fmt.Fprintf(stdout, "would set the version to %d\n", v)
+
+		return exitOK
+	}
+
+	if err := m.Force(v); err != nil {
+		return fail(stderr, err)
+	}
+
+	fmt.Fprintf(stdout, "version set to %d\n", v)
+
+	return exitOK
+}
+
+func printSteps(w io.Writer, steps []migrator.Step) {
+	for _, s := range steps {
+		direction := "up"
+		if s.Down {
+			direction = "down"
+		}
+
+		fmt.Fprintf(w, "%6d  %-4s %s\n", s.Version, direction, s.Name)
+	}
+}
+
+// confirm asks the user to type "yes". Without a terminal, such as in CI,
+// stdin is usually empty and the answer is no; pass -yes there.
+func confirm(stdin io.Reader, stderr io.Writer) bool {
+	fmt.Fprint(stderr, `type "yes" to continue: `)
+
+	answer, _ := bufio.NewReader(stdin).ReadString('\n')
+
+	return strings.TrimSpace(answer) == "yes"
+}
+
+func usageError(flags *flag.FlagSet, msg string) int {
+	fmt.Fprintf(flags.Output(), "migrator: %s\n\n", msg)
+	flags.Usage()
+
+	return exitUsage
+}
+
+func fail(stderr io.Writer, err error) int {
+	fmt.Fprintf(stderr, "migrator: %v\n", err)
+
+	if errors.Is(err, migrator.ErrDirty) {
+		fmt.Fprintln(stderr, "fix the failed migration by hand, then run force with its version")
+	}
+
+	return exitError
+}
+
+// printer prints every migration as it runs.
+type printer struct {
+	w io.Writer
+}
+
+func (p printer) Printf(format string, v ...any) {
+	fmt.Fprintf(p.w, format, v...)
 }
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"log/slog"
 	"os"
 	"os/signal"
@@ -8,6 +9,8 @@ import (
 	"xauth/internal/app"
 	"xauth/internal/config"
 	"xauth/internal/lib/logger/handlers/slogpretty"
+	"xauth/internal/lib/logger/sl"
+	"xauth/internal/lib/tracing"
 )
 
 const (
@@ -19,50 +22,139 @@ const (
 func main() {
 	cfg := config.MustLoad()
 
-	log := setupLogger(cfg.Env)
+	// The level is a variable so it can be changed on reload.
+	level := new(slog.LevelVar)
+	level.Set(cfg.Level())
+
+	log := setupLogger(cfg.Env, level)
 
 	log.Info("starting application", slog.Any("config", cfg)) // TODO: delete slog.Any("config", cfg)
 
-	application := app.New(log, cfg.GRPC.Port, cfg.StoragePath, cfg.TokenTTL)
+	shutdownTracing := tracing.MustNew(context.Background(), cfg.Tracing)
+
+	application := app.New(log, cfg)
 
 	go application.GRPCSrv.MustRun()
 
-	// Graceful shutdown
+	if application.HTTPSrv != nil {
+		go application.HTTPSrv.MustRun()
+	}
+
+	if application.MetricsSrv != nil {
+		go application.MetricsSrv.MustRun()
+	}
+
+	// Graceful shutdown; SIGHUP reloads the config instead.
 	stop := make(chan os.Signal, 1)
-	signal.Notify(stop, syscall.SIGTERM, syscall.SIGINT)
+	signal.Notify(stop, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
+
+	started, running := cfg, cfg
+
+	var sig os.Signal
+	for sig = range stop {
+		if sig != syscall.SIGHUP {
+			break
+		}
 
-	signal := <-stop
+		running = reload(log, level, application, started, running)
+	}
+
+	log.Info("received signal", slog.String("signal", sig.String()))
 
-	log.Info("received signal", slog.String("signal", signal.String()))
+	if application.HTTPSrv != nil {
+		application.HTTPSrv.Stop()
+	}
 
 	application.GRPCSrv.Stop()
 
+	if application.MetricsSrv != nil {
+		application.MetricsSrv.Stop()
+	}
+
+	if err := application.Close(); err != nil {
+		log.Error("failed to close storage", sl.Err(err))
+	}
+
+	if err := shutdownTracing(context.Background()); err != nil {
+		log.Error("failed to shutdown tracing", sl.Err(err))
+	}
+
 	log.Info("application stopped")
 }
 
-func setupLogger(env string) *slog.Logger {
+// reload re-reads the config file and applies the settings that can change
+// without a restart, returning the new config. Changes to other settings
+// since the start are logged. If the file or the TLS certificates are
+// invalid, nothing changes.
+func reload(
+	log *slog.Logger,
+	level *slog.LevelVar,
+	application *app.App,
+	started *config.Config,
+	running *config.Config,
+) *config.Config {
+	log.Info("reloading config")
+
+	cfg, err := config.Load(config.Path())
+	if err != nil {
+		log.Error("failed to reload config, keeping the current one", sl.Err(err))
+
+		return running
+	}
+
+	var applied, pending []string
+	for _, path := range config.Diff(running, cfg) {
+		if config.Reloadable(path) {
+			applied = append(applied, path)
+		}
+	}
+	for _, path := range config.Diff(started, cfg) {
+		if !config.Reloadable(path) {
+			pending = append(pending, path)
+		}
+	}
+
+	if len(pending) > 0 {
+		log.Warn("changed settings require a restart", slog.Any("settings", pending))
+	}
+
+	if err := application.Reload(cfg); err != nil {
+		log.Error("failed to reload certificates, keeping the current config",
+			sl.Err(err))
+
+		return running
+	}
+
+	level.Set(cfg.Level())
+
+	log.Info("config reloaded", slog.Any("applied", applied))
+
+	return cfg
+}
+
+func setupLogger(env string, level slog.Leveler) *slog.Logger {
 	var log *slog.Logger
 
 	switch env {
 	case envLocal:
-		log = setupPrettySlog()
+		log = setupPrettySlog(level)
 	case envDev:
 		log = slog.New(
-			slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug}),
+			slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: level}),
 		)
 	case envProd:
 		log = slog.New(
-			slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelInfo}),
+			slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: level}),
 		)
 	}
 
 	return log
 }
 
-func setupPrettySlog() *slog.Logger {
+func setupPrettySlog(level slog.Leveler) *slog.Logger {
 	opts := slogpretty.PrettyHandlerOptions{
 		SlogOpts: &slog.HandlerOptions{
-			Level: slog.LevelDebug,
+			Level: level,
 		},
 	}
 
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	accountgrpc "xauth/internal/grpc/account"
+	admingrpc "xauth/internal/grpc/admin"
+)
+
+// usersExport writes the user's data export, which is always JSON, to
+// stdout or to a file only the current user can read.
+func usersExport(ctx context.Context, c *command, args []string) error {
+	var out string
+	c.flags.StringVar(&out, "out", "", "file to write the export to (default: stdout)")
+
+	userID, err := c.parseUserID(args)
+	if err != nil {
+		return err
+	}
+
+	resp, err := call(ctx, c, func(ctx context.Context) (*accountgrpc.ExportMyDataResponse, error) {
+		return c.api.ExportMyData(ctx, &accountgrpc.ExportMyDataRequest{UserID: userID})
+	})
+	if err != nil {
+		return err
+	}
+
+	w := c.out.w
+	if out != "" {
+		f, err := os.OpenFile(out, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		w = f
+	}
+
+	if err := writeJSON(w, resp); err != nil {
+		return err
+	}
+
+	if out != "" {
+		fmt.Fprintf(c.stderr, "exported user %d to %s\n", userID, out)
+	}
+
+	return nil
+}
+
+// usersErase erases the user after showing who it is and asking for
+// confirmation, unless -yes is given.
+func usersErase(ctx context.Context, c *command, args []string) error {
+	var yes bool
+	c.flags.BoolVar(&yes, "yes", false, "don't ask before erasing the user")
+
+	userID, err := c.parseUserID(args)
+	if err != nil {
+		return err
+	}
+
+	if !yes {
+		resp, err := call(ctx, c, func(ctx context.Context) (*admingrpc.GetUserResponse, error) {
+			return c.api.GetUser(ctx, &admingrpc.GetUserRequest{UserID: userID})
+		})
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(c.stderr, "this will erase user %d (%s, %s); it can't be undone\n",
+			userID, resp.User.Email, resp.User.Username)
+
+		if !confirm(c.stdin, c.stderr) {
+			return errors.New("aborted")
+		}
+	}
+
+	_, err = call(ctx, c, func(ctx context.Context) (*accountgrpc.EraseAccountResponse, error) {
+		return c.api.EraseAccount(ctx, &accountgrpc.EraseAccountRequest{UserID: userID})
+	})
+	if err != nil {
+		return err
+	}
+
+	const msg = "user erased"
+
+	return c.out.print(map[string]any{"user_id": userID, "result": msg},
+		nil, [][]string{{msg}})
+}
+
+// parseUserID parses the command's flags and its user ID argument.
+func (c *command) parseUserID(args []string) (int64, error) {
+	arg, err := c.parse(args, "user ID")
+	if err != nil {
+		return 0, err
+	}
+
+	userID, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil || userID < 1 {
+		return 0, usageErr("user ID must be a positive number")
+	}
+
+	return userID, nil
+}
+
+func writeJSON(w io.Writer, v any) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(v)
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	admingrpc "xauth/internal/grpc/admin"
+	"xauth/internal/lib/archive"
+	"xauth/internal/services/backup"
+	"xauth/internal/storage/sqlite"
+)
+
+// dbBackup has the server back up its database as its backup config says.
+// With -offline, the database is backed up as the flags say instead.
+func dbBackup(ctx context.Context, c *command, args []string) error {
+	var dir, keyFile string
+	var compress bool
+	var keep int
+	c.flags.StringVar(&dir, "dir", "./storage/backups", "directory to write the backup to")
+	c.flags.BoolVar(&compress, "gzip", false, "compress the backup")
+	c.flags.StringVar(&keyFile, "key-file", "", "file with the key to encrypt the backup with")
+	c.flags.IntVar(&keep, "keep", 0, "delete the oldest backups beyond this many (0 keeps all)")
+
+	if _, err := c.parse(args, ""); err != nil {
+		return err
+	}
+
+	if !c.opts.offline {
+		var set []string
+		c.flags.Visit(func(f *flag.Flag) { set = append(set, "-"+f.Name) })
+		if len(set) > 0 {
+			return usageErr("flags " + strings.Join(set, ", ") + " need -offline; " +
+				"the server backs up as its backup config says")
+		}
+
+		resp, err := call(ctx, c, func(ctx context.Context) (*admingrpc.CreateBackupResponse, error) {
+			return c.api.CreateBackup(ctx, &admingrpc.CreateBackupRequest{})
+		})
+		if err != nil {
+			return err
+		}
+
+		return c.printBackup(resp.Backup)
+	}
+
+	if keep < 0 {
+		return usageErr("-keep must not be negative")
+	}
+
+	key, err := readKey(keyFile)
+	if err != nil {
+		return err
+	}
+
+	storage, err := sqlite.New(c.opts.storagePath,
+		sqlite.Options{BusyTimeout: offlineBusyTimeout})
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	b, err := backup.New(log, storage, dir, compress, key, keep).Create(ctx)
+	if err != nil {
+		return err
+	}
+
+	return c.printBackup(admingrpc.Backup{Path: b.Path, Size: b.Size, CreatedAt: b.CreatedAt})
+}
+
+func (c *command) printBackup(b admingrpc.Backup) error {
+	return c.out.print(b, []string{"PATH", "SIZE", "CREATED"}, [][]string{{
+		b.Path, strconv.FormatInt(b.Size, 10), b.CreatedAt.Local().Format(time.DateTime),
+	}})
+}
+
+// dbRestore replaces the database at -storage-path with a backup, after
+// asking for confirmation unless -yes is given. It runs on the database
+// directly, and the server must be stopped meanwhile.
+func dbRestore(ctx context.Context, c *command, args []string) error {
+	var keyFile, migrationsTable string
+	var yes bool
+	c.flags.StringVar(&keyFile, "key-file", "", "file with the key the backup is encrypted with")
+	c.flags.StringVar(&migrationsTable, "migrations-table", "migrations",
+		"table the schema version is recorded in")
+	c.flags.BoolVar(&yes, "yes", false, "don't ask before replacing the database")
+
+	path, err := c.parse(args, "backup file")
+	if err != nil {
+		return err
+	}
+	if c.opts.storagePath == "" {
+		return usageErr("db restore requires -storage-path")
+	}
+
+	key, err := readKey(keyFile)
+	if err != nil {
+		return err
+	}
+
+	if !yes {
+		fmt.Fprintf(c.stderr, "this will replace the database at %s with %s; "+
+			"stop the server first\n", c.opts.storagePath, path)
+
+		if !confirm(c.stdin, c.stderr) {
+			return errors.New("aborted")
+		}
+	}
+
+	restored, err := backup.Restore(ctx, path, c.opts.storagePath, key, migrationsTable)
+	if err != nil {
+		return err
+	}
+
+	if restored.Previous != "" {
+		fmt.Fprintf(c.stderr, "the replaced database was moved to %s\n", restored.Previous)
+	}
+	if restored.SchemaVersion < restored.Latest {
+		fmt.Fprintf(c.stderr, "the backup's schema is at version %d, the latest is %d; "+
+			"run the migrator or set auto_migrate\n", restored.SchemaVersion, restored.Latest)
+	}
+
+	const msg = "database restored"
+
+	return c.out.print(map[string]any{
+		"result":         msg,
+		"schema_version": restored.SchemaVersion,
+		"previous":       restored.Previous,
+	}, nil, [][]string{{msg}})
+}
+
+// readKey reads a backup encryption key from path, if it isn't empty.
+func readKey(path string) ([]byte, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := archive.ParseKey(strings.TrimSpace(string(b)))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return key, nil
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	admingrpc "xauth/internal/grpc/admin"
+	"xauth/internal/services/admin"
+)
+
+// maxLineLen limits JSONL lines, which hold one user each.
+const maxLineLen = 1 << 20
+
+// importRecord is a user read from the import file.
+type importRecord struct {
+	line int
+	user admingrpc.ImportUser
+}
+
+// importReport is what usersImport prints.
+type importReport struct {
+	Imported int            `json:"imported"`
+	Existing []importResult `json:"existing,omitempty"`
+	Rejected []importResult `json:"rejected,omitempty"`
+}
+
+type importResult struct {
+	Line   int    `json:"line"`
+	Email  string `json:"email"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// usersImport imports users with their password hashes from a CSV file
+// with a header row, or from JSON lines, in batches. Users that already
+// exist are skipped, so a failed import can be run again.
+func usersImport(ctx context.Context, c *command, args []string) error {
+	var format, hashFormat string
+	var batch int
+	var dryRun bool
+	c.flags.StringVar(&format, "format", "", "csv or jsonl (default: from the file extension)")
+	c.flags.StringVar(&hashFormat, "hash-format", "",
+		"format of bare digests with a salt column, e.g. alg=ssha256,enc=hex")
+	c.flags.IntVar(&batch, "batch", 500, "users sent per request")
+	c.flags.BoolVar(&dryRun, "dry-run", false, "check the users without saving them")
+
+	path, err := c.parse(args, "file")
+	if err != nil {
+		return err
+	}
+
+	if format == "" {
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".csv":
+			format = "csv"
+		case ".jsonl", ".ndjson":
+			format = "jsonl"
+		default:
+			return usageErr("-format is required for " + strconv.Quote(path))
+		}
+	}
+	if format != "csv" && format != "jsonl" {
+		return usageErr("-format must be csv or jsonl")
+	}
+	if batch < 1 || batch > admin.MaxImportBatch {
+		return usageErr(fmt.Sprintf("-batch must be between 1 and %d", admin.MaxImportBatch))
+	}
+
+	in := c.stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		in = f
+	}
+
+	var report importReport
+	var records []importRecord
+	read := 0
+
+	send := func() error {
+		if len(records) == 0 {
+			return nil
+		}
+
+		req := &admingrpc.ImportUsersRequest{HashFormat: hashFormat, DryRun: dryRun}
+		for _, r := range records {
+			req.Users = append(req.Users, r.user)
+		}
+
+		resp, err := call(ctx, c, func(ctx context.Context) (*admingrpc.ImportUsersResponse, error) {
+			return c.api.ImportUsers(ctx, req)
+		})
+		if err != nil {
+			// Earlier batches are saved; running the import again skips them.
+			return fmt.Errorf("batch starting at line %d: %s", records[0].line,
+				describe(err))
+		}
+
+		report.Imported += resp.Imported
+		for _, i := range resp.Existing {
+			report.Existing = append(report.Existing, importResult{
+				Line:  records[i].line,
+				Email: records[i].user.Email,
+			})
+		}
+		for _, r := range resp.Rejected {
+			report.Rejected = append(report.Rejected, importResult{
+				Line:   records[r.Index].line,
+				Email:  records[r.Index].user.Email,
+				Reason: r.Reason,
+			})
+		}
+
+		read += len(records)
+		records = records[:0]
+		fmt.Fprintf(c.stderr, "processed %d users\n", read)
+
+		return nil
+	}
+
+	readUsers := readCSV
+	if format == "jsonl" {
+		readUsers = readJSONL
+	}
+
+	err = readUsers(in, func(r importRecord) error {
+		records = append(records, r)
+		if len(records) < batch {
+			return nil
+		}
+
+		return send()
+	})
+	if err == nil {
+		err = send()
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := c.printImport(report, dryRun); err != nil {
+		return err
+	}
+
+	if len(report.Rejected) > 0 {
+		return fmt.Errorf("%d users were rejected", len(report.Rejected))
+	}
+
+	return nil
+}
+
+func (c *command) printImport(report importReport, dryRun bool) error {
+	rows := make([][]string, 0, len(report.Existing)+len(report.Rejected))
+	for _, r := range report.Rejected {
+		rows = append(rows, []string{strconv.Itoa(r.Line), r.Email, "rejected: " + r.Reason})
+	}
+	for _, r := range report.Existing {
+		rows = append(rows, []string{strconv.Itoa(r.Line), r.Email, "skipped: user exists"})
+	}
+
+	var header []string
+	if len(rows) > 0 {
+		header = []string{"LINE", "EMAIL", "RESULT"}
+	}
+
+	if err := c.out.print(report, header, rows); err != nil {
+		return err
+	}
+
+	if !c.out.json {
+		verb := "imported"
+		if dryRun {
+			verb = "would import"
+		}
+
+		fmt.Fprintf(c.out.w, "%s %d users, %d existing, %d rejected\n", verb,
+			report.Imported, len(report.Existing), len(report.Rejected))
+	}
+
+	return nil
+}
+
+// readCSV reads users from CSV with a header row naming the email,
+// username, password_hash and optional salt columns, in any order.
+func readCSV(r io.Reader, fn func(importRecord) error) error {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+
+	header, err := cr.Read()
+	if err != nil {
+		return fmt.Errorf("failed to read csv header: %w", err)
+	}
+
+	cols := make(map[string]int, len(header))
+	for i, name := range header {
+		cols[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range []string{"email", "username", "password_hash"} {
+		if _, ok := cols[name]; !ok {
+			return fmt.Errorf("csv header has no %s column", name)
+		}
+	}
+
+	field := func(record []string, name string) string {
+		if i, ok := cols[name]; ok && i < len(record) {
+			return record[i]
+		}
+
+		return ""
+	}
+
+	for {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		line, _ := cr.FieldPos(0)
+
+		err = fn(importRecord{line: line, user: admingrpc.ImportUser{
+			Email:        field(record, "email"),
+			Username:     field(record, "username"),
+			PasswordHash: field(record, "password_hash"),
+			Salt:         field(record, "salt"),
+		}})
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// readJSONL reads users from JSON objects with email, username,
+// password_hash and optional salt fields, one per line.
+func readJSONL(r io.Reader, fn func(importRecord) error) error {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64*1024), maxLineLen)
+
+	for line := 1; sc.Scan(); line++ {
+		if strings.TrimSpace(sc.Text()) == "" {
+			continue
+		}
+
+		var user admingrpc.ImportUser
+		if err := json.Unmarshal(sc.Bytes(), &user); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+
+		if err := fn(importRecord{line: line, user: user}); err != nil {
+			return err
+		}
+	}
+
+	return sc.Err()
+}
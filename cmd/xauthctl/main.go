@@ -0,0 +1,640 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+	accountgrpc "xauth/internal/grpc/account"
+	admingrpc "xauth/internal/grpc/admin"
+	"xauth/internal/services/account"
+	"xauth/internal/services/admin"
+	"xauth/internal/storage/sqlite"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	exitOK    = 0
+	exitError = 1
+	exitUsage = 2
+)
+
+// offlineBusyTimeout is how long -offline commands wait for the server to
+// release the database.
+const offlineBusyTimeout = 5 * time.Second
+
+const usage = `usage: xauthctl [flags] <command> [flags] [argument]
+
+commands:
+  apps create [-redirect-uri URI]... [-scope SCOPE]... NAME
+                             register an app and print its secret
+  apps list                  list apps
+  users get USER             show a user by ID, email or username
+  users suspend ID           block sign-in and reject the user's tokens
+  users unsuspend ID         lift a suspension
+  users grant-admin ID       make the user an admin
+  users revoke-admin ID      take admin rights away
+  users revoke-sessions ID   invalidate every token issued to the user so far
+  users import [-format csv|jsonl] [-hash-format SPEC] [-batch N] [-dry-run] FILE
+                             import users with password hashes from another
+                             system; FILE may be - for stdin
+  users export [-out FILE] ID
+                             export everything stored about the user as JSON
+  users erase [-yes] ID      delete the user and everything linked to them,
+                             keeping their audit events pseudonymized
+  audit tail [-f] [-n N] [-user ID]
+                             print the last audit events, and follow new ones
+  db backup [-dir DIR] [-gzip] [-key-file FILE] [-keep N]
+                             back up the database; the flags apply with
+                             -offline, the server uses its backup config
+  db restore [-key-file FILE] [-migrations-table NAME] [-yes] FILE
+                             replace the database at -storage-path with a
+                             backup; stop the server first
+
+The server is called with the admin's access token from -token or
+XAUTH_TOKEN. With -offline, the database is opened directly instead; only
+do that on the host the server runs on.
+
+flags:
+`
+
+// options are the global flags.
+type options struct {
+	addr        string
+	token       string
+	tls         bool
+	caFile      string
+	offline     bool
+	storagePath string
+	output      string
+	timeout     time.Duration
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	os.Exit(run(ctx, os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+func run(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	var opts options
+
+	flags := flag.NewFlagSet("xauthctl", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprint(stderr, usage)
+		flags.PrintDefaults()
+	}
+
+	flags.StringVar(&opts.addr, "addr", "localhost:50051", "gRPC address of the server")
+	flags.StringVar(&opts.token, "token", "",
+		"admin access token or API key (default: $XAUTH_TOKEN)")
+	flags.BoolVar(&opts.tls, "tls", false, "connect with TLS")
+	flags.StringVar(&opts.caFile, "ca-file", "", "CA certificate to verify the server with (implies -tls)")
+	flags.BoolVar(&opts.offline, "offline", false, "open the database instead of calling the server")
+	flags.StringVar(&opts.storagePath, "storage-path", "", "path to the database, for -offline and db restore")
+	flags.StringVar(&opts.output, "o", "table", "output format: table or json")
+	flags.DurationVar(&opts.timeout, "timeout", 10*time.Second, "timeout of each request")
+
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+
+		return exitUsage
+	}
+
+	if opts.token == "" {
+		opts.token = os.Getenv("XAUTH_TOKEN")
+	}
+
+	if opts.output != "table" && opts.output != "json" {
+		return usageError(flags, "-o must be table or json")
+	}
+	if opts.offline && opts.storagePath == "" {
+		return usageError(flags, "-offline requires -storage-path")
+	}
+	if flags.NArg() < 2 {
+		return usageError(flags, "command is required")
+	}
+
+	cmd, ok := commands[flags.Arg(0)+" "+flags.Arg(1)]
+	if !ok {
+		return usageError(flags, fmt.Sprintf("unknown command %q",
+			flags.Arg(0)+" "+flags.Arg(1)))
+	}
+
+	cmdFlags := flag.NewFlagSet("xauthctl "+flags.Arg(0)+" "+flags.Arg(1),
+		flag.ContinueOnError)
+	cmdFlags.SetOutput(stderr)
+	cmdFlags.Usage = flags.Usage
+
+	c := &command{
+		flags:  cmdFlags,
+		stdin:  stdin,
+		out:    printer{w: stdout, json: opts.output == "json"},
+		stderr: stderr,
+		opts:   opts,
+	}
+
+	if err := cmd(ctx, c, flags.Args()[2:]); err != nil {
+		var uerr usageErr
+		if errors.As(err, &uerr) {
+			return usageError(flags, string(uerr))
+		}
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		if errors.Is(err, context.Canceled) {
+			return exitOK
+		}
+
+		fmt.Fprintf(stderr, "xauthctl: %v\n", describe(err))
+
+		return exitError
+	}
+
+	return exitOK
+}
+
+// command is what a command runs with. The API is connected lazily, after
+// the command's arguments have been checked.
+type command struct {
+	flags  *flag.FlagSet
+	stdin  io.Reader
+	out    printer
+	stderr io.Writer
+	opts   options
+	api    *services
+}
+
+// services are the APIs commands call.
+type services struct {
+	admingrpc.AdminServer
+	accountgrpc.AccountServer
+}
+
+type usageErr string
+
+func (e usageErr) Error() string {
+	return string(e)
+}
+
+var commands = map[string]func(ctx context.Context, c *command, args []string) error{
+	"apps create":           appsCreate,
+	"apps list":             appsList,
+	"users get":             usersGet,
+	"users suspend":         userAction(suspend(true)),
+	"users unsuspend":       userAction(suspend(false)),
+	"users grant-admin":     userAction(setAdmin(true)),
+	"users revoke-admin":    userAction(setAdmin(false)),
+	"users revoke-sessions": userAction(revokeSessions),
+	"users import":          usersImport,
+	"users export":          usersExport,
+	"users erase":           usersErase,
+	"audit tail":            auditTail,
+	"db backup":             dbBackup,
+	"db restore":            dbRestore,
+}
+
+func appsCreate(ctx context.Context, c *command, args []string) error {
+	var redirectURIs, scopes stringList
+	c.flags.Var(&redirectURIs, "redirect-uri", "redirect URI of the app (repeatable)")
+	c.flags.Var(&scopes, "scope", "scope the app may request for itself (repeatable)")
+
+	name, err := c.parse(args, "app name")
+	if err != nil {
+		return err
+	}
+
+	resp, err := call(ctx, c, func(ctx context.Context) (*admingrpc.CreateAppResponse, error) {
+		return c.api.CreateApp(ctx, &admingrpc.CreateAppRequest{
+			Name:         name,
+			RedirectURIs: redirectURIs,
+			Scopes:       scopes,
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.out.print(resp.App, []string{"ID", "NAME", "SECRET", "SCOPES"},
+		[][]string{appRow(resp.App, true)})
+}
+
+func appsList(ctx context.Context, c *command, args []string) error {
+	if _, err := c.parse(args, ""); err != nil {
+		return err
+	}
+
+	resp, err := call(ctx, c, func(ctx context.Context) (*admingrpc.ListAppsResponse, error) {
+		return c.api.ListApps(ctx, &admingrpc.ListAppsRequest{})
+	})
+	if err != nil {
+		return err
+	}
+
+	rows := make([][]string, 0, len(resp.Apps))
+	for _, app := range resp.Apps {
+		rows = append(rows, appRow(app, false))
+	}
+
+	return c.out.print(resp.Apps, []string{"ID", "NAME", "SCOPES"}, rows)
+}
+
+func usersGet(ctx context.Context, c *command, args []string) error {
+	ref, err := c.parse(args, "user ID, email or username")
+	if err != nil {
+		return err
+	}
+
+	req := &admingrpc.GetUserRequest{Login: ref}
+	if id, err := strconv.ParseInt(ref, 10, 64); err == nil {
+		req = &admingrpc.GetUserRequest{UserID: id}
+	}
+
+	resp, err := call(ctx, c, func(ctx context.Context) (*admingrpc.GetUserResponse, error) {
+		return c.api.GetUser(ctx, req)
+	})
+	if err != nil {
+		return err
+	}
+
+	u := resp.User
+
+	return c.out.print(u,
+		[]string{"ID", "EMAIL", "USERNAME", "ADMIN", "SUSPENDED", "SESSIONS REVOKED"},
+		[][]string{{
+			strconv.FormatInt(u.ID, 10), u.Email, u.Username,
+			strconv.FormatBool(u.IsAdmin), formatTime(u.SuspendedAt),
+			formatTime(u.SessionsRevokedAt),
+		}})
+}
+
+// userAction returns a command changing the user given as its argument.
+func userAction(
+	action func(ctx context.Context, api admingrpc.AdminServer, userID int64) (string, error),
+) func(ctx context.Context, c *command, args []string) error {
+	return func(ctx context.Context, c *command, args []string) error {
+		userID, err := c.parseUserID(args)
+		if err != nil {
+			return err
+		}
+
+		msg, err := call(ctx, c, func(ctx context.Context) (string, error) {
+			return action(ctx, c.api, userID)
+		})
+		if err != nil {
+			return err
+		}
+
+		return c.out.print(map[string]any{"user_id": userID, "result": msg},
+			nil, [][]string{{msg}})
+	}
+}
+
+func suspend(suspended bool) func(context.Context, admingrpc.AdminServer, int64) (string, error) {
+	return func(ctx context.Context, api admingrpc.AdminServer, userID int64) (string, error) {
+		_, err := api.SetSuspended(ctx, &admingrpc.SetSuspendedRequest{
+			UserID:    userID,
+			Suspended: suspended,
+		})
+		if suspended {
+			return "user suspended", err
+		}
+
+		return "user unsuspended", err
+	}
+}
+
+func setAdmin(isAdmin bool) func(context.Context, admingrpc.AdminServer, int64) (string, error) {
+	return func(ctx context.Context, api admingrpc.AdminServer, userID int64) (string, error) {
+		_, err := api.SetAdmin(ctx, &admingrpc.SetAdminRequest{
+			UserID:  userID,
+			IsAdmin: isAdmin,
+		})
+		if isAdmin {
+			return "admin rights granted", err
+		}
+
+		return "admin rights revoked", err
+	}
+}
+
+func revokeSessions(ctx context.Context, api admingrpc.AdminServer, userID int64) (string, error) {
+	_, err := api.RevokeSessions(ctx, &admingrpc.RevokeSessionsRequest{UserID: userID})
+
+	return "sessions revoked", err
+}
+
+// auditTail prints the last events and, with -f, polls for new ones until
+// interrupted. In JSON, every event is printed on a line of its own.
+func auditTail(ctx context.Context, c *command, args []string) error {
+	var follow bool
+	var n int
+	var userID int64
+	var interval time.Duration
+	c.flags.BoolVar(&follow, "f", false, "keep printing new events")
+	c.flags.IntVar(&n, "n", 20, "number of events to print first (at most 1000)")
+	c.flags.Int64Var(&userID, "user", 0, "only print events about this user")
+	c.flags.DurationVar(&interval, "interval", 2*time.Second, "how often to poll with -f")
+
+	if _, err := c.parse(args, ""); err != nil {
+		return err
+	}
+	if n < 0 || userID < 0 || interval <= 0 {
+		return usageErr("-n, -user and -interval must be positive")
+	}
+
+	req := &admingrpc.ListAuditEventsRequest{UserID: userID, Limit: n, Newest: true}
+
+	if !c.out.json {
+		printAuditRow(c.out.w, auditHeader)
+	}
+
+	// With -n 0, start from the newest event without printing it.
+	if n == 0 {
+		req.Limit = 1
+	}
+
+	for {
+		resp, err := call(ctx, c, func(ctx context.Context) (*admingrpc.ListAuditEventsResponse, error) {
+			return c.api.ListAuditEvents(ctx, req)
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, e := range resp.Events {
+			req.AfterID = e.ID
+
+			if n == 0 && req.Newest {
+				continue
+			}
+
+			if c.out.json {
+				if err := json.NewEncoder(c.out.w).Encode(e); err != nil {
+					return err
+				}
+
+				continue
+			}
+
+			printAuditRow(c.out.w, auditRow(e))
+		}
+
+		if !follow {
+			return nil
+		}
+
+		// Follow from the last event seen, as many as a page holds.
+		req.Newest = false
+		req.Limit = 0
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+// parse parses the command's flags and returns its argument, which must be
+// given if it has a name and absent otherwise.
+func (c *command) parse(args []string, name string) (string, error) {
+	if err := c.flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return "", err
+		}
+
+		return "", usageErr(err.Error())
+	}
+
+	switch {
+	case name == "" && c.flags.NArg() > 0:
+		return "", usageErr("unexpected argument " + strconv.Quote(c.flags.Arg(0)))
+	case name == "":
+		return "", nil
+	case c.flags.NArg() == 0:
+		return "", usageErr(name + " is required")
+	case c.flags.NArg() > 1:
+		return "", usageErr("flags must come before the " + name)
+	}
+
+	return c.flags.Arg(0), nil
+}
+
+// call connects to the API if needed and runs fn with the request timeout.
+func call[T any](ctx context.Context, c *command, fn func(ctx context.Context) (T, error)) (T, error) {
+	if c.api == nil {
+		api, err := connect(c.opts)
+		if err != nil {
+			var zero T
+
+			return zero, err
+		}
+
+		c.api = api
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.opts.timeout)
+	defer cancel()
+
+	if c.opts.token != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+c.opts.token)
+	}
+
+	return fn(ctx)
+}
+
+// connect returns the API of the server, or the handlers running
+// in-process on the database with -offline.
+func connect(opts options) (*services, error) {
+	if opts.offline {
+		if _, err := os.Stat(opts.storagePath); err != nil {
+			return nil, err
+		}
+
+		storage, err := sqlite.New(opts.storagePath,
+			sqlite.Options{BusyTimeout: offlineBusyTimeout})
+		if err != nil {
+			return nil, err
+		}
+
+		log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+		return &services{
+			AdminServer:   admingrpc.NewServerAPI(admin.New(log, storage, nil)),
+			AccountServer: accountgrpc.NewServerAPI(account.New(log, storage)),
+		}, nil
+	}
+
+	if opts.token == "" {
+		return nil, errors.New("an access token is required: pass -token or set XAUTH_TOKEN")
+	}
+
+	creds := insecure.NewCredentials()
+	switch {
+	case opts.caFile != "":
+		tlsCreds, err := credentials.NewClientTLSFromFile(opts.caFile, "")
+		if err != nil {
+			return nil, err
+		}
+
+		creds = tlsCreds
+	case opts.tls:
+		creds = credentials.NewClientTLSFromCert(nil, "")
+	}
+
+	conn, err := grpc.NewClient(opts.addr, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return nil, err
+	}
+
+	return &services{
+		AdminServer:   admingrpc.NewClient(conn),
+		AccountServer: accountgrpc.NewClient(conn),
+	}, nil
+}
+
+// printer prints results as a table or as JSON.
+type printer struct {
+	w    io.Writer
+	json bool
+}
+
+// print prints v as JSON, or rows under header as a table. A nil header
+// prints the rows alone.
+func (p printer) print(v any, header []string, rows [][]string) error {
+	if p.json {
+		return writeJSON(p.w, v)
+	}
+
+	w := tabwriter.NewWriter(p.w, 0, 0, 2, ' ', 0)
+	if header != nil {
+		fmt.Fprintln(w, strings.Join(header, "\t"))
+	}
+	for _, row := range rows {
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+
+	return w.Flush()
+}
+
+func appRow(app admingrpc.App, withSecret bool) []string {
+	row := []string{strconv.Itoa(app.ID), app.Name}
+	if withSecret {
+		row = append(row, app.Secret)
+	}
+
+	return append(row, orDash(strings.Join(app.Scopes, " ")))
+}
+
+var auditHeader = []string{"ID", "TIME", "ACTION", "ACTOR", "USER", "APP", "DETAILS"}
+
+// printAuditRow prints a row of audit tail in fixed-width columns, which
+// stay aligned as events keep coming.
+func printAuditRow(w io.Writer, row []string) {
+	fmt.Fprintf(w, "%-6s  %-19s  %-24s  %-6s  %-6s  %-4s  %s\n",
+		row[0], row[1], row[2], row[3], row[4], row[5], row[6])
+}
+
+func auditRow(e admingrpc.AuditEvent) []string {
+	details := make([]string, 0, len(e.Details))
+	for _, k := range slices.Sorted(maps.Keys(e.Details)) {
+		details = append(details, k+"="+e.Details[k])
+	}
+
+	return []string{
+		strconv.FormatInt(e.ID, 10),
+		e.CreatedAt.Local().Format(time.DateTime),
+		e.Action,
+		formatID(e.ActorID),
+		formatID(e.UserID),
+		formatID(int64(e.AppID)),
+		orDash(strings.Join(details, " ")),
+	}
+}
+
+func formatID(id int64) string {
+	if id == 0 {
+		return "-"
+	}
+
+	return strconv.FormatInt(id, 10)
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+
+	return t.Local().Format(time.DateTime)
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+
+	return s
+}
+
+// describe returns the message of a status error without the "rpc error:
+// code = ... desc =" prefix, which means little to operators.
+func describe(err error) string {
+	if st, ok := status.FromError(err); ok {
+		return st.Message() + " (" + st.Code().String() + ")"
+	}
+
+	return err.Error()
+}
+
+// confirm asks the user to type "yes". Without a terminal, such as in CI,
+// stdin is usually empty and the answer is no; pass -yes there.
+func confirm(stdin io.Reader, stderr io.Writer) bool {
+	fmt.Fprint(stderr, `type "yes" to continue: `)
+
+	answer, _ := bufio.NewReader(stdin).ReadString('\n')
+
+	return strings.TrimSpace(answer) == "yes"
+}
+
+func usageError(flags *flag.FlagSet, msg string) int {
+	fmt.Fprintf(flags.Output(), "xauthctl: %s\n\n", msg)
+	flags.Usage()
+
+	return exitUsage
+}
+
+// stringList is a flag that may be given more than once.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+
+	return nil
+}
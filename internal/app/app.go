@@ -1,33 +1,358 @@
 package app
 
 import (
+	"context"
+	"crypto/tls"
+	"fmt"
 	"log/slog"
-	"time"
+	"net/http"
+	"net/url"
+	"runtime"
+	"strings"
+	"sync"
 	grpcapp "xauth/internal/app/grpc"
+	httpapp "xauth/internal/app/http"
+	"xauth/internal/config"
+	authgrpc "xauth/internal/grpc/auth"
+	authhttp "xauth/internal/http/auth"
+	metricshttp "xauth/internal/http/metrics"
+	oauthhttp "xauth/internal/http/oauth"
+	"xauth/internal/lib/archive"
+	"xauth/internal/lib/certs"
+	"xauth/internal/lib/hashpool"
+	"xauth/internal/lib/jwk"
+	"xauth/internal/lib/notify"
+	"xauth/internal/lib/oidc"
+	"xauth/internal/services/account"
+	"xauth/internal/services/admin"
+	"xauth/internal/services/apikeys"
 	"xauth/internal/services/auth"
+	"xauth/internal/services/backup"
+	"xauth/internal/services/federation"
+	"xauth/internal/services/health"
+	"xauth/internal/services/oauth"
+	"xauth/internal/services/orgs"
+	"xauth/internal/storage/migrator"
 	"xauth/internal/storage/sqlite"
+	"xauth/migrations"
 )
 
 type App struct {
 	GRPCSrv *grpcapp.App
+	// HTTPSrv is nil if the HTTP gateway is disabled.
+	HTTPSrv *httpapp.App
+	// MetricsSrv is nil if metrics are disabled.
+	MetricsSrv *httpapp.App
+
+	auth         *auth.Auth
+	oauth        *oauth.OAuth
+	apiKeys      *apikeys.APIKeys
+	orgs         *orgs.Orgs
+	federation   *federation.Federation
+	certReloader *certs.Reloader
+	hashPool     *hashpool.Pool
+	storage      *sqlite.Storage
+
+	// stopBackups stops the periodic backups, if any; wg waits for them.
+	stopBackups chan struct{}
+	wg          sync.WaitGroup
 }
 
 func New(
 	log *slog.Logger,
-	grpcPort int,
-	storagePath string,
-	tokenTTL time.Duration,
+	cfg *config.Config,
 ) *App {
-	storage, err := sqlite.New(storagePath)
+	mustMigrate(log, string(cfg.StoragePath), cfg.AutoMigrate, cfg.Health)
+
+	storage, err := sqlite.New(string(cfg.StoragePath), sqlite.Options{
+		JournalMode:     cfg.SQLite.JournalMode,
+		ForeignKeys:     cfg.SQLite.ForeignKeys,
+		BusyTimeout:     cfg.SQLite.BusyTimeout,
+		MaxOpenConns:    cfg.SQLite.MaxOpenConns,
+		MaxIdleConns:    cfg.SQLite.MaxIdleConns,
+		ConnMaxIdleTime: cfg.SQLite.ConnMaxIdleTime,
+	})
 	if err != nil {
 		panic(err)
 	}
 
-	authService := auth.New(log, storage, storage, storage, tokenTTL)
+	mustBackfillLoginKeys(log, storage)
+
+	var notifier auth.Notifier
+	if cfg.Registration.NotifyURL != "" {
+		notifier = notify.NewWebhook(cfg.Registration.NotifyURL)
+	}
+
+	hashWorkers := cfg.Hashing.Workers
+	if hashWorkers == 0 {
+		hashWorkers = max(runtime.GOMAXPROCS(0)/2, 1)
+	}
+	hashPool := hashpool.New(hashWorkers, cfg.Hashing.QueueDepth)
+
+	signingKey := mustLoadSigningKey(log, cfg.OIDC.SigningKeyFile)
+
+	authService := auth.New(log, storage, storage, storage, storage, cfg.TokenTTL,
+		cfg.OIDC.Issuer, signingKey, cfg.Registration.EnumerationSafe, notifier, hashPool)
+
+	healthChecker := health.New(storage, cfg.Health.MigrationsTable,
+		cfg.Health.SchemaVersion)
+
+	var certReloader *certs.Reloader
+	if tlsCfg := cfg.GRPC.TLS; tlsCfg.Enabled {
+		certReloader, err = certs.NewReloader(log, tlsCfg.CertFile,
+			tlsCfg.KeyFile, tlsCfg.ClientCAFile)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	apiKeysService := apikeys.New(log, storage, cfg.APIKeys.DefaultTTL,
+		cfg.APIKeys.MaxTTL)
+
+	oauthService := oauth.New(log, authService, storage, storage, storage,
+		cfg.OAuth.CodeTTL, cfg.TokenTTL, cfg.OAuth.ClientTokenTTL,
+		cfg.OIDC.Issuer, signingKey, apiKeysService)
+
+	var backupKey []byte
+	if cfg.Backup.EncryptionKey != "" {
+		backupKey, err = archive.ParseKey(string(cfg.Backup.EncryptionKey))
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	backups := backup.New(log, storage, cfg.Backup.Dir, cfg.Backup.Compress, backupKey,
+		cfg.Backup.Keep)
+
+	adminService := admin.New(log, storage, backups)
+	accountService := account.New(log, storage)
+
+	grpcApp := grpcapp.New(log, authService, adminService, accountService,
+		oauthService, storage, healthChecker, cfg.GRPC.Port,
+		cfg.Health.CheckInterval, certReloader, cfg.GRPC.TLS.ReloadInterval,
+		cfg.GRPC.TLS.AllowedIdentities)
+
+	application := &App{
+		GRPCSrv:      grpcApp,
+		auth:         authService,
+		oauth:        oauthService,
+		apiKeys:      apiKeysService,
+		certReloader: certReloader,
+		hashPool:     hashPool,
+		storage:      storage,
+	}
+
+	if cfg.Backup.Interval > 0 {
+		application.stopBackups = make(chan struct{})
+
+		application.wg.Add(1)
+		go func() {
+			defer application.wg.Done()
+
+			backups.Schedule(cfg.Backup.Interval, application.stopBackups)
+		}()
+	}
+
+	if cfg.Metrics.Enabled {
+		mux := http.NewServeMux()
+		metricshttp.Register(mux, hashPool)
+
+		application.MetricsSrv = httpapp.New(log, mux, cfg.Metrics.Port,
+			cfg.HTTP.ReadTimeout, cfg.HTTP.WriteTimeout, cfg.HTTP.ShutdownTimeout, nil)
+	}
+
+	if cfg.HTTP.Enabled {
+		var tlsConfig *tls.Config
+		if cfg.HTTP.TLS {
+			if certReloader == nil {
+				panic("http.tls requires grpc.tls to be enabled")
+			}
+
+			tlsConfig = &tls.Config{
+				MinVersion:     tls.VersionTLS12,
+				GetCertificate: certReloader.GetCertificate,
+			}
+		}
+
+		mux := http.NewServeMux()
+		authhttp.Register(mux, log, authgrpc.NewServerAPI(authService),
+			grpcApp.Interceptor())
+
+		authhttp.RegisterAPIKeys(mux, log, apiKeysService, oauthService)
+
+		application.orgs = orgs.New(log, storage, storage, storage, cfg.TokenTTL,
+			cfg.Orgs.InvitationTTL, cfg.OIDC.Issuer, signingKey)
+		authhttp.RegisterOrgs(mux, log, application.orgs, oauthService)
+
+		upstreams := make(map[string]federation.Upstream, len(cfg.OIDC.Upstreams))
+		for name, upstream := range cfg.OIDC.Upstreams {
+			redirectURL := strings.TrimSuffix(cfg.OIDC.Issuer, "/") +
+				"/federation/" + url.PathEscape(name) + "/callback"
+
+			upstreams[name] = federation.Upstream{
+				Provider: oidc.NewProvider(upstream.Issuer, upstream.ClientID,
+					string(upstream.ClientSecret), redirectURL, upstream.Scopes),
+				AllowSignup: upstream.AllowSignup,
+			}
+		}
+
+		application.federation = federation.New(log, upstreams, storage, storage,
+			oauthService, cfg.OIDC.UpstreamLoginTTL)
+
+		oauthhttp.Register(mux, log, oauthService, application.federation)
+
+		application.HTTPSrv = httpapp.New(log, mux, cfg.HTTP.Port, cfg.HTTP.ReadTimeout,
+			cfg.HTTP.WriteTimeout, cfg.HTTP.ShutdownTimeout, tlsConfig)
+	}
+
+	return application
+}
+
+// Close releases what the servers used once they are stopped.
+func (a *App) Close() error {
+	a.hashPool.Close()
+
+	if a.stopBackups != nil {
+		close(a.stopBackups)
+		a.wg.Wait()
+	}
+
+	return a.storage.Close()
+}
 
-	grpcApp := grpcapp.New(log, authService, grpcPort)
+// Reload applies the settings of cfg that can change while the servers
+// are running (see config.Reloadable) and re-reads the TLS certificates.
+// The certificates are read first: if they can't be, nothing changes. The
+// rest of cfg is ignored.
+func (a *App) Reload(cfg *config.Config) error {
+	const op = "app.Reload"
 
-	return &App{
-		GRPCSrv: grpcApp,
+	var certificates certs.Certificates
+	if a.certReloader != nil {
+		var err error
+		if certificates, err = a.certReloader.Load(); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
 	}
+
+	a.auth.SetTokenTTL(cfg.TokenTTL)
+	a.oauth.SetTTLs(cfg.OAuth.CodeTTL, cfg.TokenTTL, cfg.OAuth.ClientTokenTTL)
+	a.apiKeys.SetTTLs(cfg.APIKeys.DefaultTTL, cfg.APIKeys.MaxTTL)
+
+	if a.orgs != nil {
+		a.orgs.SetTTLs(cfg.TokenTTL, cfg.Orgs.InvitationTTL)
+	}
+	if a.federation != nil {
+		a.federation.SetStateTTL(cfg.OIDC.UpstreamLoginTTL)
+	}
+
+	if a.certReloader != nil {
+		a.certReloader.Use(certificates)
+	}
+
+	return nil
+}
+
+// mustMigrate applies the embedded migrations if autoMigrate is set, then
+// refuses to start on a database that is dirty or older than
+// health.schema_version. A newer one is only reported: the health checks
+// keep the service out of rotation until it matches.
+func mustMigrate(
+	log *slog.Logger,
+	storagePath string,
+	autoMigrate bool,
+	cfg config.HealthConfig,
+) {
+	const op = "app.mustMigrate"
+
+	log = log.With(slog.String("op", op))
+
+	m, err := migrator.NewFS(migrations.FS, storagePath, cfg.MigrationsTable,
+		migrationLogger{log})
+	if err != nil {
+		panic(err)
+	}
+	defer m.Close()
+
+	if autoMigrate {
+		steps, err := m.UpTo(cfg.SchemaVersion)
+		if err != nil {
+			panic(err)
+		}
+
+		if len(steps) > 0 {
+			log.Info("migrations applied", slog.Int("count", len(steps)))
+		}
+	}
+
+	version, dirty, err := m.Version()
+	if err != nil {
+		panic(err)
+	}
+
+	switch {
+	case dirty:
+		panic(fmt.Sprintf("database schema is dirty at version %d; "+
+			"fix it by hand and run the migrator's force command", version))
+	case version < cfg.SchemaVersion:
+		panic(fmt.Sprintf("database schema is at version %d, want %d; "+
+			"run the migrator or set auto_migrate", version, cfg.SchemaVersion))
+	case version > cfg.SchemaVersion:
+		log.Warn("database schema is newer than expected",
+			slog.Uint64("version", uint64(version)),
+			slog.Uint64("want", uint64(cfg.SchemaVersion)))
+	}
+}
+
+// mustBackfillLoginKeys sets the canonical emails and usernames of users
+// saved before they were stored, and reports users who can't be told apart
+// from another user by them. Those users sign in with their email or
+// username spelled exactly as stored until an admin changes it.
+func mustBackfillLoginKeys(log *slog.Logger, storage *sqlite.Storage) {
+	const op = "app.mustBackfillLoginKeys"
+
+	log = log.With(slog.String("op", op))
+
+	collisions, err := storage.BackfillLoginKeys(context.Background())
+	if err != nil {
+		panic(err)
+	}
+
+	for _, c := range collisions {
+		log.Warn("login collides with another user's",
+			slog.Int64("user_id", c.UserID),
+			slog.String("field", c.Field),
+			slog.Int64("other_user_id", c.OtherID))
+	}
+}
+
+// migrationLogger logs every migration applied on start.
+type migrationLogger struct {
+	log *slog.Logger
+}
+
+func (l migrationLogger) Printf(format string, v ...any) {
+	l.log.Info("migration applied",
+		slog.String("migration", strings.TrimSpace(fmt.Sprintf(format, v...))))
+}
+
+func mustLoadSigningKey(log *slog.Logger, path string) *jwk.Key {
+	if path == "" {
+		log.Warn("oidc.signing_key_file is not set, generating a temporary key; " +
+			"tokens will not verify after a restart")
+
+		key, err := jwk.Generate()
+		if err != nil {
+			panic(err)
+		}
+
+		return key
+	}
+
+	key, err := jwk.Load(path)
+	if err != nil {
+		panic(err)
+	}
+
+	return key
 }
@@ -1,37 +1,135 @@
 package grpcapp
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
 	"net"
+	"slices"
+	"time"
 
+	accountgrpc "xauth/internal/grpc/account"
+	admingrpc "xauth/internal/grpc/admin"
 	authgrpc "xauth/internal/grpc/auth"
+	"xauth/internal/lib/certs"
+	"xauth/internal/lib/logger/sl"
 
+	ssov1 "github.com/memxire/protobuf/gen/go/sso"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 )
 
+// ReadinessChecker reports whether the dependencies needed to serve
+// requests are available.
+type ReadinessChecker interface {
+	Ready(ctx context.Context) error
+}
+
 type App struct {
-	log        *slog.Logger
-	gRPCServer *grpc.Server
-	port       int
+	log            *slog.Logger
+	gRPCServer     *grpc.Server
+	interceptor    grpc.UnaryServerInterceptor
+	healthServer   *health.Server
+	readiness      ReadinessChecker
+	checkInterval  time.Duration
+	certs          *certs.Reloader
+	reloadInterval time.Duration
+	done           chan struct{}
+	port           int
 }
 
+// New creates a gRPC server for the Auth, Admin and Account services.
+//
+// Every method is subject to the access policy in policies, checked
+// against the caller's bearer token by tokens; users is consulted to tell
+// admins apart.
+//
+// If certReloader is nil, the server listens on plain TCP. Otherwise it
+// serves TLS (mutual TLS if the reloader has a client CA) and restricts
+// methods listed in allowedIdentities to matching client certificates.
 func New(
 	log *slog.Logger,
 	authService authgrpc.Auth,
+	adminService admingrpc.Admin,
+	accountService accountgrpc.Account,
+	tokens TokenValidator,
+	users UserProvider,
+	readiness ReadinessChecker,
 	port int,
+	checkInterval time.Duration,
+	certReloader *certs.Reloader,
+	reloadInterval time.Duration,
+	allowedIdentities map[string][]string,
 ) *App {
-	gRPCServer := grpc.NewServer()
+	// Interceptors shared by every transport, including in-process ones.
+	interceptors := []grpc.UnaryServerInterceptor{
+		tracingInterceptor(),
+		requestLogInterceptor(log),
+		recoveryInterceptor(log),
+		authInterceptor(log, tokens, users),
+	}
+
+	inProcess := interceptors
+	if len(allowedIdentities) > 0 {
+		inProcess = append(slices.Clone(interceptors),
+			restrictedMethodInterceptor(allowedIdentities))
+	}
+	interceptor := chainInterceptors(inProcess)
+
+	var opts []grpc.ServerOption
+
+	if certReloader != nil {
+		opts = append(opts,
+			grpc.Creds(credentials.NewTLS(certReloader.TLSConfig())))
+
+		if certReloader.MutualTLS() && len(allowedIdentities) > 0 {
+			interceptors = append(interceptors,
+				clientIdentityInterceptor(allowedIdentities))
+		}
+	}
+
+	opts = append(opts,
+		grpc.ChainUnaryInterceptor(interceptors...),
+		grpc.ChainStreamInterceptor(authStreamInterceptor(log, tokens, users)),
+	)
+
+	gRPCServer := grpc.NewServer(opts...)
 
 	authgrpc.Register(gRPCServer, authService)
+	admingrpc.Register(gRPCServer, adminService)
+	accountgrpc.Register(gRPCServer, accountService)
+
+	healthServer := health.NewServer()
+	healthpb.RegisterHealthServer(gRPCServer, healthServer)
+
+	// Nothing is served until the first readiness check passes.
+	healthServer.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	healthServer.SetServingStatus(ssov1.Auth_ServiceDesc.ServiceName,
+		healthpb.HealthCheckResponse_NOT_SERVING)
 
 	return &App{
-		log:        log,
-		gRPCServer: gRPCServer,
-		port:       port,
+		log:            log,
+		gRPCServer:     gRPCServer,
+		interceptor:    interceptor,
+		healthServer:   healthServer,
+		readiness:      readiness,
+		checkInterval:  checkInterval,
+		certs:          certReloader,
+		reloadInterval: reloadInterval,
+		done:           make(chan struct{}),
+		port:           port,
 	}
 }
 
+// Interceptor returns the interceptor chain applied to every RPC, for use
+// by in-process transports such as the HTTP gateway. They have no client
+// certificates, so methods restricted to some are refused.
+func (a *App) Interceptor() grpc.UnaryServerInterceptor {
+	return a.interceptor
+}
+
 // MustRun runs gRPC server and panics if any error occurs.
 func (a *App) MustRun() {
 	if err := a.Run(); err != nil {
@@ -53,7 +151,16 @@ func (a *App) Run() error {
 		return fmt.Errorf("%s: %w", op, err)
 	}
 
-	log.Info("grpc server is running", slog.String("address", l.Addr().String()))
+	go a.watchReadiness()
+
+	if a.certs != nil {
+		go a.certs.Watch(a.reloadInterval, a.done)
+	}
+
+	log.Info("grpc server is running",
+		slog.String("address", l.Addr().String()),
+		slog.Bool("tls", a.certs != nil),
+	)
 
 	if err := a.gRPCServer.Serve(l); err != nil {
 		return fmt.Errorf("%s: %w", op, err)
@@ -63,11 +170,86 @@ func (a *App) Run() error {
 }
 
 // Stop stops gRPC server.
+//
+// Health status is switched to NOT_SERVING before the graceful shutdown
+// begins, so load balancers stop routing new requests to this instance.
 func (a *App) Stop() {
 	const op = "grpcapp.Stop"
 
 	a.log.With(slog.String("op", op)).
 		Info("stopping gRPC server")
 
+	close(a.done)
+
+	a.healthServer.Shutdown()
+
 	a.gRPCServer.GracefulStop()
 }
+
+// watchReadiness periodically runs the readiness check and updates the
+// health status of every registered service until the server is stopped.
+func (a *App) watchReadiness() {
+	const op = "grpcapp.watchReadiness"
+
+	log := a.log.With(slog.String("op", op))
+
+	ticker := time.NewTicker(a.checkInterval)
+	defer ticker.Stop()
+
+	current := healthpb.HealthCheckResponse_NOT_SERVING
+
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), a.checkInterval)
+		err := a.readiness.Ready(ctx)
+		cancel()
+
+		next := healthpb.HealthCheckResponse_SERVING
+		if err != nil {
+			next = healthpb.HealthCheckResponse_NOT_SERVING
+		}
+
+		if next != current {
+			if err != nil {
+				log.Warn("service is not ready", sl.Err(err))
+			} else {
+				log.Info("service is ready")
+			}
+
+			a.setServingStatus(next)
+			current = next
+		}
+
+		select {
+		case <-a.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (a *App) setServingStatus(status healthpb.HealthCheckResponse_ServingStatus) {
+	a.healthServer.SetServingStatus("", status)
+	a.healthServer.SetServingStatus(ssov1.Auth_ServiceDesc.ServiceName, status)
+}
+
+// chainInterceptors combines interceptors into one, the first being the
+// outermost.
+func chainInterceptors(interceptors []grpc.UnaryServerInterceptor) grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req any,
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (any, error) {
+		next := handler
+
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			interceptor, inner := interceptors[i], next
+			next = func(ctx context.Context, req any) (any, error) {
+				return interceptor(ctx, req, info, inner)
+			}
+		}
+
+		return next(ctx, req)
+	}
+}
@@ -0,0 +1,319 @@
+package grpcapp
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+	"xauth/internal/domain/models"
+	accountgrpc "xauth/internal/grpc/account"
+	admingrpc "xauth/internal/grpc/admin"
+	"xauth/internal/lib/caller"
+	"xauth/internal/lib/jwt"
+	"xauth/internal/lib/logger/sl"
+	"xauth/internal/services/oauth"
+	"xauth/internal/storage"
+
+	ssov1 "github.com/memxire/protobuf/gen/go/sso"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// TokenValidator validates bearer tokens: access tokens and API keys.
+type TokenValidator interface {
+	ValidateToken(ctx context.Context, token string) (oauth.TokenInfo, error)
+}
+
+type UserProvider interface {
+	UserByID(ctx context.Context, userID int64) (models.User, error)
+}
+
+// policy says who may call a method.
+type policy int
+
+const (
+	// policyPublic methods need no token.
+	policyPublic policy = iota + 1
+	// policyAuthenticated methods need any valid token.
+	policyAuthenticated
+	// policySelfOrAdmin methods need a token of the user named by the
+	// request's user_id, an API key of theirs with scopeUsersRead, or an
+	// admin's credentials.
+	policySelfOrAdmin
+	// policySelfOrAdminUserToken methods are policySelfOrAdmin ones that
+	// only accept access tokens from Login, not API keys, organization
+	// tokens or tokens given to an app, as the HTTP self-service endpoints
+	// do.
+	policySelfOrAdminUserToken
+	// policySelfOrAdminRecentLogin methods are policySelfOrAdminUserToken
+	// ones that can't be undone, so they also need the token to be at most
+	// maxLoginAge old: a token that leaked can't be used for them for its
+	// whole lifetime.
+	policySelfOrAdminRecentLogin
+	// policyAdmin methods need an admin's credentials: a token from Login
+	// or an API key with scopeAdmin. Tokens the admin gave to an app don't
+	// count.
+	policyAdmin
+)
+
+// API key scopes the policies check. A key can only do what its scopes
+// allow, however much its user may do.
+const (
+	scopeUsersRead = "users:read"
+	scopeAdmin     = "admin"
+)
+
+// maxLoginAge is how long after signing in policySelfOrAdminRecentLogin
+// methods may be called.
+const maxLoginAge = 5 * time.Minute
+
+// policies lists every method served. Methods missing from it are denied,
+// so a new RPC can't be exposed without deciding who may call it.
+var policies = map[string]policy{
+	ssov1.Auth_Register_FullMethodName:   policyPublic,
+	ssov1.Auth_Login_FullMethodName:      policyPublic,
+	ssov1.Auth_GetUser_FullMethodName:    policySelfOrAdmin,
+	ssov1.Auth_IsAdmin_FullMethodName:    policySelfOrAdmin,
+	healthpb.Health_Check_FullMethodName: policyPublic,
+	healthpb.Health_Watch_FullMethodName: policyPublic,
+
+	admingrpc.CreateAppFullMethodName:       policyAdmin,
+	admingrpc.ListAppsFullMethodName:        policyAdmin,
+	admingrpc.GetUserFullMethodName:         policyAdmin,
+	admingrpc.SetSuspendedFullMethodName:    policyAdmin,
+	admingrpc.SetAdminFullMethodName:        policyAdmin,
+	admingrpc.RevokeSessionsFullMethodName:  policyAdmin,
+	admingrpc.ListAuditEventsFullMethodName: policyAdmin,
+	admingrpc.ImportUsersFullMethodName:     policyAdmin,
+	admingrpc.CreateBackupFullMethodName:    policyAdmin,
+
+	accountgrpc.ExportMyDataFullMethodName: policySelfOrAdminUserToken,
+	accountgrpc.EraseAccountFullMethodName: policySelfOrAdminRecentLogin,
+}
+
+// userIDRequest is implemented by requests about a specific user.
+type userIDRequest interface {
+	GetUserId() int64
+}
+
+// authInterceptor authenticates the bearer token from the "authorization"
+// metadata, puts the caller into the context and enforces the method's
+// policy.
+func authInterceptor(
+	log *slog.Logger,
+	tokens TokenValidator,
+	users UserProvider,
+) grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req any,
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (any, error) {
+		ctx, err := checkAccess(ctx, log, tokens, users, info.FullMethod, req)
+		if err != nil {
+			return nil, err
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// authStreamInterceptor is authInterceptor for streaming methods. Their
+// requests aren't known before the handler runs, so policySelfOrAdmin
+// methods are left to admins.
+func authStreamInterceptor(
+	log *slog.Logger,
+	tokens TokenValidator,
+	users UserProvider,
+) grpc.StreamServerInterceptor {
+	return func(
+		srv any,
+		ss grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		// Streams don't pass through requestLogInterceptor.
+		ctx := sl.NewContext(ss.Context(),
+			log.With(slog.String("method", info.FullMethod)))
+
+		ctx, err := checkAccess(ctx, log, tokens, users, info.FullMethod, nil)
+		if err != nil {
+			return err
+		}
+
+		return handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+// serverStream is a grpc.ServerStream with its context replaced.
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+// checkAccess enforces the policy of method for req and returns ctx with
+// the caller and the request logger, if the method isn't public.
+func checkAccess(
+	ctx context.Context,
+	log *slog.Logger,
+	tokens TokenValidator,
+	users UserProvider,
+	method string,
+	req any,
+) (context.Context, error) {
+	const op = "grpcapp.checkAccess"
+
+	reqLog := sl.FromContext(ctx, log)
+	log = reqLog.With(slog.String("op", op))
+
+	p, ok := policies[method]
+	if !ok {
+		log.Error("method has no access policy")
+
+		return nil, status.Error(codes.PermissionDenied, "method is not allowed")
+	}
+
+	if p == policyPublic {
+		return ctx, nil
+	}
+
+	token, ok := bearerToken(ctx)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "access token is required")
+	}
+
+	tokenInfo, err := tokens.ValidateToken(ctx, token)
+	if err != nil {
+		if errors.Is(err, oauth.ErrInvalidToken) {
+			return nil, status.Error(codes.Unauthenticated, "invalid access token")
+		}
+
+		log.Error("failed to validate token", sl.Err(err))
+
+		return nil, status.Error(codes.Internal, "failed to validate token")
+	}
+
+	c := caller.Caller{
+		UserID:    tokenInfo.UserID,
+		AppID:     tokenInfo.AppID,
+		OrgID:     tokenInfo.OrgID,
+		Scope:     tokenInfo.Scope,
+		TokenType: tokenInfo.Type,
+		Grant:     tokenInfo.Grant,
+		IssuedAt:  tokenInfo.IssuedAt,
+	}
+
+	if err := authorize(ctx, users, p, c, req); err != nil {
+		if _, ok := status.FromError(err); !ok {
+			log.Error("failed to authorize caller", sl.Err(err))
+
+			return nil, status.Error(codes.Internal, "failed to authorize caller")
+		}
+
+		return nil, err
+	}
+
+	attrs := []any{slog.Int64("user_id", c.UserID)}
+	if c.AppID != 0 {
+		attrs = append(attrs, slog.Int("app_id", c.AppID))
+	}
+
+	ctx = sl.NewContext(ctx, reqLog.With(attrs...))
+
+	return caller.NewContext(ctx, c), nil
+}
+
+// authorize checks that c may make req under policy p. Denials are
+// returned as status errors; anything else is an internal error.
+func authorize(
+	ctx context.Context,
+	users UserProvider,
+	p policy,
+	c caller.Caller,
+	req any,
+) error {
+	switch p {
+	case policyAuthenticated:
+		return nil
+	case policySelfOrAdminUserToken, policySelfOrAdminRecentLogin:
+		if !fromLogin(c) {
+			return status.Error(codes.PermissionDenied, "an access token from Login is required")
+		}
+
+		if p == policySelfOrAdminRecentLogin && time.Since(c.IssuedAt) > maxLoginAge {
+			return status.Error(codes.Unauthenticated,
+				"sign in again: the access token is too old for this method")
+		}
+
+		fallthrough
+	case policySelfOrAdmin:
+		if r, ok := req.(userIDRequest); ok && c.UserID != 0 && r.GetUserId() == c.UserID {
+			if c.TokenType == oauth.TokenTypeAPIKey && !hasScope(c, scopeUsersRead) {
+				return status.Error(codes.PermissionDenied,
+					"API key needs the "+scopeUsersRead+" scope")
+			}
+
+			return nil
+		}
+	}
+
+	// Acting as an admin takes the admin's own credentials. Client tokens
+	// act for an app, and tokens from the authorization code grant for an
+	// app the user signed in to, never for an admin.
+	if !fromLogin(c) && !(c.TokenType == oauth.TokenTypeAPIKey && hasScope(c, scopeAdmin)) {
+		return status.Error(codes.PermissionDenied, "admin access is required")
+	}
+
+	user, err := users.UserByID(ctx, c.UserID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return status.Error(codes.PermissionDenied, "admin access is required")
+		}
+
+		return err
+	}
+
+	if !user.IsAdmin {
+		return status.Error(codes.PermissionDenied, "admin access is required")
+	}
+
+	return nil
+}
+
+// fromLogin reports whether c's token was issued by Login, to the user
+// who signed in, and isn't scoped to an organization.
+func fromLogin(c caller.Caller) bool {
+	return c.TokenType == oauth.TokenTypeAccessToken && c.Grant == jwt.GrantPassword &&
+		c.UserID != 0 && c.OrgID == 0
+}
+
+func hasScope(c caller.Caller, scope string) bool {
+	return slices.Contains(strings.Fields(c.Scope), scope)
+}
+
+// bearerToken returns the token from "authorization: Bearer <token>"
+// incoming metadata.
+func bearerToken(ctx context.Context) (string, bool) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return "", false
+	}
+
+	for _, v := range md.Get("authorization") {
+		if token, ok := strings.CutPrefix(v, "Bearer "); ok && token != "" {
+			return token, true
+		}
+	}
+
+	return "", false
+}
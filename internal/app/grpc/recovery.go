@@ -0,0 +1,41 @@
+package grpcapp
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"xauth/internal/lib/logger/sl"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// recoveryInterceptor turns a panic in a handler (or any interceptor after
+// it) into a codes.Internal error, so one bad request can't take the
+// server down. The panic and its stack are logged.
+func recoveryInterceptor(log *slog.Logger) grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req any,
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (resp any, err error) {
+		const op = "grpcapp.recoveryInterceptor"
+
+		defer func() {
+			if p := recover(); p != nil {
+				sl.FromContext(ctx, log).Error("panic while handling request",
+					slog.String("op", op),
+					slog.String("panic", fmt.Sprint(p)),
+					slog.String("stack", string(debug.Stack())),
+				)
+
+				resp, err = nil, status.Error(codes.Internal, "internal error")
+			}
+		}()
+
+		return handler(ctx, req)
+	}
+}
@@ -0,0 +1,93 @@
+package grpcapp
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"xauth/internal/lib/certs"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// clientIdentityInterceptor restricts methods to clients presenting a
+// verified certificate with one of the configured identities.
+//
+// allowed maps a full method name ("/auth.Auth/IsAdmin") or a whole
+// service ("/auth.Auth/*") to the identities that may call it. Methods
+// without an entry are open to every client that passed the TLS handshake.
+func clientIdentityInterceptor(allowed map[string][]string) grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req any,
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (any, error) {
+		identities, restricted := allowedIdentities(allowed, info.FullMethod)
+		if !restricted {
+			return handler(ctx, req)
+		}
+
+		for _, id := range peerIdentities(ctx) {
+			if slices.Contains(identities, id) {
+				return handler(ctx, req)
+			}
+		}
+
+		return nil, status.Error(codes.PermissionDenied,
+			"client certificate is not allowed to call this method")
+	}
+}
+
+// restrictedMethodInterceptor refuses the methods allowed restricts to
+// some client certificates. It's for transports without them, such as
+// the HTTP gateway, which would otherwise get around the restriction.
+func restrictedMethodInterceptor(allowed map[string][]string) grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req any,
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (any, error) {
+		if _, restricted := allowedIdentities(allowed, info.FullMethod); restricted {
+			return nil, status.Error(codes.PermissionDenied,
+				"method is only served over gRPC to allowed client certificates")
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+func allowedIdentities(allowed map[string][]string, fullMethod string) ([]string, bool) {
+	if ids, ok := allowed[fullMethod]; ok {
+		return ids, true
+	}
+
+	if i := strings.LastIndex(fullMethod, "/"); i >= 0 {
+		if ids, ok := allowed[fullMethod[:i+1]+"*"]; ok {
+			return ids, true
+		}
+	}
+
+	return nil, false
+}
+
+// peerIdentities returns identities of the verified client certificate,
+// if the peer presented one.
+func peerIdentities(ctx context.Context) []string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(tlsInfo.State.VerifiedChains) == 0 ||
+		len(tlsInfo.State.VerifiedChains[0]) == 0 {
+		return nil
+	}
+
+	return certs.Identities(tlsInfo.State.VerifiedChains[0][0])
+}
@@ -0,0 +1,109 @@
+package httpapp
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"xauth/internal/lib/logger/sl"
+)
+
+type App struct {
+	log             *slog.Logger
+	httpServer      *http.Server
+	port            int
+	shutdownTimeout time.Duration
+}
+
+// New creates an HTTP server serving handler.
+//
+// If tlsConfig is nil, the server listens on plain HTTP.
+func New(
+	log *slog.Logger,
+	handler http.Handler,
+	port int,
+	readTimeout time.Duration,
+	writeTimeout time.Duration,
+	shutdownTimeout time.Duration,
+	tlsConfig *tls.Config,
+) *App {
+	httpServer := &http.Server{
+		Addr:              fmt.Sprintf(":%d", port),
+		Handler:           handler,
+		ReadTimeout:       readTimeout,
+		ReadHeaderTimeout: readTimeout,
+		WriteTimeout:      writeTimeout,
+		TLSConfig:         tlsConfig,
+		ErrorLog:          slog.NewLogLogger(log.Handler(), slog.LevelError),
+	}
+
+	return &App{
+		log:             log,
+		httpServer:      httpServer,
+		port:            port,
+		shutdownTimeout: shutdownTimeout,
+	}
+}
+
+// MustRun runs HTTP server and panics if any error occurs.
+func (a *App) MustRun() {
+	if err := a.Run(); err != nil {
+		panic(err)
+	}
+}
+
+// Run runs HTTP server.
+func (a *App) Run() error {
+	const op = "httpapp.Run"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int("port", a.port),
+	)
+
+	l, err := net.Listen("tcp", a.httpServer.Addr)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("http server is running",
+		slog.String("address", l.Addr().String()),
+		slog.Bool("tls", a.httpServer.TLSConfig != nil),
+	)
+
+	if a.httpServer.TLSConfig != nil {
+		err = a.httpServer.ServeTLS(l, "", "")
+	} else {
+		err = a.httpServer.Serve(l)
+	}
+
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// Stop gracefully stops HTTP server, waiting up to the shutdown timeout
+// for in-flight requests.
+func (a *App) Stop() {
+	const op = "httpapp.Stop"
+
+	log := a.log.With(slog.String("op", op))
+
+	log.Info("stopping HTTP server")
+
+	ctx, cancel := context.WithTimeout(context.Background(), a.shutdownTimeout)
+	defer cancel()
+
+	if err := a.httpServer.Shutdown(ctx); err != nil {
+		log.Error("failed to stop HTTP server gracefully", sl.Err(err))
+
+		_ = a.httpServer.Close()
+	}
+}
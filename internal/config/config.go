@@ -2,26 +2,200 @@ package config
 
 import (
 	"flag"
+	"fmt"
+	"log/slog"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/ilyakaznacheev/cleanenv"
 )
 
 type Config struct {
-	Env         string        `yaml:"env" env-default:"local"`
-	StoragePath string        `yaml:"storage_path" env-required:"true"`
-	TokenTTL    time.Duration `yaml:"token_ttl" env-required:"true"`
-	GRPC        GRPCConfig    `yaml:"grpc"`
+	Env string `yaml:"env" env:"XAUTH_ENV" env-default:"local"`
+	// LogLevel is one of debug, info, warn and error. If empty, it is debug
+	// for the local and dev environments and info for prod.
+	LogLevel string `yaml:"log_level" env:"XAUTH_LOG_LEVEL"`
+	// StoragePath is the SQLite database path. StoragePathFile names a file
+	// to read it from instead, for DSNs mounted as secrets.
+	StoragePath     Secret        `yaml:"storage_path" env:"XAUTH_STORAGE_PATH"`
+	StoragePathFile string        `yaml:"storage_path_file" env:"XAUTH_STORAGE_PATH_FILE"`
+	TokenTTL        time.Duration `yaml:"token_ttl" env:"XAUTH_TOKEN_TTL" env-required:"true"`
+	// AutoMigrate applies pending embedded migrations up to
+	// health.schema_version on start.
+	AutoMigrate  bool               `yaml:"auto_migrate" env:"XAUTH_AUTO_MIGRATE"`
+	SQLite       SQLiteConfig       `yaml:"sqlite" env-prefix:"XAUTH_SQLITE_"`
+	Backup       BackupConfig       `yaml:"backup" env-prefix:"XAUTH_BACKUP_"`
+	GRPC         GRPCConfig         `yaml:"grpc" env-prefix:"XAUTH_GRPC_"`
+	HTTP         HTTPConfig         `yaml:"http" env-prefix:"XAUTH_HTTP_"`
+	OAuth        OAuthConfig        `yaml:"oauth" env-prefix:"XAUTH_OAUTH_"`
+	OIDC         OIDCConfig         `yaml:"oidc" env-prefix:"XAUTH_OIDC_"`
+	APIKeys      APIKeysConfig      `yaml:"api_keys" env-prefix:"XAUTH_API_KEYS_"`
+	Orgs         OrgsConfig         `yaml:"orgs" env-prefix:"XAUTH_ORGS_"`
+	Registration RegistrationConfig `yaml:"registration" env-prefix:"XAUTH_REGISTRATION_"`
+	Hashing      HashingConfig      `yaml:"hashing" env-prefix:"XAUTH_HASHING_"`
+	Metrics      MetricsConfig      `yaml:"metrics" env-prefix:"XAUTH_METRICS_"`
+	Tracing      TracingConfig      `yaml:"tracing" env-prefix:"XAUTH_TRACING_"`
+	Health       HealthConfig       `yaml:"health" env-prefix:"XAUTH_HEALTH_"`
 }
 
 type GRPCConfig struct {
-	Port    int           `yaml:"port"`
-	Timeout time.Duration `yaml:"timeout"`
+	Port    int           `yaml:"port" env:"PORT"`
+	Timeout time.Duration `yaml:"timeout" env:"TIMEOUT"`
+	TLS     TLSConfig     `yaml:"tls" env-prefix:"TLS_"`
+}
+
+type TLSConfig struct {
+	Enabled        bool          `yaml:"enabled" env:"ENABLED"`
+	CertFile       string        `yaml:"cert_file" env:"CERT_FILE"`
+	KeyFile        string        `yaml:"key_file" env:"KEY_FILE"`
+	ClientCAFile   string        `yaml:"client_ca_file" env:"CLIENT_CA_FILE"` // enables mutual TLS
+	ReloadInterval time.Duration `yaml:"reload_interval" env:"RELOAD_INTERVAL" env-default:"1m"`
+	// AllowedIdentities maps a full method name ("/auth.Auth/IsAdmin") or a
+	// whole service ("/auth.Auth/*") to client certificate identities
+	// (common name, DNS or URI SAN) allowed to call it. Requires mutual TLS.
+	AllowedIdentities map[string][]string `yaml:"allowed_identities"`
+}
+
+type HTTPConfig struct {
+	Enabled         bool          `yaml:"enabled" env:"ENABLED"`
+	Port            int           `yaml:"port" env:"PORT" env-default:"8080"`
+	ReadTimeout     time.Duration `yaml:"read_timeout" env:"READ_TIMEOUT" env-default:"10s"`
+	WriteTimeout    time.Duration `yaml:"write_timeout" env:"WRITE_TIMEOUT" env-default:"10s"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT" env-default:"10s"`
+	// TLS serves HTTPS with the certificate from grpc.tls. Client
+	// certificates are not requested.
+	TLS bool `yaml:"tls" env:"TLS"`
+}
+
+type OAuthConfig struct {
+	CodeTTL time.Duration `yaml:"code_ttl" env:"CODE_TTL" env-default:"1m"`
+	// ClientTokenTTL is the lifetime of tokens issued by the client
+	// credentials grant.
+	ClientTokenTTL time.Duration `yaml:"client_token_ttl" env:"CLIENT_TOKEN_TTL" env-default:"1h"`
+}
+
+type OIDCConfig struct {
+	// Issuer is the public base URL of the HTTP server. It is the "iss"
+	// claim of ID tokens and the base of the discovery document URLs.
+	Issuer string `yaml:"issuer" env:"ISSUER" env-default:"http://localhost:8080"`
+	// SigningKeyFile is a PEM encoded RSA private key for ID tokens. If
+	// empty, a new key is generated on every start.
+	SigningKeyFile string `yaml:"signing_key_file" env:"SIGNING_KEY_FILE"`
+	// Upstreams are identity providers users can sign in with instead of
+	// a password, keyed by a name used in URLs. Being a map, it can't be
+	// set from the environment as a whole; see upstreamsFromEnv.
+	Upstreams map[string]UpstreamConfig `yaml:"upstreams"`
+	// UpstreamLoginTTL is how long a user may take to sign in upstream.
+	UpstreamLoginTTL time.Duration `yaml:"upstream_login_ttl" env:"UPSTREAM_LOGIN_TTL" env-default:"10m"`
+}
+
+type UpstreamConfig struct {
+	Issuer       string `yaml:"issuer"`
+	ClientID     string `yaml:"client_id"`
+	ClientSecret Secret `yaml:"client_secret"`
+	// ClientSecretFile names a file to read ClientSecret from.
+	ClientSecretFile string   `yaml:"client_secret_file"`
+	Scopes           []string `yaml:"scopes"` // default: openid email profile
+	// AllowSignup creates a local user on first sign-in. The provider must
+	// then return a verified email.
+	AllowSignup bool `yaml:"allow_signup"`
+}
+
+type APIKeysConfig struct {
+	// DefaultTTL applies if a key is created without expires_in.
+	DefaultTTL time.Duration `yaml:"default_ttl" env:"DEFAULT_TTL" env-default:"2160h"`
+	MaxTTL     time.Duration `yaml:"max_ttl" env:"MAX_TTL" env-default:"8760h"`
+}
+
+type OrgsConfig struct {
+	InvitationTTL time.Duration `yaml:"invitation_ttl" env:"INVITATION_TTL" env-default:"168h"`
+}
+
+type RegistrationConfig struct {
+	// EnumerationSafe makes Register succeed for emails and usernames that
+	// are taken, without returning the user ID, and notifies their owners
+	// instead, so that it doesn't tell who has an account.
+	EnumerationSafe bool `yaml:"enumeration_safe" env:"ENUMERATION_SAFE"`
+	// NotifyURL receives a JSON POST per notification, for delivering it
+	// to the user by email or otherwise. If empty, attempts are only
+	// recorded in the audit log.
+	NotifyURL string `yaml:"notify_url" env:"NOTIFY_URL"`
+}
+
+// SQLiteConfig configures the connections to the database.
+type SQLiteConfig struct {
+	// JournalMode is one of delete, truncate, persist, memory, wal and off.
+	JournalMode string `yaml:"journal_mode" env:"JOURNAL_MODE" env-default:"wal"`
+	// ForeignKeys enforces the foreign keys declared by the schema. It is
+	// off by default, as databases written without it may hold rows that
+	// violate them; PRAGMA foreign_key_check lists them.
+	ForeignKeys bool `yaml:"foreign_keys" env:"FOREIGN_KEYS"`
+	// BusyTimeout is how long a query waits for another connection's lock
+	// before failing.
+	BusyTimeout time.Duration `yaml:"busy_timeout" env:"BUSY_TIMEOUT" env-default:"5s"`
+	// MaxOpenConns limits the connections to the database. In WAL mode
+	// they all read at once, but only one writes at a time. 0 means no
+	// limit.
+	MaxOpenConns int `yaml:"max_open_conns" env:"MAX_OPEN_CONNS" env-default:"16"`
+	// MaxIdleConns is how many connections are kept open while unused,
+	// with their prepared statements.
+	MaxIdleConns    int           `yaml:"max_idle_conns" env:"MAX_IDLE_CONNS" env-default:"16"`
+	ConnMaxIdleTime time.Duration `yaml:"conn_max_idle_time" env:"CONN_MAX_IDLE_TIME" env-default:"10m"`
+}
+
+// BackupConfig configures backups of the database, taken on request with
+// the CreateBackup RPC and periodically.
+type BackupConfig struct {
+	Dir string `yaml:"dir" env:"DIR" env-default:"./storage/backups"`
+	// Interval is how often a backup is taken. If 0, backups are only taken
+	// on request.
+	Interval time.Duration `yaml:"interval" env:"INTERVAL"`
+	// Keep is how many backups are kept: after each one, the oldest beyond
+	// it are deleted. 0 keeps all of them.
+	Keep     int  `yaml:"keep" env:"KEEP"`
+	Compress bool `yaml:"compress" env:"COMPRESS"`
+	// EncryptionKey encrypts backups with AES-256-GCM if set. It is 32
+	// bytes, base64-encoded, as made by "openssl rand -base64 32".
+	// EncryptionKeyFile names a file to read it from instead.
+	EncryptionKey     Secret `yaml:"encryption_key" env:"ENCRYPTION_KEY"`
+	EncryptionKeyFile string `yaml:"encryption_key_file" env:"ENCRYPTION_KEY_FILE"`
+}
+
+type HashingConfig struct {
+	// Workers is how many passwords are hashed at once. If 0, it is half
+	// the CPUs the service may use, and at least 1.
+	Workers int `yaml:"workers" env:"WORKERS"`
+	// QueueDepth is how many passwords may wait for a worker. Requests
+	// beyond it fail with codes.Unavailable.
+	QueueDepth int `yaml:"queue_depth" env:"QUEUE_DEPTH" env-default:"64"`
+}
+
+// MetricsConfig configures a separate HTTP server for metrics, so that
+// they aren't exposed with the public API.
+type MetricsConfig struct {
+	Enabled bool `yaml:"enabled" env:"ENABLED"`
+	Port    int  `yaml:"port" env:"PORT" env-default:"9090"`
+}
+
+type HealthConfig struct {
+	CheckInterval   time.Duration `yaml:"check_interval" env:"CHECK_INTERVAL" env-default:"10s"`
+	MigrationsTable string        `yaml:"migrations_table" env:"MIGRATIONS_TABLE" env-default:"migrations"`
+	SchemaVersion   uint          `yaml:"schema_version" env:"SCHEMA_VERSION" env-required:"true"`
+}
+
+type TracingConfig struct {
+	Enabled     bool    `yaml:"enabled" env:"ENABLED"`
+	ServiceName string  `yaml:"service_name" env:"SERVICE_NAME" env-default:"xauth"`
+	Exporter    string  `yaml:"exporter" env:"EXPORTER" env-default:"stdout"` // otlp, stdout, file
+	Endpoint    string  `yaml:"endpoint" env:"ENDPOINT" env-default:"localhost:4317"`
+	Insecure    bool    `yaml:"insecure" env:"INSECURE"`
+	FilePath    string  `yaml:"file_path" env:"FILE_PATH"`
+	SampleRatio float64 `yaml:"sample_ratio" env:"SAMPLE_RATIO" env-default:"1"`
 }
 
 func MustLoad() *Config {
-	path := fetchConfigPath()
+	path := Path()
 	if path == "" {
 		panic("config path is empty")
 	}
@@ -30,18 +204,62 @@ func MustLoad() *Config {
 }
 
 func MustLoadByPath(configPath string) *Config {
+	cfg, err := Load(configPath)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	return cfg
+}
+
+// Load reads the config file, applies environment variable overrides,
+// reads secrets from their *_file variants and validates the result.
+func Load(configPath string) (*Config, error) {
+	const op = "config.Load"
+
 	// check if file exists
 	if _, err := os.Stat(configPath); os.IsNotExist(err) {
-		panic("config file not found: " + configPath)
+		return nil, fmt.Errorf("%s: config file not found: %s", op, configPath)
 	}
 
 	var cfg Config
 
 	if err := cleanenv.ReadConfig(configPath, &cfg); err != nil {
-		panic("failed to read config: " + err.Error())
+		return nil, fmt.Errorf("%s: failed to read config: %w", op, err)
+	}
+
+	if err := upstreamsFromEnv(cfg.OIDC.Upstreams); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := cfg.readSecretFiles(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: invalid config:\n%w", op, err)
+	}
+
+	return &cfg, nil
+}
+
+// Path returns the config file path given on the command line or in the
+// environment, for reading the config again on reload.
+var Path = sync.OnceValue(fetchConfigPath)
+
+// Level returns the configured log level, or the default for the
+// environment.
+func (c *Config) Level() slog.Level {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.LogLevel)); err == nil {
+		return level
+	}
+
+	if c.Env == "prod" {
+		return slog.LevelInfo
 	}
 
-	return &cfg
+	return slog.LevelDebug
 }
 
 // fetchConfigPath fetches config path from command line flag to environment variable
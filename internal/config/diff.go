@@ -0,0 +1,54 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+)
+
+// reloadable are the fields applied on reload without a restart.
+var reloadable = map[string]bool{
+	"log_level":               true,
+	"token_ttl":               true,
+	"oauth.code_ttl":          true,
+	"oauth.client_token_ttl":  true,
+	"oidc.upstream_login_ttl": true,
+	"api_keys.default_ttl":    true,
+	"api_keys.max_ttl":        true,
+	"orgs.invitation_ttl":     true,
+}
+
+// Reloadable reports whether a change to the field at path, as returned by
+// Diff, takes effect on reload.
+func Reloadable(path string) bool {
+	return reloadable[path]
+}
+
+// Diff returns the YAML paths of the fields that differ between a and b,
+// such as "grpc.port". Maps and lists are compared as a whole. Values are
+// not returned, so secrets can't leak through it.
+func Diff(a, b *Config) []string {
+	return diff("", reflect.ValueOf(*a), reflect.ValueOf(*b))
+}
+
+func diff(prefix string, a, b reflect.Value) []string {
+	var paths []string
+
+	for i := 0; i < a.NumField(); i++ {
+		field := a.Type().Field(i)
+
+		name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		path := prefix + name
+
+		if field.Type.Kind() == reflect.Struct {
+			paths = append(paths, diff(path+".", a.Field(i), b.Field(i))...)
+
+			continue
+		}
+
+		if !reflect.DeepEqual(a.Field(i).Interface(), b.Field(i).Interface()) {
+			paths = append(paths, path)
+		}
+	}
+
+	return paths
+}
@@ -0,0 +1,115 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// Secret is a string that is redacted when logged or marshaled, so the
+// config can be logged as a whole.
+type Secret string
+
+const redacted = "[REDACTED]"
+
+func (s Secret) LogValue() slog.Value {
+	if s == "" {
+		return slog.StringValue("")
+	}
+
+	return slog.StringValue(redacted)
+}
+
+func (s Secret) MarshalJSON() ([]byte, error) {
+	if s == "" {
+		return json.Marshal("")
+	}
+
+	return json.Marshal(redacted)
+}
+
+// readSecretFiles fills secrets from their *_file variants. Setting both a
+// secret and its file is an error, as it's unclear which one is meant.
+func (c *Config) readSecretFiles() error {
+	var errs []error
+
+	if err := readSecretFile(&c.StoragePath, c.StoragePathFile, "storage_path"); err != nil {
+		errs = append(errs, err)
+	}
+
+	err := readSecretFile(&c.Backup.EncryptionKey, c.Backup.EncryptionKeyFile,
+		"backup.encryption_key")
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	for name, upstream := range c.OIDC.Upstreams {
+		err := readSecretFile(&upstream.ClientSecret, upstream.ClientSecretFile,
+			"oidc.upstreams."+name+".client_secret")
+		if err != nil {
+			errs = append(errs, err)
+		}
+
+		c.OIDC.Upstreams[name] = upstream
+	}
+
+	return errors.Join(errs...)
+}
+
+func readSecretFile(secret *Secret, path string, field string) error {
+	if path == "" {
+		return nil
+	}
+
+	if *secret != "" {
+		return fmt.Errorf("%s: set either %s or %s_file, not both", field, field, field)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("%s_file: %w", field, err)
+	}
+
+	// Files written by editors and secret managers often end with a newline.
+	*secret = Secret(strings.TrimRight(string(b), "\r\n"))
+
+	return nil
+}
+
+// upstreamsFromEnv applies XAUTH_OIDC_UPSTREAMS_<NAME>_<FIELD> environment
+// variables to the upstream providers configured in the file. NAME is the
+// provider name in upper case with dashes replaced by underscores.
+// Providers can't be added this way: the set of names comes from the file.
+func upstreamsFromEnv(upstreams map[string]UpstreamConfig) error {
+	for name, upstream := range upstreams {
+		prefix := "XAUTH_OIDC_UPSTREAMS_" +
+			strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+
+		for _, f := range []struct {
+			key string
+			set func(string)
+		}{
+			{"ISSUER", func(v string) { upstream.Issuer = v }},
+			{"CLIENT_ID", func(v string) { upstream.ClientID = v }},
+			{"CLIENT_SECRET", func(v string) { upstream.ClientSecret = Secret(v) }},
+			{"CLIENT_SECRET_FILE", func(v string) { upstream.ClientSecretFile = v }},
+			{"SCOPES", func(v string) { upstream.Scopes = strings.Split(v, ",") }},
+			{"ALLOW_SIGNUP", func(v string) { upstream.AllowSignup = v == "true" }},
+		} {
+			if v, ok := os.LookupEnv(prefix + f.key); ok {
+				if f.key == "ALLOW_SIGNUP" && v != "true" && v != "false" {
+					return fmt.Errorf("%s%s: must be true or false", prefix, f.key)
+				}
+
+				f.set(v)
+			}
+		}
+
+		upstreams[name] = upstream
+	}
+
+	return nil
+}
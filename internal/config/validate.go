@@ -0,0 +1,194 @@
+package config
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"maps"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+var (
+	envs      = []string{"local", "dev", "prod"}
+	logLevels = []string{"debug", "info", "warn", "error"}
+	exporters = []string{"otlp", "stdout", "file"}
+	journals  = []string{"delete", "truncate", "persist", "memory", "wal", "off"}
+)
+
+// Validate checks the config for values the service can't start with. All
+// problems are reported at once, one per line, prefixed with the field's
+// YAML path.
+func (c *Config) Validate() error {
+	var v validator
+
+	if !slices.Contains(envs, c.Env) {
+		v.addf("env", "must be one of %v, got %q", envs, c.Env)
+	}
+	if c.LogLevel != "" && !slices.Contains(logLevels, c.LogLevel) {
+		v.addf("log_level", "must be one of %v, got %q", logLevels, c.LogLevel)
+	}
+	if c.StoragePath == "" {
+		v.add("storage_path", "is required")
+	}
+	v.positive("token_ttl", c.TokenTTL)
+
+	if !slices.Contains(journals, strings.ToLower(c.SQLite.JournalMode)) {
+		v.addf("sqlite.journal_mode", "must be one of %v, got %q", journals, c.SQLite.JournalMode)
+	}
+	if c.SQLite.BusyTimeout < 0 {
+		v.addf("sqlite.busy_timeout", "must not be negative, got %s", c.SQLite.BusyTimeout)
+	}
+	if c.SQLite.MaxOpenConns < 0 {
+		v.addf("sqlite.max_open_conns", "must not be negative, got %d", c.SQLite.MaxOpenConns)
+	}
+	if c.SQLite.MaxIdleConns < 0 {
+		v.addf("sqlite.max_idle_conns", "must not be negative, got %d", c.SQLite.MaxIdleConns)
+	}
+	if c.SQLite.ConnMaxIdleTime < 0 {
+		v.addf("sqlite.conn_max_idle_time", "must not be negative, got %s",
+			c.SQLite.ConnMaxIdleTime)
+	}
+
+	if c.Backup.Interval < 0 {
+		v.addf("backup.interval", "must not be negative, got %s", c.Backup.Interval)
+	}
+	if c.Backup.Keep < 0 {
+		v.addf("backup.keep", "must not be negative, got %d", c.Backup.Keep)
+	}
+	if c.Backup.EncryptionKey != "" {
+		key, err := base64.StdEncoding.DecodeString(string(c.Backup.EncryptionKey))
+		if err != nil || len(key) != 32 {
+			v.add("backup.encryption_key", "must be 32 bytes, base64-encoded")
+		}
+	}
+
+	v.port("grpc.port", c.GRPC.Port)
+	v.positive("grpc.timeout", c.GRPC.Timeout)
+	if c.GRPC.TLS.Enabled {
+		if c.GRPC.TLS.CertFile == "" {
+			v.add("grpc.tls.cert_file", "is required when TLS is enabled")
+		}
+		if c.GRPC.TLS.KeyFile == "" {
+			v.add("grpc.tls.key_file", "is required when TLS is enabled")
+		}
+		v.positive("grpc.tls.reload_interval", c.GRPC.TLS.ReloadInterval)
+	}
+	if len(c.GRPC.TLS.AllowedIdentities) > 0 &&
+		(!c.GRPC.TLS.Enabled || c.GRPC.TLS.ClientCAFile == "") {
+		v.add("grpc.tls.allowed_identities", "requires mutual TLS (grpc.tls.client_ca_file)")
+	}
+
+	if c.HTTP.Enabled {
+		v.port("http.port", c.HTTP.Port)
+		if c.HTTP.Port == c.GRPC.Port {
+			v.addf("http.port", "must differ from grpc.port, both are %d", c.HTTP.Port)
+		}
+		v.positive("http.read_timeout", c.HTTP.ReadTimeout)
+		v.positive("http.write_timeout", c.HTTP.WriteTimeout)
+		v.positive("http.shutdown_timeout", c.HTTP.ShutdownTimeout)
+		if c.HTTP.TLS && !c.GRPC.TLS.Enabled {
+			v.add("http.tls", "requires grpc.tls.enabled")
+		}
+	}
+
+	v.positive("oauth.code_ttl", c.OAuth.CodeTTL)
+	v.positive("oauth.client_token_ttl", c.OAuth.ClientTokenTTL)
+
+	v.url("oidc.issuer", c.OIDC.Issuer)
+	v.positive("oidc.upstream_login_ttl", c.OIDC.UpstreamLoginTTL)
+	for _, name := range slices.Sorted(maps.Keys(c.OIDC.Upstreams)) {
+		upstream := c.OIDC.Upstreams[name]
+		field := "oidc.upstreams." + name
+
+		v.url(field+".issuer", upstream.Issuer)
+		if upstream.ClientID == "" {
+			v.add(field+".client_id", "is required")
+		}
+	}
+
+	v.positive("api_keys.default_ttl", c.APIKeys.DefaultTTL)
+	v.positive("api_keys.max_ttl", c.APIKeys.MaxTTL)
+	if c.APIKeys.MaxTTL < c.APIKeys.DefaultTTL {
+		v.addf("api_keys.max_ttl", "must not be less than api_keys.default_ttl (%s), got %s",
+			c.APIKeys.DefaultTTL, c.APIKeys.MaxTTL)
+	}
+
+	v.positive("orgs.invitation_ttl", c.Orgs.InvitationTTL)
+
+	if c.Registration.NotifyURL != "" {
+		v.url("registration.notify_url", c.Registration.NotifyURL)
+	}
+
+	if c.Hashing.Workers < 0 {
+		v.addf("hashing.workers", "must not be negative, got %d", c.Hashing.Workers)
+	}
+	if c.Hashing.QueueDepth < 0 {
+		v.addf("hashing.queue_depth", "must not be negative, got %d", c.Hashing.QueueDepth)
+	}
+
+	if c.Metrics.Enabled {
+		v.port("metrics.port", c.Metrics.Port)
+		if c.Metrics.Port == c.GRPC.Port ||
+			(c.HTTP.Enabled && c.Metrics.Port == c.HTTP.Port) {
+			v.addf("metrics.port", "must differ from grpc.port and http.port, got %d",
+				c.Metrics.Port)
+		}
+	}
+
+	v.positive("health.check_interval", c.Health.CheckInterval)
+	if c.Health.MigrationsTable == "" {
+		v.add("health.migrations_table", "is required")
+	}
+	if c.Health.SchemaVersion == 0 {
+		v.add("health.schema_version", "must be positive")
+	}
+
+	if c.Tracing.Enabled {
+		if !slices.Contains(exporters, c.Tracing.Exporter) {
+			v.addf("tracing.exporter", "must be one of %v, got %q", exporters, c.Tracing.Exporter)
+		}
+		if c.Tracing.Exporter == "file" && c.Tracing.FilePath == "" {
+			v.add("tracing.file_path", "is required for the file exporter")
+		}
+		if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+			v.addf("tracing.sample_ratio", "must be between 0 and 1, got %g", c.Tracing.SampleRatio)
+		}
+	}
+
+	return errors.Join(v.errs...)
+}
+
+// validator collects validation errors.
+type validator struct {
+	errs []error
+}
+
+func (v *validator) add(field, msg string) {
+	v.errs = append(v.errs, fmt.Errorf("%s: %s", field, msg))
+}
+
+func (v *validator) addf(field, format string, args ...any) {
+	v.add(field, fmt.Sprintf(format, args...))
+}
+
+func (v *validator) positive(field string, d time.Duration) {
+	if d <= 0 {
+		v.addf(field, "must be positive, got %s", d)
+	}
+}
+
+func (v *validator) port(field string, port int) {
+	if port < 1 || port > 65535 {
+		v.addf(field, "must be between 1 and 65535, got %d", port)
+	}
+}
+
+func (v *validator) url(field, raw string) {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		v.addf(field, "must be an absolute http(s) URL, got %q", raw)
+	}
+}
@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// APIKey is a personal access token a user creates for scripts and CI
+// jobs. Only a hash of the key itself is stored; Prefix is kept in clear
+// text to find the key and to tell keys apart in listings.
+type APIKey struct {
+	ID        int64
+	UserID    int64
+	Name      string
+	Prefix    string
+	KeyHash   []byte
+	Scopes    []string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
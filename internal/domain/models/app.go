@@ -4,4 +4,7 @@ type App struct {
 	ID     int
 	Name   string
 	Secret string
+	// AllowedScopes are scopes the app may request for its own
+	// (client credentials) tokens.
+	AllowedScopes []string
 }
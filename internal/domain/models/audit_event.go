@@ -0,0 +1,46 @@
+package models
+
+import "time"
+
+// Audit event actions.
+const (
+	AuditUserRegistered            = "user.registered"
+	AuditUserRegistrationAttempted = "user.registration_attempted"
+	AuditUserLogin                 = "user.login"
+	AuditUserLoginFailed           = "user.login_failed"
+	AuditUserSuspended             = "user.suspended"
+	AuditUserUnsuspended           = "user.unsuspended"
+	AuditUserAdminGranted          = "user.admin_granted"
+	AuditUserAdminRevoked          = "user.admin_revoked"
+	AuditUserSessionsRevoked       = "user.sessions_revoked"
+	AuditUserDataExported          = "user.data_exported"
+	AuditUserErased                = "user.erased"
+	AuditAppCreated                = "app.created"
+	AuditUsersImported             = "users.imported"
+	AuditBackupCreated             = "backup.created"
+)
+
+// AuditEvent records something that happened to a user or app. IDs are
+// zero when they don't apply; ActorID is zero for actions taken by the
+// user themselves or by an operator with direct database access.
+type AuditEvent struct {
+	ID        int64
+	CreatedAt time.Time
+	Action    string
+	ActorID   int64
+	UserID    int64
+	AppID     int
+	Details   map[string]string
+}
+
+// AuditFilter selects audit events, oldest first.
+type AuditFilter struct {
+	// AfterID skips events up to and including this ID, for paging and
+	// following new events.
+	AfterID int64
+	// UserID limits the events to one user if set.
+	UserID int64
+	Limit  int
+	// Newest selects the last Limit events instead of the first ones.
+	Newest bool
+}
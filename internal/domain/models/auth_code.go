@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+// AuthCode is an OAuth 2.0 authorization code issued to an app on behalf
+// of a user. Only a hash of the code itself is stored.
+type AuthCode struct {
+	CodeHash            []byte
+	AppID               int
+	UserID              int64
+	RedirectURI         string
+	Scope               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	// Nonce is the OpenID Connect nonce, echoed in the ID token.
+	Nonce string
+	// AuthTime is when the user signed in to get this code.
+	AuthTime  time.Time
+	ExpiresAt time.Time
+}
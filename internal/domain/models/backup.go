@@ -0,0 +1,10 @@
+package models
+
+import "time"
+
+// Backup is a snapshot of the database written to a file.
+type Backup struct {
+	Path      string
+	Size      int64
+	CreatedAt time.Time
+}
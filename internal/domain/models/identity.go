@@ -0,0 +1,37 @@
+package models
+
+import "time"
+
+// Identity links a user of an upstream identity provider to a local user.
+type Identity struct {
+	Provider string
+	// Subject is the "sub" claim of the upstream ID token, unique per
+	// provider.
+	Subject string
+	UserID  int64
+	Email   string
+	// CreatedAt is when the identity was linked. It is only set by
+	// listings.
+	CreatedAt time.Time
+}
+
+// FederationState is a login in progress at an upstream identity provider.
+// It holds everything needed to verify the provider's response and resume
+// the authorization request that started it. Only a hash of the state
+// parameter is stored.
+type FederationState struct {
+	StateHash    []byte
+	Provider     string
+	Nonce        string
+	CodeVerifier string
+	// Request is the authorization request of the app, resumed after the
+	// upstream login.
+	AppID               int
+	RedirectURI         string
+	Scope               string
+	ClientState         string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	ClientNonce         string
+	ExpiresAt           time.Time
+}
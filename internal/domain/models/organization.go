@@ -0,0 +1,67 @@
+package models
+
+import "time"
+
+// Role is a member's role in an organization.
+type Role string
+
+const (
+	RoleOwner  Role = "owner"
+	RoleAdmin  Role = "admin"
+	RoleMember Role = "member"
+)
+
+// Valid reports whether r is a known role.
+func (r Role) Valid() bool {
+	return r == RoleOwner || r == RoleAdmin || r == RoleMember
+}
+
+// AtLeast reports whether r grants everything other grants.
+func (r Role) AtLeast(other Role) bool {
+	return r.rank() >= other.rank()
+}
+
+func (r Role) rank() int {
+	switch r {
+	case RoleOwner:
+		return 3
+	case RoleAdmin:
+		return 2
+	case RoleMember:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// Organization is a tenant users can be members of.
+type Organization struct {
+	ID        int64
+	Name      string
+	CreatedAt time.Time
+}
+
+// Membership is a user's membership in an organization. OrgName, Email
+// and Username are filled in by listings for convenience.
+type Membership struct {
+	OrgID     int64
+	OrgName   string
+	UserID    int64
+	Email     string
+	Username  string
+	Role      Role
+	CreatedAt time.Time
+}
+
+// Invitation invites whoever owns Email to join an organization. Only a
+// hash of the invitation token is stored.
+type Invitation struct {
+	ID        int64
+	OrgID     int64
+	Email     string
+	Role      Role
+	TokenHash []byte
+	InvitedBy int64
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
@@ -1,9 +1,34 @@
 package models
 
+import "time"
+
 type User struct {
 	ID       int64
 	Email    string
 	PassHash []byte
-	Username string
-	IsAdmin  bool
+	// PassHashAlgo is the algorithm of PassHash: bcrypt, or one of the
+	// algorithms of hashes imported from other systems (see passhash).
+	PassHashAlgo string
+	Username     string
+	IsAdmin      bool
+	// SuspendedAt is zero unless the user is suspended.
+	SuspendedAt time.Time
+	// SessionsRevokedAt is when the user's sessions were last revoked:
+	// access tokens issued until then are no longer valid.
+	SessionsRevokedAt time.Time
+}
+
+// Suspended reports whether the user may not sign in.
+func (u User) Suspended() bool {
+	return !u.SuspendedAt.IsZero()
+}
+
+// LoginCollision is a user whose email or username has the same canonical
+// form as another user's, so the two can't be told apart when signing in.
+type LoginCollision struct {
+	UserID int64
+	// Field is "email" or "username".
+	Field string
+	// OtherID is the user the canonical form was given to.
+	OtherID int64
 }
@@ -0,0 +1,34 @@
+package account
+
+import (
+	"context"
+	"xauth/internal/lib/grpcjson"
+
+	"google.golang.org/grpc"
+)
+
+// Client calls the Account service. It implements AccountServer, so
+// callers can use the handlers in-process instead.
+type Client struct {
+	conn grpc.ClientConnInterface
+}
+
+var _ AccountServer = (*Client)(nil)
+
+func NewClient(conn grpc.ClientConnInterface) *Client {
+	return &Client{conn: conn}
+}
+
+func (c *Client) ExportMyData(
+	ctx context.Context, req *ExportMyDataRequest,
+) (*ExportMyDataResponse, error) {
+	return grpcjson.Invoke[ExportMyDataResponse](ctx, c.conn, ExportMyDataFullMethodName,
+		req)
+}
+
+func (c *Client) EraseAccount(
+	ctx context.Context, req *EraseAccountRequest,
+) (*EraseAccountResponse, error) {
+	return grpcjson.Invoke[EraseAccountResponse](ctx, c.conn, EraseAccountFullMethodName,
+		req)
+}
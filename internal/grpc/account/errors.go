@@ -0,0 +1,28 @@
+package account
+
+import (
+	"xauth/internal/lib/grpcerr"
+	"xauth/internal/services/account"
+
+	"google.golang.org/grpc/codes"
+)
+
+// Reasons are reported in google.rpc.ErrorInfo; unlike messages they are
+// stable, so clients may match on them.
+const (
+	ReasonUserNotFound = "USER_NOT_FOUND"
+	ReasonSoleOwner    = "SOLE_OWNER"
+)
+
+// serviceErrors maps errors of the account service to what clients are
+// told.
+var serviceErrors = []grpcerr.Mapping{
+	{Err: account.ErrUserNotFound, Code: codes.NotFound, Reason: ReasonUserNotFound},
+	{Err: account.ErrSoleOwner, Code: codes.FailedPrecondition, Reason: ReasonSoleOwner},
+}
+
+// toStatus translates an error returned by the account service into a
+// status error, see grpcerr.ToStatus.
+func toStatus(err error) error {
+	return grpcerr.ToStatus(err, serviceErrors)
+}
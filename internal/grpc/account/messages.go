@@ -0,0 +1,208 @@
+package account
+
+import (
+	"time"
+	"xauth/internal/domain/models"
+	"xauth/internal/services/account"
+)
+
+type ExportMyDataRequest struct {
+	UserID int64 `json:"user_id"`
+}
+
+func (r *ExportMyDataRequest) GetUserId() int64 {
+	return r.UserID
+}
+
+// ExportMyDataResponse is the document handed to the user. Field names
+// are part of the API: users and their tools read it.
+type ExportMyDataResponse struct {
+	ExportedAt  time.Time    `json:"exported_at"`
+	Profile     Profile      `json:"profile"`
+	Roles       Roles        `json:"roles"`
+	Identities  []Identity   `json:"linked_identities"`
+	Sessions    Sessions     `json:"sessions"`
+	Invitations []Invitation `json:"invitations"`
+	AuditEvents []AuditEvent `json:"audit_events"`
+}
+
+type Profile struct {
+	UserID      int64      `json:"user_id"`
+	Email       string     `json:"email"`
+	Username    string     `json:"username"`
+	SuspendedAt *time.Time `json:"suspended_at,omitempty"`
+}
+
+type Roles struct {
+	IsAdmin       bool         `json:"is_admin"`
+	Organizations []Membership `json:"organizations"`
+}
+
+type Membership struct {
+	OrgID    int64     `json:"org_id"`
+	OrgName  string    `json:"org_name"`
+	Role     string    `json:"role"`
+	JoinedAt time.Time `json:"joined_at"`
+}
+
+type Identity struct {
+	Provider string    `json:"provider"`
+	Subject  string    `json:"subject"`
+	Email    string    `json:"email,omitempty"`
+	LinkedAt time.Time `json:"linked_at"`
+}
+
+// Sessions describes how the user is signed in. Access tokens are not
+// stored; RevokedAt is when the ones issued before were invalidated.
+type Sessions struct {
+	RevokedAt          *time.Time          `json:"revoked_at,omitempty"`
+	APIKeys            []APIKey            `json:"api_keys"`
+	AuthorizationCodes []AuthorizationCode `json:"authorization_codes"`
+}
+
+type APIKey struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"name"`
+	Prefix    string    `json:"prefix"`
+	Scopes    []string  `json:"scopes,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type AuthorizationCode struct {
+	AppID       int       `json:"app_id"`
+	RedirectURI string    `json:"redirect_uri"`
+	Scope       string    `json:"scope,omitempty"`
+	AuthTime    time.Time `json:"auth_time"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+type Invitation struct {
+	OrgID     int64     `json:"org_id"`
+	Role      string    `json:"role"`
+	InvitedBy int64     `json:"invited_by"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type AuditEvent struct {
+	ID        int64             `json:"id"`
+	CreatedAt time.Time         `json:"created_at"`
+	Action    string            `json:"action"`
+	ActorID   int64             `json:"actor_id,omitempty"`
+	AppID     int               `json:"app_id,omitempty"`
+	Details   map[string]string `json:"details,omitempty"`
+}
+
+type EraseAccountRequest struct {
+	UserID int64 `json:"user_id"`
+}
+
+func (r *EraseAccountRequest) GetUserId() int64 {
+	return r.UserID
+}
+
+type EraseAccountResponse struct{}
+
+func toExport(data account.Data, exportedAt time.Time) *ExportMyDataResponse {
+	user := data.User
+
+	resp := &ExportMyDataResponse{
+		ExportedAt: exportedAt.UTC(),
+		Profile: Profile{
+			UserID:      user.ID,
+			Email:       user.Email,
+			Username:    user.Username,
+			SuspendedAt: timePtr(user.SuspendedAt),
+		},
+		Roles: Roles{
+			IsAdmin:       user.IsAdmin,
+			Organizations: make([]Membership, 0, len(data.Memberships)),
+		},
+		Identities: make([]Identity, 0, len(data.Identities)),
+		Sessions: Sessions{
+			RevokedAt:          timePtr(user.SessionsRevokedAt),
+			APIKeys:            make([]APIKey, 0, len(data.APIKeys)),
+			AuthorizationCodes: make([]AuthorizationCode, 0, len(data.AuthCodes)),
+		},
+		Invitations: make([]Invitation, 0, len(data.Invitations)),
+		AuditEvents: make([]AuditEvent, 0, len(data.AuditEvents)),
+	}
+
+	for _, m := range data.Memberships {
+		resp.Roles.Organizations = append(resp.Roles.Organizations, Membership{
+			OrgID:    m.OrgID,
+			OrgName:  m.OrgName,
+			Role:     string(m.Role),
+			JoinedAt: m.CreatedAt.UTC(),
+		})
+	}
+
+	for _, i := range data.Identities {
+		resp.Identities = append(resp.Identities, Identity{
+			Provider: i.Provider,
+			Subject:  i.Subject,
+			Email:    i.Email,
+			LinkedAt: i.CreatedAt.UTC(),
+		})
+	}
+
+	for _, k := range data.APIKeys {
+		resp.Sessions.APIKeys = append(resp.Sessions.APIKeys, APIKey{
+			ID:        k.ID,
+			Name:      k.Name,
+			Prefix:    k.Prefix,
+			Scopes:    k.Scopes,
+			CreatedAt: k.CreatedAt.UTC(),
+			ExpiresAt: k.ExpiresAt.UTC(),
+		})
+	}
+
+	for _, c := range data.AuthCodes {
+		resp.Sessions.AuthorizationCodes = append(resp.Sessions.AuthorizationCodes,
+			AuthorizationCode{
+				AppID:       c.AppID,
+				RedirectURI: c.RedirectURI,
+				Scope:       c.Scope,
+				AuthTime:    c.AuthTime.UTC(),
+				ExpiresAt:   c.ExpiresAt.UTC(),
+			})
+	}
+
+	for _, inv := range data.Invitations {
+		resp.Invitations = append(resp.Invitations, Invitation{
+			OrgID:     inv.OrgID,
+			Role:      string(inv.Role),
+			InvitedBy: inv.InvitedBy,
+			CreatedAt: inv.CreatedAt.UTC(),
+			ExpiresAt: inv.ExpiresAt.UTC(),
+		})
+	}
+
+	for _, e := range data.AuditEvents {
+		resp.AuditEvents = append(resp.AuditEvents, toAuditEvent(e))
+	}
+
+	return resp
+}
+
+func toAuditEvent(event models.AuditEvent) AuditEvent {
+	return AuditEvent{
+		ID:        event.ID,
+		CreatedAt: event.CreatedAt.UTC(),
+		Action:    event.Action,
+		ActorID:   event.ActorID,
+		AppID:     event.AppID,
+		Details:   event.Details,
+	}
+}
+
+func timePtr(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+
+	t = t.UTC()
+
+	return &t
+}
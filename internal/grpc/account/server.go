@@ -0,0 +1,111 @@
+// Package account serves the account service over gRPC as
+// xauth.account.v1.Account, next to the Auth service.
+package account
+
+import (
+	"context"
+	"time"
+	"xauth/internal/lib/caller"
+	"xauth/internal/lib/grpcerr"
+	"xauth/internal/lib/grpcjson"
+	"xauth/internal/services/account"
+
+	"google.golang.org/grpc"
+)
+
+const ServiceName = "xauth.account.v1.Account"
+
+const (
+	ExportMyDataFullMethodName = "/" + ServiceName + "/ExportMyData"
+	EraseAccountFullMethodName = "/" + ServiceName + "/EraseAccount"
+)
+
+type Account interface {
+	Export(ctx context.Context, actorID int64, userID int64) (account.Data, error)
+	Erase(ctx context.Context, actorID int64, userID int64) error
+}
+
+// AccountServer is the Account service API.
+type AccountServer interface {
+	ExportMyData(ctx context.Context, req *ExportMyDataRequest) (*ExportMyDataResponse, error)
+	EraseAccount(ctx context.Context, req *EraseAccountRequest) (*EraseAccountResponse, error)
+}
+
+type serverAPI struct {
+	account Account
+}
+
+// Register registers the Account service. Its methods are only reachable
+// through the server's access policies, which must require the user named
+// by the request or an admin.
+func Register(gRPC *grpc.Server, account Account) {
+	gRPC.RegisterService(&serviceDesc, &serverAPI{account: account})
+}
+
+// NewServerAPI returns the Account handlers without registering them, so
+// they can be called in-process. Nothing checks who the caller is.
+func NewServerAPI(account Account) AccountServer {
+	return &serverAPI{account: account}
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: ServiceName,
+	HandlerType: (*AccountServer)(nil),
+	Methods: []grpc.MethodDesc{
+		unary("ExportMyData", (*serverAPI).ExportMyData),
+		unary("EraseAccount", (*serverAPI).EraseAccount),
+	},
+}
+
+func unary[Req, Resp any](
+	name string,
+	call func(*serverAPI, context.Context, *Req) (*Resp, error),
+) grpc.MethodDesc {
+	return grpcjson.Unary(ServiceName, name, call)
+}
+
+func (s *serverAPI) ExportMyData(
+	ctx context.Context, req *ExportMyDataRequest,
+) (*ExportMyDataResponse, error) {
+	if err := validateUserID(req.UserID); err != nil {
+		return nil, err
+	}
+
+	data, err := s.account.Export(ctx, actorID(ctx), req.UserID)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	return toExport(data, time.Now()), nil
+}
+
+func (s *serverAPI) EraseAccount(
+	ctx context.Context, req *EraseAccountRequest,
+) (*EraseAccountResponse, error) {
+	if err := validateUserID(req.UserID); err != nil {
+		return nil, err
+	}
+
+	if err := s.account.Erase(ctx, actorID(ctx), req.UserID); err != nil {
+		return nil, toStatus(err)
+	}
+
+	return &EraseAccountResponse{}, nil
+}
+
+// actorID returns the user making the request, for the audit log.
+func actorID(ctx context.Context) int64 {
+	c, _ := caller.FromContext(ctx)
+
+	return c.UserID
+}
+
+func validateUserID(userID int64) error {
+	var v grpcerr.Violations
+
+	if userID == 0 {
+		v.Add("user_id", "user_id is required")
+	}
+
+	return v.Err()
+}
@@ -0,0 +1,77 @@
+package admin
+
+import (
+	"context"
+	"xauth/internal/lib/grpcjson"
+
+	"google.golang.org/grpc"
+)
+
+// Client calls the Admin service. It implements AdminServer, so callers
+// can use the handlers in-process instead.
+type Client struct {
+	conn grpc.ClientConnInterface
+}
+
+var _ AdminServer = (*Client)(nil)
+
+func NewClient(conn grpc.ClientConnInterface) *Client {
+	return &Client{conn: conn}
+}
+
+func (c *Client) CreateApp(
+	ctx context.Context, req *CreateAppRequest,
+) (*CreateAppResponse, error) {
+	return grpcjson.Invoke[CreateAppResponse](ctx, c.conn, CreateAppFullMethodName, req)
+}
+
+func (c *Client) ListApps(
+	ctx context.Context, req *ListAppsRequest,
+) (*ListAppsResponse, error) {
+	return grpcjson.Invoke[ListAppsResponse](ctx, c.conn, ListAppsFullMethodName, req)
+}
+
+func (c *Client) GetUser(
+	ctx context.Context, req *GetUserRequest,
+) (*GetUserResponse, error) {
+	return grpcjson.Invoke[GetUserResponse](ctx, c.conn, GetUserFullMethodName, req)
+}
+
+func (c *Client) SetSuspended(
+	ctx context.Context, req *SetSuspendedRequest,
+) (*Empty, error) {
+	return grpcjson.Invoke[Empty](ctx, c.conn, SetSuspendedFullMethodName, req)
+}
+
+func (c *Client) SetAdmin(
+	ctx context.Context, req *SetAdminRequest,
+) (*Empty, error) {
+	return grpcjson.Invoke[Empty](ctx, c.conn, SetAdminFullMethodName, req)
+}
+
+func (c *Client) RevokeSessions(
+	ctx context.Context, req *RevokeSessionsRequest,
+) (*Empty, error) {
+	return grpcjson.Invoke[Empty](ctx, c.conn, RevokeSessionsFullMethodName, req)
+}
+
+func (c *Client) ListAuditEvents(
+	ctx context.Context, req *ListAuditEventsRequest,
+) (*ListAuditEventsResponse, error) {
+	return grpcjson.Invoke[ListAuditEventsResponse](ctx, c.conn,
+		ListAuditEventsFullMethodName, req)
+}
+
+func (c *Client) ImportUsers(
+	ctx context.Context, req *ImportUsersRequest,
+) (*ImportUsersResponse, error) {
+	return grpcjson.Invoke[ImportUsersResponse](ctx, c.conn, ImportUsersFullMethodName,
+		req)
+}
+
+func (c *Client) CreateBackup(
+	ctx context.Context, req *CreateBackupRequest,
+) (*CreateBackupResponse, error) {
+	return grpcjson.Invoke[CreateBackupResponse](ctx, c.conn, CreateBackupFullMethodName,
+		req)
+}
@@ -0,0 +1,39 @@
+package admin
+
+import (
+	"xauth/internal/lib/grpcerr"
+	"xauth/internal/lib/passhash"
+	"xauth/internal/services/admin"
+
+	"google.golang.org/grpc/codes"
+)
+
+// Reasons are reported in google.rpc.ErrorInfo; unlike messages they are
+// stable, so clients may match on them.
+const (
+	ReasonAppExists       = "APP_EXISTS"
+	ReasonUserNotFound    = "USER_NOT_FOUND"
+	ReasonSelfSuspension  = "SELF_SUSPENSION"
+	ReasonSelfDemotion    = "SELF_DEMOTION"
+	ReasonBackupsDisabled = "BACKUPS_DISABLED"
+)
+
+// serviceErrors maps errors of the admin service to what clients are told.
+// Their messages are meant for operators and returned as they are.
+var serviceErrors = []grpcerr.Mapping{
+	{Err: admin.ErrInvalidName, Field: "name"},
+	{Err: admin.ErrInvalidRedirectURI, Field: "redirect_uris"},
+	{Err: admin.ErrInvalidScope, Field: "scopes"},
+	{Err: admin.ErrAppExists, Code: codes.AlreadyExists, Reason: ReasonAppExists},
+	{Err: admin.ErrUserNotFound, Code: codes.NotFound, Reason: ReasonUserNotFound},
+	{Err: admin.ErrImportTooLarge, Field: "users"},
+	{Err: passhash.ErrInvalidFormat, Field: "hash_format"},
+	{Err: admin.ErrBackupsDisabled, Code: codes.FailedPrecondition,
+		Reason: ReasonBackupsDisabled},
+}
+
+// toStatus translates an error returned by the admin service into a status
+// error, see grpcerr.ToStatus.
+func toStatus(err error) error {
+	return grpcerr.ToStatus(err, serviceErrors)
+}
@@ -0,0 +1,178 @@
+package admin
+
+import (
+	"time"
+	"xauth/internal/domain/models"
+)
+
+type App struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+	// Secret is only returned when the app is created.
+	Secret string   `json:"secret,omitempty"`
+	Scopes []string `json:"scopes,omitempty"`
+}
+
+type User struct {
+	ID                int64      `json:"id"`
+	Email             string     `json:"email"`
+	Username          string     `json:"username"`
+	IsAdmin           bool       `json:"is_admin"`
+	SuspendedAt       *time.Time `json:"suspended_at,omitempty"`
+	SessionsRevokedAt *time.Time `json:"sessions_revoked_at,omitempty"`
+}
+
+type AuditEvent struct {
+	ID        int64             `json:"id"`
+	CreatedAt time.Time         `json:"created_at"`
+	Action    string            `json:"action"`
+	ActorID   int64             `json:"actor_id,omitempty"`
+	UserID    int64             `json:"user_id,omitempty"`
+	AppID     int               `json:"app_id,omitempty"`
+	Details   map[string]string `json:"details,omitempty"`
+}
+
+type CreateAppRequest struct {
+	Name         string   `json:"name"`
+	RedirectURIs []string `json:"redirect_uris"`
+	Scopes       []string `json:"scopes"`
+}
+
+type CreateAppResponse struct {
+	App App `json:"app"`
+}
+
+type ListAppsRequest struct{}
+
+type ListAppsResponse struct {
+	Apps []App `json:"apps"`
+}
+
+// GetUserRequest names the user either by ID or by email or username.
+type GetUserRequest struct {
+	UserID int64  `json:"user_id,omitempty"`
+	Login  string `json:"login,omitempty"`
+}
+
+type GetUserResponse struct {
+	User User `json:"user"`
+}
+
+type SetSuspendedRequest struct {
+	UserID    int64 `json:"user_id"`
+	Suspended bool  `json:"suspended"`
+}
+
+type SetAdminRequest struct {
+	UserID  int64 `json:"user_id"`
+	IsAdmin bool  `json:"is_admin"`
+}
+
+type RevokeSessionsRequest struct {
+	UserID int64 `json:"user_id"`
+}
+
+type Empty struct{}
+
+// ListAuditEventsRequest pages through audit events oldest first: pass the
+// ID of the last event seen as AfterID to get the next ones. With Newest,
+// the last Limit events are returned instead of the first.
+type ListAuditEventsRequest struct {
+	AfterID int64 `json:"after_id,omitempty"`
+	UserID  int64 `json:"user_id,omitempty"`
+	Limit   int   `json:"limit,omitempty"`
+	Newest  bool  `json:"newest,omitempty"`
+}
+
+type ListAuditEventsResponse struct {
+	Events []AuditEvent `json:"events"`
+}
+
+// ImportUser is a user exported from another system. PasswordHash is in
+// bcrypt, PHC or LDAP form, or a bare digest going with Salt if the import
+// has a hash format.
+type ImportUser struct {
+	Email        string `json:"email"`
+	Username     string `json:"username"`
+	PasswordHash string `json:"password_hash"`
+	Salt         string `json:"salt,omitempty"`
+}
+
+type ImportUsersRequest struct {
+	Users      []ImportUser `json:"users"`
+	HashFormat string       `json:"hash_format,omitempty"`
+	DryRun     bool         `json:"dry_run,omitempty"`
+}
+
+// ImportUsersResponse refers to users by their index in the request.
+type ImportUsersResponse struct {
+	Imported int               `json:"imported"`
+	Existing []int             `json:"existing,omitempty"`
+	Rejected []ImportRejection `json:"rejected,omitempty"`
+}
+
+type ImportRejection struct {
+	Index  int    `json:"index"`
+	Reason string `json:"reason"`
+}
+
+// Backup is a file on the server holding a snapshot of its database.
+type Backup struct {
+	Path      string    `json:"path"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type CreateBackupRequest struct{}
+
+type CreateBackupResponse struct {
+	Backup Backup `json:"backup"`
+}
+
+func toApp(app models.App) App {
+	return App{
+		ID:     app.ID,
+		Name:   app.Name,
+		Secret: app.Secret,
+		Scopes: app.AllowedScopes,
+	}
+}
+
+func toUser(user models.User) User {
+	return User{
+		ID:                user.ID,
+		Email:             user.Email,
+		Username:          user.Username,
+		IsAdmin:           user.IsAdmin,
+		SuspendedAt:       timePtr(user.SuspendedAt),
+		SessionsRevokedAt: timePtr(user.SessionsRevokedAt),
+	}
+}
+
+func toAuditEvent(event models.AuditEvent) AuditEvent {
+	return AuditEvent{
+		ID:        event.ID,
+		CreatedAt: event.CreatedAt,
+		Action:    event.Action,
+		ActorID:   event.ActorID,
+		UserID:    event.UserID,
+		AppID:     event.AppID,
+		Details:   event.Details,
+	}
+}
+
+func timePtr(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+
+	return &t
+}
+
+func toBackup(backup models.Backup) Backup {
+	return Backup{
+		Path:      backup.Path,
+		Size:      backup.Size,
+		CreatedAt: backup.CreatedAt,
+	}
+}
@@ -0,0 +1,318 @@
+// Package admin serves the admin service over gRPC as
+// xauth.admin.v1.Admin, next to the Auth service.
+package admin
+
+import (
+	"context"
+	"xauth/internal/domain/models"
+	"xauth/internal/lib/caller"
+	"xauth/internal/lib/grpcerr"
+	"xauth/internal/lib/grpcjson"
+	"xauth/internal/lib/passhash"
+	"xauth/internal/services/admin"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+const ServiceName = "xauth.admin.v1.Admin"
+
+const (
+	CreateAppFullMethodName       = "/" + ServiceName + "/CreateApp"
+	ListAppsFullMethodName        = "/" + ServiceName + "/ListApps"
+	GetUserFullMethodName         = "/" + ServiceName + "/GetUser"
+	SetSuspendedFullMethodName    = "/" + ServiceName + "/SetSuspended"
+	SetAdminFullMethodName        = "/" + ServiceName + "/SetAdmin"
+	RevokeSessionsFullMethodName  = "/" + ServiceName + "/RevokeSessions"
+	ListAuditEventsFullMethodName = "/" + ServiceName + "/ListAuditEvents"
+	ImportUsersFullMethodName     = "/" + ServiceName + "/ImportUsers"
+	CreateBackupFullMethodName    = "/" + ServiceName + "/CreateBackup"
+)
+
+type Admin interface {
+	CreateApp(
+		ctx context.Context,
+		actorID int64,
+		name string,
+		redirectURIs []string,
+		scopes []string,
+	) (models.App, error)
+	Apps(ctx context.Context) ([]models.App, error)
+	User(ctx context.Context, userID int64) (models.User, error)
+	FindUser(ctx context.Context, login string) (models.User, error)
+	SetSuspended(ctx context.Context, actorID int64, userID int64, suspended bool) error
+	SetAdmin(ctx context.Context, actorID int64, userID int64, isAdmin bool) error
+	RevokeSessions(ctx context.Context, actorID int64, userID int64) error
+	AuditEvents(ctx context.Context, filter models.AuditFilter) ([]models.AuditEvent, error)
+	ImportUsers(
+		ctx context.Context,
+		actorID int64,
+		users []admin.ImportUser,
+		hashFormat string,
+		dryRun bool,
+	) (admin.ImportResult, error)
+	Backup(ctx context.Context, actorID int64) (models.Backup, error)
+}
+
+// AdminServer is the Admin service API.
+type AdminServer interface {
+	CreateApp(ctx context.Context, req *CreateAppRequest) (*CreateAppResponse, error)
+	ListApps(ctx context.Context, req *ListAppsRequest) (*ListAppsResponse, error)
+	GetUser(ctx context.Context, req *GetUserRequest) (*GetUserResponse, error)
+	SetSuspended(ctx context.Context, req *SetSuspendedRequest) (*Empty, error)
+	SetAdmin(ctx context.Context, req *SetAdminRequest) (*Empty, error)
+	RevokeSessions(ctx context.Context, req *RevokeSessionsRequest) (*Empty, error)
+	ListAuditEvents(
+		ctx context.Context,
+		req *ListAuditEventsRequest,
+	) (*ListAuditEventsResponse, error)
+	ImportUsers(ctx context.Context, req *ImportUsersRequest) (*ImportUsersResponse, error)
+	CreateBackup(ctx context.Context, req *CreateBackupRequest) (*CreateBackupResponse, error)
+}
+
+type serverAPI struct {
+	admin Admin
+}
+
+// Register registers the Admin service. Its methods are only reachable
+// through the server's access policies, which must require an admin.
+func Register(gRPC *grpc.Server, admin Admin) {
+	gRPC.RegisterService(&serviceDesc, &serverAPI{admin: admin})
+}
+
+// NewServerAPI returns the Admin handlers without registering them, so
+// they can be called in-process. Nothing checks that the caller is an
+// admin; actions are recorded with the caller from the context, if any.
+func NewServerAPI(admin Admin) AdminServer {
+	return &serverAPI{admin: admin}
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: ServiceName,
+	HandlerType: (*AdminServer)(nil),
+	Methods: []grpc.MethodDesc{
+		unary("CreateApp", (*serverAPI).CreateApp),
+		unary("ListApps", (*serverAPI).ListApps),
+		unary("GetUser", (*serverAPI).GetUser),
+		unary("SetSuspended", (*serverAPI).SetSuspended),
+		unary("SetAdmin", (*serverAPI).SetAdmin),
+		unary("RevokeSessions", (*serverAPI).RevokeSessions),
+		unary("ListAuditEvents", (*serverAPI).ListAuditEvents),
+		unary("ImportUsers", (*serverAPI).ImportUsers),
+		unary("CreateBackup", (*serverAPI).CreateBackup),
+	},
+}
+
+func unary[Req, Resp any](
+	name string,
+	call func(*serverAPI, context.Context, *Req) (*Resp, error),
+) grpc.MethodDesc {
+	return grpcjson.Unary(ServiceName, name, call)
+}
+
+func (s *serverAPI) CreateApp(
+	ctx context.Context, req *CreateAppRequest,
+) (*CreateAppResponse, error) {
+	app, err := s.admin.CreateApp(ctx, actorID(ctx), req.Name, req.RedirectURIs,
+		req.Scopes)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	return &CreateAppResponse{App: toApp(app)}, nil
+}
+
+func (s *serverAPI) ListApps(
+	ctx context.Context, _ *ListAppsRequest,
+) (*ListAppsResponse, error) {
+	apps, err := s.admin.Apps(ctx)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	resp := &ListAppsResponse{Apps: make([]App, 0, len(apps))}
+	for _, app := range apps {
+		app.Secret = ""
+		resp.Apps = append(resp.Apps, toApp(app))
+	}
+
+	return resp, nil
+}
+
+func (s *serverAPI) GetUser(
+	ctx context.Context, req *GetUserRequest,
+) (*GetUserResponse, error) {
+	var user models.User
+	var err error
+
+	switch {
+	case req.UserID != 0 && req.Login != "":
+		return nil, violation("login", "user_id and login are mutually exclusive")
+	case req.UserID != 0:
+		user, err = s.admin.User(ctx, req.UserID)
+	case req.Login != "":
+		user, err = s.admin.FindUser(ctx, req.Login)
+	default:
+		return nil, violation("user_id", "user_id or login is required")
+	}
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	return &GetUserResponse{User: toUser(user)}, nil
+}
+
+func (s *serverAPI) SetSuspended(
+	ctx context.Context, req *SetSuspendedRequest,
+) (*Empty, error) {
+	if req.UserID == 0 {
+		return nil, violation("user_id", "user_id is required")
+	}
+
+	actor := actorID(ctx)
+	if req.Suspended && req.UserID == actor {
+		return nil, grpcerr.New(codes.FailedPrecondition, ReasonSelfSuspension,
+			"admins can't suspend themselves")
+	}
+
+	if err := s.admin.SetSuspended(ctx, actor, req.UserID, req.Suspended); err != nil {
+		return nil, toStatus(err)
+	}
+
+	return &Empty{}, nil
+}
+
+func (s *serverAPI) SetAdmin(
+	ctx context.Context, req *SetAdminRequest,
+) (*Empty, error) {
+	if req.UserID == 0 {
+		return nil, violation("user_id", "user_id is required")
+	}
+
+	actor := actorID(ctx)
+	if !req.IsAdmin && req.UserID == actor {
+		return nil, grpcerr.New(codes.FailedPrecondition, ReasonSelfDemotion,
+			"admins can't revoke their own admin rights")
+	}
+
+	if err := s.admin.SetAdmin(ctx, actor, req.UserID, req.IsAdmin); err != nil {
+		return nil, toStatus(err)
+	}
+
+	return &Empty{}, nil
+}
+
+func (s *serverAPI) RevokeSessions(
+	ctx context.Context, req *RevokeSessionsRequest,
+) (*Empty, error) {
+	if req.UserID == 0 {
+		return nil, violation("user_id", "user_id is required")
+	}
+
+	if err := s.admin.RevokeSessions(ctx, actorID(ctx), req.UserID); err != nil {
+		return nil, toStatus(err)
+	}
+
+	return &Empty{}, nil
+}
+
+func (s *serverAPI) ListAuditEvents(
+	ctx context.Context, req *ListAuditEventsRequest,
+) (*ListAuditEventsResponse, error) {
+	var v grpcerr.Violations
+	if req.AfterID < 0 {
+		v.Add("after_id", "after_id must not be negative")
+	}
+	if req.UserID < 0 {
+		v.Add("user_id", "user_id must not be negative")
+	}
+	if req.Limit < 0 {
+		v.Add("limit", "limit must not be negative")
+	}
+	if err := v.Err(); err != nil {
+		return nil, err
+	}
+
+	events, err := s.admin.AuditEvents(ctx, models.AuditFilter{
+		AfterID: req.AfterID,
+		UserID:  req.UserID,
+		Limit:   req.Limit,
+		Newest:  req.Newest,
+	})
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	resp := &ListAuditEventsResponse{Events: make([]AuditEvent, 0, len(events))}
+	for _, event := range events {
+		resp.Events = append(resp.Events, toAuditEvent(event))
+	}
+
+	return resp, nil
+}
+
+func (s *serverAPI) ImportUsers(
+	ctx context.Context, req *ImportUsersRequest,
+) (*ImportUsersResponse, error) {
+	if len(req.Users) > admin.MaxImportBatch {
+		return nil, toStatus(admin.ErrImportTooLarge)
+	}
+
+	if req.HashFormat != "" {
+		if _, err := passhash.ParseFormat(req.HashFormat); err != nil {
+			return nil, violation("hash_format", err.Error())
+		}
+	}
+
+	users := make([]admin.ImportUser, 0, len(req.Users))
+	for _, u := range req.Users {
+		users = append(users, admin.ImportUser{
+			Email:        u.Email,
+			Username:     u.Username,
+			PasswordHash: u.PasswordHash,
+			Salt:         u.Salt,
+		})
+	}
+
+	res, err := s.admin.ImportUsers(ctx, actorID(ctx), users, req.HashFormat, req.DryRun)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	resp := &ImportUsersResponse{
+		Imported: res.Imported,
+		Existing: res.Existing,
+		Rejected: make([]ImportRejection, 0, len(res.Rejected)),
+	}
+	for _, r := range res.Rejected {
+		resp.Rejected = append(resp.Rejected, ImportRejection{Index: r.Index, Reason: r.Reason})
+	}
+
+	return resp, nil
+}
+
+func (s *serverAPI) CreateBackup(
+	ctx context.Context, _ *CreateBackupRequest,
+) (*CreateBackupResponse, error) {
+	backup, err := s.admin.Backup(ctx, actorID(ctx))
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	return &CreateBackupResponse{Backup: toBackup(backup)}, nil
+}
+
+// actorID returns the user making the request, for the audit log.
+func actorID(ctx context.Context) int64 {
+	c, _ := caller.FromContext(ctx)
+
+	return c.UserID
+}
+
+// violation returns an InvalidArgument error about one request field.
+func violation(field string, description string) error {
+	var v grpcerr.Violations
+	v.Add(field, description)
+
+	return v.Err()
+}
@@ -0,0 +1,41 @@
+package auth
+
+import (
+	"xauth/internal/lib/grpcerr"
+	"xauth/internal/services/auth"
+
+	"google.golang.org/grpc/codes"
+)
+
+// Reasons are reported in google.rpc.ErrorInfo; unlike messages they are
+// stable, so clients may match on them.
+const (
+	ReasonInvalidCredentials = "INVALID_CREDENTIALS"
+	ReasonInvalidAppID       = "INVALID_APP_ID"
+	ReasonUserExists         = "USER_EXISTS"
+	ReasonUserNotFound       = "USER_NOT_FOUND"
+	ReasonUserSuspended      = "USER_SUSPENDED"
+	ReasonOverloaded         = "OVERLOADED"
+)
+
+// serviceErrors maps errors of the Auth service to what clients are told.
+var serviceErrors = []grpcerr.Mapping{
+	{Err: auth.ErrInvalidCredentials, Code: codes.InvalidArgument,
+		Reason: ReasonInvalidCredentials, Msg: "invalid email or password"},
+	{Err: auth.ErrInvalidAppID, Code: codes.InvalidArgument,
+		Reason: ReasonInvalidAppID, Msg: "invalid app_id"},
+	{Err: auth.ErrUserExists, Code: codes.AlreadyExists,
+		Reason: ReasonUserExists, Msg: "user already exists"},
+	{Err: auth.ErrUserNotFound, Code: codes.NotFound,
+		Reason: ReasonUserNotFound, Msg: "user not found"},
+	{Err: auth.ErrUserSuspended, Code: codes.PermissionDenied,
+		Reason: ReasonUserSuspended, Msg: "user is suspended"},
+	{Err: auth.ErrOverloaded, Code: codes.Unavailable,
+		Reason: ReasonOverloaded, Msg: "too many sign-ins in progress, try again later"},
+}
+
+// toStatus translates an error returned by the Auth service into a status
+// error, see grpcerr.ToStatus.
+func toStatus(err error) error {
+	return grpcerr.ToStatus(err, serviceErrors)
+}
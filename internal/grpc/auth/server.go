@@ -2,14 +2,12 @@ package auth
 
 import (
 	"context"
-	"errors"
 	"xauth/internal/domain/models"
-	"xauth/internal/services/auth"
+	"xauth/internal/lib/grpcerr"
+	"xauth/internal/lib/identifier"
 
 	ssov1 "github.com/memxire/protobuf/gen/go/sso"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/status"
 )
 
 type Auth interface {
@@ -34,7 +32,13 @@ type serverAPI struct {
 }
 
 func Register(gRPC *grpc.Server, auth Auth) {
-	ssov1.RegisterAuthServer(gRPC, &serverAPI{auth: auth})
+	ssov1.RegisterAuthServer(gRPC, NewServerAPI(auth))
+}
+
+// NewServerAPI returns the Auth gRPC handlers without registering them,
+// so other transports (such as the HTTP gateway) can call them in-process.
+func NewServerAPI(auth Auth) ssov1.AuthServer {
+	return &serverAPI{auth: auth}
 }
 
 const (
@@ -56,12 +60,7 @@ func (s *serverAPI) Login(
 	token, err := s.auth.Login(ctx, req.GetEmail(), req.GetPassword(),
 		int(req.GetAppId()), req.GetUsername())
 	if err != nil {
-		if errors.Is(err, auth.ErrInvalidCredentials) {
-			return nil, status.Error(codes.InvalidArgument,
-				"invalid email or password")
-		}
-
-		return nil, status.Error(codes.Internal, "failed to login")
+		return nil, toStatus(err)
 	}
 
 	return &ssov1.LoginResponse{
@@ -70,7 +69,8 @@ func (s *serverAPI) Login(
 }
 
 // Register registers new user in the system and returns user ID.
-// If user with given username or email already exists, returns error.
+// If user with given username or email already exists, returns error,
+// unless registration is enumeration-safe (see auth.New).
 func (s *serverAPI) Register(
 	ctx context.Context, req *ssov1.RegisterRequest,
 ) (*ssov1.RegisterResponse, error) {
@@ -81,11 +81,7 @@ func (s *serverAPI) Register(
 	userID, err := s.auth.RegisterNewUser(ctx, req.GetEmail(), req.GetPassword(),
 		req.GetUsername())
 	if err != nil {
-		if errors.Is(err, auth.ErrUserExists) {
-			return nil, status.Error(codes.AlreadyExists, "user already exists")
-		}
-
-		return nil, status.Error(codes.Internal, "internal error")
+		return nil, toStatus(err)
 	}
 
 	return &ssov1.RegisterResponse{
@@ -93,6 +89,9 @@ func (s *serverAPI) Register(
 	}, nil
 }
 
+// GetUser returns the user with the given ID.
+//
+// If user doesn't exist, returns error with codes.NotFound code.
 func (s *serverAPI) GetUser(
 	ctx context.Context, req *ssov1.GetUserRequest,
 ) (*ssov1.GetUserResponse, error) {
@@ -102,12 +101,7 @@ func (s *serverAPI) GetUser(
 
 	user, err := s.auth.GetUser(ctx, req.GetUserId())
 	if err != nil {
-		if errors.Is(err, auth.ErrInvalidCredentials) {
-			return nil, status.Error(codes.InvalidArgument,
-				"invalid user id")
-		}
-
-		return nil, status.Error(codes.Internal, "failed to get user by id")
+		return nil, toStatus(err)
 	}
 
 	return &ssov1.GetUserResponse{
@@ -120,7 +114,7 @@ func (s *serverAPI) GetUser(
 
 // IsAdmin checks if user is admin.
 //
-// If user doesn't exist, returns error.
+// If user doesn't exist, returns error with codes.NotFound code.
 func (s *serverAPI) IsAdmin(
 	ctx context.Context, req *ssov1.IsAdminRequest,
 ) (*ssov1.IsAdminResponse, error) {
@@ -130,11 +124,7 @@ func (s *serverAPI) IsAdmin(
 
 	isAdmin, err := s.auth.IsAdmin(ctx, req.GetUserId())
 	if err != nil {
-		if errors.Is(err, auth.ErrUserNotFound) {
-			return nil, status.Error(codes.NotFound, "app not found")
-		}
-
-		return nil, status.Error(codes.Internal, "internal error")
+		return nil, toStatus(err)
 	}
 
 	return &ssov1.IsAdminResponse{
@@ -143,46 +133,59 @@ func (s *serverAPI) IsAdmin(
 }
 
 func validateLogin(req *ssov1.LoginRequest) error {
+	var v grpcerr.Violations
+
+	if req.GetEmail() == "" && req.GetUsername() == "" {
+		v.Add("email", "email or username is required")
+	}
 
 	if req.GetPassword() == "" {
-		return status.Error(codes.InvalidArgument, "password is required")
+		v.Add("password", "password is required")
 	}
 
 	if req.GetAppId() == emptyValue {
-		return status.Error(codes.InvalidArgument, "app_id is required")
+		v.Add("app_id", "app_id is required")
 	}
 
-	return nil
+	return v.Err()
 }
 
 func validateRegister(req *ssov1.RegisterRequest) error {
+	var v grpcerr.Violations
+
 	if req.GetEmail() == "" {
-		return status.Error(codes.InvalidArgument, "email is required")
+		v.Add("email", "email is required")
 	}
 
 	if req.GetUsername() == "" {
-		return status.Error(codes.InvalidArgument, "username is required")
+		v.Add("username", "username is required")
+	} else if identifier.IsEmail(req.GetUsername()) {
+		v.Add("username", "username must not contain @")
 	}
 
 	if req.GetPassword() == "" {
-		return status.Error(codes.InvalidArgument, "password is required")
+		v.Add("password", "password is required")
 	}
 
-	return nil
+	return v.Err()
 }
 
 func validateUserID(req *ssov1.GetUserRequest) error {
+	var v grpcerr.Violations
+
 	if req.GetUserId() == emptyValue {
-		return status.Error(codes.InvalidArgument, "user id is required")
+		v.Add("user_id", "user_id is required")
 	}
 
-	return nil
+	return v.Err()
 }
 
 func validateIsAdmin(req *ssov1.IsAdminRequest) error {
+	var v grpcerr.Violations
+
 	if req.GetUserId() == emptyValue {
-		return status.Error(codes.InvalidArgument, "user_id is required")
+		v.Add("user_id", "user_id is required")
 	}
 
-	return nil
+	return v.Err()
 }
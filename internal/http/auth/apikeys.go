@@ -0,0 +1,197 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+	"xauth/internal/domain/models"
+	"xauth/internal/lib/logger/sl"
+	"xauth/internal/services/apikeys"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type APIKeys interface {
+	Create(
+		ctx context.Context,
+		userID int64,
+		name string,
+		scopes []string,
+		ttl time.Duration,
+	) (models.APIKey, string, error)
+	List(ctx context.Context, userID int64) ([]models.APIKey, error)
+	Revoke(ctx context.Context, userID int64, id int64) error
+}
+
+type apiKeysHandler struct {
+	*userAuth
+	apiKeys APIKeys
+}
+
+// RegisterAPIKeys registers endpoints for users to manage their own API
+// keys on mux. Requests must carry a user access token from Login; API
+// keys can't be used to create more keys.
+func RegisterAPIKeys(
+	mux *http.ServeMux,
+	log *slog.Logger,
+	apiKeys APIKeys,
+	tokens TokenValidator,
+) {
+	h := &apiKeysHandler{
+		userAuth: &userAuth{
+			gateway: &gateway{log: log},
+			tokens:  tokens,
+		},
+		apiKeys: apiKeys,
+	}
+
+	mux.HandleFunc("POST /v1/api-keys", h.create)
+	mux.HandleFunc("GET /v1/api-keys", h.list)
+	mux.HandleFunc("DELETE /v1/api-keys/{key_id}", h.revoke)
+}
+
+type createAPIKeyRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	// ExpiresIn is the lifetime in seconds; the server default if 0.
+	ExpiresIn int64 `json:"expires_in"`
+}
+
+type apiKeyResponse struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"name"`
+	Prefix    string    `json:"prefix"`
+	Scopes    []string  `json:"scopes"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type createAPIKeyResponse struct {
+	apiKeyResponse
+	// Key is only ever returned here.
+	Key string `json:"key"`
+}
+
+type listAPIKeysResponse struct {
+	APIKeys []apiKeyResponse `json:"api_keys"`
+}
+
+func (h *apiKeysHandler) create(w http.ResponseWriter, r *http.Request) {
+	const op = "auth.apiKeys.create"
+
+	userID, ok := h.authenticateUser(w, r)
+	if !ok {
+		return
+	}
+
+	var body createAPIKeyRequest
+	if !h.decode(w, r, &body) {
+		return
+	}
+
+	if body.ExpiresIn < 0 {
+		h.writeError(w, status.Error(codes.InvalidArgument, "expires_in must not be negative"))
+
+		return
+	}
+
+	key, secret, err := h.apiKeys.Create(r.Context(), userID, body.Name,
+		body.Scopes, time.Duration(body.ExpiresIn)*time.Second)
+	if err != nil {
+		switch {
+		case errors.Is(err, apikeys.ErrInvalidName):
+			h.writeError(w, status.Error(codes.InvalidArgument, apikeys.ErrInvalidName.Error()))
+		case errors.Is(err, apikeys.ErrInvalidScope):
+			h.writeError(w, status.Error(codes.InvalidArgument, apikeys.ErrInvalidScope.Error()))
+		case errors.Is(err, apikeys.ErrInvalidTTL):
+			h.writeError(w, status.Error(codes.InvalidArgument, "expires_in exceeds the maximum"))
+		case errors.Is(err, apikeys.ErrKeyExists):
+			h.writeError(w, status.Error(codes.AlreadyExists, apikeys.ErrKeyExists.Error()))
+		default:
+			h.log.Error("failed to create api key", slog.String("op", op), sl.Err(err))
+			h.writeError(w, status.Error(codes.Internal, "failed to create api key"))
+		}
+
+		return
+	}
+
+	h.writeJSON(w, http.StatusCreated, createAPIKeyResponse{
+		apiKeyResponse: toAPIKeyResponse(key),
+		Key:            secret,
+	})
+}
+
+func (h *apiKeysHandler) list(w http.ResponseWriter, r *http.Request) {
+	const op = "auth.apiKeys.list"
+
+	userID, ok := h.authenticateUser(w, r)
+	if !ok {
+		return
+	}
+
+	keys, err := h.apiKeys.List(r.Context(), userID)
+	if err != nil {
+		h.log.Error("failed to list api keys", slog.String("op", op), sl.Err(err))
+		h.writeError(w, status.Error(codes.Internal, "failed to list api keys"))
+
+		return
+	}
+
+	res := listAPIKeysResponse{APIKeys: make([]apiKeyResponse, 0, len(keys))}
+	for _, key := range keys {
+		res.APIKeys = append(res.APIKeys, toAPIKeyResponse(key))
+	}
+
+	h.writeJSON(w, http.StatusOK, res)
+}
+
+func (h *apiKeysHandler) revoke(w http.ResponseWriter, r *http.Request) {
+	const op = "auth.apiKeys.revoke"
+
+	userID, ok := h.authenticateUser(w, r)
+	if !ok {
+		return
+	}
+
+	keyID, err := strconv.ParseInt(r.PathValue("key_id"), 10, 64)
+	if err != nil {
+		h.writeError(w, status.Error(codes.InvalidArgument, "invalid key id"))
+
+		return
+	}
+
+	if err := h.apiKeys.Revoke(r.Context(), userID, keyID); err != nil {
+		if errors.Is(err, apikeys.ErrKeyNotFound) {
+			h.writeError(w, status.Error(codes.NotFound, apikeys.ErrKeyNotFound.Error()))
+
+			return
+		}
+
+		h.log.Error("failed to revoke api key", slog.String("op", op), sl.Err(err))
+		h.writeError(w, status.Error(codes.Internal, "failed to revoke api key"))
+
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func toAPIKeyResponse(key models.APIKey) apiKeyResponse {
+	scopes := key.Scopes
+	if scopes == nil {
+		scopes = []string{}
+	}
+
+	return apiKeyResponse{
+		ID:        key.ID,
+		Name:      key.Name,
+		Prefix:    key.Prefix,
+		Scopes:    scopes,
+		CreatedAt: key.CreatedAt.UTC(),
+		ExpiresAt: key.ExpiresAt.UTC(),
+	}
+}
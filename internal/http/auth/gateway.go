@@ -0,0 +1,397 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"xauth/internal/lib/logger/sl"
+	"xauth/internal/lib/requestid"
+
+	ssov1 "github.com/memxire/protobuf/gen/go/sso"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+const maxBodySize = 1 << 20
+
+// forwardedHeaders are copied from the HTTP request into incoming gRPC
+// metadata, so interceptors see the same values for both transports.
+var forwardedHeaders = []string{
+	"authorization",
+	"traceparent",
+	"tracestate",
+	"baggage",
+}
+
+type gateway struct {
+	log         *slog.Logger
+	api         ssov1.AuthServer
+	interceptor grpc.UnaryServerInterceptor
+}
+
+// Register registers JSON endpoints for the Auth API on mux.
+//
+// Every request is translated to the matching gRPC request and passed
+// through interceptor (if not nil) before reaching api, so validation,
+// tracing and error codes are identical to the gRPC transport.
+func Register(
+	mux *http.ServeMux,
+	log *slog.Logger,
+	api ssov1.AuthServer,
+	interceptor grpc.UnaryServerInterceptor,
+) {
+	g := &gateway{
+		log:         log,
+		api:         api,
+		interceptor: interceptor,
+	}
+
+	mux.HandleFunc("POST /v1/auth/register", g.register)
+	mux.HandleFunc("POST /v1/auth/login", g.login)
+	mux.HandleFunc("GET /v1/users/{user_id}", g.getUser)
+	mux.HandleFunc("GET /v1/users/{user_id}/is-admin", g.isAdmin)
+}
+
+type registerRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+	Username string `json:"username"`
+}
+
+type registerResponse struct {
+	UserID int64 `json:"user_id"`
+}
+
+func (g *gateway) register(w http.ResponseWriter, r *http.Request) {
+	var body registerRequest
+	if !g.decode(w, r, &body) {
+		return
+	}
+
+	req := &ssov1.RegisterRequest{
+		Email:    body.Email,
+		Password: body.Password,
+		Username: body.Username,
+	}
+
+	resp, err := g.invoke(w, r, ssov1.Auth_Register_FullMethodName, req,
+		func(ctx context.Context, req any) (any, error) {
+			return g.api.Register(ctx, req.(*ssov1.RegisterRequest))
+		})
+	if err != nil {
+		g.writeError(w, err)
+
+		return
+	}
+
+	g.writeJSON(w, http.StatusOK, registerResponse{
+		UserID: resp.(*ssov1.RegisterResponse).GetUserId(),
+	})
+}
+
+type loginRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+	AppID    int32  `json:"app_id"`
+	Username string `json:"username"`
+	// Identifier is an email or a username, whichever it looks like. It
+	// takes the place of both.
+	Identifier string `json:"identifier"`
+}
+
+type loginResponse struct {
+	Token string `json:"token"`
+}
+
+func (g *gateway) login(w http.ResponseWriter, r *http.Request) {
+	var body loginRequest
+	if !g.decode(w, r, &body) {
+		return
+	}
+
+	if body.Identifier != "" {
+		// The auth service treats the same email and username as one
+		// identifier.
+		body.Email, body.Username = body.Identifier, body.Identifier
+	}
+
+	req := &ssov1.LoginRequest{
+		Email:    body.Email,
+		Password: body.Password,
+		AppId:    body.AppID,
+		Username: body.Username,
+	}
+
+	resp, err := g.invoke(w, r, ssov1.Auth_Login_FullMethodName, req,
+		func(ctx context.Context, req any) (any, error) {
+			return g.api.Login(ctx, req.(*ssov1.LoginRequest))
+		})
+	if err != nil {
+		g.writeError(w, err)
+
+		return
+	}
+
+	g.writeJSON(w, http.StatusOK, loginResponse{
+		Token: resp.(*ssov1.LoginResponse).GetToken(),
+	})
+}
+
+type userResponse struct {
+	UserID   int64  `json:"user_id"`
+	Email    string `json:"email"`
+	Username string `json:"username"`
+	IsAdmin  bool   `json:"is_admin"`
+}
+
+func (g *gateway) getUser(w http.ResponseWriter, r *http.Request) {
+	userID, ok := g.pathUserID(w, r)
+	if !ok {
+		return
+	}
+
+	req := &ssov1.GetUserRequest{UserId: userID}
+
+	resp, err := g.invoke(w, r, ssov1.Auth_GetUser_FullMethodName, req,
+		func(ctx context.Context, req any) (any, error) {
+			return g.api.GetUser(ctx, req.(*ssov1.GetUserRequest))
+		})
+	if err != nil {
+		g.writeError(w, err)
+
+		return
+	}
+
+	user := resp.(*ssov1.GetUserResponse)
+
+	g.writeJSON(w, http.StatusOK, userResponse{
+		UserID:   user.GetUserId(),
+		Email:    user.GetEmail(),
+		Username: user.GetUsername(),
+		IsAdmin:  user.GetIsAdmin(),
+	})
+}
+
+type isAdminResponse struct {
+	IsAdmin bool `json:"is_admin"`
+}
+
+func (g *gateway) isAdmin(w http.ResponseWriter, r *http.Request) {
+	userID, ok := g.pathUserID(w, r)
+	if !ok {
+		return
+	}
+
+	req := &ssov1.IsAdminRequest{UserId: userID}
+
+	resp, err := g.invoke(w, r, ssov1.Auth_IsAdmin_FullMethodName, req,
+		func(ctx context.Context, req any) (any, error) {
+			return g.api.IsAdmin(ctx, req.(*ssov1.IsAdminRequest))
+		})
+	if err != nil {
+		g.writeError(w, err)
+
+		return
+	}
+
+	g.writeJSON(w, http.StatusOK, isAdminResponse{
+		IsAdmin: resp.(*ssov1.IsAdminResponse).GetIsAdmin(),
+	})
+}
+
+// invoke calls handler as if req arrived over gRPC as fullMethod.
+//
+// The request ID is settled here rather than by the interceptor, so it can
+// be returned in the X-Request-Id header.
+func (g *gateway) invoke(
+	w http.ResponseWriter,
+	r *http.Request,
+	fullMethod string,
+	req any,
+	handler grpc.UnaryHandler,
+) (any, error) {
+	id := r.Header.Get(requestid.Key)
+	if !requestid.Valid(id) {
+		id = requestid.New()
+	}
+
+	w.Header().Set(requestid.Key, id)
+
+	ctx := incomingContext(r, id)
+
+	if g.interceptor == nil {
+		return handler(ctx, req)
+	}
+
+	return g.interceptor(ctx, req, &grpc.UnaryServerInfo{
+		Server:     g.api,
+		FullMethod: fullMethod,
+	}, handler)
+}
+
+// incomingContext attaches forwarded headers and the request ID as
+// incoming gRPC metadata and the HTTP client address as the gRPC peer.
+func incomingContext(r *http.Request, requestID string) context.Context {
+	md := metadata.MD{}
+	for _, h := range forwardedHeaders {
+		if v := r.Header.Values(h); len(v) > 0 {
+			md.Set(h, v...)
+		}
+	}
+	md.Set(requestid.Key, requestID)
+
+	ctx := metadata.NewIncomingContext(r.Context(), md)
+
+	if addr, err := net.ResolveTCPAddr("tcp", r.RemoteAddr); err == nil {
+		ctx = peer.NewContext(ctx, &peer.Peer{Addr: addr})
+	}
+
+	return ctx
+}
+
+func (g *gateway) pathUserID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	userID, err := strconv.ParseInt(r.PathValue("user_id"), 10, 64)
+	if err != nil {
+		g.writeError(w, status.Error(codes.InvalidArgument, "invalid user id"))
+
+		return 0, false
+	}
+
+	return userID, true
+}
+
+func (g *gateway) decode(w http.ResponseWriter, r *http.Request, v any) bool {
+	dec := json.NewDecoder(io.LimitReader(r.Body, maxBodySize))
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(v); err != nil {
+		msg := "invalid request body"
+		if errors.Is(err, io.EOF) {
+			msg = "request body is required"
+		}
+
+		g.writeError(w, status.Error(codes.InvalidArgument, msg))
+
+		return false
+	}
+
+	return true
+}
+
+// errorBody is returned for every failed request.
+type errorBody struct {
+	Error errorDetails `json:"error"`
+}
+
+type errorDetails struct {
+	// Code is the HTTP status code.
+	Code int `json:"code"`
+	// Status is the gRPC status code name, e.g. "INVALID_ARGUMENT".
+	Status  string `json:"status"`
+	Message string `json:"message"`
+	// Reason and FieldViolations come from google.rpc.ErrorInfo and
+	// google.rpc.BadRequest details of the status, if any.
+	Reason          string           `json:"reason,omitempty"`
+	FieldViolations []fieldViolation `json:"field_violations,omitempty"`
+}
+
+type fieldViolation struct {
+	Field       string `json:"field"`
+	Description string `json:"description"`
+}
+
+func (g *gateway) writeError(w http.ResponseWriter, err error) {
+	st := status.Convert(err)
+	httpCode := HTTPStatusFromCode(st.Code())
+
+	details := errorDetails{
+		Code:    httpCode,
+		Status:  codeName(st.Code()),
+		Message: st.Message(),
+	}
+
+	for _, d := range st.Details() {
+		switch d := d.(type) {
+		case *errdetails.ErrorInfo:
+			details.Reason = d.GetReason()
+		case *errdetails.BadRequest:
+			for _, fv := range d.GetFieldViolations() {
+				details.FieldViolations = append(details.FieldViolations, fieldViolation{
+					Field:       fv.GetField(),
+					Description: fv.GetDescription(),
+				})
+			}
+		}
+	}
+
+	g.writeJSON(w, httpCode, errorBody{Error: details})
+}
+
+func (g *gateway) writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		g.log.Warn("failed to write response", sl.Err(err))
+	}
+}
+
+// HTTPStatusFromCode maps a gRPC status code to the HTTP status returned
+// by the gateway.
+func HTTPStatusFromCode(code codes.Code) int {
+	switch code {
+	case codes.OK:
+		return http.StatusOK
+	case codes.Canceled:
+		return 499
+	case codes.InvalidArgument, codes.FailedPrecondition, codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// codeName returns the canonical upper snake case name of code.
+func codeName(code codes.Code) string {
+	if code == codes.OK {
+		return "OK"
+	}
+
+	name := code.String()
+
+	var b strings.Builder
+	for i, r := range name {
+		if i > 0 && r >= 'A' && r <= 'Z' {
+			b.WriteByte('_')
+		}
+		b.WriteRune(r)
+	}
+
+	return strings.ToUpper(b.String())
+}
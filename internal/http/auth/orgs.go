@@ -0,0 +1,412 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+	"xauth/internal/domain/models"
+	"xauth/internal/lib/logger/sl"
+	"xauth/internal/services/orgs"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type Orgs interface {
+	Create(ctx context.Context, userID int64, name string) (models.Organization, error)
+	List(ctx context.Context, userID int64) ([]models.Membership, error)
+	Members(ctx context.Context, userID int64, orgID int64) ([]models.Membership, error)
+	Invite(
+		ctx context.Context,
+		userID int64,
+		orgID int64,
+		email string,
+		role models.Role,
+	) (models.Invitation, string, error)
+	Accept(ctx context.Context, userID int64, token string) (models.Membership, error)
+	SetRole(
+		ctx context.Context,
+		userID int64,
+		orgID int64,
+		memberID int64,
+		role models.Role,
+	) error
+	Remove(ctx context.Context, userID int64, orgID int64, memberID int64) error
+	Token(ctx context.Context, userID int64, appID int, orgID int64) (string, error)
+}
+
+type orgsHandler struct {
+	*userAuth
+	orgs Orgs
+}
+
+// RegisterOrgs registers endpoints for managing organizations and their
+// members on mux. Like the API key endpoints, they require a user access
+// token; what a user may do in an organization depends on their role in it.
+func RegisterOrgs(
+	mux *http.ServeMux,
+	log *slog.Logger,
+	orgs Orgs,
+	tokens TokenValidator,
+) {
+	h := &orgsHandler{
+		userAuth: &userAuth{
+			gateway: &gateway{log: log},
+			tokens:  tokens,
+		},
+		orgs: orgs,
+	}
+
+	mux.HandleFunc("POST /v1/orgs", h.create)
+	mux.HandleFunc("GET /v1/orgs", h.list)
+	mux.HandleFunc("GET /v1/orgs/{org_id}/members", h.members)
+	mux.HandleFunc("PATCH /v1/orgs/{org_id}/members/{user_id}", h.setRole)
+	mux.HandleFunc("DELETE /v1/orgs/{org_id}/members/{user_id}", h.remove)
+	mux.HandleFunc("POST /v1/orgs/{org_id}/invitations", h.invite)
+	mux.HandleFunc("POST /v1/orgs/{org_id}/token", h.token)
+	mux.HandleFunc("POST /v1/invitations/accept", h.accept)
+}
+
+type createOrgRequest struct {
+	Name string `json:"name"`
+}
+
+type orgResponse struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type membershipResponse struct {
+	OrgID    int64     `json:"org_id"`
+	OrgName  string    `json:"org_name"`
+	Role     string    `json:"role"`
+	JoinedAt time.Time `json:"joined_at"`
+}
+
+type listOrgsResponse struct {
+	Organizations []membershipResponse `json:"organizations"`
+}
+
+type memberResponse struct {
+	UserID   int64     `json:"user_id"`
+	Email    string    `json:"email"`
+	Username string    `json:"username"`
+	Role     string    `json:"role"`
+	JoinedAt time.Time `json:"joined_at"`
+}
+
+type listMembersResponse struct {
+	Members []memberResponse `json:"members"`
+}
+
+type setRoleRequest struct {
+	Role string `json:"role"`
+}
+
+type inviteRequest struct {
+	Email string `json:"email"`
+	Role  string `json:"role"`
+}
+
+type inviteResponse struct {
+	ID        int64     `json:"id"`
+	Email     string    `json:"email"`
+	Role      string    `json:"role"`
+	ExpiresAt time.Time `json:"expires_at"`
+	// Token is only ever returned here; it's up to the inviter to pass it
+	// on to the invitee.
+	Token string `json:"token"`
+}
+
+type acceptRequest struct {
+	Token string `json:"token"`
+}
+
+type orgTokenResponse struct {
+	Token string `json:"token"`
+}
+
+func (h *orgsHandler) create(w http.ResponseWriter, r *http.Request) {
+	const op = "auth.orgs.create"
+
+	userID, ok := h.authenticateUser(w, r)
+	if !ok {
+		return
+	}
+
+	var body createOrgRequest
+	if !h.decode(w, r, &body) {
+		return
+	}
+
+	org, err := h.orgs.Create(r.Context(), userID, body.Name)
+	if err != nil {
+		h.writeOrgError(w, op, err, "failed to create organization")
+
+		return
+	}
+
+	h.writeJSON(w, http.StatusCreated, orgResponse{
+		ID:        org.ID,
+		Name:      org.Name,
+		CreatedAt: org.CreatedAt.UTC(),
+	})
+}
+
+func (h *orgsHandler) list(w http.ResponseWriter, r *http.Request) {
+	const op = "auth.orgs.list"
+
+	userID, ok := h.authenticateUser(w, r)
+	if !ok {
+		return
+	}
+
+	members, err := h.orgs.List(r.Context(), userID)
+	if err != nil {
+		h.writeOrgError(w, op, err, "failed to list organizations")
+
+		return
+	}
+
+	res := listOrgsResponse{Organizations: make([]membershipResponse, 0, len(members))}
+	for _, m := range members {
+		res.Organizations = append(res.Organizations, membershipResponse{
+			OrgID:    m.OrgID,
+			OrgName:  m.OrgName,
+			Role:     string(m.Role),
+			JoinedAt: m.CreatedAt.UTC(),
+		})
+	}
+
+	h.writeJSON(w, http.StatusOK, res)
+}
+
+func (h *orgsHandler) members(w http.ResponseWriter, r *http.Request) {
+	const op = "auth.orgs.members"
+
+	userID, ok := h.authenticateUser(w, r)
+	if !ok {
+		return
+	}
+
+	orgID, ok := h.pathOrgID(w, r)
+	if !ok {
+		return
+	}
+
+	members, err := h.orgs.Members(r.Context(), userID, orgID)
+	if err != nil {
+		h.writeOrgError(w, op, err, "failed to list members")
+
+		return
+	}
+
+	res := listMembersResponse{Members: make([]memberResponse, 0, len(members))}
+	for _, m := range members {
+		res.Members = append(res.Members, memberResponse{
+			UserID:   m.UserID,
+			Email:    m.Email,
+			Username: m.Username,
+			Role:     string(m.Role),
+			JoinedAt: m.CreatedAt.UTC(),
+		})
+	}
+
+	h.writeJSON(w, http.StatusOK, res)
+}
+
+func (h *orgsHandler) setRole(w http.ResponseWriter, r *http.Request) {
+	const op = "auth.orgs.setRole"
+
+	userID, ok := h.authenticateUser(w, r)
+	if !ok {
+		return
+	}
+
+	orgID, ok := h.pathOrgID(w, r)
+	if !ok {
+		return
+	}
+
+	memberID, ok := h.pathUserID(w, r)
+	if !ok {
+		return
+	}
+
+	var body setRoleRequest
+	if !h.decode(w, r, &body) {
+		return
+	}
+
+	err := h.orgs.SetRole(r.Context(), userID, orgID, memberID, models.Role(body.Role))
+	if err != nil {
+		h.writeOrgError(w, op, err, "failed to change role")
+
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *orgsHandler) remove(w http.ResponseWriter, r *http.Request) {
+	const op = "auth.orgs.remove"
+
+	userID, ok := h.authenticateUser(w, r)
+	if !ok {
+		return
+	}
+
+	orgID, ok := h.pathOrgID(w, r)
+	if !ok {
+		return
+	}
+
+	memberID, ok := h.pathUserID(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.orgs.Remove(r.Context(), userID, orgID, memberID); err != nil {
+		h.writeOrgError(w, op, err, "failed to remove member")
+
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *orgsHandler) invite(w http.ResponseWriter, r *http.Request) {
+	const op = "auth.orgs.invite"
+
+	userID, ok := h.authenticateUser(w, r)
+	if !ok {
+		return
+	}
+
+	orgID, ok := h.pathOrgID(w, r)
+	if !ok {
+		return
+	}
+
+	var body inviteRequest
+	if !h.decode(w, r, &body) {
+		return
+	}
+
+	role := models.Role(body.Role)
+	if role == "" {
+		role = models.RoleMember
+	}
+
+	inv, token, err := h.orgs.Invite(r.Context(), userID, orgID, body.Email, role)
+	if err != nil {
+		h.writeOrgError(w, op, err, "failed to invite member")
+
+		return
+	}
+
+	h.writeJSON(w, http.StatusCreated, inviteResponse{
+		ID:        inv.ID,
+		Email:     inv.Email,
+		Role:      string(inv.Role),
+		ExpiresAt: inv.ExpiresAt.UTC(),
+		Token:     token,
+	})
+}
+
+func (h *orgsHandler) accept(w http.ResponseWriter, r *http.Request) {
+	const op = "auth.orgs.accept"
+
+	userID, ok := h.authenticateUser(w, r)
+	if !ok {
+		return
+	}
+
+	var body acceptRequest
+	if !h.decode(w, r, &body) {
+		return
+	}
+
+	m, err := h.orgs.Accept(r.Context(), userID, body.Token)
+	if err != nil {
+		h.writeOrgError(w, op, err, "failed to accept invitation")
+
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, membershipResponse{
+		OrgID:    m.OrgID,
+		OrgName:  m.OrgName,
+		Role:     string(m.Role),
+		JoinedAt: m.CreatedAt.UTC(),
+	})
+}
+
+// token exchanges the caller's access token for one scoped to the
+// organization, issued to the same app.
+func (h *orgsHandler) token(w http.ResponseWriter, r *http.Request) {
+	const op = "auth.orgs.token"
+
+	info, ok := h.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	orgID, ok := h.pathOrgID(w, r)
+	if !ok {
+		return
+	}
+
+	token, err := h.orgs.Token(r.Context(), info.UserID, info.AppID, orgID)
+	if err != nil {
+		h.writeOrgError(w, op, err, "failed to issue token")
+
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, orgTokenResponse{Token: token})
+}
+
+func (h *orgsHandler) pathOrgID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	orgID, err := strconv.ParseInt(r.PathValue("org_id"), 10, 64)
+	if err != nil {
+		h.writeError(w, status.Error(codes.InvalidArgument, "invalid organization id"))
+
+		return 0, false
+	}
+
+	return orgID, true
+}
+
+// writeOrgError reports err from the organizations service, logging it
+// with msg if it's unexpected.
+func (h *orgsHandler) writeOrgError(w http.ResponseWriter, op string, err error, msg string) {
+	for _, e := range []struct {
+		err  error
+		code codes.Code
+	}{
+		{orgs.ErrInvalidName, codes.InvalidArgument},
+		{orgs.ErrInvalidRole, codes.InvalidArgument},
+		{orgs.ErrInvalidEmail, codes.InvalidArgument},
+		{orgs.ErrInvalidInvitation, codes.InvalidArgument},
+		{orgs.ErrOrgExists, codes.AlreadyExists},
+		{orgs.ErrAlreadyMember, codes.AlreadyExists},
+		{orgs.ErrOrgNotFound, codes.NotFound},
+		{orgs.ErrMemberNotFound, codes.NotFound},
+		{orgs.ErrForbidden, codes.PermissionDenied},
+		{orgs.ErrLastOwner, codes.FailedPrecondition},
+	} {
+		if errors.Is(err, e.err) {
+			h.writeError(w, status.Error(e.code, e.err.Error()))
+
+			return
+		}
+	}
+
+	h.log.Error(msg, slog.String("op", op), sl.Err(err))
+	h.writeError(w, status.Error(codes.Internal, msg))
+}
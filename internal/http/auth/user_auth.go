@@ -0,0 +1,71 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"xauth/internal/lib/jwt"
+	"xauth/internal/lib/logger/sl"
+	"xauth/internal/services/oauth"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type TokenValidator interface {
+	ValidateToken(ctx context.Context, token string) (oauth.TokenInfo, error)
+}
+
+// userAuth authenticates self-service endpoints, which must be called with
+// a user access token from Login. API keys, client tokens and tokens apps
+// got from the authorization code grant are rejected.
+type userAuth struct {
+	*gateway
+	tokens TokenValidator
+}
+
+// authenticate returns what the request's access token grants.
+func (a *userAuth) authenticate(w http.ResponseWriter, r *http.Request) (oauth.TokenInfo, bool) {
+	const op = "auth.userAuth.authenticate"
+
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		a.writeError(w, status.Error(codes.Unauthenticated, "access token is required"))
+
+		return oauth.TokenInfo{}, false
+	}
+
+	info, err := a.tokens.ValidateToken(r.Context(), token)
+	if err != nil {
+		if errors.Is(err, oauth.ErrInvalidToken) {
+			a.writeError(w, status.Error(codes.Unauthenticated, "invalid access token"))
+
+			return oauth.TokenInfo{}, false
+		}
+
+		a.log.Error("failed to validate token", slog.String("op", op), sl.Err(err))
+		a.writeError(w, status.Error(codes.Internal, "failed to validate token"))
+
+		return oauth.TokenInfo{}, false
+	}
+
+	if info.Type != oauth.TokenTypeAccessToken || info.Grant != jwt.GrantPassword ||
+		info.UserID == 0 {
+		a.writeError(w, status.Error(codes.Unauthenticated,
+			"a user access token is required"))
+
+		return oauth.TokenInfo{}, false
+	}
+
+	return info, true
+}
+
+// authenticateUser returns the ID of the user the request's access token
+// was issued to.
+func (a *userAuth) authenticateUser(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	info, ok := a.authenticate(w, r)
+
+	return info.UserID, ok
+}
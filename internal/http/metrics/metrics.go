@@ -0,0 +1,68 @@
+// Package metrics serves the service's metrics in the Prometheus text
+// format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"xauth/internal/lib/hashpool"
+)
+
+type HashPool interface {
+	Stats() hashpool.Stats
+}
+
+type handler struct {
+	hashPool HashPool
+}
+
+// metric is a single sample with its help text and type.
+type metric struct {
+	name  string
+	kind  string
+	help  string
+	value float64
+}
+
+// Register adds GET /metrics to mux.
+func Register(mux *http.ServeMux, hashPool HashPool) {
+	h := &handler{hashPool: hashPool}
+
+	mux.HandleFunc("GET /metrics", h.metrics)
+}
+
+func (h *handler) metrics(w http.ResponseWriter, _ *http.Request) {
+	s := h.hashPool.Stats()
+
+	metrics := []metric{
+		{"xauth_hash_pool_workers", "gauge",
+			"Passwords hashed at once at most.", float64(s.Workers)},
+		{"xauth_hash_pool_busy_workers", "gauge",
+			"Workers hashing a password.", float64(s.Busy)},
+		{"xauth_hash_pool_queued", "gauge",
+			"Passwords waiting for a worker.", float64(s.Queued)},
+		{"xauth_hash_pool_queue_depth", "gauge",
+			"Passwords that may wait for a worker at most.", float64(s.QueueDepth)},
+		{"xauth_hash_pool_completed_total", "counter",
+			"Passwords hashed.", float64(s.Completed)},
+		{"xauth_hash_pool_rejected_queue_full_total", "counter",
+			"Passwords not hashed because the queue was full.", float64(s.RejectedFull)},
+		{"xauth_hash_pool_rejected_deadline_total", "counter",
+			"Passwords not hashed because they couldn't be before the request deadline.",
+			float64(s.RejectedDeadline)},
+		{"xauth_hash_pool_wait_seconds_total", "counter",
+			"Time passwords spent waiting for a worker.", s.WaitTime.Seconds()},
+		{"xauth_hash_pool_job_seconds", "gauge",
+			"Moving average of the time it takes to hash a password.", s.AvgJobTime.Seconds()},
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	bw := bufio.NewWriter(w)
+	for _, m := range metrics {
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s %s\n%s %g\n",
+			m.name, m.help, m.name, m.kind, m.name, m.value)
+	}
+	_ = bw.Flush()
+}
@@ -0,0 +1,153 @@
+package oauth
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"xauth/internal/lib/logger/sl"
+	"xauth/internal/services/federation"
+	"xauth/internal/services/oauth"
+)
+
+type Federation interface {
+	Providers() []string
+	Start(
+		ctx context.Context,
+		provider string,
+		req oauth.AuthorizeRequest,
+		clientState string,
+	) (authURL string, state string, err error)
+	Callback(
+		ctx context.Context,
+		provider string,
+		state string,
+		code string,
+		upstreamError string,
+	) (federation.Result, error)
+}
+
+// stateCookie binds an upstream login to the browser that started it, so
+// a callback URL can't be replayed in another browser (login CSRF). The
+// state itself expires server-side.
+const (
+	stateCookie     = "xauth_federation_state"
+	stateCookiePath = "/federation/"
+)
+
+// federationLogin sends the user agent to the upstream provider, carrying
+// the app's authorization request along.
+func (h *handler) federationLogin(w http.ResponseWriter, r *http.Request) {
+	const op = "oauth.federationLogin"
+
+	params := parseAuthorizeParams(r.URL.Query())
+
+	req, ok := h.validateAuthorize(w, r, params)
+	if !ok {
+		return
+	}
+
+	authURL, state, err := h.federation.Start(r.Context(), r.PathValue("provider"),
+		req, params.State)
+	if err != nil {
+		if errors.Is(err, federation.ErrUnknownProvider) {
+			h.renderError(w, http.StatusNotFound, "Unknown identity provider.")
+
+			return
+		}
+
+		h.log.Error("failed to start upstream login", slog.String("op", op), sl.Err(err))
+		redirectError(w, r, params, errServerError, "")
+
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     stateCookie,
+		Value:    state,
+		Path:     stateCookiePath,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		// Lax lets the cookie through on the top-level redirect back from
+		// the provider.
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// federationCallback is the redirect URI registered at upstream providers.
+func (h *handler) federationCallback(w http.ResponseWriter, r *http.Request) {
+	const op = "oauth.federationCallback"
+
+	log := h.log.With(slog.String("op", op))
+
+	q := r.URL.Query()
+	state := q.Get("state")
+
+	cookie, err := r.Cookie(stateCookie)
+	if err != nil || state == "" ||
+		subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state)) != 1 {
+		h.renderError(w, http.StatusBadRequest,
+			"Your sign-in session has expired. Please start over.")
+
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:   stateCookie,
+		Path:   stateCookiePath,
+		MaxAge: -1,
+	})
+
+	res, err := h.federation.Callback(r.Context(), r.PathValue("provider"),
+		state, q.Get("code"), q.Get("error"))
+	if err != nil {
+		if res.RedirectURI == "" {
+			switch {
+			case errors.Is(err, federation.ErrUnknownProvider):
+				h.renderError(w, http.StatusNotFound, "Unknown identity provider.")
+			case errors.Is(err, federation.ErrInvalidState):
+				h.renderError(w, http.StatusBadRequest,
+					"Your sign-in session has expired. Please start over.")
+			default:
+				log.Error("failed to finish upstream login", sl.Err(err))
+				h.renderError(w, http.StatusInternalServerError,
+					"Something went wrong. Please try again later.")
+			}
+
+			return
+		}
+
+		params := authorizeParams{
+			RedirectURI: res.RedirectURI,
+			State:       res.ClientState,
+		}
+
+		switch {
+		case errors.Is(err, federation.ErrAccessDenied):
+			redirectError(w, r, params, errAccessDenied,
+				"the identity provider denied access")
+		case errors.Is(err, federation.ErrSignupNotAllowed):
+			redirectError(w, r, params, errAccessDenied,
+				"no account is linked to this identity")
+		case errors.Is(err, federation.ErrIdentityConflict):
+			redirectError(w, r, params, errAccessDenied,
+				"an account with the same email or username already exists")
+		default:
+			log.Error("failed to finish upstream login", sl.Err(err))
+			redirectError(w, r, params, errServerError, "")
+		}
+
+		return
+	}
+
+	q = url.Values{"code": {res.Code}}
+	if res.ClientState != "" {
+		q.Set("state", res.ClientState)
+	}
+
+	redirect(w, r, res.RedirectURI, q)
+}
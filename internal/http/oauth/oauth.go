@@ -0,0 +1,678 @@
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"xauth/internal/domain/models"
+	"xauth/internal/lib/jwk"
+	"xauth/internal/lib/logger/sl"
+	"xauth/internal/services/auth"
+	"xauth/internal/services/oauth"
+)
+
+type OAuth interface {
+	ValidateClient(ctx context.Context, appID int, redirectURI string) error
+	ValidateAuthorize(ctx context.Context, req oauth.AuthorizeRequest) error
+	Authorize(
+		ctx context.Context,
+		req oauth.AuthorizeRequest,
+		login string,
+		password string,
+	) (code string, err error)
+	Exchange(ctx context.Context, req oauth.TokenRequest) (oauth.Token, error)
+	ClientCredentials(
+		ctx context.Context,
+		appID int,
+		clientSecret string,
+		scope string,
+	) (oauth.Token, error)
+	UserInfo(ctx context.Context, token string) (models.User, error)
+	Introspect(
+		ctx context.Context,
+		appID int,
+		clientSecret string,
+		token string,
+	) (oauth.TokenInfo, error)
+	Issuer() string
+	KeySet() jwk.Set
+}
+
+// Error codes from RFC 6749, sections 4.1.2.1 and 5.2.
+const (
+	errInvalidRequest          = "invalid_request"
+	errAccessDenied            = "access_denied"
+	errInvalidClient           = "invalid_client"
+	errInvalidGrant            = "invalid_grant"
+	errInvalidScope            = "invalid_scope"
+	errUnsupportedGrantType    = "unsupported_grant_type"
+	errUnsupportedResponseType = "unsupported_response_type"
+	errServerError             = "server_error"
+	// errInvalidToken is from RFC 6750, section 3.1.
+	errInvalidToken = "invalid_token"
+)
+
+const (
+	grantTypeAuthorizationCode = "authorization_code"
+	grantTypeClientCredentials = "client_credentials"
+	responseTypeCode           = "code"
+)
+
+type handler struct {
+	log        *slog.Logger
+	oauth      OAuth
+	federation Federation
+}
+
+// Register registers the OAuth 2.0 authorization and token endpoints, the
+// OpenID Connect discovery, JWK set and UserInfo endpoints, and sign-in
+// through upstream identity providers on mux.
+func Register(
+	mux *http.ServeMux,
+	log *slog.Logger,
+	oauth OAuth,
+	federation Federation,
+) {
+	h := &handler{
+		log:        log,
+		oauth:      oauth,
+		federation: federation,
+	}
+
+	mux.HandleFunc("GET /authorize", h.authorizeForm)
+	mux.HandleFunc("POST /authorize", h.authorize)
+	mux.HandleFunc("POST /token", h.token)
+	mux.HandleFunc("GET /.well-known/openid-configuration", h.discovery)
+	mux.HandleFunc("GET /.well-known/jwks.json", h.jwks)
+	mux.HandleFunc("GET /userinfo", h.userInfo)
+	mux.HandleFunc("POST /userinfo", h.userInfo)
+	mux.HandleFunc("POST /introspect", h.introspect)
+	mux.HandleFunc("GET /federation/{provider}/login", h.federationLogin)
+	mux.HandleFunc("GET /federation/{provider}/callback", h.federationCallback)
+}
+
+// authorizeParams are the query parameters of an authorization request.
+// They are carried through the login form in hidden fields.
+type authorizeParams struct {
+	ResponseType        string
+	ClientID            string
+	RedirectURI         string
+	Scope               string
+	State               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	Nonce               string
+}
+
+func parseAuthorizeParams(v url.Values) authorizeParams {
+	return authorizeParams{
+		ResponseType:        v.Get("response_type"),
+		ClientID:            v.Get("client_id"),
+		RedirectURI:         v.Get("redirect_uri"),
+		Scope:               v.Get("scope"),
+		State:               v.Get("state"),
+		CodeChallenge:       v.Get("code_challenge"),
+		CodeChallengeMethod: v.Get("code_challenge_method"),
+		Nonce:               v.Get("nonce"),
+	}
+}
+
+func (p authorizeParams) values() url.Values {
+	v := url.Values{}
+	for k, val := range map[string]string{
+		"response_type":         p.ResponseType,
+		"client_id":             p.ClientID,
+		"redirect_uri":          p.RedirectURI,
+		"scope":                 p.Scope,
+		"state":                 p.State,
+		"code_challenge":        p.CodeChallenge,
+		"code_challenge_method": p.CodeChallengeMethod,
+		"nonce":                 p.Nonce,
+	} {
+		if val != "" {
+			v.Set(k, val)
+		}
+	}
+
+	return v
+}
+
+func (p authorizeParams) request(appID int) oauth.AuthorizeRequest {
+	return oauth.AuthorizeRequest{
+		AppID:               appID,
+		RedirectURI:         p.RedirectURI,
+		Scope:               p.Scope,
+		CodeChallenge:       p.CodeChallenge,
+		CodeChallengeMethod: p.CodeChallengeMethod,
+		Nonce:               p.Nonce,
+	}
+}
+
+func (h *handler) authorizeForm(w http.ResponseWriter, r *http.Request) {
+	params := parseAuthorizeParams(r.URL.Query())
+
+	if _, ok := h.validateAuthorize(w, r, params); !ok {
+		return
+	}
+
+	h.renderLogin(w, http.StatusOK, params, "")
+}
+
+func (h *handler) authorize(w http.ResponseWriter, r *http.Request) {
+	const op = "oauth.authorize"
+
+	log := h.log.With(slog.String("op", op))
+
+	if err := r.ParseForm(); err != nil {
+		h.renderError(w, http.StatusBadRequest, "Malformed request.")
+
+		return
+	}
+
+	params := parseAuthorizeParams(r.PostForm)
+
+	req, ok := h.validateAuthorize(w, r, params)
+	if !ok {
+		return
+	}
+
+	code, err := h.oauth.Authorize(r.Context(), req,
+		r.PostForm.Get("login"), r.PostForm.Get("password"))
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidCredentials) {
+			h.renderLogin(w, http.StatusUnauthorized, params,
+				"Invalid login or password.")
+
+			return
+		}
+		if errors.Is(err, auth.ErrUserSuspended) {
+			h.renderLogin(w, http.StatusForbidden, params,
+				"This account is suspended.")
+
+			return
+		}
+		if errors.Is(err, auth.ErrOverloaded) {
+			h.renderLogin(w, http.StatusServiceUnavailable, params,
+				"Too many sign-ins right now. Please try again in a moment.")
+
+			return
+		}
+
+		log.Error("failed to authorize", sl.Err(err))
+		redirectError(w, r, params, errServerError, "")
+
+		return
+	}
+
+	q := url.Values{"code": {code}}
+	if params.State != "" {
+		q.Set("state", params.State)
+	}
+
+	redirect(w, r, params.RedirectURI, q)
+}
+
+// validateAuthorize validates params and writes the error response if
+// they are invalid. Errors are only sent back to the client's redirect
+// URI once it is known to be registered; otherwise an error page is shown.
+func (h *handler) validateAuthorize(
+	w http.ResponseWriter,
+	r *http.Request,
+	params authorizeParams,
+) (oauth.AuthorizeRequest, bool) {
+	const op = "oauth.validateAuthorize"
+
+	appID, err := strconv.Atoi(params.ClientID)
+	if err != nil {
+		h.renderError(w, http.StatusBadRequest, "Unknown client.")
+
+		return oauth.AuthorizeRequest{}, false
+	}
+
+	if err := h.oauth.ValidateClient(r.Context(), appID, params.RedirectURI); err != nil {
+		switch {
+		case errors.Is(err, oauth.ErrInvalidClient):
+			h.renderError(w, http.StatusBadRequest, "Unknown client.")
+		case errors.Is(err, oauth.ErrInvalidRedirectURI):
+			h.renderError(w, http.StatusBadRequest,
+				"The redirect URI is not registered for this client.")
+		default:
+			h.log.Error("failed to validate client",
+				slog.String("op", op), sl.Err(err))
+			h.renderError(w, http.StatusInternalServerError,
+				"Something went wrong. Please try again later.")
+		}
+
+		return oauth.AuthorizeRequest{}, false
+	}
+
+	if params.ResponseType != responseTypeCode {
+		redirectError(w, r, params, errUnsupportedResponseType,
+			"response_type must be code")
+
+		return oauth.AuthorizeRequest{}, false
+	}
+
+	req := params.request(appID)
+
+	if err := h.oauth.ValidateAuthorize(r.Context(), req); err != nil {
+		if errors.Is(err, oauth.ErrInvalidRequest) {
+			description := "invalid authorization request"
+			switch {
+			case errors.Is(err, oauth.ErrCodeChallengeRequired):
+				description = "code_challenge is required"
+			case errors.Is(err, oauth.ErrCodeChallengeMethod):
+				description = "code_challenge_method must be S256"
+			}
+
+			redirectError(w, r, params, errInvalidRequest, description)
+
+			return oauth.AuthorizeRequest{}, false
+		}
+
+		h.log.Error("failed to validate authorization request",
+			slog.String("op", op), sl.Err(err))
+		redirectError(w, r, params, errServerError, "")
+
+		return oauth.AuthorizeRequest{}, false
+	}
+
+	return req, true
+}
+
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+	Scope       string `json:"scope,omitempty"`
+	IDToken     string `json:"id_token,omitempty"`
+}
+
+type errorResponse struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+func (h *handler) token(w http.ResponseWriter, r *http.Request) {
+	const op = "oauth.token"
+
+	log := h.log.With(slog.String("op", op))
+
+	if err := r.ParseForm(); err != nil {
+		h.writeJSON(w, http.StatusBadRequest, errorResponse{
+			Error: errInvalidRequest,
+		})
+
+		return
+	}
+
+	grantType := r.PostForm.Get("grant_type")
+	if grantType != grantTypeAuthorizationCode && grantType != grantTypeClientCredentials {
+		h.writeJSON(w, http.StatusBadRequest, errorResponse{
+			Error: errUnsupportedGrantType,
+			ErrorDescription: "grant_type must be " + grantTypeAuthorizationCode +
+				" or " + grantTypeClientCredentials,
+		})
+
+		return
+	}
+
+	clientID, clientSecret, ok := r.BasicAuth()
+	if !ok {
+		clientID = r.PostForm.Get("client_id")
+		clientSecret = r.PostForm.Get("client_secret")
+	}
+
+	appID, err := strconv.Atoi(clientID)
+	if err != nil {
+		h.writeJSON(w, http.StatusUnauthorized, errorResponse{
+			Error: errInvalidClient,
+		})
+
+		return
+	}
+
+	var token oauth.Token
+
+	switch grantType {
+	case grantTypeAuthorizationCode:
+		token, err = h.oauth.Exchange(r.Context(), oauth.TokenRequest{
+			AppID:        appID,
+			ClientSecret: clientSecret,
+			Code:         r.PostForm.Get("code"),
+			RedirectURI:  r.PostForm.Get("redirect_uri"),
+			CodeVerifier: r.PostForm.Get("code_verifier"),
+		})
+	case grantTypeClientCredentials:
+		token, err = h.oauth.ClientCredentials(r.Context(), appID, clientSecret,
+			r.PostForm.Get("scope"))
+	}
+
+	if err != nil {
+		switch {
+		case errors.Is(err, oauth.ErrInvalidClient):
+			h.writeJSON(w, http.StatusUnauthorized, errorResponse{
+				Error: errInvalidClient,
+			})
+		case errors.Is(err, oauth.ErrInvalidGrant):
+			h.writeJSON(w, http.StatusBadRequest, errorResponse{
+				Error: errInvalidGrant,
+				ErrorDescription: "authorization code is invalid, expired " +
+					"or doesn't match the request",
+			})
+		case errors.Is(err, oauth.ErrInvalidScope):
+			h.writeJSON(w, http.StatusBadRequest, errorResponse{
+				Error:            errInvalidScope,
+				ErrorDescription: "requested scope is not allowed for the client",
+			})
+		default:
+			log.Error("failed to issue token", sl.Err(err),
+				slog.String("grant_type", grantType))
+			h.writeJSON(w, http.StatusInternalServerError, errorResponse{
+				Error: errServerError,
+			})
+		}
+
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, tokenResponse{
+		AccessToken: token.AccessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(token.ExpiresIn.Seconds()),
+		Scope:       token.Scope,
+		IDToken:     token.IDToken,
+	})
+}
+
+type discoveryResponse struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserInfoEndpoint                  string   `json:"userinfo_endpoint"`
+	IntrospectionEndpoint             string   `json:"introspection_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+}
+
+// discovery serves the OpenID Provider Metadata, see OpenID Connect
+// Discovery 1.0, section 3.
+func (h *handler) discovery(w http.ResponseWriter, _ *http.Request) {
+	issuer := strings.TrimSuffix(h.oauth.Issuer(), "/")
+
+	h.writeJSON(w, http.StatusOK, discoveryResponse{
+		Issuer:                 h.oauth.Issuer(),
+		AuthorizationEndpoint:  issuer + "/authorize",
+		TokenEndpoint:          issuer + "/token",
+		UserInfoEndpoint:       issuer + "/userinfo",
+		IntrospectionEndpoint:  issuer + "/introspect",
+		JWKSURI:                issuer + "/.well-known/jwks.json",
+		ScopesSupported:        []string{oauth.ScopeOpenID, "email", "profile"},
+		ResponseTypesSupported: []string{responseTypeCode},
+		GrantTypesSupported: []string{
+			grantTypeAuthorizationCode,
+			grantTypeClientCredentials,
+		},
+		SubjectTypesSupported:            []string{"public"},
+		IDTokenSigningAlgValuesSupported: []string{"RS256"},
+		TokenEndpointAuthMethodsSupported: []string{
+			"client_secret_basic",
+			"client_secret_post",
+			"none",
+		},
+		CodeChallengeMethodsSupported: []string{"S256"},
+		ClaimsSupported: []string{
+			"iss", "sub", "aud", "exp", "iat", "auth_time", "nonce",
+			"email", "email_verified", "preferred_username",
+		},
+	})
+}
+
+func (h *handler) jwks(w http.ResponseWriter, _ *http.Request) {
+	h.writeJSON(w, http.StatusOK, h.oauth.KeySet())
+}
+
+// introspectionResponse is described in RFC 7662, section 2.2. Only
+// Active is set for inactive tokens. OrgID and OrgRole are extensions for
+// organization-scoped tokens.
+type introspectionResponse struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	Username  string `json:"username,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	Exp       int64  `json:"exp,omitempty"`
+	Iat       int64  `json:"iat,omitempty"`
+	Sub       string `json:"sub,omitempty"`
+	OrgID     int64  `json:"org_id,omitempty"`
+	OrgRole   string `json:"org_role,omitempty"`
+}
+
+func (h *handler) introspect(w http.ResponseWriter, r *http.Request) {
+	const op = "oauth.introspect"
+
+	if err := r.ParseForm(); err != nil || r.PostForm.Get("token") == "" {
+		h.writeJSON(w, http.StatusBadRequest, errorResponse{
+			Error: errInvalidRequest,
+		})
+
+		return
+	}
+
+	clientID, clientSecret, ok := r.BasicAuth()
+	if !ok {
+		clientID = r.PostForm.Get("client_id")
+		clientSecret = r.PostForm.Get("client_secret")
+	}
+
+	appID, err := strconv.Atoi(clientID)
+	if err != nil {
+		h.writeJSON(w, http.StatusUnauthorized, errorResponse{
+			Error: errInvalidClient,
+		})
+
+		return
+	}
+
+	info, err := h.oauth.Introspect(r.Context(), appID, clientSecret,
+		r.PostForm.Get("token"))
+	if err != nil {
+		switch {
+		case errors.Is(err, oauth.ErrInvalidClient):
+			h.writeJSON(w, http.StatusUnauthorized, errorResponse{
+				Error: errInvalidClient,
+			})
+		case errors.Is(err, oauth.ErrInvalidToken):
+			h.writeJSON(w, http.StatusOK, introspectionResponse{Active: false})
+		default:
+			h.log.Error("failed to introspect token", slog.String("op", op), sl.Err(err))
+			h.writeJSON(w, http.StatusInternalServerError, errorResponse{
+				Error: errServerError,
+			})
+		}
+
+		return
+	}
+
+	res := introspectionResponse{
+		Active:    true,
+		Scope:     info.Scope,
+		Username:  info.Username,
+		TokenType: info.Type,
+		Exp:       info.ExpiresAt.Unix(),
+		OrgID:     info.OrgID,
+		OrgRole:   info.OrgRole,
+	}
+	if info.AppID != 0 {
+		res.ClientID = strconv.Itoa(info.AppID)
+	}
+	if !info.IssuedAt.IsZero() {
+		res.Iat = info.IssuedAt.Unix()
+	}
+	if info.UserID != 0 {
+		res.Sub = strconv.FormatInt(info.UserID, 10)
+	} else {
+		res.Sub = res.ClientID
+	}
+
+	h.writeJSON(w, http.StatusOK, res)
+}
+
+type userInfoResponse struct {
+	Sub               string `json:"sub"`
+	Email             string `json:"email"`
+	EmailVerified     bool   `json:"email_verified"`
+	PreferredUsername string `json:"preferred_username"`
+}
+
+func (h *handler) userInfo(w http.ResponseWriter, r *http.Request) {
+	const op = "oauth.userInfo"
+
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		h.writeJSON(w, http.StatusUnauthorized, errorResponse{
+			Error: errInvalidRequest,
+		})
+
+		return
+	}
+
+	user, err := h.oauth.UserInfo(r.Context(), token)
+	if err != nil {
+		if errors.Is(err, oauth.ErrInvalidToken) {
+			w.Header().Set("WWW-Authenticate", `Bearer error="`+errInvalidToken+`"`)
+			h.writeJSON(w, http.StatusUnauthorized, errorResponse{
+				Error: errInvalidToken,
+			})
+
+			return
+		}
+
+		h.log.Error("failed to get user info", slog.String("op", op), sl.Err(err))
+		h.writeJSON(w, http.StatusInternalServerError, errorResponse{
+			Error: errServerError,
+		})
+
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, userInfoResponse{
+		Sub:               strconv.FormatInt(user.ID, 10),
+		Email:             user.Email,
+		EmailVerified:     false,
+		PreferredUsername: user.Username,
+	})
+}
+
+func (h *handler) writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		h.log.Warn("failed to write response", sl.Err(err))
+	}
+}
+
+func (h *handler) renderLogin(
+	w http.ResponseWriter,
+	code int,
+	params authorizeParams,
+	errMsg string,
+) {
+	type provider struct {
+		Name string
+		URL  string
+	}
+
+	// Provider links carry the authorization request, so the app's request
+	// resumes after the upstream login.
+	query := params.values().Encode()
+
+	names := h.federation.Providers()
+	providers := make([]provider, 0, len(names))
+	for _, name := range names {
+		providers = append(providers, provider{
+			Name: name,
+			URL:  "/federation/" + url.PathEscape(name) + "/login?" + query,
+		})
+	}
+
+	h.render(w, code, loginTmpl, struct {
+		Params    authorizeParams
+		Providers []provider
+		Error     string
+	}{
+		Params:    params,
+		Providers: providers,
+		Error:     errMsg,
+	})
+}
+
+func (h *handler) renderError(w http.ResponseWriter, code int, msg string) {
+	h.render(w, code, errorTmpl, msg)
+}
+
+func (h *handler) render(w http.ResponseWriter, code int, tmpl *template.Template, data any) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	// The login form must not be framed by other sites (clickjacking).
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", "frame-ancestors 'none'")
+	w.WriteHeader(code)
+
+	if err := tmpl.Execute(w, data); err != nil {
+		h.log.Warn("failed to render page", sl.Err(err))
+	}
+}
+
+func redirectError(
+	w http.ResponseWriter,
+	r *http.Request,
+	params authorizeParams,
+	code string,
+	description string,
+) {
+	q := url.Values{"error": {code}}
+	if description != "" {
+		q.Set("error_description", description)
+	}
+	if params.State != "" {
+		q.Set("state", params.State)
+	}
+
+	redirect(w, r, params.RedirectURI, q)
+}
+
+// redirect sends the user agent to redirectURI with q added to its query.
+// redirectURI must already be validated against the registered ones.
+func redirect(w http.ResponseWriter, r *http.Request, redirectURI string, q url.Values) {
+	u, err := url.Parse(redirectURI)
+	if err != nil {
+		http.Error(w, "invalid redirect uri", http.StatusBadRequest)
+
+		return
+	}
+
+	query := u.Query()
+	for k, v := range q {
+		query[k] = v
+	}
+	u.RawQuery = query.Encode()
+
+	http.Redirect(w, r, u.String(), http.StatusFound)
+}
@@ -0,0 +1,48 @@
+package oauth
+
+import "html/template"
+
+var loginTmpl = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Sign in · xAuth</title>
+</head>
+<body>
+<main>
+<h1>Sign in</h1>
+{{if .Error}}<p role="alert">{{.Error}}</p>{{end}}
+<form method="post" action="/authorize">
+<input type="hidden" name="response_type" value="{{.Params.ResponseType}}">
+<input type="hidden" name="client_id" value="{{.Params.ClientID}}">
+<input type="hidden" name="redirect_uri" value="{{.Params.RedirectURI}}">
+<input type="hidden" name="scope" value="{{.Params.Scope}}">
+<input type="hidden" name="state" value="{{.Params.State}}">
+<input type="hidden" name="code_challenge" value="{{.Params.CodeChallenge}}">
+<input type="hidden" name="code_challenge_method" value="{{.Params.CodeChallengeMethod}}">
+<input type="hidden" name="nonce" value="{{.Params.Nonce}}">
+<label>Email or username <input name="login" autocomplete="username" required autofocus></label>
+<label>Password <input name="password" type="password" autocomplete="current-password" required></label>
+<button type="submit">Sign in</button>
+</form>
+{{range .Providers}}<p><a href="{{.URL}}">Sign in with {{.Name}}</a></p>
+{{end}}</main>
+</body>
+</html>
+`))
+
+var errorTmpl = template.Must(template.New("error").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Error · xAuth</title>
+</head>
+<body>
+<main>
+<h1>Sign-in request can't be processed</h1>
+<p>{{.}}</p>
+</main>
+</body>
+</html>
+`))
@@ -0,0 +1,342 @@
+// Package archive compresses and encrypts database backups, and reads them
+// back, telling the formats apart by their first bytes.
+//
+// A backup that is neither compressed nor encrypted is the SQLite database
+// itself, and a compressed one is a gzip file, so both can be used with
+// the usual tools. Encrypted backups start with a header and hold the
+// database, or its gzip file, in chunks sealed with AES-256-GCM. Every
+// chunk's nonce includes its index and whether it's the last one, so
+// chunks can't be reordered, dropped or cut off unnoticed.
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// KeySize is the size of encryption keys, in bytes.
+const KeySize = 32
+
+const (
+	// chunkSize is how much plaintext a chunk holds, except the last one,
+	// which may hold less.
+	chunkSize = 64 << 10
+
+	// The header is the magic, the format version and the random part of
+	// the chunk nonces.
+	magic       = "XAUTHENC"
+	version     = 1
+	prefixSize  = 7
+	headerSize  = len(magic) + 1 + prefixSize
+	counterSize = 4
+)
+
+var (
+	sqliteMagic = []byte("SQLite format 3\x00")
+	gzipMagic   = []byte{0x1f, 0x8b}
+)
+
+var (
+	ErrInvalidKey    = fmt.Errorf("key must be %d bytes, base64-encoded", KeySize)
+	ErrKeyRequired   = errors.New("backup is encrypted, but no key was given")
+	ErrDecrypt       = errors.New("backup can't be decrypted: wrong key or corrupted file")
+	ErrTruncated     = errors.New("backup is truncated")
+	ErrUnknownFormat = errors.New("not a database backup")
+)
+
+// ParseKey decodes a base64-encoded encryption key, such as one made with
+// "openssl rand -base64 32".
+func ParseKey(s string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(s)
+	if err != nil || len(key) != KeySize {
+		return nil, ErrInvalidKey
+	}
+
+	return key, nil
+}
+
+// NewWriter returns a writer that writes the database to w, compressed with
+// gzip if compress is set and encrypted with key if it isn't nil. Close
+// must be called to write the end of the backup; it doesn't close w.
+func NewWriter(w io.Writer, compress bool, key []byte) (io.WriteCloser, error) {
+	var closers []io.Closer
+
+	if key != nil {
+		ew, err := newEncrypter(w, key)
+		if err != nil {
+			return nil, err
+		}
+
+		w = ew
+		closers = append(closers, ew)
+	}
+
+	if compress {
+		gw := gzip.NewWriter(w)
+
+		w = gw
+		closers = append(closers, gw)
+	}
+
+	return &writer{Writer: w, closers: closers}, nil
+}
+
+// writer closes the layers it writes through innermost first.
+type writer struct {
+	io.Writer
+	closers []io.Closer
+}
+
+func (w *writer) Close() error {
+	for i := len(w.closers) - 1; i >= 0; i-- {
+		if err := w.closers[i].Close(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// NewReader returns a reader of the database in the backup read from r,
+// decrypting it with key and decompressing it as needed. Reads fail with
+// ErrDecrypt or ErrTruncated if the backup was tampered with.
+func NewReader(r io.Reader, key []byte) (io.Reader, error) {
+	br := bufio.NewReader(r)
+
+	head, err := br.Peek(len(magic))
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	if bytes.Equal(head, []byte(magic)) {
+		if key == nil {
+			return nil, ErrKeyRequired
+		}
+
+		dr, err := newDecrypter(br, key)
+		if err != nil {
+			return nil, err
+		}
+
+		br = bufio.NewReader(dr)
+	}
+
+	head, err = br.Peek(len(sqliteMagic))
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	switch {
+	case bytes.HasPrefix(head, gzipMagic):
+		return gzip.NewReader(br)
+	case bytes.Equal(head, sqliteMagic):
+		return br, nil
+	default:
+		return nil, ErrUnknownFormat
+	}
+}
+
+// encrypter seals what is written to it chunk by chunk. A chunk is only
+// sealed once more is written, or on Close, to know if it's the last.
+type encrypter struct {
+	w      io.Writer
+	aead   cipher.AEAD
+	header []byte
+	nonce  []byte
+	index  uint32
+	buf    []byte
+}
+
+func newEncrypter(w io.Writer, key []byte) (*encrypter, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, headerSize)
+	copy(header, magic)
+	header[len(magic)] = version
+	if _, err := rand.Read(header[len(magic)+1:]); err != nil {
+		return nil, err
+	}
+
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+
+	return &encrypter{
+		w:      w,
+		aead:   aead,
+		header: header,
+		nonce:  make([]byte, aead.NonceSize()),
+		buf:    make([]byte, 0, chunkSize+aead.Overhead()),
+	}, nil
+}
+
+func (e *encrypter) Write(p []byte) (int, error) {
+	n := 0
+	for len(p) > 0 {
+		if len(e.buf) == chunkSize {
+			if err := e.seal(false); err != nil {
+				return n, err
+			}
+		}
+
+		m := min(len(p), chunkSize-len(e.buf))
+		e.buf = append(e.buf, p[:m]...)
+		p = p[m:]
+		n += m
+	}
+
+	return n, nil
+}
+
+// Close seals the last chunk, which may be empty.
+func (e *encrypter) Close() error {
+	return e.seal(true)
+}
+
+func (e *encrypter) seal(last bool) error {
+	if e.index == math.MaxUint32 {
+		return errors.New("backup is too large to encrypt")
+	}
+
+	setNonce(e.nonce, e.header, e.index, last)
+	e.index++
+
+	sealed := e.aead.Seal(e.buf[:0], e.nonce, e.buf, e.header)
+	e.buf = e.buf[:0]
+
+	_, err := e.w.Write(sealed)
+
+	return err
+}
+
+// decrypter opens the chunks read from r one by one.
+type decrypter struct {
+	r      *bufio.Reader
+	aead   cipher.AEAD
+	header []byte
+	nonce  []byte
+	index  uint32
+	chunk  []byte
+	buf    []byte
+	plain  []byte
+	done   bool
+}
+
+// newDecrypter reads the header from r, which is at its start.
+func newDecrypter(r *bufio.Reader, key []byte) (*decrypter, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, ErrTruncated
+	}
+	if header[len(magic)] != version {
+		return nil, fmt.Errorf("unsupported backup format version %d", header[len(magic)])
+	}
+
+	return &decrypter{
+		r:      r,
+		aead:   aead,
+		header: header,
+		nonce:  make([]byte, aead.NonceSize()),
+		chunk:  make([]byte, chunkSize+aead.Overhead()),
+		buf:    make([]byte, chunkSize),
+	}, nil
+}
+
+func (d *decrypter) Read(p []byte) (int, error) {
+	for len(d.plain) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+
+		if err := d.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, d.plain)
+	d.plain = d.plain[n:]
+
+	return n, nil
+}
+
+// open reads and opens the next chunk. It's the last one if the file ends
+// after it.
+func (d *decrypter) open() error {
+	n, err := io.ReadFull(d.r, d.chunk)
+	switch {
+	case errors.Is(err, io.EOF):
+		return ErrTruncated
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		d.done = true
+	case err != nil:
+		return err
+	default:
+		if _, err := d.r.Peek(1); errors.Is(err, io.EOF) {
+			d.done = true
+		}
+	}
+
+	setNonce(d.nonce, d.header, d.index, d.done)
+	d.index++
+
+	plain, err := d.aead.Open(d.buf[:0], d.nonce, d.chunk[:n], d.header)
+	if err != nil {
+		// A chunk that isn't the last fails to open as the last, which
+		// is what a cut-off backup looks like.
+		if d.done {
+			setNonce(d.nonce, d.header, d.index-1, false)
+			if _, err := d.aead.Open(d.buf[:0], d.nonce, d.chunk[:n], d.header); err == nil {
+				return ErrTruncated
+			}
+		}
+
+		return ErrDecrypt
+	}
+
+	d.plain = plain
+
+	return nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != KeySize {
+		return nil, ErrInvalidKey
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// setNonce sets nonce to the random prefix from the header, the chunk's
+// index and a last-chunk flag.
+func setNonce(nonce, header []byte, index uint32, last bool) {
+	copy(nonce, header[len(magic)+1:])
+	binary.BigEndian.PutUint32(nonce[prefixSize:], index)
+
+	nonce[prefixSize+counterSize] = 0
+	if last {
+		nonce[prefixSize+counterSize] = 1
+	}
+}
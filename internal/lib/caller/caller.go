@@ -0,0 +1,40 @@
+// Package caller carries the authenticated caller of a request in its
+// context.
+package caller
+
+import (
+	"context"
+	"time"
+)
+
+// Caller is who a request was made by, as established from its bearer
+// token.
+type Caller struct {
+	// UserID is 0 for client tokens.
+	UserID int64
+	// AppID is 0 for API keys.
+	AppID int
+	// OrgID is set for organization-scoped tokens.
+	OrgID     int64
+	Scope     string
+	TokenType string
+	// Grant is the grant an access token was issued by, see oauth.TokenInfo.
+	Grant string
+	// IssuedAt is when the token was issued; for a token from Login, when
+	// the user last signed in.
+	IssuedAt time.Time
+}
+
+type ctxKey struct{}
+
+// NewContext returns a copy of ctx carrying c.
+func NewContext(ctx context.Context, c Caller) context.Context {
+	return context.WithValue(ctx, ctxKey{}, c)
+}
+
+// FromContext returns the caller carried by ctx, if any.
+func FromContext(ctx context.Context) (Caller, bool) {
+	c, ok := ctx.Value(ctxKey{}).(Caller)
+
+	return c, ok
+}
@@ -0,0 +1,245 @@
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+	"xauth/internal/lib/logger/sl"
+)
+
+var ErrNoCertificates = errors.New("no certificates found in CA file")
+
+// Reloader keeps a server certificate and an optional client CA pool in
+// memory and reloads them when the files on disk change.
+//
+// It is safe for concurrent use; handshakes always see a consistent
+// certificate/CA pair.
+type Reloader struct {
+	log          *slog.Logger
+	certFile     string
+	keyFile      string
+	clientCAFile string
+
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	clientCA *x509.CertPool
+	modTimes map[string]time.Time
+}
+
+// NewReloader loads the certificate, key and optional client CA.
+// If clientCAFile is empty, client certificates are not verified.
+func NewReloader(
+	log *slog.Logger,
+	certFile string,
+	keyFile string,
+	clientCAFile string,
+) (*Reloader, error) {
+	const op = "certs.NewReloader"
+
+	r := &Reloader{
+		log:          log,
+		certFile:     certFile,
+		keyFile:      keyFile,
+		clientCAFile: clientCAFile,
+	}
+
+	if err := r.Reload(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return r, nil
+}
+
+// Certificates are the files read by Load, not in use yet.
+type Certificates struct {
+	cert     *tls.Certificate
+	clientCA *x509.CertPool
+	modTimes map[string]time.Time
+}
+
+// Reload reads the files from disk and atomically swaps them in.
+// On error the previously loaded certificates stay in use.
+func (r *Reloader) Reload() error {
+	const op = "certs.Reload"
+
+	c, err := r.Load()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	r.Use(c)
+
+	return nil
+}
+
+// Load reads the files from disk and checks them, without using them, so
+// that callers can swap them in together with other changes.
+func (r *Reloader) Load() (Certificates, error) {
+	const op = "certs.Load"
+
+	modTimes, err := r.statFiles()
+	if err != nil {
+		return Certificates{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return Certificates{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	var pool *x509.CertPool
+	if r.clientCAFile != "" {
+		pem, err := os.ReadFile(r.clientCAFile)
+		if err != nil {
+			return Certificates{}, fmt.Errorf("%s: %w", op, err)
+		}
+
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return Certificates{}, fmt.Errorf("%s: %w", op, ErrNoCertificates)
+		}
+	}
+
+	return Certificates{cert: &cert, clientCA: pool, modTimes: modTimes}, nil
+}
+
+// Use atomically swaps in certificates returned by Load.
+func (r *Reloader) Use(c Certificates) {
+	r.mu.Lock()
+	r.cert = c.cert
+	r.clientCA = c.clientCA
+	r.modTimes = c.modTimes
+	r.mu.Unlock()
+}
+
+// Watch polls the files every interval and reloads them when their
+// modification time changes. It returns when done is closed.
+func (r *Reloader) Watch(interval time.Duration, done <-chan struct{}) {
+	const op = "certs.Watch"
+
+	log := r.log.With(slog.String("op", op))
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		if !r.changed() {
+			continue
+		}
+
+		if err := r.Reload(); err != nil {
+			log.Error("failed to reload certificates", sl.Err(err))
+
+			continue
+		}
+
+		log.Info("certificates reloaded")
+	}
+}
+
+// TLSConfig returns a server TLS config that always serves the most
+// recently loaded certificate and verifies clients against the most
+// recently loaded CA pool.
+func (r *Reloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			r.mu.RLock()
+			defer r.mu.RUnlock()
+
+			cfg := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*r.cert},
+				NextProtos:   []string{"h2"},
+			}
+
+			if r.clientCA != nil {
+				cfg.ClientCAs = r.clientCA
+				cfg.ClientAuth = tls.RequireAndVerifyClientCert
+			}
+
+			return cfg, nil
+		},
+	}
+}
+
+// GetCertificate returns the most recently loaded certificate. It can be
+// used as tls.Config.GetCertificate for servers that don't verify clients.
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.cert, nil
+}
+
+// MutualTLS reports whether client certificates are required.
+func (r *Reloader) MutualTLS() bool {
+	return r.clientCAFile != ""
+}
+
+func (r *Reloader) changed() bool {
+	modTimes, err := r.statFiles()
+	if err != nil {
+		return false
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for file, modTime := range modTimes {
+		if !modTime.Equal(r.modTimes[file]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r *Reloader) statFiles() (map[string]time.Time, error) {
+	files := []string{r.certFile, r.keyFile}
+	if r.clientCAFile != "" {
+		files = append(files, r.clientCAFile)
+	}
+
+	modTimes := make(map[string]time.Time, len(files))
+
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, err
+		}
+
+		modTimes[file] = info.ModTime()
+	}
+
+	return modTimes, nil
+}
+
+// Identities returns the names a verified client certificate can be
+// matched by: its subject common name, DNS names and URIs.
+func Identities(cert *x509.Certificate) []string {
+	ids := make([]string, 0, 1+len(cert.DNSNames)+len(cert.URIs))
+
+	if cert.Subject.CommonName != "" {
+		ids = append(ids, cert.Subject.CommonName)
+	}
+
+	ids = append(ids, cert.DNSNames...)
+
+	for _, uri := range cert.URIs {
+		ids = append(ids, uri.String())
+	}
+
+	return ids
+}
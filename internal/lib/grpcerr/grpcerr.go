@@ -0,0 +1,101 @@
+// Package grpcerr builds the status errors the gRPC services return, with
+// google.rpc details: an ErrorInfo whose reason clients may match on, or
+// a BadRequest listing the request fields that are wrong.
+package grpcerr
+
+import (
+	"errors"
+	"strings"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/protoadapt"
+)
+
+// Domain is the domain of every google.rpc.ErrorInfo returned.
+const Domain = "xauth"
+
+// Mapping maps an error returned by a service to what clients are told.
+type Mapping struct {
+	Err    error
+	Code   codes.Code
+	Reason string
+	// Msg is told to clients. If empty, the message of Err is, so it must
+	// be meant for them.
+	Msg string
+	// Field, if set, names the request field Err is about. The error is
+	// then reported as a violation of it, with codes.InvalidArgument,
+	// instead of with Code and Reason.
+	Field string
+}
+
+// ToStatus translates err into a status error with the first of mappings
+// it matches. Errors matching none are reported as Internal without
+// details, so nothing about them leaks to clients.
+func ToStatus(err error, mappings []Mapping) error {
+	for _, m := range mappings {
+		if errors.Is(err, m.Err) {
+			msg := m.Msg
+			if msg == "" {
+				msg = m.Err.Error()
+			}
+
+			if m.Field != "" {
+				var v Violations
+				v.Add(m.Field, msg)
+
+				return v.Err()
+			}
+
+			return New(m.Code, m.Reason, msg)
+		}
+	}
+
+	return status.Error(codes.Internal, "internal error")
+}
+
+// New returns a status error with code and msg, and an ErrorInfo with
+// reason.
+func New(code codes.Code, reason string, msg string) error {
+	return withDetails(status.New(code, msg), &errdetails.ErrorInfo{
+		Reason: reason,
+		Domain: Domain,
+	})
+}
+
+// Violations collects problems with request fields.
+type Violations []*errdetails.BadRequest_FieldViolation
+
+func (v *Violations) Add(field string, description string) {
+	*v = append(*v, &errdetails.BadRequest_FieldViolation{
+		Field:       field,
+		Description: description,
+	})
+}
+
+// Err returns an InvalidArgument error with a google.rpc.BadRequest
+// listing the violations, or nil if there are none.
+func (v Violations) Err() error {
+	if len(v) == 0 {
+		return nil
+	}
+
+	msgs := make([]string, 0, len(v))
+	for _, fv := range v {
+		msgs = append(msgs, fv.GetDescription())
+	}
+
+	return withDetails(status.New(codes.InvalidArgument, strings.Join(msgs, "; ")),
+		&errdetails.BadRequest{FieldViolations: v})
+}
+
+// withDetails returns st with details attached as an error. Details are a
+// courtesy: if they can't be attached, st is returned without them.
+func withDetails(st *status.Status, details ...protoadapt.MessageV1) error {
+	if withDetails, err := st.WithDetails(details...); err == nil {
+		st = withDetails
+	}
+
+	return st.Err()
+}
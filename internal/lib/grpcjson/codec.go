@@ -0,0 +1,31 @@
+// Package grpcjson serves and calls gRPC services whose messages are plain
+// Go structs encoded as JSON rather than protobuf, so they need no
+// generated code.
+package grpcjson
+
+import (
+	"encoding/json"
+
+	"google.golang.org/grpc/encoding"
+)
+
+// Codec is the content subtype the services are served with.
+const Codec = "json"
+
+func init() {
+	encoding.RegisterCodec(jsonCodec{})
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+func (jsonCodec) Name() string {
+	return Codec
+}
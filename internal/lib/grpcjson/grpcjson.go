@@ -0,0 +1,60 @@
+package grpcjson
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+)
+
+// Unary builds the descriptor of a method of service, doing what generated
+// code would: decode the request and run the handler through the
+// interceptors. S is the type of the server registered for the service.
+func Unary[S, Req, Resp any](
+	service string,
+	name string,
+	call func(S, context.Context, *Req) (*Resp, error),
+) grpc.MethodDesc {
+	return grpc.MethodDesc{
+		MethodName: name,
+		Handler: func(
+			srv any,
+			ctx context.Context,
+			dec func(any) error,
+			interceptor grpc.UnaryServerInterceptor,
+		) (any, error) {
+			req := new(Req)
+			if err := dec(req); err != nil {
+				return nil, err
+			}
+
+			handler := func(ctx context.Context, req any) (any, error) {
+				return call(srv.(S), ctx, req.(*Req))
+			}
+			if interceptor == nil {
+				return handler(ctx, req)
+			}
+
+			return interceptor(ctx, req, &grpc.UnaryServerInfo{
+				Server:     srv,
+				FullMethod: "/" + service + "/" + name,
+			}, handler)
+		},
+	}
+}
+
+// Invoke calls method on conn with the JSON codec.
+func Invoke[Resp any](
+	ctx context.Context,
+	conn grpc.ClientConnInterface,
+	method string,
+	req any,
+) (*Resp, error) {
+	resp := new(Resp)
+
+	if err := conn.Invoke(ctx, method, req, resp,
+		grpc.CallContentSubtype(Codec)); err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,258 @@
+// Package hashpool runs password hashing on a fixed number of workers, so
+// that a burst of logins can't take every CPU from other requests.
+package hashpool
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ErrQueueFull = errors.New("hashing queue is full")
+	ErrNoTime    = errors.New("hashing can't finish before the deadline")
+	ErrClosed    = errors.New("hashing pool is closed")
+)
+
+// Pool runs hashing jobs on its workers, queueing a limited number of
+// them. Jobs that wouldn't finish before their context's deadline are
+// rejected rather than run, since their result would be thrown away.
+type Pool struct {
+	jobs    chan *job
+	workers int
+
+	// pending counts the jobs queued or running, up to cap(jobs): the
+	// workers plus the queue depth.
+	pending atomic.Int64
+
+	// avgNanos is a moving average of how long a job takes, 0 until the
+	// first one finishes.
+	avgNanos atomic.Int64
+
+	busy             atomic.Int64
+	completed        atomic.Uint64
+	rejectedFull     atomic.Uint64
+	rejectedDeadline atomic.Uint64
+	waitNanos        atomic.Uint64
+
+	closeOnce sync.Once
+	done      chan struct{}
+	wg        sync.WaitGroup
+}
+
+type job struct {
+	ctx      context.Context
+	fn       func()
+	queuedAt time.Time
+	// state is one of the job states below, changed by whoever gets to it
+	// first: the worker or the caller giving up on it.
+	state  atomic.Int32
+	result chan error
+}
+
+const (
+	jobQueued int32 = iota
+	jobTaken
+	jobAbandoned
+)
+
+// Stats is a snapshot of the pool's state and counters since it started.
+type Stats struct {
+	Workers    int
+	Busy       int
+	Queued     int
+	QueueDepth int
+	Completed  uint64
+	// RejectedFull counts jobs rejected because the queue was full, and
+	// RejectedDeadline those that couldn't finish before their deadline.
+	RejectedFull     uint64
+	RejectedDeadline uint64
+	// WaitTime is the total time jobs spent queued before they ran.
+	WaitTime time.Duration
+	// AvgJobTime is the moving average of how long a job takes.
+	AvgJobTime time.Duration
+}
+
+// New starts a pool of workers, which queues at most queueDepth jobs
+// while they are busy.
+func New(workers int, queueDepth int) *Pool {
+	p := &Pool{
+		jobs:    make(chan *job, workers+queueDepth),
+		workers: workers,
+		done:    make(chan struct{}),
+	}
+
+	p.wg.Add(workers)
+	for range workers {
+		go p.work()
+	}
+
+	return p
+}
+
+// Do runs fn on a worker and waits for it to return.
+//
+// If the queue is full, it returns ErrQueueFull at once. If ctx has a
+// deadline that fn can't be expected to meet, going by how long recent
+// jobs waited and ran, it returns ErrNoTime, either at once or when fn is
+// due to run. If ctx is done while fn is queued, fn doesn't run and ctx's
+// error is returned.
+func (p *Pool) Do(ctx context.Context, fn func()) error {
+	select {
+	case <-p.done:
+		return ErrClosed
+	default:
+	}
+
+	pending := p.pending.Add(1)
+	if pending > int64(cap(p.jobs)) {
+		p.pending.Add(-1)
+		p.rejectedFull.Add(1)
+
+		return ErrQueueFull
+	}
+
+	if err := p.checkDeadline(ctx, int(pending)); err != nil {
+		p.pending.Add(-1)
+
+		return err
+	}
+
+	j := &job{ctx: ctx, fn: fn, queuedAt: time.Now(), result: make(chan error, 1)}
+
+	// pending guarantees room in the channel.
+	p.jobs <- j
+
+	var err error
+	select {
+	case err = <-j.result:
+		return err
+	case <-ctx.Done():
+		err = ctx.Err()
+	case <-p.done:
+		err = ErrClosed
+	}
+
+	if j.state.CompareAndSwap(jobQueued, jobAbandoned) {
+		return err
+	}
+
+	// A worker took the job already; fn can't be stopped.
+	return <-j.result
+}
+
+// checkDeadline returns ErrNoTime if a job can't be expected to finish
+// before ctx's deadline, with ahead jobs queued or running before it,
+// counting itself.
+func (p *Pool) checkDeadline(ctx context.Context, ahead int) error {
+	deadline, ok := ctx.Deadline()
+	avg := time.Duration(p.avgNanos.Load())
+	if !ok || avg == 0 {
+		return nil
+	}
+
+	// Jobs ahead are spread over the workers; the last round includes
+	// this one.
+	rounds := (ahead + p.workers - 1) / p.workers
+	if time.Until(deadline) < time.Duration(rounds)*avg {
+		p.rejectedDeadline.Add(1)
+
+		return ErrNoTime
+	}
+
+	return nil
+}
+
+func (p *Pool) work() {
+	defer p.wg.Done()
+
+	for {
+		select {
+		case <-p.done:
+			return
+		case j := <-p.jobs:
+			p.run(j)
+		}
+	}
+}
+
+func (p *Pool) run(j *job) {
+	defer p.pending.Add(-1)
+
+	if !j.state.CompareAndSwap(jobQueued, jobTaken) {
+		return
+	}
+
+	p.waitNanos.Add(uint64(time.Since(j.queuedAt)))
+
+	if err := p.checkDeadline(j.ctx, 1); err != nil {
+		j.result <- err
+
+		return
+	}
+
+	p.busy.Add(1)
+	start := time.Now()
+	j.fn()
+	took := time.Since(start)
+	p.busy.Add(-1)
+
+	p.completed.Add(1)
+	p.observe(took)
+
+	j.result <- nil
+}
+
+// observe adds a job's duration to the moving average, weighing it by 1/8.
+func (p *Pool) observe(took time.Duration) {
+	for {
+		old := p.avgNanos.Load()
+
+		avg := int64(took)
+		if old != 0 {
+			avg = old + (int64(took)-old)/8
+		}
+
+		if p.avgNanos.CompareAndSwap(old, avg) {
+			return
+		}
+	}
+}
+
+// Stats returns the pool's current state and counters.
+func (p *Pool) Stats() Stats {
+	return Stats{
+		Workers:          p.workers,
+		Busy:             int(p.busy.Load()),
+		Queued:           len(p.jobs),
+		QueueDepth:       cap(p.jobs) - p.workers,
+		Completed:        p.completed.Load(),
+		RejectedFull:     p.rejectedFull.Load(),
+		RejectedDeadline: p.rejectedDeadline.Load(),
+		WaitTime:         time.Duration(p.waitNanos.Load()),
+		AvgJobTime:       time.Duration(p.avgNanos.Load()),
+	}
+}
+
+// Close stops the workers once their current jobs finish. Jobs still
+// queued fail with ErrClosed, as do later calls to Do.
+func (p *Pool) Close() {
+	p.closeOnce.Do(func() {
+		close(p.done)
+		p.wg.Wait()
+
+		for {
+			select {
+			case j := <-p.jobs:
+				if j.state.CompareAndSwap(jobQueued, jobTaken) {
+					j.result <- ErrClosed
+				}
+				p.pending.Add(-1)
+			default:
+				return
+			}
+		}
+	})
+}
@@ -0,0 +1,28 @@
+// Package identifier canonicalizes the emails and usernames users sign in
+// with, so that a user is found however they type them.
+package identifier
+
+import (
+	"strings"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Normalize returns the canonical form of an email or username: NFKC
+// normalized, case folded and without surrounding spaces. Identifiers
+// with the same canonical form name the same user.
+func Normalize(s string) string {
+	// Case folding may undo NFKC normalization, so it is applied again.
+	s = norm.NFKC.String(s)
+	s = cases.Fold().String(s)
+	s = norm.NFKC.String(s)
+
+	return strings.TrimSpace(s)
+}
+
+// IsEmail reports whether the identifier is an email address rather than
+// a username. Usernames chosen at registration can't contain "@".
+func IsEmail(s string) bool {
+	return strings.Contains(s, "@")
+}
@@ -0,0 +1,157 @@
+package jwk
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+)
+
+const keyBits = 2048
+
+var (
+	ErrNoPEMBlock = errors.New("no PEM block found in key file")
+	ErrNotRSAKey  = errors.New("key is not an RSA private key")
+	ErrBadJWK     = errors.New("malformed RSA JWK")
+)
+
+// Key is an RSA key used to sign ID tokens.
+type Key struct {
+	// ID is the RFC 7638 thumbprint of the public key. It is sent as the
+	// "kid" header so verifiers can pick the key from the JWK set.
+	ID      string
+	Private *rsa.PrivateKey
+}
+
+// JWK is the public part of a key as described in RFC 7517.
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// Set is a JWK set served at the jwks_uri.
+type Set struct {
+	Keys []JWK `json:"keys"`
+}
+
+// Load reads a PEM encoded RSA private key (PKCS #1 or PKCS #8).
+func Load(path string) (*Key, error) {
+	const op = "jwk.Load"
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: %w", op, ErrNoPEMBlock)
+	}
+
+	private, err := parsePrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return newKey(private), nil
+}
+
+// Generate creates a new random key. Tokens signed with it can't be
+// verified after a restart, so it is meant for development only.
+func Generate() (*Key, error) {
+	const op = "jwk.Generate"
+
+	private, err := rsa.GenerateKey(rand.Reader, keyBits)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return newKey(private), nil
+}
+
+// Public returns the public key as a JWK.
+func (k *Key) Public() JWK {
+	pub := k.Private.PublicKey
+
+	return JWK{
+		Kty: "RSA",
+		Use: "sig",
+		Alg: "RS256",
+		Kid: k.ID,
+		N:   encodeInt(pub.N),
+		E:   encodeInt(big.NewInt(int64(pub.E))),
+	}
+}
+
+// PublicKey decodes an RSA JWK, e.g. one fetched from another provider's
+// JWK set.
+func (j JWK) PublicKey() (*rsa.PublicKey, error) {
+	if j.Kty != "RSA" {
+		return nil, ErrBadJWK
+	}
+
+	n, err := base64.RawURLEncoding.DecodeString(j.N)
+	if err != nil || len(n) == 0 {
+		return nil, ErrBadJWK
+	}
+
+	e, err := base64.RawURLEncoding.DecodeString(j.E)
+	if err != nil || len(e) == 0 || len(e) > 4 {
+		return nil, ErrBadJWK
+	}
+
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(n),
+		E: int(new(big.Int).SetBytes(e).Int64()),
+	}, nil
+}
+
+func newKey(private *rsa.PrivateKey) *Key {
+	return &Key{
+		ID:      thumbprint(&private.PublicKey),
+		Private: private,
+	}
+}
+
+func parsePrivateKey(der []byte) (*rsa.PrivateKey, error) {
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+
+	private, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, ErrNotRSAKey
+	}
+
+	return private, nil
+}
+
+// thumbprint computes the RFC 7638 thumbprint: the hash of the required
+// members in lexicographic order, without whitespace.
+func thumbprint(pub *rsa.PublicKey) string {
+	canonical := fmt.Sprintf(`{"e":"%s","kty":"RSA","n":"%s"}`,
+		encodeInt(big.NewInt(int64(pub.E))), encodeInt(pub.N))
+
+	sum := sha256.Sum256([]byte(canonical))
+
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func encodeInt(i *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(i.Bytes())
+}
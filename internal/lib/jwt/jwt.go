@@ -1,27 +1,248 @@
+// Package jwt issues and verifies the tokens of xAuth. All of them are
+// signed with the provider's RSA key, which only the server holds, so apps
+// can verify them with the public key from the JWK set but can't mint
+// them. Access tokens are meant for the xAuth API and the apps that accept
+// them alike, so their audience is the issuer itself; ID tokens are meant
+// for one app.
 package jwt
 
 import (
+	"errors"
+	"strconv"
+	"strings"
 	"time"
 	"xauth/internal/domain/models"
+	"xauth/internal/lib/jwk"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
-// NewToken creates a new JWT token for the given user and app.
-func NewToken(user models.User, app models.App, duration time.Duration) (string, error) {
-	token := jwt.New(jwt.SigningMethodHS256)
+var ErrInvalidToken = errors.New("invalid token")
 
-	claims := token.Claims.(jwt.MapClaims)
+// Grants a token can be issued by, as recorded in its gty claim.
+const (
+	// GrantPassword tokens are issued by Login, to the user who signed in
+	// with their password, and by exchanging them for organization tokens.
+	GrantPassword = "password"
+	// GrantAuthorizationCode tokens are issued to an app the user signed
+	// in to through the authorization endpoint.
+	GrantAuthorizationCode = "authorization_code"
+	// GrantClientCredentials tokens are issued to an app for itself.
+	GrantClientCredentials = "client_credentials"
+)
+
+// Claims are the verified claims of a token issued by NewToken,
+// NewCodeToken, NewOrgToken or NewClientToken.
+type Claims struct {
+	// UserID is 0 for client tokens.
+	UserID   int64
+	AppID    int
+	Email    string
+	Username string
+	Scope    string
+	Grant    string
+	// OrgID is 0 unless the token was issued by NewOrgToken.
+	OrgID   int64
+	OrgRole string
+	// IssuedAt is zero for tokens issued before the claim was added.
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+}
+
+// accessTokenType is the "typ" header of access tokens (RFC 9068), which
+// tells them apart from ID tokens signed with the same key.
+const accessTokenType = "at+jwt"
+
+// NewToken creates a new JWT token for the given user and app, as issued
+// by Login.
+func NewToken(
+	user models.User,
+	app models.App,
+	key *jwk.Key,
+	issuer string,
+	duration time.Duration,
+) (string, error) {
+	claims := accessClaims(issuer, GrantPassword, duration)
 	claims["uid"] = user.ID
 	claims["username"] = user.Username
 	claims["email"] = user.Email
-	claims["exp"] = time.Now().Add(duration).Unix()
 	claims["app_id"] = app.ID
 
-	tokenString, err := token.SignedString([]byte(app.Secret))
+	return sign(claims, key)
+}
+
+// NewCodeToken creates a new JWT token for the given user and app, as
+// issued by the authorization code grant. It carries the same claims as
+// NewToken plus the scope the app was granted.
+func NewCodeToken(
+	user models.User,
+	app models.App,
+	scope string,
+	key *jwk.Key,
+	issuer string,
+	duration time.Duration,
+) (string, error) {
+	claims := accessClaims(issuer, GrantAuthorizationCode, duration)
+	claims["uid"] = user.ID
+	claims["username"] = user.Username
+	claims["email"] = user.Email
+	claims["app_id"] = app.ID
+	claims["scope"] = scope
+
+	return sign(claims, key)
+}
+
+// NewOrgToken creates a new JWT token for the given user and app, scoped
+// to one of the user's organizations. It carries the same claims as
+// NewToken plus org_id and org_role.
+func NewOrgToken(
+	user models.User,
+	app models.App,
+	member models.Membership,
+	key *jwk.Key,
+	issuer string,
+	duration time.Duration,
+) (string, error) {
+	claims := accessClaims(issuer, GrantPassword, duration)
+	claims["uid"] = user.ID
+	claims["username"] = user.Username
+	claims["email"] = user.Email
+	claims["app_id"] = app.ID
+	claims["org_id"] = member.OrgID
+	claims["org_role"] = string(member.Role)
+
+	return sign(claims, key)
+}
+
+// NewClientToken creates a new JWT token for the app itself, as issued by
+// the client credentials grant. The token carries no user claims: its
+// subject is the client.
+func NewClientToken(
+	app models.App,
+	scopes []string,
+	key *jwk.Key,
+	issuer string,
+	duration time.Duration,
+) (string, error) {
+	claims := accessClaims(issuer, GrantClientCredentials, duration)
+	claims["sub"] = strconv.Itoa(app.ID)
+	claims["client_id"] = strconv.Itoa(app.ID)
+	claims["app_id"] = app.ID
+	claims["scope"] = strings.Join(scopes, " ")
+
+	return sign(claims, key)
+}
+
+func accessClaims(issuer, grant string, duration time.Duration) jwt.MapClaims {
+	now := time.Now()
+
+	return jwt.MapClaims{
+		"iss": issuer,
+		"aud": issuer,
+		"gty": grant,
+		"iat": now.Unix(),
+		"exp": now.Add(duration).Unix(),
+	}
+}
+
+func sign(claims jwt.MapClaims, key *jwk.Key) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = key.ID
+	token.Header["typ"] = accessTokenType
+
+	return token.SignedString(key.Private)
+}
+
+// NewIDToken creates an OpenID Connect ID token for the given user, issued
+// to app. Unlike access tokens, its audience is app and it has no at+jwt
+// type, so it can't be used in their place.
+func NewIDToken(
+	user models.User,
+	app models.App,
+	key *jwk.Key,
+	issuer string,
+	nonce string,
+	authTime time.Time,
+	duration time.Duration,
+) (string, error) {
+	token := jwt.New(jwt.SigningMethodRS256)
+	token.Header["kid"] = key.ID
+
+	now := time.Now()
+
+	claims := token.Claims.(jwt.MapClaims)
+	claims["iss"] = issuer
+	claims["sub"] = strconv.FormatInt(user.ID, 10)
+	claims["aud"] = strconv.Itoa(app.ID)
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(duration).Unix()
+	claims["auth_time"] = authTime.Unix()
+	claims["email"] = user.Email
+	// Email ownership is never verified on registration.
+	claims["email_verified"] = false
+	claims["preferred_username"] = user.Username
+
+	if nonce != "" {
+		claims["nonce"] = nonce
+	}
+
+	tokenString, err := token.SignedString(key.Private)
 	if err != nil {
 		return "", err
 	}
 
 	return tokenString, nil
 }
+
+// Parse verifies a token issued by NewToken, NewCodeToken, NewOrgToken or
+// NewClientToken with key and issuer, and returns its claims. Any problem with the token
+// is reported as ErrInvalidToken.
+func Parse(tokenString string, key *jwk.Key, issuer string) (Claims, error) {
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (any, error) {
+		if token.Header["typ"] != accessTokenType || token.Header["kid"] != key.ID {
+			return nil, ErrInvalidToken
+		}
+
+		return &key.Private.PublicKey, nil
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg()}),
+		jwt.WithIssuer(issuer),
+		jwt.WithAudience(issuer),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return Claims{}, ErrInvalidToken
+	}
+
+	mc := token.Claims.(jwt.MapClaims)
+
+	appID, ok := mc["app_id"].(float64)
+	if !ok {
+		return Claims{}, ErrInvalidToken
+	}
+
+	claims := Claims{
+		AppID: int(appID),
+	}
+
+	if uid, ok := mc["uid"].(float64); ok {
+		claims.UserID = int64(uid)
+	}
+	claims.Email, _ = mc["email"].(string)
+	claims.Username, _ = mc["username"].(string)
+	claims.Scope, _ = mc["scope"].(string)
+	claims.Grant, _ = mc["gty"].(string)
+	if orgID, ok := mc["org_id"].(float64); ok {
+		claims.OrgID = int64(orgID)
+	}
+	claims.OrgRole, _ = mc["org_role"].(string)
+
+	if iat, err := mc.GetIssuedAt(); err == nil && iat != nil {
+		claims.IssuedAt = iat.Time
+	}
+	if exp, err := mc.GetExpirationTime(); err == nil && exp != nil {
+		claims.ExpiresAt = exp.Time
+	}
+
+	return claims, nil
+}
@@ -6,6 +6,7 @@ import (
 	"io"
 	stdLog "log"
 	"log/slog"
+	"slices"
 
 	"github.com/fatih/color"
 )
@@ -85,7 +86,8 @@ func (h *PrettyHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
 	return &PrettyHandler{
 		Handler: h.Handler,
 		l:       h.l,
-		attrs:   attrs,
+		// Loggers derived from each other must not share the backing array.
+		attrs: append(slices.Clip(h.attrs), attrs...),
 	}
 }
 
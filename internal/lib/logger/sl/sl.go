@@ -1,6 +1,7 @@
 package sl
 
 import (
+	"context"
 	"log/slog"
 )
 
@@ -10,3 +11,21 @@ func Err(err error) slog.Attr {
 		Value: slog.StringValue(err.Error()),
 	}
 }
+
+type ctxKey struct{}
+
+// NewContext returns a copy of ctx carrying log, a logger scoped to the
+// request ctx belongs to.
+func NewContext(ctx context.Context, log *slog.Logger) context.Context {
+	return context.WithValue(ctx, ctxKey{}, log)
+}
+
+// FromContext returns the request-scoped logger carried by ctx, or
+// fallback if there is none.
+func FromContext(ctx context.Context, fallback *slog.Logger) *slog.Logger {
+	if log, ok := ctx.Value(ctxKey{}).(*slog.Logger); ok {
+		return log
+	}
+
+	return fallback
+}
@@ -0,0 +1,90 @@
+// Package notify delivers notifications meant for users to a webhook,
+// which sends them on by email or otherwise.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+	"xauth/internal/domain/models"
+)
+
+const httpTimeout = 10 * time.Second
+
+// Webhook posts notifications as JSON to a URL. Any 2xx response means the
+// notification was accepted.
+type Webhook struct {
+	url    string
+	client *http.Client
+}
+
+// message is the body of a notification.
+type message struct {
+	// Event is the audit action of what happened.
+	Event    string    `json:"event"`
+	Time     time.Time `json:"time"`
+	UserID   int64     `json:"user_id"`
+	Email    string    `json:"email"`
+	Username string    `json:"username"`
+	// Field is "email" or "username", whichever of the user's someone
+	// tried to register with.
+	Field string `json:"field,omitempty"`
+}
+
+// NewWebhook returns a Webhook posting to url.
+func NewWebhook(url string) *Webhook {
+	return &Webhook{
+		url:    url,
+		client: &http.Client{Timeout: httpTimeout},
+	}
+}
+
+// RegistrationAttempted tells the user that someone tried to register with
+// their email or username, named by field.
+func (w *Webhook) RegistrationAttempted(ctx context.Context, user models.User, field string) error {
+	const op = "notify.Webhook.RegistrationAttempted"
+
+	if err := w.post(ctx, message{
+		Event:    models.AuditUserRegistrationAttempted,
+		Time:     time.Now().UTC(),
+		UserID:   user.ID,
+		Email:    user.Email,
+		Username: user.Username,
+		Field:    field,
+	}); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (w *Webhook) post(ctx context.Context, msg message) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook responded with %s", resp.Status)
+	}
+
+	return nil
+}
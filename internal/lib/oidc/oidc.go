@@ -0,0 +1,326 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"xauth/internal/lib/jwk"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	maxResponseSize = 1 << 20
+	httpTimeout     = 10 * time.Second
+)
+
+var (
+	ErrIssuerMismatch = errors.New("discovered issuer doesn't match configured one")
+	ErrNoIDToken      = errors.New("token response has no id_token")
+	ErrInvalidIDToken = errors.New("invalid id token")
+)
+
+// Provider is a client of an upstream OpenID Connect provider using the
+// authorization code flow with PKCE.
+//
+// Provider metadata and signing keys are fetched on first use and cached,
+// so the provider doesn't have to be reachable when the server starts.
+type Provider struct {
+	issuer       string
+	clientID     string
+	clientSecret string
+	redirectURL  string
+	scopes       []string
+	client       *http.Client
+
+	mu       sync.Mutex
+	metadata *metadata
+	keys     map[string]*rsa.PublicKey
+}
+
+// IDToken holds the verified claims of an upstream ID token.
+type IDToken struct {
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	PreferredUsername string
+}
+
+type metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// NewProvider returns a client for the provider at issuer. If scopes is
+// empty, "openid email profile" is requested.
+func NewProvider(
+	issuer string,
+	clientID string,
+	clientSecret string,
+	redirectURL string,
+	scopes []string,
+) *Provider {
+	if len(scopes) == 0 {
+		scopes = []string{"openid", "email", "profile"}
+	}
+
+	return &Provider{
+		issuer:       issuer,
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		redirectURL:  redirectURL,
+		scopes:       scopes,
+		client:       &http.Client{Timeout: httpTimeout},
+	}
+}
+
+// AuthCodeURL returns the URL of the provider's authorization endpoint
+// the user agent is sent to.
+func (p *Provider) AuthCodeURL(
+	ctx context.Context,
+	state string,
+	nonce string,
+	codeChallenge string,
+) (string, error) {
+	const op = "oidc.AuthCodeURL"
+
+	md, err := p.discover(ctx)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	u, err := url.Parse(md.AuthorizationEndpoint)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	q := u.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", p.clientID)
+	q.Set("redirect_uri", p.redirectURL)
+	q.Set("scope", strings.Join(p.scopes, " "))
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", codeChallenge)
+	q.Set("code_challenge_method", "S256")
+	u.RawQuery = q.Encode()
+
+	return u.String(), nil
+}
+
+// Exchange redeems code at the provider's token endpoint and returns the
+// verified ID token. nonce must be the one sent in AuthCodeURL.
+func (p *Provider) Exchange(
+	ctx context.Context,
+	code string,
+	codeVerifier string,
+	nonce string,
+) (IDToken, error) {
+	const op = "oidc.Exchange"
+
+	md, err := p.discover(ctx)
+	if err != nil {
+		return IDToken{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.redirectURL},
+		"code_verifier": {codeVerifier},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, md.TokenEndpoint,
+		strings.NewReader(form.Encode()))
+	if err != nil {
+		return IDToken{}, fmt.Errorf("%s: %w", op, err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(p.clientID), url.QueryEscape(p.clientSecret))
+
+	var res struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := p.do(req, &res); err != nil {
+		return IDToken{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if res.IDToken == "" {
+		return IDToken{}, fmt.Errorf("%s: %w", op, ErrNoIDToken)
+	}
+
+	token, err := p.verify(ctx, res.IDToken, nonce)
+	if err != nil {
+		return IDToken{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return token, nil
+}
+
+// verify checks the signature, issuer, audience, expiry and nonce of a
+// raw ID token, see OpenID Connect Core 1.0, section 3.1.3.7.
+func (p *Provider) verify(ctx context.Context, raw string, nonce string) (IDToken, error) {
+	token, err := jwt.Parse(raw, func(token *jwt.Token) (any, error) {
+		kid, _ := token.Header["kid"].(string)
+
+		return p.key(ctx, kid)
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg()}),
+		jwt.WithIssuer(p.issuer),
+		jwt.WithAudience(p.clientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil {
+		return IDToken{}, fmt.Errorf("%w: %w", ErrInvalidIDToken, err)
+	}
+
+	claims := token.Claims.(jwt.MapClaims)
+
+	if got, _ := claims["nonce"].(string); got != nonce {
+		return IDToken{}, fmt.Errorf("%w: nonce mismatch", ErrInvalidIDToken)
+	}
+
+	var idToken IDToken
+	idToken.Subject, _ = claims["sub"].(string)
+	idToken.Email, _ = claims["email"].(string)
+	idToken.EmailVerified, _ = claims["email_verified"].(bool)
+	idToken.PreferredUsername, _ = claims["preferred_username"].(string)
+
+	if idToken.Subject == "" {
+		return IDToken{}, fmt.Errorf("%w: no subject", ErrInvalidIDToken)
+	}
+
+	return idToken, nil
+}
+
+// key returns the signing key with the given ID. The key set is fetched
+// again if the key is unknown, to pick up rotated keys.
+func (p *Provider) key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	p.mu.Lock()
+	key, ok := p.keys[kid]
+	p.mu.Unlock()
+
+	if ok {
+		return key, nil
+	}
+
+	if err := p.fetchKeys(ctx); err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+
+	// Providers with a single key may omit the key ID.
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+func (p *Provider) fetchKeys(ctx context.Context) error {
+	md, err := p.discover(ctx)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, md.JWKSURI, nil)
+	if err != nil {
+		return err
+	}
+
+	var set jwk.Set
+	if err := p.do(req, &set); err != nil {
+		return err
+	}
+
+	keys := make(map[string]*rsa.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		key, err := k.PublicKey()
+		if err != nil {
+			// Keys of other types are ignored.
+			continue
+		}
+
+		keys[k.Kid] = key
+	}
+
+	p.mu.Lock()
+	p.keys = keys
+	p.mu.Unlock()
+
+	return nil
+}
+
+func (p *Provider) discover(ctx context.Context) (*metadata, error) {
+	p.mu.Lock()
+	md := p.metadata
+	p.mu.Unlock()
+
+	if md != nil {
+		return md, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		strings.TrimSuffix(p.issuer, "/")+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	md = &metadata{}
+	if err := p.do(req, md); err != nil {
+		return nil, err
+	}
+
+	if md.Issuer != p.issuer {
+		return nil, ErrIssuerMismatch
+	}
+
+	p.mu.Lock()
+	p.metadata = md
+	p.mu.Unlock()
+
+	return md, nil
+}
+
+func (p *Provider) do(req *http.Request, v any) error {
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s %s: unexpected status %d: %s", req.Method, req.URL,
+			resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	return json.Unmarshal(body, v)
+}
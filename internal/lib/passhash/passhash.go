@@ -0,0 +1,419 @@
+// Package passhash verifies password hashes imported from other systems,
+// so their users can sign in before their passwords are rehashed with
+// bcrypt.
+//
+// Hashes are stored in PHC string format
+// ($<algorithm>$<param>=<value>,...$<salt>$<digest>, both in unpadded
+// standard base64), except bcrypt, which keeps its crypt form ($2b$...).
+package passhash
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Algorithms supported.
+const (
+	Bcrypt       = "bcrypt"
+	PBKDF2SHA1   = "pbkdf2-sha1"
+	PBKDF2SHA256 = "pbkdf2-sha256"
+	PBKDF2SHA512 = "pbkdf2-sha512"
+	Scrypt       = "scrypt"
+	// Salted SHA hashes are digests of the password followed by the salt,
+	// or the other way round with the salt=prefix parameter.
+	SSHA1   = "ssha1"
+	SSHA256 = "ssha256"
+	SSHA512 = "ssha512"
+)
+
+// Limits on the cost parameters of imported hashes, so that a hash can't
+// make every login attempt take minutes or exhaust memory. scrypt takes
+// 128·r·2^ln bytes and time in proportion to r·p·2^ln.
+const (
+	maxIterations   = 10_000_000
+	maxScryptLogN   = 20
+	maxScryptRP     = 16
+	maxScryptMemory = 256 << 20
+)
+
+var (
+	ErrUnsupported = errors.New("unsupported password hash algorithm")
+	ErrMalformed   = errors.New("malformed password hash")
+
+	ErrInvalidFormat = errors.New("invalid hash format")
+)
+
+// Hash is a parsed password hash.
+type Hash struct {
+	Algorithm string
+	// params are the PHC parameters; bcrypt has none.
+	params map[string]string
+	salt   []byte
+	digest []byte
+	// crypt is the whole bcrypt hash.
+	crypt []byte
+}
+
+// Parse parses a password hash in bcrypt crypt form, in PHC string format
+// (also with passlib's bare PBKDF2 rounds: $pbkdf2-sha256$29000$...), in
+// Django's form (pbkdf2_sha256$<iterations>$<salt>$<digest>) or in LDAP
+// form ({SSHA}, {SSHA256} or {SSHA512} followed by the base64 of the
+// digest and the salt).
+func Parse(s string) (Hash, error) {
+	switch {
+	case strings.HasPrefix(s, "pbkdf2_"):
+		return parseDjango(s)
+	case strings.HasPrefix(s, "$2"):
+		if _, err := bcrypt.Cost([]byte(s)); err != nil {
+			return Hash{}, fmt.Errorf("%w: %w", ErrMalformed, err)
+		}
+
+		return Hash{Algorithm: Bcrypt, crypt: []byte(s)}, nil
+	case strings.HasPrefix(s, "{"):
+		return parseLDAP(s)
+	case strings.HasPrefix(s, "$"):
+		return parsePHC(s)
+	}
+
+	return Hash{}, ErrUnsupported
+}
+
+func parsePHC(s string) (Hash, error) {
+	fields := strings.Split(s[1:], "$")
+
+	switch fields[0] {
+	case PBKDF2SHA1, PBKDF2SHA256, PBKDF2SHA512, Scrypt, SSHA1, SSHA256, SSHA512:
+	default:
+		return Hash{}, fmt.Errorf("%w: %q", ErrUnsupported, fields[0])
+	}
+
+	// $id$params$salt$digest; scrypt and PBKDF2 parameters are required.
+	if len(fields) == 3 {
+		fields = []string{fields[0], "", fields[1], fields[2]}
+	}
+	if len(fields) != 4 {
+		return Hash{}, ErrMalformed
+	}
+
+	params := make(map[string]string)
+	if _, err := strconv.Atoi(fields[1]); err == nil {
+		params["i"] = fields[1]
+	} else if fields[1] != "" {
+		for _, kv := range strings.Split(fields[1], ",") {
+			k, v, ok := strings.Cut(kv, "=")
+			if !ok {
+				return Hash{}, ErrMalformed
+			}
+
+			params[k] = v
+		}
+	}
+
+	salt, err := decodeB64(fields[2])
+	if err != nil {
+		return Hash{}, err
+	}
+
+	digest, err := decodeB64(fields[3])
+	if err != nil {
+		return Hash{}, err
+	}
+
+	return newHash(fields[0], params, salt, digest)
+}
+
+// parseDjango parses Django's PBKDF2 hashes, whose salt is used as is
+// rather than base64 decoded.
+func parseDjango(s string) (Hash, error) {
+	fields := strings.Split(s, "$")
+	if len(fields) != 4 {
+		return Hash{}, ErrMalformed
+	}
+
+	algorithm := strings.ReplaceAll(fields[0], "_", "-")
+	if algorithm != PBKDF2SHA1 && algorithm != PBKDF2SHA256 {
+		return Hash{}, ErrUnsupported
+	}
+
+	digest, err := base64.StdEncoding.DecodeString(fields[3])
+	if err != nil {
+		return Hash{}, ErrMalformed
+	}
+
+	return newHash(algorithm, map[string]string{"i": fields[1]}, []byte(fields[2]), digest)
+}
+
+func parseLDAP(s string) (Hash, error) {
+	scheme, value, ok := strings.Cut(s[1:], "}")
+	if !ok {
+		return Hash{}, ErrMalformed
+	}
+
+	algorithm, size := "", 0
+	switch strings.ToUpper(scheme) {
+	case "SSHA":
+		algorithm, size = SSHA1, sha1.Size
+	case "SSHA256":
+		algorithm, size = SSHA256, sha256.Size
+	case "SSHA512":
+		algorithm, size = SSHA512, sha512.Size
+	default:
+		return Hash{}, ErrUnsupported
+	}
+
+	b, err := base64.StdEncoding.DecodeString(value)
+	if err != nil || len(b) <= size {
+		return Hash{}, ErrMalformed
+	}
+
+	return newHash(algorithm, map[string]string{}, b[size:], b[:size])
+}
+
+// newHash checks a hash of a PHC algorithm.
+func newHash(algorithm string, params map[string]string, salt, digest []byte) (Hash, error) {
+	if err := checkParams(algorithm, params); err != nil {
+		return Hash{}, err
+	}
+
+	h := Hash{Algorithm: algorithm, params: params, salt: salt, digest: digest}
+
+	if len(digest) == 0 {
+		return Hash{}, ErrMalformed
+	}
+
+	switch algorithm {
+	case SSHA1, SSHA256, SSHA512:
+		if len(digest) != h.newSHA()().Size() {
+			return Hash{}, ErrMalformed
+		}
+	}
+
+	return h, nil
+}
+
+// checkParams checks the parameters of a PHC algorithm.
+func checkParams(algorithm string, params map[string]string) error {
+	switch algorithm {
+	case PBKDF2SHA1, PBKDF2SHA256, PBKDF2SHA512:
+		if _, err := intParam(params, "i", 1, maxIterations); err != nil {
+			return err
+		}
+	case Scrypt:
+		logN, err := intParam(params, "ln", 1, maxScryptLogN)
+		if err != nil {
+			return err
+		}
+		r, err := intParam(params, "r", 1, maxScryptRP)
+		if err != nil {
+			return err
+		}
+		p, err := intParam(params, "p", 1, maxScryptRP)
+		if err != nil {
+			return err
+		}
+
+		if r*p > maxScryptRP {
+			return fmt.Errorf("%w: r*p must be at most %d", ErrMalformed, maxScryptRP)
+		}
+		if 128*r<<logN > maxScryptMemory {
+			return fmt.Errorf("%w: scrypt must use at most %d MiB", ErrMalformed,
+				maxScryptMemory>>20)
+		}
+	case SSHA1, SSHA256, SSHA512:
+		if salt := params["salt"]; salt != "" && salt != "prefix" && salt != "suffix" {
+			return fmt.Errorf("%w: salt must be prefix or suffix", ErrMalformed)
+		}
+	default:
+		return fmt.Errorf("%w: %q", ErrUnsupported, algorithm)
+	}
+
+	return nil
+}
+
+// String returns the hash in the form it is stored in.
+func (h Hash) String() string {
+	if h.Algorithm == Bcrypt {
+		return string(h.crypt)
+	}
+
+	keys := make([]string, 0, len(h.params))
+	for _, k := range []string{"ln", "r", "p", "i", "salt"} {
+		if v, ok := h.params[k]; ok {
+			keys = append(keys, k+"="+v)
+		}
+	}
+
+	b64 := base64.RawStdEncoding
+
+	return "$" + h.Algorithm + "$" + strings.Join(keys, ",") + "$" +
+		b64.EncodeToString(h.salt) + "$" + b64.EncodeToString(h.digest)
+}
+
+// Verify reports whether password matches the hash.
+func (h Hash) Verify(password string) bool {
+	var sum []byte
+
+	switch h.Algorithm {
+	case Bcrypt:
+		return bcrypt.CompareHashAndPassword(h.crypt, []byte(password)) == nil
+	case PBKDF2SHA1, PBKDF2SHA256, PBKDF2SHA512:
+		iter, _ := intParam(h.params, "i", 1, maxIterations)
+		sum = pbkdf2.Key([]byte(password), h.salt, iter, len(h.digest), h.newSHA())
+	case Scrypt:
+		logN, _ := intParam(h.params, "ln", 1, maxScryptLogN)
+		r, _ := intParam(h.params, "r", 1, maxScryptRP)
+		p, _ := intParam(h.params, "p", 1, maxScryptRP)
+
+		var err error
+		sum, err = scrypt.Key([]byte(password), h.salt, 1<<logN, r, p, len(h.digest))
+		if err != nil {
+			return false
+		}
+	case SSHA1, SSHA256, SSHA512:
+		d := h.newSHA()()
+		if h.params["salt"] == "prefix" {
+			d.Write(h.salt)
+			d.Write([]byte(password))
+		} else {
+			d.Write([]byte(password))
+			d.Write(h.salt)
+		}
+		sum = d.Sum(nil)
+	default:
+		return false
+	}
+
+	return subtle.ConstantTimeCompare(sum, h.digest) == 1
+}
+
+// NeedsRehash reports whether the hash should be replaced with a bcrypt
+// hash of the default cost once the password is known.
+func (h Hash) NeedsRehash() bool {
+	if h.Algorithm != Bcrypt {
+		return true
+	}
+
+	cost, err := bcrypt.Cost(h.crypt)
+
+	return err != nil || cost < bcrypt.DefaultCost
+}
+
+func (h Hash) newSHA() func() hash.Hash {
+	switch h.Algorithm {
+	case PBKDF2SHA1, SSHA1:
+		return sha1.New
+	case PBKDF2SHA512, SSHA512:
+		return sha512.New
+	default:
+		return sha256.New
+	}
+}
+
+func intParam(params map[string]string, name string, minValue, maxValue int) (int, error) {
+	v, err := strconv.Atoi(params[name])
+	if err != nil || v < minValue || v > maxValue {
+		return 0, fmt.Errorf("%w: %s must be between %d and %d", ErrMalformed, name,
+			minValue, maxValue)
+	}
+
+	return v, nil
+}
+
+// decodeB64 decodes unpadded or padded standard base64, also accepting
+// the "." that passlib uses instead of "+".
+func decodeB64(s string) ([]byte, error) {
+	s = strings.TrimRight(strings.ReplaceAll(s, ".", "+"), "=")
+
+	b, err := base64.RawStdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrMalformed
+	}
+
+	return b, nil
+}
+
+// Format describes hashes exported as a bare digest with the salt apart,
+// such as hex columns of a legacy database, and converts them.
+type Format struct {
+	algorithm string
+	decode    func(string) ([]byte, error)
+	params    map[string]string
+}
+
+// ParseFormat parses a format spec: comma-separated key=value pairs with
+// the algorithm as "alg", the encoding of digest and salt as "enc" (hex or
+// base64, the default) and the algorithm's PHC parameters, for example
+// "alg=ssha256,enc=hex,salt=prefix" or "alg=pbkdf2-sha256,i=10000".
+func ParseFormat(spec string) (Format, error) {
+	f := Format{params: make(map[string]string), decode: decodeB64}
+
+	for _, kv := range strings.Split(spec, ",") {
+		k, v, ok := strings.Cut(strings.TrimSpace(kv), "=")
+		if !ok || v == "" {
+			return Format{}, fmt.Errorf("%w: expected key=value pairs", ErrInvalidFormat)
+		}
+
+		switch k {
+		case "alg":
+			f.algorithm = v
+		case "enc":
+			switch v {
+			case "hex":
+				f.decode = decodeHex
+			case "base64":
+				f.decode = decodeB64
+			default:
+				return Format{}, fmt.Errorf("%w: enc must be hex or base64", ErrInvalidFormat)
+			}
+		default:
+			f.params[k] = v
+		}
+	}
+
+	if f.algorithm == "" {
+		return Format{}, fmt.Errorf("%w: alg is required", ErrInvalidFormat)
+	}
+
+	if err := checkParams(f.algorithm, f.params); err != nil {
+		return Format{}, fmt.Errorf("%w: %w", ErrInvalidFormat, err)
+	}
+
+	return f, nil
+}
+
+// Hash returns the hash with the given digest and salt.
+func (f Format) Hash(digest string, salt string) (Hash, error) {
+	d, err := f.decode(digest)
+	if err != nil {
+		return Hash{}, err
+	}
+
+	s, err := f.decode(salt)
+	if err != nil {
+		return Hash{}, err
+	}
+
+	return newHash(f.algorithm, f.params, s, d)
+}
+
+func decodeHex(s string) ([]byte, error) {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, ErrMalformed
+	}
+
+	return b, nil
+}
@@ -0,0 +1,53 @@
+package pkce
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+)
+
+// MethodS256 is the only code challenge method accepted: the plain method
+// offers no protection if the authorization request is intercepted.
+const MethodS256 = "S256"
+
+const (
+	minVerifierLen = 43
+	maxVerifierLen = 128
+)
+
+// ChallengeS256 derives the S256 code challenge from verifier as defined
+// in RFC 7636, section 4.2.
+func ChallengeS256(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// Verify reports whether verifier matches challenge created with method.
+func Verify(challenge, method, verifier string) bool {
+	if method != MethodS256 || !validVerifier(verifier) {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare(
+		[]byte(ChallengeS256(verifier)), []byte(challenge)) == 1
+}
+
+// validVerifier checks length and charset of a code verifier:
+// 43-128 characters of [A-Z] / [a-z] / [0-9] / "-" / "." / "_" / "~".
+func validVerifier(verifier string) bool {
+	if len(verifier) < minVerifierLen || len(verifier) > maxVerifierLen {
+		return false
+	}
+
+	for _, c := range verifier {
+		switch {
+		case c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z', c >= '0' && c <= '9',
+			c == '-', c == '.', c == '_', c == '~':
+		default:
+			return false
+		}
+	}
+
+	return true
+}
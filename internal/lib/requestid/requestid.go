@@ -0,0 +1,41 @@
+// Package requestid generates and checks request IDs used to correlate log
+// lines of one request, across services.
+package requestid
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// Key is the gRPC metadata key (and HTTP header) carrying the request ID.
+const Key = "x-request-id"
+
+const maxLen = 128
+
+// New returns a random request ID.
+func New() string {
+	b := make([]byte, 16)
+	// crypto/rand.Read never fails on supported platforms.
+	_, _ = rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
+
+// Valid reports whether id, as received from a caller, may be used as is.
+// IDs are limited in length and to characters that are safe in logs.
+func Valid(id string) bool {
+	if id == "" || len(id) > maxLen {
+		return false
+	}
+
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+
+	return true
+}
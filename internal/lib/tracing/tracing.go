@@ -0,0 +1,132 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"xauth/internal/config"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	exporterOTLP   = "otlp"
+	exporterStdout = "stdout"
+	exporterFile   = "file"
+)
+
+// ShutdownFunc flushes pending spans and releases exporter resources.
+type ShutdownFunc func(ctx context.Context) error
+
+// New configures the global tracer provider and W3C trace-context
+// propagator according to cfg.
+//
+// If tracing is disabled, the global no-op provider is left in place and
+// the returned ShutdownFunc does nothing.
+func New(ctx context.Context, cfg config.TracingConfig) (ShutdownFunc, error) {
+	const op = "tracing.New"
+
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	if !cfg.Enabled {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, closer, err := newExporter(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	res := resource.NewSchemaless(
+		attribute.String("service.name", cfg.ServiceName),
+	)
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(
+			sdktrace.TraceIDRatioBased(cfg.SampleRatio),
+		)),
+	)
+
+	otel.SetTracerProvider(tp)
+
+	return func(ctx context.Context) error {
+		err := tp.Shutdown(ctx)
+		if closer != nil {
+			err = errors.Join(err, closer.Close())
+		}
+
+		return err
+	}, nil
+}
+
+// MustNew is like New but panics if tracing can't be configured.
+func MustNew(ctx context.Context, cfg config.TracingConfig) ShutdownFunc {
+	shutdown, err := New(ctx, cfg)
+	if err != nil {
+		panic(err)
+	}
+
+	return shutdown
+}
+
+func newExporter(
+	ctx context.Context, cfg config.TracingConfig,
+) (sdktrace.SpanExporter, io.Closer, error) {
+	switch cfg.Exporter {
+	case exporterOTLP:
+		opts := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(cfg.Endpoint)}
+		if cfg.Insecure {
+			opts = append(opts, otlptracegrpc.WithInsecure())
+		}
+
+		exp, err := otlptracegrpc.New(ctx, opts...)
+
+		return exp, nil, err
+	case exporterStdout:
+		exp, err := stdouttrace.New(stdouttrace.WithPrettyPrint())
+
+		return exp, nil, err
+	case exporterFile:
+		f, err := os.OpenFile(cfg.FilePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		exp, err := stdouttrace.New(stdouttrace.WithWriter(f))
+		if err != nil {
+			_ = f.Close()
+
+			return nil, nil, err
+		}
+
+		return exp, f, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown exporter %q", cfg.Exporter)
+	}
+}
+
+// Tracer returns a named tracer from the global provider.
+func Tracer(name string) trace.Tracer {
+	return otel.Tracer(name)
+}
+
+// Err records err on span and marks the span as failed.
+func Err(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
@@ -0,0 +1,212 @@
+package account
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+	"xauth/internal/domain/models"
+	"xauth/internal/lib/logger/sl"
+	"xauth/internal/lib/tracing"
+	"xauth/internal/storage"
+)
+
+// auditPageSize is how many audit events are loaded at once for an export.
+const auditPageSize = 1000
+
+// Account answers data-subject requests: exporting what is stored about a
+// user and erasing their account. actorID arguments name the user acting,
+// for the audit log; it is the user themselves, an admin, or 0 for
+// operators with direct database access.
+type Account struct {
+	log   *slog.Logger
+	store Store
+}
+
+type Store interface {
+	UserByID(ctx context.Context, userID int64) (models.User, error)
+	Identities(ctx context.Context, userID int64) ([]models.Identity, error)
+	APIKeys(ctx context.Context, userID int64) ([]models.APIKey, error)
+	AuthCodes(ctx context.Context, userID int64) ([]models.AuthCode, error)
+	Memberships(ctx context.Context, userID int64) ([]models.Membership, error)
+	InvitationsByEmail(ctx context.Context, email string) ([]models.Invitation, error)
+	AuditEvents(ctx context.Context, filter models.AuditFilter) ([]models.AuditEvent, error)
+	EraseUser(ctx context.Context, userID int64, pseudonym string) error
+	SaveAuditEvent(ctx context.Context, event models.AuditEvent) error
+}
+
+var tracer = tracing.Tracer("xauth/internal/services/account")
+
+var (
+	ErrUserNotFound = errors.New("user not found")
+	ErrSoleOwner    = errors.New("user is the only owner of an organization with " +
+		"other members; ownership must be transferred first")
+)
+
+// Data is everything stored about a user. Secrets and their hashes are
+// left out.
+type Data struct {
+	User       models.User
+	Identities []models.Identity
+	APIKeys    []models.APIKey
+	// AuthCodes are the authorization codes issued to apps that haven't
+	// been exchanged for tokens yet. Access tokens themselves aren't
+	// stored.
+	AuthCodes   []models.AuthCode
+	Memberships []models.Membership
+	// Invitations are the invitations to organizations sent to the user's
+	// email address.
+	Invitations []models.Invitation
+	AuditEvents []models.AuditEvent
+}
+
+// New returns a new instance of the account service.
+func New(log *slog.Logger, store Store) *Account {
+	return &Account{
+		log:   log,
+		store: store,
+	}
+}
+
+// logger returns the logger scoped to the request ctx belongs to, falling
+// back to the service's logger outside of requests.
+func (a *Account) logger(ctx context.Context) *slog.Logger {
+	return sl.FromContext(ctx, a.log)
+}
+
+// Export returns everything stored about the user.
+func (a *Account) Export(ctx context.Context, actorID int64, userID int64) (Data, error) {
+	const op = "account.Export"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer span.End()
+
+	user, err := a.store.UserByID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return Data{}, fmt.Errorf("%s: %w", op, ErrUserNotFound)
+		}
+
+		tracing.Err(span, err)
+
+		return Data{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	user.PassHash = nil
+	data := Data{User: user}
+
+	if err := a.load(ctx, &data); err != nil {
+		tracing.Err(span, err)
+
+		return Data{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	a.logger(ctx).Info("user data exported", slog.String("op", op),
+		slog.Int64("user_id", userID), slog.Int64("actor_id", actorID))
+	a.audit(ctx, models.AuditEvent{Action: models.AuditUserDataExported,
+		ActorID: actorID, UserID: userID})
+
+	return data, nil
+}
+
+// load fills data in for data.User.
+func (a *Account) load(ctx context.Context, data *Data) error {
+	userID := data.User.ID
+
+	var err error
+	if data.Identities, err = a.store.Identities(ctx, userID); err != nil {
+		return err
+	}
+	if data.APIKeys, err = a.store.APIKeys(ctx, userID); err != nil {
+		return err
+	}
+	if data.AuthCodes, err = a.store.AuthCodes(ctx, userID); err != nil {
+		return err
+	}
+	if data.Memberships, err = a.store.Memberships(ctx, userID); err != nil {
+		return err
+	}
+	if data.Invitations, err = a.store.InvitationsByEmail(ctx, data.User.Email); err != nil {
+		return err
+	}
+
+	filter := models.AuditFilter{UserID: userID, Limit: auditPageSize}
+	for {
+		events, err := a.store.AuditEvents(ctx, filter)
+		if err != nil {
+			return err
+		}
+
+		data.AuditEvents = append(data.AuditEvents, events...)
+		if len(events) < filter.Limit {
+			return nil
+		}
+
+		filter.AfterID = events[len(events)-1].ID
+	}
+}
+
+// Erase deletes the user and everything linked to them. Their audit
+// events are kept with a random pseudonym in place of their ID, which is
+// never given to another user, so their tokens can't be used again.
+func (a *Account) Erase(ctx context.Context, actorID int64, userID int64) error {
+	const op = "account.Erase"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer span.End()
+
+	pseudonym, err := newPseudonym()
+	if err != nil {
+		tracing.Err(span, err)
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.store.EraseUser(ctx, userID, pseudonym); err != nil {
+		switch {
+		case errors.Is(err, storage.ErrUserNotFound):
+			return fmt.Errorf("%s: %w", op, ErrUserNotFound)
+		case errors.Is(err, storage.ErrSoleOwner):
+			return fmt.Errorf("%s: %w", op, ErrSoleOwner)
+		}
+
+		tracing.Err(span, err)
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	// The erased user's ID must not be recorded next to the pseudonym.
+	if actorID == userID {
+		actorID = 0
+	}
+
+	a.logger(ctx).Info("user erased", slog.String("op", op),
+		slog.String("pseudonym", pseudonym), slog.Int64("actor_id", actorID))
+	a.audit(ctx, models.AuditEvent{Action: models.AuditUserErased, ActorID: actorID,
+		Details: map[string]string{"user": pseudonym}})
+
+	return nil
+}
+
+// audit records an event. Failing to do so is logged, but doesn't fail the
+// action, which has already been taken.
+func (a *Account) audit(ctx context.Context, event models.AuditEvent) {
+	event.CreatedAt = time.Now()
+
+	if err := a.store.SaveAuditEvent(ctx, event); err != nil {
+		a.logger(ctx).Error("failed to save audit event",
+			slog.String("action", event.Action), sl.Err(err))
+	}
+}
+
+func newPseudonym() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return "erased-" + hex.EncodeToString(b), nil
+}
@@ -0,0 +1,509 @@
+package admin
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+	"xauth/internal/domain/models"
+	"xauth/internal/lib/identifier"
+	"xauth/internal/lib/logger/sl"
+	"xauth/internal/lib/passhash"
+	"xauth/internal/lib/tracing"
+	"xauth/internal/storage"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	maxNameLen = 64
+
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+
+	// MaxImportBatch is how many users ImportUsers takes at once.
+	MaxImportBatch = 1000
+)
+
+// Admin implements operator tasks. actorID arguments name the admin acting,
+// for the audit log; it is 0 for operators with direct database access.
+type Admin struct {
+	log     *slog.Logger
+	store   Store
+	backups Backups
+}
+
+type Store interface {
+	SaveApp(ctx context.Context, app models.App, redirectURIs []string) (int, error)
+	Apps(ctx context.Context) ([]models.App, error)
+	UserByEmail(ctx context.Context, email string) (models.User, error)
+	UserByUsername(ctx context.Context, username string) (models.User, error)
+	UserByID(ctx context.Context, userID int64) (models.User, error)
+	SetUserSuspended(ctx context.Context, userID int64, suspendedAt time.Time) error
+	SetAdmin(ctx context.Context, userID int64, isAdmin bool) error
+	RevokeSessions(ctx context.Context, userID int64, at time.Time) error
+	SaveAuditEvent(ctx context.Context, event models.AuditEvent) error
+	AuditEvents(ctx context.Context, filter models.AuditFilter) ([]models.AuditEvent, error)
+	ImportUsers(ctx context.Context, users []models.User, dryRun bool) ([]int64, error)
+}
+
+type Backups interface {
+	Create(ctx context.Context) (models.Backup, error)
+}
+
+var tracer = tracing.Tracer("xauth/internal/services/admin")
+
+var (
+	ErrInvalidName        = errors.New("name must be 1 to 64 characters")
+	ErrInvalidRedirectURI = errors.New("redirect URIs must be absolute URLs without a fragment")
+	ErrInvalidScope       = errors.New("scopes must not be empty or contain spaces or quotes")
+	ErrAppExists          = errors.New("app with this name already exists")
+	ErrUserNotFound       = errors.New("user not found")
+	ErrBackupsDisabled    = errors.New("backups are not available here")
+	ErrImportTooLarge     = fmt.Errorf("at most %d users can be imported at once",
+		MaxImportBatch)
+)
+
+// ImportUser is a user exported from another system.
+type ImportUser struct {
+	Email    string
+	Username string
+	// PasswordHash is in a form passhash.Parse accepts, or a bare digest
+	// if the import has a hash format.
+	PasswordHash string
+	// Salt goes with bare digests.
+	Salt string
+}
+
+// ImportResult tells what became of each user of an import, by index.
+type ImportResult struct {
+	// Imported is how many users were saved.
+	Imported int
+	// Existing users were skipped: their email or username is taken.
+	Existing []int
+	Rejected []ImportRejection
+}
+
+type ImportRejection struct {
+	Index  int
+	Reason string
+}
+
+// New returns a new instance of the admin service. backups may be nil,
+// where the database isn't the server's, and Backup then fails.
+func New(log *slog.Logger, store Store, backups Backups) *Admin {
+	return &Admin{
+		log:     log,
+		store:   store,
+		backups: backups,
+	}
+}
+
+// logger returns the logger scoped to the request ctx belongs to, falling
+// back to the service's logger outside of requests.
+func (a *Admin) logger(ctx context.Context) *slog.Logger {
+	return sl.FromContext(ctx, a.log)
+}
+
+// CreateApp registers an app with a generated secret and returns it.
+func (a *Admin) CreateApp(
+	ctx context.Context,
+	actorID int64,
+	name string,
+	redirectURIs []string,
+	scopes []string,
+) (models.App, error) {
+	const op = "admin.CreateApp"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer span.End()
+
+	if name == "" || utf8.RuneCountInString(name) > maxNameLen {
+		return models.App{}, fmt.Errorf("%s: %w", op, ErrInvalidName)
+	}
+
+	for _, uri := range redirectURIs {
+		u, err := url.Parse(uri)
+		if err != nil || !u.IsAbs() || u.Host == "" || u.Fragment != "" {
+			return models.App{}, fmt.Errorf("%s: %w", op, ErrInvalidRedirectURI)
+		}
+	}
+
+	for _, s := range scopes {
+		if s == "" || strings.ContainsAny(s, " \t\r\n\"\\") {
+			return models.App{}, fmt.Errorf("%s: %w", op, ErrInvalidScope)
+		}
+	}
+
+	secret, err := generateSecret()
+	if err != nil {
+		return models.App{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	app := models.App{Name: name, Secret: secret, AllowedScopes: scopes}
+
+	app.ID, err = a.store.SaveApp(ctx, app, redirectURIs)
+	if err != nil {
+		if errors.Is(err, storage.ErrAppExists) {
+			return models.App{}, fmt.Errorf("%s: %w", op, ErrAppExists)
+		}
+
+		tracing.Err(span, err)
+
+		return models.App{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	a.logger(ctx).Info("app created", slog.String("op", op),
+		slog.Int("app_id", app.ID), slog.Int64("actor_id", actorID))
+	a.audit(ctx, models.AuditEvent{Action: models.AuditAppCreated,
+		ActorID: actorID, AppID: app.ID})
+
+	return app, nil
+}
+
+// Apps returns all apps.
+func (a *Admin) Apps(ctx context.Context) ([]models.App, error) {
+	const op = "admin.Apps"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer span.End()
+
+	apps, err := a.store.Apps(ctx)
+	if err != nil {
+		tracing.Err(span, err)
+
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return apps, nil
+}
+
+// User returns the user with the given ID.
+func (a *Admin) User(ctx context.Context, userID int64) (models.User, error) {
+	const op = "admin.User"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer span.End()
+
+	user, err := a.store.UserByID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return models.User{}, fmt.Errorf("%s: %w", op, ErrUserNotFound)
+		}
+
+		tracing.Err(span, err)
+
+		return models.User{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return user, nil
+}
+
+// FindUser returns the user with the given email or username, depending
+// on which one login looks like.
+func (a *Admin) FindUser(ctx context.Context, login string) (models.User, error) {
+	const op = "admin.FindUser"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer span.End()
+
+	find := a.store.UserByUsername
+	if identifier.IsEmail(login) {
+		find = a.store.UserByEmail
+	}
+
+	user, err := find(ctx, login)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return models.User{}, fmt.Errorf("%s: %w", op, ErrUserNotFound)
+		}
+
+		tracing.Err(span, err)
+
+		return models.User{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	user.PassHash = nil
+
+	return user, nil
+}
+
+// SetSuspended suspends the user or lifts the suspension. Suspended users
+// can't sign in, and their tokens and API keys are rejected.
+func (a *Admin) SetSuspended(
+	ctx context.Context,
+	actorID int64,
+	userID int64,
+	suspended bool,
+) error {
+	const op = "admin.SetSuspended"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer span.End()
+
+	var at time.Time
+	action := models.AuditUserUnsuspended
+	if suspended {
+		at = time.Now()
+		action = models.AuditUserSuspended
+	}
+
+	if err := a.store.SetUserSuspended(ctx, userID, at); err != nil {
+		return a.userError(span, op, err)
+	}
+
+	a.logger(ctx).Info("user suspension changed", slog.String("op", op),
+		slog.Int64("user_id", userID), slog.Bool("suspended", suspended),
+		slog.Int64("actor_id", actorID))
+	a.audit(ctx, models.AuditEvent{Action: action, ActorID: actorID, UserID: userID})
+
+	return nil
+}
+
+// SetAdmin grants or revokes the user's admin rights.
+func (a *Admin) SetAdmin(
+	ctx context.Context,
+	actorID int64,
+	userID int64,
+	isAdmin bool,
+) error {
+	const op = "admin.SetAdmin"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer span.End()
+
+	if err := a.store.SetAdmin(ctx, userID, isAdmin); err != nil {
+		return a.userError(span, op, err)
+	}
+
+	action := models.AuditUserAdminRevoked
+	if isAdmin {
+		action = models.AuditUserAdminGranted
+	}
+
+	a.logger(ctx).Info("user admin rights changed", slog.String("op", op),
+		slog.Int64("user_id", userID), slog.Bool("is_admin", isAdmin),
+		slog.Int64("actor_id", actorID))
+	a.audit(ctx, models.AuditEvent{Action: action, ActorID: actorID, UserID: userID})
+
+	return nil
+}
+
+// RevokeSessions invalidates every access token issued to the user so far.
+// API keys are not affected.
+func (a *Admin) RevokeSessions(ctx context.Context, actorID int64, userID int64) error {
+	const op = "admin.RevokeSessions"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer span.End()
+
+	if err := a.store.RevokeSessions(ctx, userID, time.Now()); err != nil {
+		return a.userError(span, op, err)
+	}
+
+	a.logger(ctx).Info("user sessions revoked", slog.String("op", op),
+		slog.Int64("user_id", userID), slog.Int64("actor_id", actorID))
+	a.audit(ctx, models.AuditEvent{Action: models.AuditUserSessionsRevoked,
+		ActorID: actorID, UserID: userID})
+
+	return nil
+}
+
+// AuditEvents returns audit events, oldest first. A zero limit means the
+// default of 100; it is capped at 1000.
+func (a *Admin) AuditEvents(
+	ctx context.Context,
+	filter models.AuditFilter,
+) ([]models.AuditEvent, error) {
+	const op = "admin.AuditEvents"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer span.End()
+
+	if filter.Limit <= 0 {
+		filter.Limit = defaultAuditLimit
+	}
+	filter.Limit = min(filter.Limit, maxAuditLimit)
+
+	events, err := a.store.AuditEvents(ctx, filter)
+	if err != nil {
+		tracing.Err(span, err)
+
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return events, nil
+}
+
+// ImportUsers saves users of another system with their password hashes,
+// which are verified on login and replaced with bcrypt hashes once the
+// password is known. Users are saved all at once, skipping the ones whose
+// email or username is taken and the ones rejected for invalid data, so
+// an import can be retried.
+//
+// hashFormat describes bare digests (see passhash.ParseFormat); if empty,
+// hashes must be in a form passhash.Parse accepts. With dryRun, nothing is
+// saved but the result is the same.
+func (a *Admin) ImportUsers(
+	ctx context.Context,
+	actorID int64,
+	users []ImportUser,
+	hashFormat string,
+	dryRun bool,
+) (ImportResult, error) {
+	const op = "admin.ImportUsers"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer span.End()
+
+	if len(users) > MaxImportBatch {
+		return ImportResult{}, fmt.Errorf("%s: %w", op, ErrImportTooLarge)
+	}
+
+	var format *passhash.Format
+	if hashFormat != "" {
+		f, err := passhash.ParseFormat(hashFormat)
+		if err != nil {
+			return ImportResult{}, fmt.Errorf("%s: %w", op, err)
+		}
+
+		format = &f
+	}
+
+	var res ImportResult
+
+	valid := make([]models.User, 0, len(users))
+	indexes := make([]int, 0, len(users))
+
+	for i, u := range users {
+		hash, err := importHash(u, format)
+		switch {
+		case !strings.Contains(u.Email, "@"):
+			err = errors.New("invalid email")
+		case u.Username == "":
+			err = errors.New("username is required")
+		}
+		if err != nil {
+			res.Rejected = append(res.Rejected, ImportRejection{Index: i, Reason: err.Error()})
+
+			continue
+		}
+
+		valid = append(valid, models.User{
+			Email:        u.Email,
+			Username:     u.Username,
+			PassHash:     []byte(hash.String()),
+			PassHashAlgo: hash.Algorithm,
+		})
+		indexes = append(indexes, i)
+	}
+
+	ids, err := a.store.ImportUsers(ctx, valid, dryRun)
+	if err != nil {
+		tracing.Err(span, err)
+
+		return ImportResult{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	for i, id := range ids {
+		if id == 0 {
+			res.Existing = append(res.Existing, indexes[i])
+		} else {
+			res.Imported++
+		}
+	}
+
+	if dryRun || res.Imported == 0 {
+		return res, nil
+	}
+
+	a.logger(ctx).Info("users imported", slog.String("op", op),
+		slog.Int("imported", res.Imported), slog.Int("existing", len(res.Existing)),
+		slog.Int("rejected", len(res.Rejected)), slog.Int64("actor_id", actorID))
+	a.audit(ctx, models.AuditEvent{Action: models.AuditUsersImported, ActorID: actorID,
+		Details: map[string]string{"count": strconv.Itoa(res.Imported)}})
+
+	return res, nil
+}
+
+// Backup takes a backup of the database on the server.
+func (a *Admin) Backup(ctx context.Context, actorID int64) (models.Backup, error) {
+	const op = "admin.Backup"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer span.End()
+
+	if a.backups == nil {
+		return models.Backup{}, fmt.Errorf("%s: %w", op, ErrBackupsDisabled)
+	}
+
+	backup, err := a.backups.Create(ctx)
+	if err != nil {
+		tracing.Err(span, err)
+
+		return models.Backup{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	a.logger(ctx).Info("database backed up", slog.String("op", op),
+		slog.String("path", backup.Path), slog.Int64("size", backup.Size),
+		slog.Int64("actor_id", actorID))
+	a.audit(ctx, models.AuditEvent{Action: models.AuditBackupCreated, ActorID: actorID,
+		Details: map[string]string{"file": filepath.Base(backup.Path)}})
+
+	return backup, nil
+}
+
+// importHash parses the password hash of an imported user.
+func importHash(u ImportUser, format *passhash.Format) (passhash.Hash, error) {
+	if u.PasswordHash == "" {
+		return passhash.Hash{}, errors.New("password hash is required")
+	}
+
+	if format != nil {
+		return format.Hash(u.PasswordHash, u.Salt)
+	}
+
+	if u.Salt != "" {
+		return passhash.Hash{}, errors.New("salt given without a hash format")
+	}
+
+	return passhash.Parse(u.PasswordHash)
+}
+
+func (a *Admin) userError(span trace.Span, op string, err error) error {
+	if errors.Is(err, storage.ErrUserNotFound) {
+		return fmt.Errorf("%s: %w", op, ErrUserNotFound)
+	}
+
+	tracing.Err(span, err)
+
+	return fmt.Errorf("%s: %w", op, err)
+}
+
+// audit records an event. Failing to do so is logged, but doesn't fail the
+// action, which has already been taken.
+func (a *Admin) audit(ctx context.Context, event models.AuditEvent) {
+	event.CreatedAt = time.Now()
+
+	if err := a.store.SaveAuditEvent(ctx, event); err != nil {
+		a.logger(ctx).Error("failed to save audit event",
+			slog.String("action", event.Action), sl.Err(err))
+	}
+}
+
+func generateSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,220 @@
+package apikeys
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+	"xauth/internal/domain/models"
+	"xauth/internal/lib/apikey"
+	"xauth/internal/lib/logger/sl"
+	"xauth/internal/lib/tracing"
+	"xauth/internal/storage"
+)
+
+var tracer = tracing.Tracer("xauth/internal/services/apikeys")
+
+const maxNameLen = 64
+
+type APIKeys struct {
+	log   *slog.Logger
+	store Store
+	ttls  atomic.Pointer[ttls]
+}
+
+// ttls are swapped together so a key never sees the default of one config
+// and the maximum of another.
+type ttls struct {
+	defaultTTL time.Duration
+	maxTTL     time.Duration
+}
+
+type Store interface {
+	SaveAPIKey(ctx context.Context, key models.APIKey) (int64, error)
+	APIKeys(ctx context.Context, userID int64) ([]models.APIKey, error)
+	APIKeyByPrefix(ctx context.Context, prefix string) (models.APIKey, error)
+	DeleteAPIKey(ctx context.Context, userID int64, id int64) error
+}
+
+var (
+	ErrInvalidName  = errors.New("name must be 1 to 64 characters")
+	ErrInvalidScope = errors.New("scopes must not be empty or contain spaces or quotes")
+	ErrInvalidTTL   = errors.New("ttl is out of range")
+	ErrKeyExists    = errors.New("api key with this name already exists")
+	ErrKeyNotFound  = errors.New("api key not found")
+	ErrInvalidKey   = errors.New("invalid api key")
+)
+
+// New returns a new instance of the API keys service.
+func New(
+	log *slog.Logger,
+	store Store,
+	defaultTTL time.Duration,
+	maxTTL time.Duration,
+) *APIKeys {
+	a := &APIKeys{
+		log:   log,
+		store: store,
+	}
+	a.SetTTLs(defaultTTL, maxTTL)
+
+	return a
+}
+
+// SetTTLs changes the default and maximum lifetime of keys created from now
+// on. It is safe to call while requests are being served.
+func (a *APIKeys) SetTTLs(defaultTTL, maxTTL time.Duration) {
+	a.ttls.Store(&ttls{defaultTTL: defaultTTL, maxTTL: maxTTL})
+}
+
+// Create creates an API key for the user and returns it along with the
+// key itself, which can't be recovered later. If ttl is 0, the default
+// TTL is used.
+func (a *APIKeys) Create(
+	ctx context.Context,
+	userID int64,
+	name string,
+	scopes []string,
+	ttl time.Duration,
+) (models.APIKey, string, error) {
+	const op = "apikeys.Create"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer span.End()
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int64("user_id", userID),
+	)
+
+	if name == "" || utf8.RuneCountInString(name) > maxNameLen {
+		return models.APIKey{}, "", fmt.Errorf("%s: %w", op, ErrInvalidName)
+	}
+
+	for _, s := range scopes {
+		if s == "" || strings.ContainsAny(s, " \t\r\n\"\\") {
+			return models.APIKey{}, "", fmt.Errorf("%s: %w", op, ErrInvalidScope)
+		}
+	}
+
+	limits := a.ttls.Load()
+	if ttl == 0 {
+		ttl = limits.defaultTTL
+	}
+	if ttl < 0 || ttl > limits.maxTTL {
+		return models.APIKey{}, "", fmt.Errorf("%s: %w", op, ErrInvalidTTL)
+	}
+
+	key, prefix, err := apikey.Generate()
+	if err != nil {
+		return models.APIKey{}, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	now := time.Now()
+
+	apiKey := models.APIKey{
+		UserID:    userID,
+		Name:      name,
+		Prefix:    prefix,
+		KeyHash:   apikey.Hash(key),
+		Scopes:    scopes,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}
+
+	apiKey.ID, err = a.store.SaveAPIKey(ctx, apiKey)
+	if err != nil {
+		if errors.Is(err, storage.ErrAPIKeyExists) {
+			return models.APIKey{}, "", fmt.Errorf("%s: %w", op, ErrKeyExists)
+		}
+
+		log.Error("failed to save api key", sl.Err(err))
+		tracing.Err(span, err)
+
+		return models.APIKey{}, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("api key created", slog.String("prefix", prefix))
+
+	return apiKey, key, nil
+}
+
+// List returns the user's API keys.
+func (a *APIKeys) List(ctx context.Context, userID int64) ([]models.APIKey, error) {
+	const op = "apikeys.List"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer span.End()
+
+	keys, err := a.store.APIKeys(ctx, userID)
+	if err != nil {
+		tracing.Err(span, err)
+
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return keys, nil
+}
+
+// Revoke deletes the user's API key. Keys of other users are reported as
+// not found.
+func (a *APIKeys) Revoke(ctx context.Context, userID int64, id int64) error {
+	const op = "apikeys.Revoke"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer span.End()
+
+	if err := a.store.DeleteAPIKey(ctx, userID, id); err != nil {
+		if errors.Is(err, storage.ErrAPIKeyNotFound) {
+			return fmt.Errorf("%s: %w", op, ErrKeyNotFound)
+		}
+
+		tracing.Err(span, err)
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	a.log.Info("api key revoked",
+		slog.String("op", op),
+		slog.Int64("user_id", userID),
+		slog.Int64("key_id", id),
+	)
+
+	return nil
+}
+
+// Validate returns the API key if it exists and hasn't expired.
+func (a *APIKeys) Validate(ctx context.Context, key string) (models.APIKey, error) {
+	const op = "apikeys.Validate"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer span.End()
+
+	prefix, ok := apikey.Prefix(key)
+	if !ok {
+		return models.APIKey{}, fmt.Errorf("%s: %w", op, ErrInvalidKey)
+	}
+
+	apiKey, err := a.store.APIKeyByPrefix(ctx, prefix)
+	if err != nil {
+		if errors.Is(err, storage.ErrAPIKeyNotFound) {
+			return models.APIKey{}, fmt.Errorf("%s: %w", op, ErrInvalidKey)
+		}
+
+		tracing.Err(span, err)
+
+		return models.APIKey{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if subtle.ConstantTimeCompare(apikey.Hash(key), apiKey.KeyHash) != 1 ||
+		time.Now().After(apiKey.ExpiresAt) {
+		return models.APIKey{}, fmt.Errorf("%s: %w", op, ErrInvalidKey)
+	}
+
+	return apiKey, nil
+}
@@ -5,10 +5,15 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"sync/atomic"
 	"time"
 	"xauth/internal/domain/models"
+	"xauth/internal/lib/identifier"
+	"xauth/internal/lib/jwk"
 	"xauth/internal/lib/jwt"
 	"xauth/internal/lib/logger/sl"
+	"xauth/internal/lib/passhash"
+	"xauth/internal/lib/tracing"
 	"xauth/internal/storage"
 
 	"golang.org/x/crypto/bcrypt"
@@ -19,7 +24,16 @@ type Auth struct {
 	usrSaver    UserSaver
 	usrProvider UserProvider
 	appProvider AppProvider
-	tokenTTL    time.Duration
+	auditLog    AuditLog
+	notifier    Notifier
+	hashPool    HashPool
+	tokenTTL    atomic.Int64 // time.Duration, see SetTokenTTL
+	issuer      string
+	signingKey  *jwk.Key
+
+	// enumerationSafe hides from RegisterNewUser's caller whether the user
+	// existed, see New.
+	enumerationSafe bool
 }
 
 type UserSaver interface {
@@ -29,10 +43,12 @@ type UserSaver interface {
 		passHash []byte,
 		username string,
 	) (uid int64, err error)
+	SetPassHash(ctx context.Context, userID int64, passHash []byte, algo string) error
 }
 
 type UserProvider interface {
-	User(ctx context.Context, email string, username string) (models.User, error)
+	UserByEmail(ctx context.Context, email string) (models.User, error)
+	UserByUsername(ctx context.Context, username string) (models.User, error)
 	UserByID(ctx context.Context, userID int64) (models.User, error)
 	IsAdmin(ctx context.Context, userID int64) (bool, error)
 }
@@ -41,28 +57,93 @@ type AppProvider interface {
 	App(ctx context.Context, appID int) (models.App, error)
 }
 
+type AuditLog interface {
+	SaveAuditEvent(ctx context.Context, event models.AuditEvent) error
+}
+
+// Notifier tells a user that someone tried to register with their email
+// or username, named by field.
+type Notifier interface {
+	RegistrationAttempted(ctx context.Context, user models.User, field string) error
+}
+
+// HashPool runs password hashing, which takes a lot of CPU time, with
+// bounded concurrency. Do fails if fn can't run soon enough.
+type HashPool interface {
+	Do(ctx context.Context, fn func()) error
+}
+
+// backgroundTimeout limits work done after the request it was for has been
+// answered, see background.
+const backgroundTimeout = 30 * time.Second
+
+// dummyHash is compared with the passwords of users that don't exist or
+// have none, so that they take as long to fail as users with a wrong
+// password. Its cost is bcrypt.DefaultCost, which other hashes are
+// upgraded to on sign-in.
+var dummyHash = []byte("$2a$10$oyyZyL4wy9J7orVgNtcpHuFaJKYKILk1tXdxJEQBu8vk.JaxY/pL.")
+
+var tracer = tracing.Tracer("xauth/internal/services/auth")
+
 var (
 	ErrInvalidCredentials = errors.New("invalid credentials")
 	ErrInvalidAppID       = errors.New("invalid app ID")
 	ErrUserExists         = errors.New("user already exists")
 	ErrUserNotFound       = errors.New("user not found")
+	ErrUserSuspended      = errors.New("user is suspended")
+	ErrOverloaded         = errors.New("too many passwords are being hashed")
 )
 
 // New returns a new instance of the Auth service.
+//
+// If enumerationSafe is set, RegisterNewUser succeeds for emails and
+// usernames that are taken, and tells their owners through notifier, which
+// may be nil, instead.
+//
+// Passwords are hashed and compared on hashPool; if it is overloaded,
+// methods return ErrOverloaded.
+//
+// Tokens are signed with signingKey for issuer, see jwt.NewToken.
 func New(
 	log *slog.Logger,
 	userSaver UserSaver,
 	userProvider UserProvider,
 	appProvider AppProvider,
+	auditLog AuditLog,
 	tokenTTL time.Duration,
+	issuer string,
+	signingKey *jwk.Key,
+	enumerationSafe bool,
+	notifier Notifier,
+	hashPool HashPool,
 ) *Auth {
-	return &Auth{
-		usrSaver:    userSaver,
-		usrProvider: userProvider,
-		log:         log,
-		appProvider: appProvider,
-		tokenTTL:    tokenTTL,
+	a := &Auth{
+		usrSaver:        userSaver,
+		usrProvider:     userProvider,
+		log:             log,
+		appProvider:     appProvider,
+		auditLog:        auditLog,
+		notifier:        notifier,
+		hashPool:        hashPool,
+		issuer:          issuer,
+		signingKey:      signingKey,
+		enumerationSafe: enumerationSafe,
 	}
+	a.SetTokenTTL(tokenTTL)
+
+	return a
+}
+
+// SetTokenTTL changes the lifetime of tokens issued from now on. It is safe
+// to call while requests are being served.
+func (a *Auth) SetTokenTTL(ttl time.Duration) {
+	a.tokenTTL.Store(int64(ttl))
+}
+
+// logger returns the logger scoped to the request ctx belongs to, falling
+// back to the service's logger outside of requests.
+func (a *Auth) logger(ctx context.Context) *slog.Logger {
+	return sl.FromContext(ctx, a.log)
 }
 
 // Login checks if user with given credentials exists in the system and
@@ -79,7 +160,10 @@ func (a *Auth) Login(
 ) (string, error) {
 	const op = "auth.Login"
 
-	log := a.log.With(
+	ctx, span := tracer.Start(ctx, op)
+	defer span.End()
+
+	log := a.logger(ctx).With(
 		slog.String("op", op),
 		slog.String("email", email),
 		slog.String("username", username),
@@ -87,44 +171,285 @@ func (a *Auth) Login(
 
 	log.Info("attempting to login user")
 
-	user, err := a.usrProvider.User(ctx, email, username)
+	user, err := a.Authenticate(ctx, email, password, username)
 	if err != nil {
-		if errors.Is(err, storage.ErrUserNotFound) {
-			a.log.Warn("user not found", sl.Err(err))
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
 
-			return "", fmt.Errorf("%s: %w", op, ErrInvalidCredentials)
+	app, err := a.appProvider.App(ctx, appID)
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			log.Warn("app not found", slog.Int("app_id", appID))
+
+			return "", fmt.Errorf("%s: %w", op, ErrInvalidAppID)
 		}
 
-		a.log.Error("failed to get user", sl.Err(err))
+		tracing.Err(span, err)
 
 		return "", fmt.Errorf("%s: %w", op, err)
 	}
 
-	if err := bcrypt.CompareHashAndPassword(user.PassHash, []byte(password)); err != nil {
-		a.log.Info("invalid credentials", sl.Err(err))
+	log.Info("user logged in successfully")
+
+	token, err := jwt.NewToken(user, app, a.signingKey, a.issuer,
+		time.Duration(a.tokenTTL.Load()))
+	if err != nil {
+		log.Error("failed to create token", sl.Err(err))
+		tracing.Err(span, err)
 
-		return "", fmt.Errorf("%s: %w", op, ErrInvalidCredentials)
+		return "", fmt.Errorf("%s, %w", op, err)
 	}
 
-	app, err := a.appProvider.App(ctx, appID)
+	return token, nil
+}
+
+// Authenticate checks user credentials and returns the user they belong to.
+//
+// The user is looked up by email, by username or, if both are the same
+// identifier, by whichever of the two it looks like. If both are given and
+// differ, they must belong to the same user. Emails and usernames are
+// compared in canonical form (see identifier.Normalize).
+//
+// If user doesn't exist or password is incorrect, returns
+// ErrInvalidCredentials. If the user is suspended, returns
+// ErrUserSuspended.
+func (a *Auth) Authenticate(
+	ctx context.Context,
+	email string,
+	password string,
+	username string,
+) (models.User, error) {
+	const op = "auth.Authenticate"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer span.End()
+
+	log := a.logger(ctx).With(
+		slog.String("op", op),
+		slog.String("email", email),
+		slog.String("username", username),
+	)
+
+	user, err := a.findUser(ctx, email, username)
 	if err != nil {
-		return "", fmt.Errorf("%s: %w", op, err)
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.Warn("user not found", sl.Err(err))
+			if err := a.compareDummy(ctx, password); err != nil {
+				log.Warn("failed to hash password", sl.Err(err))
+
+				return models.User{}, fmt.Errorf("%s: %w", op, err)
+			}
+
+			return models.User{}, fmt.Errorf("%s: %w", op, ErrInvalidCredentials)
+		}
+
+		log.Error("failed to get user", sl.Err(err))
+		tracing.Err(span, err)
+
+		return models.User{}, fmt.Errorf("%s: %w", op, err)
 	}
 
-	log.Info("user logged in successfully")
+	if err := a.checkPassword(ctx, user, password); err != nil {
+		if errors.Is(err, ErrOverloaded) {
+			log.Warn("failed to hash password", sl.Err(err))
+
+			return models.User{}, fmt.Errorf("%s: %w", op, err)
+		}
+
+		log.Info("invalid credentials", sl.Err(err))
+		// Unknown users aren't audited, so this mustn't delay the response.
+		a.background(ctx, func(ctx context.Context) {
+			a.audit(ctx, models.AuditEvent{Action: models.AuditUserLoginFailed,
+				UserID: user.ID, Details: map[string]string{"reason": "invalid_password"}})
+		})
+
+		return models.User{}, fmt.Errorf("%s: %w", op, ErrInvalidCredentials)
+	}
 
-	token, err := jwt.NewToken(user, app, a.tokenTTL)
+	if user.Suspended() {
+		log.Info("user is suspended", slog.Int64("user_id", user.ID))
+		a.audit(ctx, models.AuditEvent{Action: models.AuditUserLoginFailed,
+			UserID: user.ID, Details: map[string]string{"reason": "suspended"}})
+
+		return models.User{}, fmt.Errorf("%s: %w", op, ErrUserSuspended)
+	}
+
+	a.audit(ctx, models.AuditEvent{Action: models.AuditUserLogin, UserID: user.ID})
+
+	return user, nil
+}
+
+// findUser returns the user with the email and username, see Authenticate.
+func (a *Auth) findUser(ctx context.Context, email string, username string) (models.User, error) {
+	if email == username {
+		if identifier.IsEmail(email) {
+			username = ""
+		} else {
+			email = ""
+		}
+	}
+
+	switch {
+	case email == "" && username == "":
+		return models.User{}, storage.ErrUserNotFound
+	case username == "":
+		return a.usrProvider.UserByEmail(ctx, email)
+	case email == "":
+		return a.usrProvider.UserByUsername(ctx, username)
+	}
+
+	// Both are looked up even if the email is unknown, so that it doesn't
+	// fail faster than a wrong password.
+	user, err := a.usrProvider.UserByEmail(ctx, email)
+	other, otherErr := a.usrProvider.UserByUsername(ctx, username)
 	if err != nil {
-		a.log.Error("failed to create token", sl.Err(err))
+		return models.User{}, err
+	}
+	if otherErr != nil {
+		return models.User{}, otherErr
+	}
 
-		return "", fmt.Errorf("%s, %w", op, err)
+	if other.ID != user.ID {
+		return models.User{}, storage.ErrUserNotFound
 	}
 
-	return token, nil
+	return user, nil
+}
+
+// checkPassword compares password with the user's hash. Hashes imported
+// from other systems, and bcrypt hashes of a lower cost, are replaced with
+// a bcrypt hash of the default cost once the password matches.
+//
+// A wrong password takes at least as long to fail as for a user that
+// doesn't exist, whose password is compared with dummyHash.
+func (a *Auth) checkPassword(ctx context.Context, user models.User, password string) error {
+	const op = "auth.checkPassword"
+
+	if len(user.PassHash) == 0 {
+		// Users of upstream providers have no password.
+		if err := a.compareDummy(ctx, password); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+
+		return fmt.Errorf("%s: %w", op, bcrypt.ErrMismatchedHashAndPassword)
+	}
+
+	var compareErr error
+	var upgrade bool
+
+	if user.PassHashAlgo == passhash.Bcrypt {
+		if err := a.hash(ctx, "bcrypt.CompareHashAndPassword", func() {
+			compareErr = bcrypt.CompareHashAndPassword(user.PassHash, []byte(password))
+		}); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+
+		cost, err := bcrypt.Cost(user.PassHash)
+		upgrade = err != nil || cost < bcrypt.DefaultCost
+	} else {
+		hash, err := passhash.Parse(string(user.PassHash))
+		if err != nil {
+			a.logger(ctx).Error("failed to parse password hash", slog.String("op", op),
+				slog.Int64("user_id", user.ID), sl.Err(err))
+
+			return fmt.Errorf("%s: %w", op, err)
+		}
+
+		var ok bool
+		if err := a.hash(ctx, "passhash.Verify", func() {
+			ok = hash.Verify(password)
+		}); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+		if !ok {
+			compareErr = bcrypt.ErrMismatchedHashAndPassword
+		}
+
+		upgrade = true
+	}
+
+	if compareErr != nil {
+		if upgrade {
+			// The hash is cheaper than dummyHash, or may be.
+			if err := a.compareDummy(ctx, password); err != nil {
+				return fmt.Errorf("%s: %w", op, err)
+			}
+		}
+
+		return fmt.Errorf("%s: %w", op, compareErr)
+	}
+
+	if upgrade {
+		a.background(ctx, func(ctx context.Context) {
+			a.upgradePassHash(ctx, user, password)
+		})
+	}
+
+	return nil
+}
+
+// compareDummy compares password with dummyHash, only to take the time a
+// real comparison would.
+func (a *Auth) compareDummy(ctx context.Context, password string) error {
+	return a.hash(ctx, "bcrypt.CompareHashAndPassword", func() {
+		_ = bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+	})
+}
+
+// hash runs fn, which hashes a password, on the hashing pool in a span
+// named name. If the pool can't run it in time, returns ErrOverloaded.
+func (a *Auth) hash(ctx context.Context, name string, fn func()) error {
+	_, span := tracer.Start(ctx, name)
+	defer span.End()
+
+	if err := a.hashPool.Do(ctx, fn); err != nil {
+		return fmt.Errorf("%w: %w", ErrOverloaded, err)
+	}
+
+	return nil
+}
+
+// upgradePassHash rehashes the password with bcrypt. Failing to do so is
+// logged, and tried again on the next login. It runs in the background,
+// as it would tell users with hashes to upgrade apart by response time.
+func (a *Auth) upgradePassHash(ctx context.Context, user models.User, password string) {
+	const op = "auth.upgradePassHash"
+
+	log := a.logger(ctx).With(
+		slog.String("op", op),
+		slog.Int64("user_id", user.ID),
+		slog.String("from", user.PassHashAlgo),
+	)
+
+	var passHash []byte
+	var err error
+	if hashErr := a.hash(ctx, "bcrypt.GenerateFromPassword", func() {
+		passHash, err = bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	}); hashErr != nil {
+		log.Warn("failed to generate password hash", sl.Err(hashErr))
+
+		return
+	}
+	if err != nil {
+		log.Error("failed to generate password hash", sl.Err(err))
+
+		return
+	}
+
+	if err := a.usrSaver.SetPassHash(ctx, user.ID, passHash, passhash.Bcrypt); err != nil {
+		log.Error("failed to save password hash", sl.Err(err))
+
+		return
+	}
+
+	log.Info("password hash upgraded")
 }
 
 // RegisterNewUser registers new user in the system and returns user ID and username.
 // If user with given username already exists, returns error.
+//
+// In enumeration-safe mode (see New), the ID is always 0 and a taken email
+// or username isn't an error; their owners are notified instead.
 func (a *Auth) RegisterNewUser(
 	ctx context.Context,
 	email string,
@@ -133,7 +458,10 @@ func (a *Auth) RegisterNewUser(
 ) (int64, error) {
 	const op = "auth.RegisterNewUser"
 
-	log := a.log.With(
+	ctx, span := tracer.Start(ctx, op)
+	defer span.End()
+
+	log := a.logger(ctx).With(
 		slog.String("op", op),
 		slog.String("email", email),
 		slog.String("username", username),
@@ -141,9 +469,18 @@ func (a *Auth) RegisterNewUser(
 
 	log.Info("registering new user")
 
-	passHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	var passHash []byte
+	var err error
+	if hashErr := a.hash(ctx, "bcrypt.GenerateFromPassword", func() {
+		passHash, err = bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	}); hashErr != nil {
+		log.Warn("failed to generate password hash", sl.Err(hashErr))
+
+		return 0, fmt.Errorf("%s: %w", op, hashErr)
+	}
 	if err != nil {
 		log.Error("failed to generate password hash", sl.Err(err))
+		tracing.Err(span, err)
 
 		return 0, fmt.Errorf("%s: %w", op, err)
 	}
@@ -153,10 +490,19 @@ func (a *Auth) RegisterNewUser(
 		if errors.Is(err, storage.ErrUserExists) {
 			log.Warn("user already exists", sl.Err(err))
 
+			if a.enumerationSafe {
+				a.background(ctx, func(ctx context.Context) {
+					a.notifyOwners(ctx, email, username)
+				})
+
+				return 0, nil
+			}
+
 			return 0, fmt.Errorf("%s: %w", op, ErrUserExists)
 		}
 
 		log.Error("failed to save user", sl.Err(err))
+		tracing.Err(span, err)
 
 		return 0, fmt.Errorf("%s: %w", op, err)
 	}
@@ -164,16 +510,93 @@ func (a *Auth) RegisterNewUser(
 	log.Info("user registered", slog.Int64("id", id),
 		slog.String("username", username))
 
+	registered := models.AuditEvent{Action: models.AuditUserRegistered, UserID: id}
+
+	if a.enumerationSafe {
+		// Waiting for the audit event, which taken emails don't get, would
+		// tell new users from existing ones, as would the ID.
+		a.background(ctx, func(ctx context.Context) {
+			a.audit(ctx, registered)
+		})
+
+		return 0, nil
+	}
+
+	a.audit(ctx, registered)
+
 	return id, nil
 }
 
+// notifyOwners tells the users with the email and the username that
+// someone tried to register with them. It runs in the background, as
+// waiting for the lookups, audit events and notifications would tell taken
+// emails apart.
+func (a *Auth) notifyOwners(ctx context.Context, email string, username string) {
+	const op = "auth.notifyOwners"
+
+	log := a.logger(ctx).With(slog.String("op", op))
+
+	notified := make(map[int64]bool, 2)
+	for _, find := range []struct {
+		field string
+		user  func(context.Context, string) (models.User, error)
+		login string
+	}{
+		{"email", a.usrProvider.UserByEmail, email},
+		{"username", a.usrProvider.UserByUsername, username},
+	} {
+		if find.login == "" {
+			continue
+		}
+
+		user, err := find.user(ctx, find.login)
+		if err != nil {
+			if !errors.Is(err, storage.ErrUserNotFound) {
+				log.Error("failed to get user", sl.Err(err))
+			}
+
+			continue
+		}
+		if notified[user.ID] {
+			continue
+		}
+		notified[user.ID] = true
+
+		a.audit(ctx, models.AuditEvent{Action: models.AuditUserRegistrationAttempted,
+			UserID: user.ID, Details: map[string]string{"field": find.field}})
+
+		if a.notifier == nil {
+			continue
+		}
+
+		if err := a.notifier.RegistrationAttempted(ctx, user, find.field); err != nil {
+			log.Error("failed to notify user", slog.Int64("user_id", user.ID), sl.Err(err))
+		}
+	}
+}
+
+// background runs fn in a goroutine, with ctx detached from the request so
+// that it isn't canceled once the response is sent. Work whose duration
+// would tell existing users apart is done there.
+func (a *Auth) background(ctx context.Context, fn func(ctx context.Context)) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), backgroundTimeout)
+		defer cancel()
+
+		fn(ctx)
+	}()
+}
+
 // GetUser returns user by ID.
 // If user doesn't exist, returns error with codes.NotFound code.
 // If internal error occurred, returns error with codes.Internal code.
 func (a *Auth) GetUser(ctx context.Context, userID int64) (models.User, error) {
 	const op = "auth.UserByID"
 
-	log := a.log.With(
+	ctx, span := tracer.Start(ctx, op)
+	defer span.End()
+
+	log := a.logger(ctx).With(
 		slog.String("op", op),
 		slog.Int64("userID", userID),
 	)
@@ -189,6 +612,7 @@ func (a *Auth) GetUser(ctx context.Context, userID int64) (models.User, error) {
 		}
 
 		log.Error("failed to get user by id", sl.Err(err))
+		tracing.Err(span, err)
 
 		return models.User{}, fmt.Errorf("%s: %w", op, err)
 	}
@@ -206,7 +630,10 @@ func (a *Auth) GetUser(ctx context.Context, userID int64) (models.User, error) {
 func (a *Auth) IsAdmin(ctx context.Context, userID int64) (bool, error) {
 	const op = "auth.IsAdmin"
 
-	log := a.log.With(
+	ctx, span := tracer.Start(ctx, op)
+	defer span.End()
+
+	log := a.logger(ctx).With(
 		slog.String("op", op),
 		slog.Int64("userID", userID),
 	)
@@ -215,12 +642,14 @@ func (a *Auth) IsAdmin(ctx context.Context, userID int64) (bool, error) {
 
 	isAdmin, err := a.usrProvider.IsAdmin(ctx, userID)
 	if err != nil {
-		if errors.Is(err, storage.ErrAppNotFound) {
+		if errors.Is(err, storage.ErrUserNotFound) {
 			log.Warn("user not found", sl.Err(err))
 
-			return false, fmt.Errorf("%s: %w", op, ErrInvalidAppID)
+			return false, fmt.Errorf("%s: %w", op, ErrUserNotFound)
 		}
 
+		tracing.Err(span, err)
+
 		return false, fmt.Errorf("%s: %w", op, err)
 	}
 
@@ -228,3 +657,14 @@ func (a *Auth) IsAdmin(ctx context.Context, userID int64) (bool, error) {
 
 	return isAdmin, nil
 }
+
+// audit records an event. Failing to do so is logged, but doesn't fail the
+// request.
+func (a *Auth) audit(ctx context.Context, event models.AuditEvent) {
+	event.CreatedAt = time.Now()
+
+	if err := a.auditLog.SaveAuditEvent(ctx, event); err != nil {
+		a.logger(ctx).Error("failed to save audit event",
+			slog.String("action", event.Action), sl.Err(err))
+	}
+}
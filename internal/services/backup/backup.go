@@ -0,0 +1,253 @@
+// Package backup takes consistent backups of the database, on request and
+// periodically, keeps the newest of them, and restores them.
+package backup
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"xauth/internal/domain/models"
+	"xauth/internal/lib/archive"
+	"xauth/internal/lib/logger/sl"
+	"xauth/internal/lib/tracing"
+)
+
+var tracer = tracing.Tracer("xauth/internal/services/backup")
+
+const (
+	// Backups are named after the time they were taken, so that they sort
+	// by it: xauth-20060102T150405.000Z.db, followed by .gz if compressed
+	// and .enc if encrypted.
+	filePrefix = "xauth-"
+	timeLayout = "20060102T150405.000Z"
+)
+
+// suffixes are the extensions of backups, as written by Create.
+var suffixes = []string{".db", ".db.gz", ".db.enc", ".db.gz.enc"}
+
+type Backups struct {
+	log      *slog.Logger
+	db       Snapshotter
+	dir      string
+	compress bool
+	key      []byte
+	keep     int
+
+	// mu lets one backup be taken at a time.
+	mu sync.Mutex
+}
+
+type Snapshotter interface {
+	Backup(ctx context.Context, path string) error
+}
+
+// New returns a new instance of the backup service, writing backups to
+// dir, compressed if compress is set and encrypted with key if it isn't
+// nil. After each backup, the oldest ones beyond keep are deleted; 0 keeps
+// all of them.
+func New(
+	log *slog.Logger,
+	db Snapshotter,
+	dir string,
+	compress bool,
+	key []byte,
+	keep int,
+) *Backups {
+	return &Backups{
+		log:      log,
+		db:       db,
+		dir:      dir,
+		compress: compress,
+		key:      key,
+		keep:     keep,
+	}
+}
+
+// Create backs up the database. The backup only appears in the directory
+// once it's complete.
+func (b *Backups) Create(ctx context.Context) (models.Backup, error) {
+	const op = "backup.Create"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer span.End()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := os.MkdirAll(b.dir, 0o700); err != nil {
+		return models.Backup{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	createdAt := time.Now().UTC()
+	path := filepath.Join(b.dir, fileName(createdAt, b.compress, b.key != nil))
+
+	if err := b.write(ctx, path); err != nil {
+		tracing.Err(span, err)
+
+		return models.Backup{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return models.Backup{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := b.prune(); err != nil {
+		b.log.Error("failed to delete old backups", slog.String("op", op), sl.Err(err))
+	}
+
+	return models.Backup{Path: path, Size: info.Size(), CreatedAt: createdAt}, nil
+}
+
+// write snapshots the database next to path and then renames it to path,
+// compressing and encrypting it on the way if needed.
+func (b *Backups) write(ctx context.Context, path string) error {
+	snapshot := path + ".snapshot"
+	if err := b.db.Backup(ctx, snapshot); err != nil {
+		return err
+	}
+	defer os.Remove(snapshot)
+
+	if !b.compress && b.key == nil {
+		return os.Rename(snapshot, path)
+	}
+
+	tmp := path + ".tmp"
+	if err := encode(snapshot, tmp, b.compress, b.key); err != nil {
+		_ = os.Remove(tmp)
+
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+func encode(src, dst string, compress bool, key []byte) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	w, err := archive.NewWriter(out, compress, key)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(w, in); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		return err
+	}
+
+	return out.Close()
+}
+
+// prune deletes the oldest backups beyond the number to keep. Other files
+// in the directory are left alone.
+func (b *Backups) prune() error {
+	if b.keep == 0 {
+		return nil
+	}
+
+	entries, err := os.ReadDir(b.dir)
+	if err != nil {
+		return err
+	}
+
+	var backups []string
+	for _, e := range entries {
+		if e.Type().IsRegular() && isBackup(e.Name()) {
+			backups = append(backups, e.Name())
+		}
+	}
+
+	if len(backups) <= b.keep {
+		return nil
+	}
+
+	// The names sort by the time the backups were taken.
+	slices.Sort(backups)
+
+	for _, name := range backups[:len(backups)-b.keep] {
+		if err := os.Remove(filepath.Join(b.dir, name)); err != nil {
+			return err
+		}
+
+		b.log.Info("old backup deleted", slog.String("name", name))
+	}
+
+	return nil
+}
+
+// Schedule backs up the database every interval until done is closed,
+// logging failures. A backup being taken when done is closed is finished.
+func (b *Backups) Schedule(interval time.Duration, done <-chan struct{}) {
+	const op = "backup.Schedule"
+
+	log := b.log.With(slog.String("op", op))
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		backup, err := b.Create(context.Background())
+		if err != nil {
+			log.Error("failed to back up the database", sl.Err(err))
+
+			continue
+		}
+
+		log.Info("database backed up",
+			slog.String("path", backup.Path), slog.Int64("size", backup.Size))
+	}
+}
+
+func fileName(createdAt time.Time, compressed, encrypted bool) string {
+	name := filePrefix + createdAt.Format(timeLayout) + ".db"
+	if compressed {
+		name += ".gz"
+	}
+	if encrypted {
+		name += ".enc"
+	}
+
+	return name
+}
+
+// isBackup reports whether name is that of a backup written by Create.
+func isBackup(name string) bool {
+	rest, ok := strings.CutPrefix(name, filePrefix)
+	if !ok || len(rest) < len(timeLayout) {
+		return false
+	}
+
+	if _, err := time.Parse(timeLayout, rest[:len(timeLayout)]); err != nil {
+		return false
+	}
+
+	return slices.Contains(suffixes, rest[len(timeLayout):])
+}
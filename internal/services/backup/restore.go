@@ -0,0 +1,190 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+	"xauth/internal/lib/archive"
+	"xauth/internal/storage/migrator"
+	"xauth/internal/storage/sqlite"
+	"xauth/migrations"
+)
+
+var (
+	ErrNoSchema     = errors.New("backup has no migrations applied")
+	ErrSchemaDirty  = errors.New("backup's schema is dirty")
+	ErrSchemaTooNew = errors.New("backup's schema is newer than this build's migrations")
+)
+
+// Restored describes a restored backup.
+type Restored struct {
+	// SchemaVersion is the backup's. If it's older than Latest, the
+	// database must be migrated before the server uses it.
+	SchemaVersion uint
+	Latest        uint
+
+	// Previous is where the replaced database was moved, or empty if there
+	// was none.
+	Previous string
+}
+
+// Restore replaces the database at storagePath with the backup at
+// backupPath, decrypting it with key if it's encrypted. The backup is
+// decoded next to the database and checked first: it must be intact, and
+// its schema, recorded in migrationsTable, clean and no newer than the
+// embedded migrations. Only then is the database, with its WAL files,
+// moved aside to <storagePath>.pre-restore-<time> and the backup moved in.
+//
+// The server must be stopped: it would go on using the replaced database.
+func Restore(
+	ctx context.Context,
+	backupPath string,
+	storagePath string,
+	key []byte,
+	migrationsTable string,
+) (Restored, error) {
+	const op = "backup.Restore"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer span.End()
+
+	tmp, err := decode(backupPath, storagePath, key)
+	if err != nil {
+		return Restored{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	restored, err := check(ctx, tmp, migrationsTable)
+	if err == nil {
+		restored.Previous, err = replace(storagePath, tmp)
+	}
+	if err != nil {
+		removeDB(tmp)
+
+		return Restored{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return restored, nil
+}
+
+// decode writes the database in the backup to a new file in the directory
+// of storagePath and returns its path.
+func decode(backupPath, storagePath string, key []byte) (string, error) {
+	in, err := os.Open(backupPath)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+
+	r, err := archive.NewReader(in, key)
+	if err != nil {
+		return "", err
+	}
+
+	out, err := os.CreateTemp(filepath.Dir(storagePath),
+		filepath.Base(storagePath)+".restore-*")
+	if err != nil {
+		return "", err
+	}
+	defer out.Close()
+
+	_, err = io.Copy(out, r)
+	if err == nil {
+		err = out.Sync()
+	}
+	if err == nil {
+		err = out.Close()
+	}
+	if err != nil {
+		_ = os.Remove(out.Name())
+
+		return "", err
+	}
+
+	return out.Name(), nil
+}
+
+// check runs the integrity check on the database at path and reads its
+// schema version. The database is only read, so the file is restored as it
+// was backed up.
+func check(ctx context.Context, path, migrationsTable string) (Restored, error) {
+	version, dirty, err := sqlite.Inspect(ctx, path, migrationsTable)
+	if err != nil {
+		return Restored{}, err
+	}
+
+	latest, err := migrator.LatestFS(migrations.FS)
+	if err != nil {
+		return Restored{}, err
+	}
+
+	switch {
+	case dirty:
+		return Restored{}, fmt.Errorf("%w at version %d", ErrSchemaDirty, version)
+	case version == 0:
+		return Restored{}, ErrNoSchema
+	case version > latest:
+		return Restored{}, fmt.Errorf("%w: version %d, latest is %d",
+			ErrSchemaTooNew, version, latest)
+	}
+
+	return Restored{SchemaVersion: version, Latest: latest}, nil
+}
+
+// dbSuffixes are those of the files a database consists of.
+var dbSuffixes = []string{"", "-wal", "-shm"}
+
+// replace moves the database at storagePath aside, if there is one, and
+// the one at path in its place. It returns where the old one went. If that
+// fails, the old one is moved back.
+func replace(storagePath, path string) (string, error) {
+	var previous string
+	var moved []string
+
+	_, err := os.Stat(storagePath)
+	switch {
+	case err == nil:
+		previous = storagePath + ".pre-restore-" + time.Now().UTC().Format(timeLayout)
+
+		for _, suffix := range dbSuffixes {
+			err := os.Rename(storagePath+suffix, previous+suffix)
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				moveBack(previous, storagePath, moved)
+
+				return "", err
+			}
+
+			moved = append(moved, suffix)
+		}
+	case !errors.Is(err, fs.ErrNotExist):
+		return "", err
+	}
+
+	if err := os.Rename(path, storagePath); err != nil {
+		moveBack(previous, storagePath, moved)
+
+		return "", err
+	}
+
+	return previous, nil
+}
+
+// moveBack undoes replace moving the files with suffixes aside.
+func moveBack(previous, storagePath string, suffixes []string) {
+	for _, suffix := range suffixes {
+		_ = os.Rename(previous+suffix, storagePath+suffix)
+	}
+}
+
+func removeDB(path string) {
+	for _, suffix := range dbSuffixes {
+		_ = os.Remove(path + suffix)
+	}
+}
@@ -0,0 +1,368 @@
+package federation
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync/atomic"
+	"time"
+	"xauth/internal/domain/models"
+	"xauth/internal/lib/logger/sl"
+	"xauth/internal/lib/oidc"
+	"xauth/internal/lib/pkce"
+	"xauth/internal/lib/tracing"
+	"xauth/internal/services/oauth"
+	"xauth/internal/storage"
+)
+
+var tracer = tracing.Tracer("xauth/internal/services/federation")
+
+const randomLen = 32
+
+type Federation struct {
+	log        *slog.Logger
+	upstreams  map[string]Upstream
+	users      UserStore
+	states     StateStore
+	authorizer Authorizer
+	stateTTL   atomic.Int64 // time.Duration, see SetStateTTL
+}
+
+// Upstream is a configured upstream identity provider.
+type Upstream struct {
+	Provider Provider
+	// AllowSignup allows creating local users on first login. Otherwise
+	// only identities linked beforehand can sign in.
+	AllowSignup bool
+}
+
+type Provider interface {
+	AuthCodeURL(
+		ctx context.Context,
+		state string,
+		nonce string,
+		codeChallenge string,
+	) (string, error)
+	Exchange(
+		ctx context.Context,
+		code string,
+		codeVerifier string,
+		nonce string,
+	) (oidc.IDToken, error)
+}
+
+type UserStore interface {
+	UserByIdentity(ctx context.Context, provider string, subject string) (models.User, error)
+	SaveFederatedUser(
+		ctx context.Context,
+		email string,
+		username string,
+		identity models.Identity,
+	) (int64, error)
+}
+
+type StateStore interface {
+	SaveFederationState(ctx context.Context, state models.FederationState) error
+	ConsumeFederationState(ctx context.Context, stateHash []byte) (models.FederationState, error)
+}
+
+type Authorizer interface {
+	ValidateAuthorize(ctx context.Context, req oauth.AuthorizeRequest) error
+	AuthorizeUser(ctx context.Context, req oauth.AuthorizeRequest, userID int64) (string, error)
+}
+
+var (
+	ErrUnknownProvider  = errors.New("unknown identity provider")
+	ErrInvalidState     = errors.New("login state is invalid or expired")
+	ErrAccessDenied     = errors.New("identity provider denied access")
+	ErrUpstream         = errors.New("identity provider login failed")
+	ErrSignupNotAllowed = errors.New("no local user is linked to the identity")
+	ErrIdentityConflict = errors.New("a local user with the same email or username exists")
+)
+
+// Result tells where to send the user agent after an upstream login: the
+// app's redirect URI with either an authorization code or an error.
+type Result struct {
+	RedirectURI string
+	ClientState string
+	// Code is empty if the login failed.
+	Code string
+}
+
+// New returns a new instance of the federation service.
+func New(
+	log *slog.Logger,
+	upstreams map[string]Upstream,
+	users UserStore,
+	states StateStore,
+	authorizer Authorizer,
+	stateTTL time.Duration,
+) *Federation {
+	f := &Federation{
+		log:        log,
+		upstreams:  upstreams,
+		users:      users,
+		states:     states,
+		authorizer: authorizer,
+	}
+	f.SetStateTTL(stateTTL)
+
+	return f
+}
+
+// SetStateTTL changes how long logins started from now on may take at the
+// upstream provider. It is safe to call while requests are being served.
+func (f *Federation) SetStateTTL(ttl time.Duration) {
+	f.stateTTL.Store(int64(ttl))
+}
+
+// Providers returns the names of the configured providers, sorted.
+func (f *Federation) Providers() []string {
+	names := make([]string, 0, len(f.upstreams))
+	for name := range f.upstreams {
+		names = append(names, name)
+	}
+
+	slices.Sort(names)
+
+	return names
+}
+
+// Start begins a login at the upstream provider for an authorization
+// request of an app. It returns the provider URL to send the user agent to
+// and the state the callback must come back with.
+func (f *Federation) Start(
+	ctx context.Context,
+	provider string,
+	req oauth.AuthorizeRequest,
+	clientState string,
+) (authURL string, state string, err error) {
+	const op = "federation.Start"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer span.End()
+
+	upstream, ok := f.upstreams[provider]
+	if !ok {
+		return "", "", fmt.Errorf("%s: %w", op, ErrUnknownProvider)
+	}
+
+	if err := f.authorizer.ValidateAuthorize(ctx, req); err != nil {
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	var nonce, verifier string
+	for _, v := range []*string{&state, &nonce, &verifier} {
+		if *v, err = randomString(); err != nil {
+			return "", "", fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	err = f.states.SaveFederationState(ctx, models.FederationState{
+		StateHash:           hash(state),
+		Provider:            provider,
+		Nonce:               nonce,
+		CodeVerifier:        verifier,
+		AppID:               req.AppID,
+		RedirectURI:         req.RedirectURI,
+		Scope:               req.Scope,
+		ClientState:         clientState,
+		CodeChallenge:       req.CodeChallenge,
+		CodeChallengeMethod: req.CodeChallengeMethod,
+		ClientNonce:         req.Nonce,
+		ExpiresAt:           time.Now().Add(time.Duration(f.stateTTL.Load())),
+	})
+	if err != nil {
+		tracing.Err(span, err)
+
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	authURL, err = upstream.Provider.AuthCodeURL(ctx, state, nonce,
+		pkce.ChallengeS256(verifier))
+	if err != nil {
+		f.log.Error("failed to build upstream authorization url",
+			slog.String("op", op), slog.String("provider", provider), sl.Err(err))
+		tracing.Err(span, err)
+
+		return "", "", fmt.Errorf("%s: %w: %w", op, ErrUpstream, err)
+	}
+
+	return authURL, state, nil
+}
+
+// Callback finishes an upstream login: it redeems the provider's code,
+// finds or creates the linked local user and issues an authorization code
+// for the app's original request.
+//
+// upstreamError is the "error" parameter the provider redirected with, if
+// any. Once the state is known to be valid, the returned Result has the
+// app's redirect URI set even if an error is returned, so the error can be
+// reported to the app.
+func (f *Federation) Callback(
+	ctx context.Context,
+	provider string,
+	state string,
+	code string,
+	upstreamError string,
+) (Result, error) {
+	const op = "federation.Callback"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer span.End()
+
+	log := f.log.With(
+		slog.String("op", op),
+		slog.String("provider", provider),
+	)
+
+	upstream, ok := f.upstreams[provider]
+	if !ok {
+		return Result{}, fmt.Errorf("%s: %w", op, ErrUnknownProvider)
+	}
+
+	st, err := f.states.ConsumeFederationState(ctx, hash(state))
+	if err != nil {
+		if errors.Is(err, storage.ErrStateNotFound) {
+			return Result{}, fmt.Errorf("%s: %w", op, ErrInvalidState)
+		}
+
+		tracing.Err(span, err)
+
+		return Result{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if st.Provider != provider {
+		return Result{}, fmt.Errorf("%s: %w", op, ErrInvalidState)
+	}
+
+	res := Result{
+		RedirectURI: st.RedirectURI,
+		ClientState: st.ClientState,
+	}
+
+	if upstreamError != "" {
+		log.Info("upstream login failed", slog.String("error", upstreamError))
+
+		return res, fmt.Errorf("%s: %w: %s", op, ErrAccessDenied, upstreamError)
+	}
+
+	idToken, err := upstream.Provider.Exchange(ctx, code, st.CodeVerifier, st.Nonce)
+	if err != nil {
+		log.Warn("failed to redeem upstream code", sl.Err(err))
+		tracing.Err(span, err)
+
+		return res, fmt.Errorf("%s: %w: %w", op, ErrUpstream, err)
+	}
+
+	user, err := f.user(ctx, provider, upstream, idToken)
+	if err != nil {
+		return res, fmt.Errorf("%s: %w", op, err)
+	}
+
+	res.Code, err = f.authorizer.AuthorizeUser(ctx, oauth.AuthorizeRequest{
+		AppID:               st.AppID,
+		RedirectURI:         st.RedirectURI,
+		Scope:               st.Scope,
+		CodeChallenge:       st.CodeChallenge,
+		CodeChallengeMethod: st.CodeChallengeMethod,
+		Nonce:               st.ClientNonce,
+	}, user.ID)
+	if err != nil {
+		tracing.Err(span, err)
+
+		return res, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("user signed in via identity provider", slog.Int64("user_id", user.ID))
+
+	return res, nil
+}
+
+// user returns the local user linked to the upstream identity, creating
+// one if the provider allows it.
+//
+// Existing local users are never linked by email: a provider that doesn't
+// verify emails could otherwise take over any account.
+func (f *Federation) user(
+	ctx context.Context,
+	provider string,
+	upstream Upstream,
+	idToken oidc.IDToken,
+) (models.User, error) {
+	const op = "federation.user"
+
+	log := f.log.With(
+		slog.String("op", op),
+		slog.String("provider", provider),
+		slog.String("subject", idToken.Subject),
+	)
+
+	user, err := f.users.UserByIdentity(ctx, provider, idToken.Subject)
+	if err == nil {
+		return user, nil
+	}
+	if !errors.Is(err, storage.ErrIdentityNotFound) {
+		return models.User{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if !upstream.AllowSignup {
+		log.Warn("identity is not linked and signup is disabled")
+
+		return models.User{}, fmt.Errorf("%s: %w", op, ErrSignupNotAllowed)
+	}
+
+	if idToken.Email == "" || !idToken.EmailVerified {
+		log.Warn("identity has no verified email")
+
+		return models.User{}, fmt.Errorf("%s: %w", op, ErrSignupNotAllowed)
+	}
+
+	username := idToken.PreferredUsername
+	if username == "" {
+		username = idToken.Email
+	}
+
+	id, err := f.users.SaveFederatedUser(ctx, idToken.Email, username, models.Identity{
+		Provider: provider,
+		Subject:  idToken.Subject,
+		Email:    idToken.Email,
+	})
+	if err != nil {
+		if errors.Is(err, storage.ErrUserExists) {
+			log.Warn("local user with the same email or username exists")
+
+			return models.User{}, fmt.Errorf("%s: %w", op, ErrIdentityConflict)
+		}
+
+		return models.User{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("user created", slog.Int64("user_id", id))
+
+	return models.User{
+		ID:       id,
+		Email:    idToken.Email,
+		Username: username,
+	}, nil
+}
+
+func randomString() (string, error) {
+	b := make([]byte, randomLen)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hash(s string) []byte {
+	sum := sha256.Sum256([]byte(s))
+
+	return sum[:]
+}
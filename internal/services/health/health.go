@@ -0,0 +1,65 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+type Checker struct {
+	db              DBChecker
+	migrationsTable string
+	schemaVersion   uint
+}
+
+type DBChecker interface {
+	Ping(ctx context.Context) error
+	SchemaVersion(ctx context.Context, migrationsTable string) (version uint, dirty bool, err error)
+}
+
+var (
+	ErrDatabaseUnavailable = errors.New("database unavailable")
+	ErrSchemaDirty         = errors.New("schema is dirty")
+	ErrSchemaVersion       = errors.New("unexpected schema version")
+)
+
+// New returns a new readiness checker that expects the database to be
+// migrated exactly to schemaVersion.
+func New(
+	db DBChecker,
+	migrationsTable string,
+	schemaVersion uint,
+) *Checker {
+	return &Checker{
+		db:              db,
+		migrationsTable: migrationsTable,
+		schemaVersion:   schemaVersion,
+	}
+}
+
+// Ready reports whether the service can handle requests: the database
+// must be reachable and its schema must be clean and at the expected
+// version.
+func (c *Checker) Ready(ctx context.Context) error {
+	const op = "health.Ready"
+
+	if err := c.db.Ping(ctx); err != nil {
+		return fmt.Errorf("%s: %w: %w", op, ErrDatabaseUnavailable, err)
+	}
+
+	version, dirty, err := c.db.SchemaVersion(ctx, c.migrationsTable)
+	if err != nil {
+		return fmt.Errorf("%s: %w: %w", op, ErrDatabaseUnavailable, err)
+	}
+
+	if dirty {
+		return fmt.Errorf("%s: %w: version %d", op, ErrSchemaDirty, version)
+	}
+
+	if version != c.schemaVersion {
+		return fmt.Errorf("%s: %w: got %d, want %d",
+			op, ErrSchemaVersion, version, c.schemaVersion)
+	}
+
+	return nil
+}
@@ -0,0 +1,718 @@
+package oauth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+	"xauth/internal/domain/models"
+	"xauth/internal/lib/apikey"
+	"xauth/internal/lib/jwk"
+	"xauth/internal/lib/jwt"
+	"xauth/internal/lib/logger/sl"
+	"xauth/internal/lib/pkce"
+	"xauth/internal/lib/tracing"
+	"xauth/internal/services/apikeys"
+	"xauth/internal/services/auth"
+	"xauth/internal/storage"
+)
+
+var tracer = tracing.Tracer("xauth/internal/services/oauth")
+
+const codeLen = 32
+
+// ScopeOpenID turns an authorization request into an OpenID Connect one:
+// the token response then includes an ID token.
+const ScopeOpenID = "openid"
+
+type OAuth struct {
+	log            *slog.Logger
+	authenticator  Authenticator
+	usrProvider    UserProvider
+	appProvider    AppProvider
+	codeStore      CodeStore
+	codeTTL        atomic.Int64 // time.Duration, see SetTTLs
+	tokenTTL       atomic.Int64
+	clientTokenTTL atomic.Int64
+	issuer         string
+	signingKey     *jwk.Key
+	apiKeys        APIKeyValidator
+}
+
+type Authenticator interface {
+	Authenticate(
+		ctx context.Context,
+		email string,
+		password string,
+		username string,
+	) (models.User, error)
+}
+
+type UserProvider interface {
+	UserByID(ctx context.Context, userID int64) (models.User, error)
+}
+
+type AppProvider interface {
+	App(ctx context.Context, appID int) (models.App, error)
+	RedirectURIs(ctx context.Context, appID int) ([]string, error)
+}
+
+type APIKeyValidator interface {
+	Validate(ctx context.Context, key string) (models.APIKey, error)
+}
+
+type CodeStore interface {
+	SaveAuthCode(ctx context.Context, code models.AuthCode) error
+	ConsumeAuthCode(ctx context.Context, codeHash []byte) (models.AuthCode, error)
+}
+
+var (
+	ErrInvalidClient      = errors.New("invalid client")
+	ErrInvalidRedirectURI = errors.New("redirect uri is not registered for the client")
+	ErrInvalidRequest     = errors.New("invalid request")
+	ErrInvalidGrant       = errors.New("invalid grant")
+	ErrInvalidScope       = errors.New("scope is not allowed for the client")
+	ErrInvalidToken       = errors.New("invalid access token")
+
+	ErrCodeChallengeRequired = fmt.Errorf("%w: code_challenge is required",
+		ErrInvalidRequest)
+	ErrCodeChallengeMethod = fmt.Errorf("%w: code_challenge_method must be %s",
+		ErrInvalidRequest, pkce.MethodS256)
+)
+
+// AuthorizeRequest holds parameters of an authorization request.
+type AuthorizeRequest struct {
+	AppID               int
+	RedirectURI         string
+	Scope               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	Nonce               string
+}
+
+// TokenRequest holds parameters of an authorization code exchange.
+// ClientSecret is required if the app has one; apps registered without a
+// secret are public clients and rely on PKCE alone.
+type TokenRequest struct {
+	AppID        int
+	ClientSecret string
+	Code         string
+	RedirectURI  string
+	CodeVerifier string
+}
+
+// Token is an access token issued by the token endpoint.
+type Token struct {
+	AccessToken string
+	// IDToken is only set if the openid scope was granted.
+	IDToken   string
+	ExpiresIn time.Duration
+	Scope     string
+}
+
+// Token types reported by ValidateToken.
+const (
+	TokenTypeAccessToken = "access_token"
+	TokenTypeAPIKey      = "api_key"
+)
+
+// TokenInfo describes a valid bearer token.
+type TokenInfo struct {
+	Type string
+	// Grant is the grant an access token was issued by, one of the
+	// jwt.Grant constants; empty for API keys.
+	Grant string
+	// UserID is 0 for client tokens.
+	UserID int64
+	// AppID is 0 for API keys, which aren't issued to an app.
+	AppID     int
+	Scope     string
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+	// OrgID and OrgRole are set for organization-scoped access tokens.
+	OrgID   int64
+	OrgRole string
+	// Username is only set by Introspect.
+	Username string
+}
+
+// New returns a new instance of the OAuth service.
+func New(
+	log *slog.Logger,
+	authenticator Authenticator,
+	userProvider UserProvider,
+	appProvider AppProvider,
+	codeStore CodeStore,
+	codeTTL time.Duration,
+	tokenTTL time.Duration,
+	clientTokenTTL time.Duration,
+	issuer string,
+	signingKey *jwk.Key,
+	apiKeys APIKeyValidator,
+) *OAuth {
+	o := &OAuth{
+		log:           log,
+		authenticator: authenticator,
+		usrProvider:   userProvider,
+		appProvider:   appProvider,
+		codeStore:     codeStore,
+		issuer:        issuer,
+		signingKey:    signingKey,
+		apiKeys:       apiKeys,
+	}
+	o.SetTTLs(codeTTL, tokenTTL, clientTokenTTL)
+
+	return o
+}
+
+// SetTTLs changes the lifetime of codes and tokens issued from now on. It
+// is safe to call while requests are being served.
+func (o *OAuth) SetTTLs(codeTTL, tokenTTL, clientTokenTTL time.Duration) {
+	o.codeTTL.Store(int64(codeTTL))
+	o.tokenTTL.Store(int64(tokenTTL))
+	o.clientTokenTTL.Store(int64(clientTokenTTL))
+}
+
+// Issuer returns the OpenID Connect issuer identifier.
+func (o *OAuth) Issuer() string {
+	return o.issuer
+}
+
+// KeySet returns the public keys ID tokens can be verified with.
+func (o *OAuth) KeySet() jwk.Set {
+	return jwk.Set{Keys: []jwk.JWK{o.signingKey.Public()}}
+}
+
+// ValidateClient checks that the app exists and redirectURI is registered
+// for it. Until it passes, errors must not be sent to redirectURI.
+func (o *OAuth) ValidateClient(ctx context.Context, appID int, redirectURI string) error {
+	const op = "oauth.ValidateClient"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer span.End()
+
+	if _, err := o.appProvider.App(ctx, appID); err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			return fmt.Errorf("%s: %w", op, ErrInvalidClient)
+		}
+
+		tracing.Err(span, err)
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	uris, err := o.appProvider.RedirectURIs(ctx, appID)
+	if err != nil {
+		tracing.Err(span, err)
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	// Redirect URIs are compared exactly, as required by OAuth 2.1.
+	if !slices.Contains(uris, redirectURI) {
+		return fmt.Errorf("%s: %w", op, ErrInvalidRedirectURI)
+	}
+
+	return nil
+}
+
+// ValidateAuthorize checks an authorization request before the login
+// form is shown.
+func (o *OAuth) ValidateAuthorize(ctx context.Context, req AuthorizeRequest) error {
+	const op = "oauth.ValidateAuthorize"
+
+	if err := o.ValidateClient(ctx, req.AppID, req.RedirectURI); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if req.CodeChallenge == "" {
+		return fmt.Errorf("%s: %w", op, ErrCodeChallengeRequired)
+	}
+
+	if req.CodeChallengeMethod != pkce.MethodS256 {
+		return fmt.Errorf("%s: %w", op, ErrCodeChallengeMethod)
+	}
+
+	return nil
+}
+
+// Authorize authenticates the user and issues an authorization code bound
+// to the request's redirect URI and PKCE challenge.
+//
+// If credentials are wrong, returns auth.ErrInvalidCredentials.
+func (o *OAuth) Authorize(
+	ctx context.Context,
+	req AuthorizeRequest,
+	login string,
+	password string,
+) (string, error) {
+	const op = "oauth.Authorize"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer span.End()
+
+	if err := o.ValidateAuthorize(ctx, req); err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	user, err := o.authenticator.Authenticate(ctx, login, password, login)
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidCredentials) ||
+			errors.Is(err, auth.ErrUserSuspended) ||
+			errors.Is(err, auth.ErrOverloaded) {
+			return "", fmt.Errorf("%s: %w", op, err)
+		}
+
+		tracing.Err(span, err)
+
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	code, err := o.AuthorizeUser(ctx, req, user.ID)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	return code, nil
+}
+
+// AuthorizeUser issues an authorization code for a user who has already
+// signed in some other way, e.g. at an upstream identity provider.
+func (o *OAuth) AuthorizeUser(
+	ctx context.Context,
+	req AuthorizeRequest,
+	userID int64,
+) (string, error) {
+	const op = "oauth.AuthorizeUser"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer span.End()
+
+	log := o.log.With(
+		slog.String("op", op),
+		slog.Int("app_id", req.AppID),
+		slog.Int64("user_id", userID),
+	)
+
+	if err := o.ValidateAuthorize(ctx, req); err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	authTime := time.Now()
+
+	code, err := randomCode()
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	err = o.codeStore.SaveAuthCode(ctx, models.AuthCode{
+		CodeHash:            hashCode(code),
+		AppID:               req.AppID,
+		UserID:              userID,
+		RedirectURI:         req.RedirectURI,
+		Scope:               req.Scope,
+		CodeChallenge:       req.CodeChallenge,
+		CodeChallengeMethod: req.CodeChallengeMethod,
+		Nonce:               req.Nonce,
+		AuthTime:            authTime,
+		ExpiresAt:           authTime.Add(time.Duration(o.codeTTL.Load())),
+	})
+	if err != nil {
+		log.Error("failed to save authorization code", sl.Err(err))
+		tracing.Err(span, err)
+
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("authorization code issued")
+
+	return code, nil
+}
+
+// Exchange redeems an authorization code for an access token.
+//
+// Codes are single use: a code is consumed even if the exchange fails
+// afterwards, e.g. because of a wrong code verifier.
+func (o *OAuth) Exchange(ctx context.Context, req TokenRequest) (Token, error) {
+	const op = "oauth.Exchange"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer span.End()
+
+	log := o.log.With(
+		slog.String("op", op),
+		slog.Int("app_id", req.AppID),
+	)
+
+	app, err := o.appProvider.App(ctx, req.AppID)
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			return Token{}, fmt.Errorf("%s: %w", op, ErrInvalidClient)
+		}
+
+		tracing.Err(span, err)
+
+		return Token{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	// Apps with a secret are confidential clients, which must authenticate
+	// (RFC 6749, section 4.1.3): a code and its verifier aren't enough.
+	if app.Secret != "" &&
+		subtle.ConstantTimeCompare([]byte(req.ClientSecret), []byte(app.Secret)) != 1 {
+		log.Warn("invalid client secret")
+
+		return Token{}, fmt.Errorf("%s: %w", op, ErrInvalidClient)
+	}
+
+	code, err := o.codeStore.ConsumeAuthCode(ctx, hashCode(req.Code))
+	if err != nil {
+		if errors.Is(err, storage.ErrCodeNotFound) {
+			log.Warn("unknown or expired authorization code")
+
+			return Token{}, fmt.Errorf("%s: %w", op, ErrInvalidGrant)
+		}
+
+		tracing.Err(span, err)
+
+		return Token{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if code.AppID != req.AppID || code.RedirectURI != req.RedirectURI {
+		log.Warn("authorization code was issued for another client or redirect uri")
+
+		return Token{}, fmt.Errorf("%s: %w", op, ErrInvalidGrant)
+	}
+
+	if !pkce.Verify(code.CodeChallenge, code.CodeChallengeMethod, req.CodeVerifier) {
+		log.Warn("code verifier doesn't match code challenge")
+
+		return Token{}, fmt.Errorf("%s: %w", op, ErrInvalidGrant)
+	}
+
+	user, err := o.usrProvider.UserByID(ctx, code.UserID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return Token{}, fmt.Errorf("%s: %w", op, ErrInvalidGrant)
+		}
+
+		tracing.Err(span, err)
+
+		return Token{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	// The user may have been suspended since the code was issued.
+	if user.Suspended() {
+		return Token{}, fmt.Errorf("%s: %w", op, ErrInvalidGrant)
+	}
+
+	tokenTTL := time.Duration(o.tokenTTL.Load())
+
+	token, err := jwt.NewCodeToken(user, app, code.Scope, o.signingKey, o.issuer, tokenTTL)
+	if err != nil {
+		log.Error("failed to create token", sl.Err(err))
+		tracing.Err(span, err)
+
+		return Token{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	var idToken string
+	if slices.Contains(strings.Fields(code.Scope), ScopeOpenID) {
+		idToken, err = jwt.NewIDToken(user, app, o.signingKey, o.issuer,
+			code.Nonce, code.AuthTime, tokenTTL)
+		if err != nil {
+			log.Error("failed to create id token", sl.Err(err))
+			tracing.Err(span, err)
+
+			return Token{}, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	log.Info("authorization code exchanged", slog.Int64("user_id", user.ID))
+
+	return Token{
+		AccessToken: token,
+		IDToken:     idToken,
+		ExpiresIn:   tokenTTL,
+		Scope:       code.Scope,
+	}, nil
+}
+
+// ClientCredentials issues a token to the app itself, for
+// service-to-service calls that don't act on behalf of a user.
+//
+// If scope is empty, the token gets every scope the app is allowed.
+// Otherwise each requested scope must be in the app's allowed list.
+func (o *OAuth) ClientCredentials(
+	ctx context.Context,
+	appID int,
+	clientSecret string,
+	scope string,
+) (Token, error) {
+	const op = "oauth.ClientCredentials"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer span.End()
+
+	log := o.log.With(
+		slog.String("op", op),
+		slog.Int("app_id", appID),
+	)
+
+	app, err := o.appProvider.App(ctx, appID)
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			return Token{}, fmt.Errorf("%s: %w", op, ErrInvalidClient)
+		}
+
+		tracing.Err(span, err)
+
+		return Token{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	// Unlike the authorization code grant there is no PKCE to fall back
+	// on, so the secret is mandatory.
+	if clientSecret == "" ||
+		subtle.ConstantTimeCompare([]byte(clientSecret), []byte(app.Secret)) != 1 {
+		log.Warn("invalid client secret")
+
+		return Token{}, fmt.Errorf("%s: %w", op, ErrInvalidClient)
+	}
+
+	scopes := app.AllowedScopes
+	if requested := strings.Fields(scope); len(requested) > 0 {
+		for _, s := range requested {
+			if !slices.Contains(app.AllowedScopes, s) {
+				log.Warn("scope is not allowed", slog.String("scope", s))
+
+				return Token{}, fmt.Errorf("%s: %w: %s", op, ErrInvalidScope, s)
+			}
+		}
+
+		scopes = requested
+	}
+
+	clientTokenTTL := time.Duration(o.clientTokenTTL.Load())
+
+	token, err := jwt.NewClientToken(app, scopes, o.signingKey, o.issuer, clientTokenTTL)
+	if err != nil {
+		log.Error("failed to create token", sl.Err(err))
+		tracing.Err(span, err)
+
+		return Token{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("client token issued")
+
+	return Token{
+		AccessToken: token,
+		ExpiresIn:   clientTokenTTL,
+		Scope:       strings.Join(scopes, " "),
+	}, nil
+}
+
+// UserInfo returns the user an access token or API key was issued for.
+//
+// Client tokens aren't issued for a user, so they are rejected with
+// ErrInvalidToken, like expired or forged ones.
+func (o *OAuth) UserInfo(ctx context.Context, token string) (models.User, error) {
+	const op = "oauth.UserInfo"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer span.End()
+
+	info, err := o.ValidateToken(ctx, token)
+	if err != nil {
+		return models.User{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if info.UserID == 0 {
+		return models.User{}, fmt.Errorf("%s: %w", op, ErrInvalidToken)
+	}
+
+	user, err := o.usrProvider.UserByID(ctx, info.UserID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return models.User{}, fmt.Errorf("%s: %w", op, ErrInvalidToken)
+		}
+
+		tracing.Err(span, err)
+
+		return models.User{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return user, nil
+}
+
+// ValidateToken checks a bearer token, which is either a JWT issued by
+// Login or the token endpoint, or a user's API key.
+//
+// If the token is expired, forged or revoked, or its user is suspended,
+// returns ErrInvalidToken.
+func (o *OAuth) ValidateToken(ctx context.Context, token string) (TokenInfo, error) {
+	const op = "oauth.ValidateToken"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer span.End()
+
+	if apikey.Is(token) {
+		key, err := o.apiKeys.Validate(ctx, token)
+		if err != nil {
+			if errors.Is(err, apikeys.ErrInvalidKey) {
+				return TokenInfo{}, fmt.Errorf("%s: %w", op, ErrInvalidToken)
+			}
+
+			tracing.Err(span, err)
+
+			return TokenInfo{}, fmt.Errorf("%s: %w", op, err)
+		}
+
+		if _, err := o.activeUser(ctx, key.UserID); err != nil {
+			if !errors.Is(err, ErrInvalidToken) {
+				tracing.Err(span, err)
+			}
+
+			return TokenInfo{}, fmt.Errorf("%s: %w", op, err)
+		}
+
+		return TokenInfo{
+			Type:      TokenTypeAPIKey,
+			UserID:    key.UserID,
+			Scope:     strings.Join(key.Scopes, " "),
+			IssuedAt:  key.CreatedAt,
+			ExpiresAt: key.ExpiresAt,
+		}, nil
+	}
+
+	claims, err := jwt.Parse(token, o.signingKey, o.issuer)
+	if err != nil {
+		return TokenInfo{}, fmt.Errorf("%s: %w", op, ErrInvalidToken)
+	}
+
+	if claims.UserID != 0 {
+		user, err := o.activeUser(ctx, claims.UserID)
+		if err != nil {
+			if !errors.Is(err, ErrInvalidToken) {
+				tracing.Err(span, err)
+			}
+
+			return TokenInfo{}, fmt.Errorf("%s: %w", op, err)
+		}
+
+		// Both are whole seconds, so tokens issued in the second sessions
+		// were revoked in are revoked too, as are tokens without iat.
+		if !user.SessionsRevokedAt.IsZero() &&
+			!claims.IssuedAt.After(user.SessionsRevokedAt) {
+			return TokenInfo{}, fmt.Errorf("%s: %w", op, ErrInvalidToken)
+		}
+	}
+
+	return TokenInfo{
+		Type:      TokenTypeAccessToken,
+		Grant:     claims.Grant,
+		UserID:    claims.UserID,
+		AppID:     claims.AppID,
+		Scope:     claims.Scope,
+		IssuedAt:  claims.IssuedAt,
+		ExpiresAt: claims.ExpiresAt,
+		OrgID:     claims.OrgID,
+		OrgRole:   claims.OrgRole,
+	}, nil
+}
+
+// activeUser returns the user a token was issued to, or ErrInvalidToken
+// if they no longer exist or are suspended.
+func (o *OAuth) activeUser(ctx context.Context, userID int64) (models.User, error) {
+	user, err := o.usrProvider.UserByID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return models.User{}, ErrInvalidToken
+		}
+
+		return models.User{}, err
+	}
+
+	if user.Suspended() {
+		return models.User{}, ErrInvalidToken
+	}
+
+	return user, nil
+}
+
+// Introspect returns what token grants, for resource servers that can't
+// validate tokens themselves (RFC 7662). The caller authenticates as an
+// app with its secret.
+//
+// If the token is not valid, returns ErrInvalidToken; callers report it as
+// inactive rather than as an error.
+func (o *OAuth) Introspect(
+	ctx context.Context,
+	appID int,
+	clientSecret string,
+	token string,
+) (TokenInfo, error) {
+	const op = "oauth.Introspect"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer span.End()
+
+	app, err := o.appProvider.App(ctx, appID)
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			return TokenInfo{}, fmt.Errorf("%s: %w", op, ErrInvalidClient)
+		}
+
+		tracing.Err(span, err)
+
+		return TokenInfo{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if clientSecret == "" ||
+		subtle.ConstantTimeCompare([]byte(clientSecret), []byte(app.Secret)) != 1 {
+		return TokenInfo{}, fmt.Errorf("%s: %w", op, ErrInvalidClient)
+	}
+
+	info, err := o.ValidateToken(ctx, token)
+	if err != nil {
+		return TokenInfo{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if info.UserID != 0 {
+		user, err := o.usrProvider.UserByID(ctx, info.UserID)
+		if err != nil {
+			if errors.Is(err, storage.ErrUserNotFound) {
+				return TokenInfo{}, fmt.Errorf("%s: %w", op, ErrInvalidToken)
+			}
+
+			tracing.Err(span, err)
+
+			return TokenInfo{}, fmt.Errorf("%s: %w", op, err)
+		}
+
+		info.Username = user.Username
+	}
+
+	return info, nil
+}
+
+func randomCode() (string, error) {
+	b := make([]byte, codeLen)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashCode(code string) []byte {
+	sum := sha256.Sum256([]byte(code))
+
+	return sum[:]
+}
@@ -0,0 +1,532 @@
+package orgs
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+	"xauth/internal/domain/models"
+	"xauth/internal/lib/jwk"
+	"xauth/internal/lib/jwt"
+	"xauth/internal/lib/logger/sl"
+	"xauth/internal/lib/tracing"
+	"xauth/internal/storage"
+)
+
+var tracer = tracing.Tracer("xauth/internal/services/orgs")
+
+const (
+	maxNameLen     = 64
+	invitationSize = 32
+)
+
+type Orgs struct {
+	log           *slog.Logger
+	store         Store
+	usrProvider   UserProvider
+	appProvider   AppProvider
+	tokenTTL      atomic.Int64 // time.Duration, see SetTTLs
+	invitationTTL atomic.Int64
+	issuer        string
+	signingKey    *jwk.Key
+}
+
+type Store interface {
+	SaveOrg(ctx context.Context, name string, ownerID int64) (int64, error)
+	Org(ctx context.Context, id int64) (models.Organization, error)
+	Memberships(ctx context.Context, userID int64) ([]models.Membership, error)
+	Members(ctx context.Context, orgID int64) ([]models.Membership, error)
+	Member(ctx context.Context, orgID int64, userID int64) (models.Membership, error)
+	OwnerCount(ctx context.Context, orgID int64) (int, error)
+	UpdateMemberRole(ctx context.Context, orgID int64, userID int64, role models.Role) error
+	DeleteMember(ctx context.Context, orgID int64, userID int64) error
+	SaveInvitation(ctx context.Context, inv models.Invitation) (int64, error)
+	Invitation(ctx context.Context, tokenHash []byte) (models.Invitation, error)
+	AcceptInvitation(ctx context.Context, inv models.Invitation, userID int64) error
+}
+
+type UserProvider interface {
+	UserByID(ctx context.Context, userID int64) (models.User, error)
+}
+
+type AppProvider interface {
+	App(ctx context.Context, appID int) (models.App, error)
+}
+
+var (
+	ErrInvalidName       = errors.New("name must be 1 to 64 characters")
+	ErrInvalidRole       = errors.New("role must be owner, admin or member")
+	ErrInvalidEmail      = errors.New("email is required")
+	ErrOrgExists         = errors.New("organization with this name already exists")
+	ErrOrgNotFound       = errors.New("organization not found")
+	ErrForbidden         = errors.New("not allowed for this member role")
+	ErrMemberNotFound    = errors.New("member not found")
+	ErrLastOwner         = errors.New("organization must keep at least one owner")
+	ErrInvalidInvitation = errors.New("invitation is invalid or expired")
+	ErrAlreadyMember     = errors.New("user is already a member")
+)
+
+// New returns a new instance of the organizations service.
+func New(
+	log *slog.Logger,
+	store Store,
+	userProvider UserProvider,
+	appProvider AppProvider,
+	tokenTTL time.Duration,
+	invitationTTL time.Duration,
+	issuer string,
+	signingKey *jwk.Key,
+) *Orgs {
+	o := &Orgs{
+		log:         log,
+		store:       store,
+		usrProvider: userProvider,
+		appProvider: appProvider,
+		issuer:      issuer,
+		signingKey:  signingKey,
+	}
+	o.SetTTLs(tokenTTL, invitationTTL)
+
+	return o
+}
+
+// SetTTLs changes the lifetime of organization tokens and invitations
+// issued from now on. It is safe to call while requests are being served.
+func (o *Orgs) SetTTLs(tokenTTL, invitationTTL time.Duration) {
+	o.tokenTTL.Store(int64(tokenTTL))
+	o.invitationTTL.Store(int64(invitationTTL))
+}
+
+// Create creates an organization owned by the user.
+func (o *Orgs) Create(ctx context.Context, userID int64, name string) (models.Organization, error) {
+	const op = "orgs.Create"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer span.End()
+
+	name = strings.TrimSpace(name)
+	if name == "" || utf8.RuneCountInString(name) > maxNameLen {
+		return models.Organization{}, fmt.Errorf("%s: %w", op, ErrInvalidName)
+	}
+
+	id, err := o.store.SaveOrg(ctx, name, userID)
+	if err != nil {
+		if errors.Is(err, storage.ErrOrgExists) {
+			return models.Organization{}, fmt.Errorf("%s: %w", op, ErrOrgExists)
+		}
+
+		tracing.Err(span, err)
+
+		return models.Organization{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	org, err := o.store.Org(ctx, id)
+	if err != nil {
+		tracing.Err(span, err)
+
+		return models.Organization{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	o.log.Info("organization created",
+		slog.String("op", op),
+		slog.Int64("org_id", id),
+		slog.Int64("user_id", userID),
+	)
+
+	return org, nil
+}
+
+// List returns the user's memberships.
+func (o *Orgs) List(ctx context.Context, userID int64) ([]models.Membership, error) {
+	const op = "orgs.List"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer span.End()
+
+	members, err := o.store.Memberships(ctx, userID)
+	if err != nil {
+		tracing.Err(span, err)
+
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return members, nil
+}
+
+// Members returns the members of the organization. Any member may list
+// them.
+func (o *Orgs) Members(ctx context.Context, userID int64, orgID int64) ([]models.Membership, error) {
+	const op = "orgs.Members"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer span.End()
+
+	if _, err := o.caller(ctx, orgID, userID, models.RoleMember); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	members, err := o.store.Members(ctx, orgID)
+	if err != nil {
+		tracing.Err(span, err)
+
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return members, nil
+}
+
+// Invite invites email to join the organization with the given role and
+// returns the invitation along with its token, which can't be recovered
+// later. Admins may invite admins and members; only owners may invite
+// owners.
+func (o *Orgs) Invite(
+	ctx context.Context,
+	userID int64,
+	orgID int64,
+	email string,
+	role models.Role,
+) (models.Invitation, string, error) {
+	const op = "orgs.Invite"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer span.End()
+
+	email = strings.TrimSpace(email)
+	if email == "" {
+		return models.Invitation{}, "", fmt.Errorf("%s: %w", op, ErrInvalidEmail)
+	}
+	if !role.Valid() {
+		return models.Invitation{}, "", fmt.Errorf("%s: %w", op, ErrInvalidRole)
+	}
+
+	caller, err := o.caller(ctx, orgID, userID, models.RoleAdmin)
+	if err != nil {
+		return models.Invitation{}, "", fmt.Errorf("%s: %w", op, err)
+	}
+	if !caller.Role.AtLeast(role) {
+		return models.Invitation{}, "", fmt.Errorf("%s: %w", op, ErrForbidden)
+	}
+
+	token, err := randomString()
+	if err != nil {
+		return models.Invitation{}, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	now := time.Now()
+
+	inv := models.Invitation{
+		OrgID:     orgID,
+		Email:     email,
+		Role:      role,
+		TokenHash: hash(token),
+		InvitedBy: userID,
+		CreatedAt: now,
+		ExpiresAt: now.Add(time.Duration(o.invitationTTL.Load())),
+	}
+
+	inv.ID, err = o.store.SaveInvitation(ctx, inv)
+	if err != nil {
+		tracing.Err(span, err)
+
+		return models.Invitation{}, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	o.log.Info("member invited",
+		slog.String("op", op),
+		slog.Int64("org_id", orgID),
+		slog.Int64("user_id", userID),
+		slog.Int64("invitation_id", inv.ID),
+	)
+
+	return inv, token, nil
+}
+
+// Accept adds the user to the organization the invitation is for. The
+// invitation must have been sent to the user's email.
+func (o *Orgs) Accept(ctx context.Context, userID int64, token string) (models.Membership, error) {
+	const op = "orgs.Accept"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer span.End()
+
+	log := o.log.With(
+		slog.String("op", op),
+		slog.Int64("user_id", userID),
+	)
+
+	inv, err := o.store.Invitation(ctx, hash(token))
+	if err != nil {
+		if errors.Is(err, storage.ErrInvitationNotFound) {
+			return models.Membership{}, fmt.Errorf("%s: %w", op, ErrInvalidInvitation)
+		}
+
+		tracing.Err(span, err)
+
+		return models.Membership{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	user, err := o.usrProvider.UserByID(ctx, userID)
+	if err != nil {
+		tracing.Err(span, err)
+
+		return models.Membership{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	// The token alone isn't enough: it could have been forwarded.
+	if !strings.EqualFold(user.Email, inv.Email) {
+		log.Warn("invitation was sent to another email",
+			slog.Int64("invitation_id", inv.ID))
+
+		return models.Membership{}, fmt.Errorf("%s: %w", op, ErrInvalidInvitation)
+	}
+
+	if err := o.store.AcceptInvitation(ctx, inv, userID); err != nil {
+		switch {
+		case errors.Is(err, storage.ErrInvitationNotFound):
+			return models.Membership{}, fmt.Errorf("%s: %w", op, ErrInvalidInvitation)
+		case errors.Is(err, storage.ErrMemberExists):
+			return models.Membership{}, fmt.Errorf("%s: %w", op, ErrAlreadyMember)
+		}
+
+		tracing.Err(span, err)
+
+		return models.Membership{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	member, err := o.store.Member(ctx, inv.OrgID, userID)
+	if err != nil {
+		tracing.Err(span, err)
+
+		return models.Membership{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("invitation accepted", slog.Int64("org_id", inv.OrgID))
+
+	return member, nil
+}
+
+// SetRole changes the role of a member. Admins may manage admins and
+// members; only owners may grant or take away ownership.
+func (o *Orgs) SetRole(
+	ctx context.Context,
+	userID int64,
+	orgID int64,
+	memberID int64,
+	role models.Role,
+) error {
+	const op = "orgs.SetRole"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer span.End()
+
+	if !role.Valid() {
+		return fmt.Errorf("%s: %w", op, ErrInvalidRole)
+	}
+
+	caller, err := o.caller(ctx, orgID, userID, models.RoleAdmin)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	member, err := o.member(ctx, orgID, memberID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if !caller.Role.AtLeast(member.Role) || !caller.Role.AtLeast(role) {
+		return fmt.Errorf("%s: %w", op, ErrForbidden)
+	}
+
+	if member.Role == models.RoleOwner && role != models.RoleOwner {
+		if err := o.keepOwner(ctx, orgID); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	if err := o.store.UpdateMemberRole(ctx, orgID, memberID, role); err != nil {
+		if errors.Is(err, storage.ErrMemberNotFound) {
+			return fmt.Errorf("%s: %w", op, ErrMemberNotFound)
+		}
+
+		tracing.Err(span, err)
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	o.log.Info("member role changed",
+		slog.String("op", op),
+		slog.Int64("org_id", orgID),
+		slog.Int64("user_id", userID),
+		slog.Int64("member_id", memberID),
+		slog.String("role", string(role)),
+	)
+
+	return nil
+}
+
+// Remove removes a member from the organization. Members may always leave;
+// removing others follows the same rules as SetRole.
+func (o *Orgs) Remove(ctx context.Context, userID int64, orgID int64, memberID int64) error {
+	const op = "orgs.Remove"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer span.End()
+
+	minRole := models.RoleAdmin
+	if memberID == userID {
+		minRole = models.RoleMember
+	}
+
+	caller, err := o.caller(ctx, orgID, userID, minRole)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	member, err := o.member(ctx, orgID, memberID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if !caller.Role.AtLeast(member.Role) {
+		return fmt.Errorf("%s: %w", op, ErrForbidden)
+	}
+
+	if member.Role == models.RoleOwner {
+		if err := o.keepOwner(ctx, orgID); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	if err := o.store.DeleteMember(ctx, orgID, memberID); err != nil {
+		if errors.Is(err, storage.ErrMemberNotFound) {
+			return fmt.Errorf("%s: %w", op, ErrMemberNotFound)
+		}
+
+		tracing.Err(span, err)
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	o.log.Info("member removed",
+		slog.String("op", op),
+		slog.Int64("org_id", orgID),
+		slog.Int64("user_id", userID),
+		slog.Int64("member_id", memberID),
+	)
+
+	return nil
+}
+
+// Token issues an access token for the app scoped to the organization,
+// carrying org_id and org_role claims.
+func (o *Orgs) Token(ctx context.Context, userID int64, appID int, orgID int64) (string, error) {
+	const op = "orgs.Token"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer span.End()
+
+	member, err := o.caller(ctx, orgID, userID, models.RoleMember)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	user, err := o.usrProvider.UserByID(ctx, userID)
+	if err != nil {
+		tracing.Err(span, err)
+
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	app, err := o.appProvider.App(ctx, appID)
+	if err != nil {
+		tracing.Err(span, err)
+
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	token, err := jwt.NewOrgToken(user, app, member, o.signingKey, o.issuer,
+		time.Duration(o.tokenTTL.Load()))
+	if err != nil {
+		o.log.Error("failed to generate token", slog.String("op", op), sl.Err(err))
+		tracing.Err(span, err)
+
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	return token, nil
+}
+
+// caller returns the user's membership if it grants at least minRole.
+// Users outside the organization get ErrOrgNotFound, so they can't learn
+// which organizations exist.
+func (o *Orgs) caller(
+	ctx context.Context,
+	orgID int64,
+	userID int64,
+	minRole models.Role,
+) (models.Membership, error) {
+	member, err := o.store.Member(ctx, orgID, userID)
+	if err != nil {
+		if errors.Is(err, storage.ErrMemberNotFound) {
+			return models.Membership{}, ErrOrgNotFound
+		}
+
+		return models.Membership{}, err
+	}
+
+	if !member.Role.AtLeast(minRole) {
+		return models.Membership{}, ErrForbidden
+	}
+
+	return member, nil
+}
+
+func (o *Orgs) member(ctx context.Context, orgID int64, userID int64) (models.Membership, error) {
+	member, err := o.store.Member(ctx, orgID, userID)
+	if err != nil {
+		if errors.Is(err, storage.ErrMemberNotFound) {
+			return models.Membership{}, ErrMemberNotFound
+		}
+
+		return models.Membership{}, err
+	}
+
+	return member, nil
+}
+
+// keepOwner fails with ErrLastOwner unless the organization has another
+// owner besides the one about to go.
+func (o *Orgs) keepOwner(ctx context.Context, orgID int64) error {
+	n, err := o.store.OwnerCount(ctx, orgID)
+	if err != nil {
+		return err
+	}
+
+	if n <= 1 {
+		return ErrLastOwner
+	}
+
+	return nil
+}
+
+func randomString() (string, error) {
+	b := make([]byte, invitationSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hash(s string) []byte {
+	sum := sha256.Sum256([]byte(s))
+
+	return sum[:]
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package migrator
+
+// lockFile does nothing where flock isn't available: migrations are only
+// serialized within the process by golang-migrate itself.
+func lockFile(string) (func() error, error) {
+	return func() error { return nil }, nil
+}
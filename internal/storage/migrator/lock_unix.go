@@ -0,0 +1,26 @@
+//go:build unix
+
+package migrator
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile takes an exclusive lock on path, creating the file if needed,
+// and waits while another process holds it. The lock is released by the
+// returned function or when the process exits.
+func lockFile(path string) (func() error, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		_ = f.Close()
+
+		return nil, err
+	}
+
+	return f.Close, nil
+}
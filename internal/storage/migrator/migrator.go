@@ -0,0 +1,516 @@
+// Package migrator applies and rolls back golang-migrate migrations on the
+// SQLite database, planning every change before it is made so callers can
+// show or confirm it first.
+package migrator
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database"
+	// Driver to complete SQLite 3 migrations
+	_ "github.com/golang-migrate/migrate/v4/database/sqlite3"
+	"github.com/golang-migrate/migrate/v4/source"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+	// Driver for getting migrations from files
+	_ "github.com/golang-migrate/migrate/v4/source/file"
+)
+
+var (
+	ErrDirty          = errors.New("database is dirty")
+	ErrUnknownVersion = errors.New("no migration with this version")
+	ErrTooManySteps   = errors.New("not enough migrations")
+	ErrChanged        = errors.New("database version changed since planning")
+)
+
+// Migration is a migration found in the source.
+type Migration struct {
+	Version uint
+	Name    string
+	Applied bool
+}
+
+// Step is a migration to apply (or roll back, if Down is set).
+type Step struct {
+	Version uint
+	Name    string
+	Down    bool
+}
+
+// Status is the state of the database relative to the source.
+type Status struct {
+	// Version is the last applied migration, or 0 if none is.
+	Version    uint
+	Dirty      bool
+	Migrations []Migration
+}
+
+// Logger receives a line for every migration applied or rolled back.
+type Logger interface {
+	Printf(format string, v ...any)
+}
+
+type Migrator struct {
+	m        *migrate.Migrate
+	lockPath string
+	versions []uint
+	names    map[uint]string
+}
+
+// New opens the migrations at sourceURL (such as "file://./migrations") for
+// the SQLite database at storagePath, which may have connection
+// parameters, recording the version in the given table. log may be nil.
+func New(sourceURL, storagePath, migrationsTable string, log Logger) (*Migrator, error) {
+	const op = "storage.migrator.New"
+
+	src, err := source.Open(sourceURL)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	m, err := newWithSource(src, storagePath, migrationsTable, log)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return m, nil
+}
+
+// NewFS is like New, but reads the migrations from fsys, such as the
+// embedded migrations.FS.
+func NewFS(fsys fs.FS, storagePath, migrationsTable string, log Logger) (*Migrator, error) {
+	const op = "storage.migrator.NewFS"
+
+	src, err := iofs.New(fsys, ".")
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	m, err := newWithSource(src, storagePath, migrationsTable, log)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return m, nil
+}
+
+// LatestFS returns the version of the latest migration in fsys, without
+// opening a database.
+func LatestFS(fsys fs.FS) (uint, error) {
+	const op = "storage.migrator.LatestFS"
+
+	src, err := iofs.New(fsys, ".")
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	defer src.Close()
+
+	versions, _, err := readSource(src)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if len(versions) == 0 {
+		return 0, nil
+	}
+
+	return versions[len(versions)-1], nil
+}
+
+// newWithSource takes ownership of src, closing it on error.
+func newWithSource(
+	src source.Driver,
+	storagePath string,
+	migrationsTable string,
+	log Logger,
+) (*Migrator, error) {
+	versions, names, err := readSource(src)
+	if err != nil {
+		_ = src.Close()
+
+		return nil, err
+	}
+
+	// storagePath may have connection parameters already.
+	sep := "?"
+	if strings.Contains(storagePath, "?") {
+		sep = "&"
+	}
+
+	// Migrations run their own transactions (BEGIN ... COMMIT), so that
+	// ones rebuilding a table can turn foreign keys off first, which
+	// SQLite ignores inside a transaction.
+	dsn := fmt.Sprintf("sqlite3://%s%sx-migrations-table=%s&x-no-tx-wrap=true",
+		storagePath, sep, url.QueryEscape(migrationsTable))
+
+	m, err := migrate.NewWithSourceInstance("", src, dsn)
+	if err != nil {
+		_ = src.Close()
+
+		return nil, err
+	}
+
+	if log != nil {
+		m.Log = logger{log}
+	}
+
+	return &Migrator{
+		m:        m,
+		lockPath: lockPath(storagePath),
+		versions: versions,
+		names:    names,
+	}, nil
+}
+
+// lockPath returns the path of the file locked while migrating the
+// database at storagePath, next to the database file itself.
+func lockPath(storagePath string) string {
+	path, _, _ := strings.Cut(storagePath, "?")
+
+	return strings.TrimPrefix(path, "file:") + ".migrate.lock"
+}
+
+// Close closes the source and the database.
+func (m *Migrator) Close() error {
+	srcErr, dbErr := m.m.Close()
+
+	return errors.Join(srcErr, dbErr)
+}
+
+// Version returns the last applied migration, or 0 if none is, and
+// whether it failed halfway.
+func (m *Migrator) Version() (uint, bool, error) {
+	const op = "storage.migrator.Version"
+
+	version, dirty, err := m.m.Version()
+	if errors.Is(err, migrate.ErrNilVersion) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return version, dirty, nil
+}
+
+// Status lists the migrations in the source and which of them are applied.
+func (m *Migrator) Status() (Status, error) {
+	const op = "storage.migrator.Status"
+
+	version, dirty, err := m.Version()
+	if err != nil {
+		return Status{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	status := Status{Version: version, Dirty: dirty}
+	for _, v := range m.versions {
+		status.Migrations = append(status.Migrations, Migration{
+			Version: v,
+			Name:    m.names[v],
+			Applied: v <= version,
+		})
+	}
+
+	return status, nil
+}
+
+// Latest returns the version of the last migration in the source.
+func (m *Migrator) Latest() uint {
+	if len(m.versions) == 0 {
+		return 0
+	}
+
+	return m.versions[len(m.versions)-1]
+}
+
+// PlanUp returns the next n pending migrations, or all of them if n is 0.
+func (m *Migrator) PlanUp(n int) ([]Step, error) {
+	const op = "storage.migrator.PlanUp"
+
+	pending, err := m.pending()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if n == 0 {
+		n = len(pending)
+	}
+	if n < 0 || n > len(pending) {
+		return nil, fmt.Errorf("%s: %w: %d pending, asked for %d",
+			op, ErrTooManySteps, len(pending), n)
+	}
+
+	return m.steps(pending[:n], false), nil
+}
+
+// PlanDown returns the last n applied migrations, latest first.
+func (m *Migrator) PlanDown(n int) ([]Step, error) {
+	const op = "storage.migrator.PlanDown"
+
+	applied, err := m.applied()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if n < 1 || n > len(applied) {
+		return nil, fmt.Errorf("%s: %w: %d applied, asked for %d",
+			op, ErrTooManySteps, len(applied), n)
+	}
+
+	return m.steps(applied[:n], true), nil
+}
+
+// PlanGoto returns the migrations to apply or roll back to end up at
+// version.
+func (m *Migrator) PlanGoto(version uint) ([]Step, error) {
+	const op = "storage.migrator.PlanGoto"
+
+	if !slices.Contains(m.versions, version) {
+		return nil, fmt.Errorf("%s: %w: %d", op, ErrUnknownVersion, version)
+	}
+
+	pending, err := m.pending()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	applied, err := m.applied()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	var versions []uint
+	for _, v := range pending {
+		if v <= version {
+			versions = append(versions, v)
+		}
+	}
+	if len(versions) > 0 {
+		return m.steps(versions, false), nil
+	}
+
+	for _, v := range applied {
+		if v > version {
+			versions = append(versions, v)
+		}
+	}
+
+	return m.steps(versions, true), nil
+}
+
+// Apply runs the steps returned by one of the Plan methods. It fails with
+// ErrChanged if another process migrated the database in the meantime.
+func (m *Migrator) Apply(steps []Step) error {
+	const op = "storage.migrator.Apply"
+
+	if len(steps) == 0 {
+		return nil
+	}
+
+	unlock, err := lockFile(m.lockPath)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer unlock()
+
+	var current []Step
+	if steps[0].Down {
+		current, err = m.PlanDown(1)
+	} else {
+		current, err = m.PlanUp(1)
+	}
+	if errors.Is(err, ErrTooManySteps) ||
+		(err == nil && current[0].Version != steps[0].Version) {
+		return fmt.Errorf("%s: %w", op, ErrChanged)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := m.apply(steps); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// UpTo applies the pending migrations up to and including version and
+// returns them. A database already at or past version is left alone.
+// Concurrent callers, such as replicas starting at the same time, wait for
+// each other, and only the first one migrates.
+func (m *Migrator) UpTo(version uint) ([]Step, error) {
+	const op = "storage.migrator.UpTo"
+
+	if !slices.Contains(m.versions, version) {
+		return nil, fmt.Errorf("%s: %w: %d", op, ErrUnknownVersion, version)
+	}
+
+	unlock, err := lockFile(m.lockPath)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer unlock()
+
+	pending, err := m.pending()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	var versions []uint
+	for _, v := range pending {
+		if v <= version {
+			versions = append(versions, v)
+		}
+	}
+
+	steps := m.steps(versions, false)
+	if err := m.apply(steps); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return steps, nil
+}
+
+func (m *Migrator) apply(steps []Step) error {
+	if len(steps) == 0 {
+		return nil
+	}
+
+	n := len(steps)
+	if steps[0].Down {
+		n = -n
+	}
+
+	return m.m.Steps(n)
+}
+
+// Force records version as applied and clears the dirty flag without
+// running anything, for recovering from a failed migration once the
+// database has been fixed by hand. Version 0 records that nothing is
+// applied.
+func (m *Migrator) Force(version uint) error {
+	const op = "storage.migrator.Force"
+
+	v := int(version)
+	if version == 0 {
+		v = database.NilVersion
+	} else if !slices.Contains(m.versions, version) {
+		return fmt.Errorf("%s: %w: %d", op, ErrUnknownVersion, version)
+	}
+
+	unlock, err := lockFile(m.lockPath)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer unlock()
+
+	if err := m.m.Force(v); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// pending returns the versions after the current one, in order.
+func (m *Migrator) pending() ([]uint, error) {
+	version, err := m.cleanVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []uint
+	for _, v := range m.versions {
+		if v > version {
+			pending = append(pending, v)
+		}
+	}
+
+	return pending, nil
+}
+
+// applied returns the versions up to the current one, latest first.
+func (m *Migrator) applied() ([]uint, error) {
+	version, err := m.cleanVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	var applied []uint
+	for _, v := range slices.Backward(m.versions) {
+		if v <= version {
+			applied = append(applied, v)
+		}
+	}
+
+	return applied, nil
+}
+
+func (m *Migrator) cleanVersion() (uint, error) {
+	version, dirty, err := m.Version()
+	if err != nil {
+		return 0, err
+	}
+	if dirty {
+		return 0, fmt.Errorf("%w at version %d", ErrDirty, version)
+	}
+
+	return version, nil
+}
+
+func (m *Migrator) steps(versions []uint, down bool) []Step {
+	steps := make([]Step, 0, len(versions))
+	for _, v := range versions {
+		steps = append(steps, Step{Version: v, Name: m.names[v], Down: down})
+	}
+
+	return steps
+}
+
+// readSource lists the versions in src in order, with their names.
+func readSource(src source.Driver) ([]uint, map[uint]string, error) {
+	var versions []uint
+	names := make(map[uint]string)
+
+	v, err := src.First()
+	for err == nil {
+		versions = append(versions, v)
+
+		names[v], err = readName(src, v)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		v, err = src.Next(v)
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, nil, err
+	}
+
+	return versions, names, nil
+}
+
+func readName(src source.Driver, version uint) (string, error) {
+	r, name, err := src.ReadUp(version)
+	if errors.Is(err, fs.ErrNotExist) {
+		r, name, err = src.ReadDown(version)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return name, r.Close()
+}
+
+// logger adapts Logger to migrate.Logger.
+type logger struct {
+	Logger
+}
+
+func (logger) Verbose() bool {
+	return false
+}
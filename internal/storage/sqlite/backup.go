@@ -0,0 +1,127 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"xauth/internal/storage"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// Backup writes a consistent snapshot of the database to a new file at
+// path with SQLite's online backup API. Writes made meanwhile by other
+// connections aren't included, and aren't blocked in WAL mode.
+func (s *Storage) Backup(ctx context.Context, path string) error {
+	const op = "storage.sqlite.Backup"
+
+	ctx, span := startSpan(ctx, op)
+	defer span.End()
+
+	// The backup would overwrite whatever is there.
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	_ = f.Close()
+
+	if err := s.backup(ctx, path); err != nil {
+		_ = os.Remove(path)
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) backup(ctx context.Context, path string) error {
+	dst, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	dstConn, err := dst.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer dstConn.Close()
+
+	srcConn, err := s.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer srcConn.Close()
+
+	return dstConn.Raw(func(dstDriverConn any) error {
+		return srcConn.Raw(func(srcDriverConn any) error {
+			b, err := dstDriverConn.(*sqlite3.SQLiteConn).Backup("main",
+				srcDriverConn.(*sqlite3.SQLiteConn), "main")
+			if err != nil {
+				return err
+			}
+
+			// Copying every page at once holds a read transaction on the
+			// source, so that others writing can't restart the backup.
+			if _, err := b.Step(-1); err != nil {
+				return errors.Join(err, b.Finish())
+			}
+
+			return b.Finish()
+		})
+	})
+}
+
+// Inspect opens the database at path read-only, such as a backup before
+// it's restored, runs SQLite's integrity check on it and returns the schema
+// version recorded in migrationsTable, as SchemaVersion does; version 0 if
+// there is no such table. A failed check is reported as storage.ErrCorrupt
+// with the first problem found.
+//
+// The database is opened as immutable, so that no WAL files are created
+// next to it, and must not change meanwhile.
+func Inspect(ctx context.Context, path string,
+	migrationsTable string) (uint, bool, error) {
+	const op = "storage.sqlite.Inspect"
+
+	ctx, span := startSpan(ctx, op)
+	defer span.End()
+
+	uri := url.URL{Scheme: "file", Path: path, RawQuery: "mode=ro&immutable=1"}
+
+	db, err := sql.Open("sqlite3", uri.String())
+	if err != nil {
+		return 0, false, fmt.Errorf("%s: %w", op, err)
+	}
+	defer db.Close()
+
+	var result string
+	if err := db.QueryRowContext(ctx, "PRAGMA integrity_check(1)").Scan(&result); err != nil {
+		return 0, false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if result != "ok" {
+		return 0, false, fmt.Errorf("%s: %w: %s", op, storage.ErrCorrupt, result)
+	}
+
+	var exists bool
+	err = db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM sqlite_master
+		WHERE type = 'table' AND name = ?)`, migrationsTable).Scan(&exists)
+	if err != nil {
+		return 0, false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if !exists {
+		return 0, false, nil
+	}
+
+	version, dirty, err := schemaVersion(ctx, db, migrationsTable)
+	if err != nil {
+		return 0, false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return version, dirty, nil
+}
@@ -3,30 +3,164 @@ package sqlite
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 	"xauth/internal/domain/models"
+	"xauth/internal/lib/identifier"
+	"xauth/internal/lib/tracing"
 	"xauth/internal/storage"
 
 	"github.com/mattn/go-sqlite3"
 	_ "github.com/mattn/go-sqlite3"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+var tracer = tracing.Tracer("xauth/internal/storage/sqlite")
+
 type Storage struct {
-	db *sql.DB
+	db    *sql.DB
+	stmts statements
+	// prepared lists the statements in stmts, to close them.
+	prepared []*sql.Stmt
+}
+
+// Options configure the connections to the database. Zero values leave
+// the defaults of SQLite and database/sql.
+type Options struct {
+	// JournalMode is the journal mode set on every connection, such as
+	// "wal", which lets reads run while another connection writes.
+	JournalMode string
+	ForeignKeys bool
+	// BusyTimeout is how long a connection waits for another one to
+	// release a lock before failing with SQLITE_BUSY.
+	BusyTimeout time.Duration
+
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxIdleTime time.Duration
 }
 
-// New creates a new instance of the SQLite storage.
-func New(storagePath string) (*Storage, error) {
+// New opens the SQLite database at storagePath and prepares the storage's
+// statements, so the schema must be migrated already.
+//
+// Transactions take the write lock when they begin, so that a transaction
+// which reads before it writes waits for BusyTimeout like any other write
+// rather than failing when another connection wrote in between.
+func New(storagePath string, opts Options) (*Storage, error) {
 	const op = "storage.sqlite.New"
 
-	// Path to the database file
-	db, err := sql.Open("sqlite3", storagePath)
+	db, err := sql.Open("sqlite3", dsn(storagePath, opts))
 	if err != nil {
 		return nil, fmt.Errorf("%s: %w", op, err)
 	}
 
-	return &Storage{db: db}, nil
+	db.SetMaxOpenConns(opts.MaxOpenConns)
+	if opts.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(opts.MaxIdleConns)
+	}
+	db.SetConnMaxIdleTime(opts.ConnMaxIdleTime)
+
+	s := &Storage{db: db}
+	if err := s.prepare(context.Background()); err != nil {
+		_ = db.Close()
+
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return s, nil
+}
+
+// dsn adds the connection parameters for opts to storagePath, which may
+// have some already.
+func dsn(storagePath string, opts Options) string {
+	params := url.Values{}
+	params.Set("_txlock", "immediate")
+
+	if opts.JournalMode != "" {
+		params.Set("_journal_mode", opts.JournalMode)
+	}
+	if opts.ForeignKeys {
+		params.Set("_foreign_keys", "on")
+	}
+	if opts.BusyTimeout > 0 {
+		params.Set("_busy_timeout", strconv.FormatInt(opts.BusyTimeout.Milliseconds(), 10))
+	}
+
+	sep := "?"
+	if strings.Contains(storagePath, "?") {
+		sep = "&"
+	}
+
+	return storagePath + sep + params.Encode()
+}
+
+// Close closes the prepared statements and the database, once the queries
+// already running finish. Later calls fail.
+func (s *Storage) Close() error {
+	const op = "storage.sqlite.Close"
+
+	if err := errors.Join(s.closeStatements(), s.db.Close()); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// Ping checks that the database is reachable.
+func (s *Storage) Ping(ctx context.Context) error {
+	const op = "storage.sqlite.Ping"
+
+	if err := s.db.PingContext(ctx); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// SchemaVersion returns the migration version recorded by golang-migrate in
+// the given migrations table and whether the last migration left it dirty.
+//
+// If no migration has been applied yet, returns version 0.
+func (s *Storage) SchemaVersion(ctx context.Context,
+	migrationsTable string) (uint, bool, error) {
+	const op = "storage.sqlite.SchemaVersion"
+
+	ctx, span := startSpan(ctx, op)
+	defer span.End()
+
+	version, dirty, err := schemaVersion(ctx, s.db, migrationsTable)
+	if err != nil {
+		return 0, false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return version, dirty, nil
+}
+
+func schemaVersion(ctx context.Context, db *sql.DB,
+	migrationsTable string) (uint, bool, error) {
+	query := fmt.Sprintf("SELECT version, dirty FROM %q LIMIT 1", migrationsTable)
+
+	var (
+		version uint
+		dirty   bool
+	)
+
+	err := db.QueryRowContext(ctx, query).Scan(&version, &dirty)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, false, nil
+		}
+
+		return 0, false, err
+	}
+
+	return version, dirty, nil
 }
 
 // SaveUser saves user to db.
@@ -34,13 +168,12 @@ func (s *Storage) SaveUser(ctx context.Context,
 	email string, passHash []byte, username string) (int64, error) {
 	const op = "storage.sqlite.SaveUser"
 
-	// Request to create a new user
-	stmt, err := s.db.Prepare("INSERT INTO users(email, pass_hash, username) VALUES(?, ?, ?)")
-	if err != nil {
-		return 0, fmt.Errorf("%s: %w", op, err)
-	}
+	ctx, span := startSpan(ctx, op)
+	defer span.End()
 
-	res, err := stmt.ExecContext(ctx, email, passHash, username)
+	// Request to create a new user
+	res, err := s.stmts.saveUser.ExecContext(ctx, email, identifier.Normalize(email),
+		passHash, username, identifier.Normalize(username))
 	if err != nil {
 		var sqliteErr sqlite3.Error
 		if errors.As(err, &sqliteErr) &&
@@ -60,26 +193,54 @@ func (s *Storage) SaveUser(ctx context.Context,
 	return id, nil
 }
 
-// User returns user by email or username
-func (s *Storage) User(ctx context.Context,
-	email string, username string) (models.User, error) {
-	const op = "storage.sqlite.User"
+// UserByEmail returns the user with the email, compared in canonical form
+// (see identifier.Normalize).
+func (s *Storage) UserByEmail(ctx context.Context, email string) (models.User, error) {
+	const op = "storage.sqlite.UserByEmail"
+
+	ctx, span := startSpan(ctx, op)
+	defer span.End()
+
+	user, err := userByLogin(ctx, s.stmts.userByEmail, email)
+	if err != nil {
+		return models.User{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return user, nil
+}
+
+// UserByUsername returns the user with the username, compared in canonical
+// form (see identifier.Normalize).
+func (s *Storage) UserByUsername(ctx context.Context, username string) (models.User, error) {
+	const op = "storage.sqlite.UserByUsername"
 
-	stmt, err := s.db.Prepare("SELECT id, email, pass_hash, username FROM users WHERE email = ? OR username = ?")
+	ctx, span := startSpan(ctx, op)
+	defer span.End()
+
+	user, err := userByLogin(ctx, s.stmts.userByUsername, username)
 	if err != nil {
 		return models.User{}, fmt.Errorf("%s: %w", op, err)
 	}
 
-	row := stmt.QueryRowContext(ctx, email, username)
+	return user, nil
+}
+
+// userByLogin returns the user found by stmt, userByEmail or
+// userByUsername, for login, with the password hash.
+//
+// Users whose key couldn't be set because it collides with another user's
+// (see BackfillLoginKeys) are only found by their exact email or username,
+// which takes precedence over the key of the other user.
+func userByLogin(ctx context.Context, stmt *sql.Stmt, login string) (models.User, error) {
+	row := stmt.QueryRowContext(ctx, identifier.Normalize(login), login)
 
-	var user models.User
-	err = row.Scan(&user.ID, &user.Email, &user.PassHash, &user.Username)
+	user, err := scanUser(row.Scan, true)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return models.User{}, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+			return models.User{}, storage.ErrUserNotFound
 		}
 
-		return models.User{}, fmt.Errorf("%s: %w", op, err)
+		return models.User{}, err
 	}
 
 	return user, nil
@@ -88,15 +249,12 @@ func (s *Storage) User(ctx context.Context,
 func (s *Storage) UserByID(ctx context.Context, id int64) (models.User, error) {
 	const op = "storage.sqlite.UserByID"
 
-	stmt, err := s.db.Prepare("SELECT id, email, username, is_admin FROM users WHERE id = ?")
-	if err != nil {
-		return models.User{}, fmt.Errorf("%s: %w", op, err)
-	}
+	ctx, span := startSpan(ctx, op)
+	defer span.End()
 
-	row := stmt.QueryRowContext(ctx, id)
+	row := s.stmts.userByID.QueryRowContext(ctx, id)
 
-	var user models.User
-	err = row.Scan(&user.ID, &user.Email, &user.Username, &user.IsAdmin)
+	user, err := scanUser(row.Scan, false)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return models.User{}, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
@@ -112,19 +270,17 @@ func (s *Storage) UserByID(ctx context.Context, id int64) (models.User, error) {
 func (s *Storage) IsAdmin(ctx context.Context, userID int64) (bool, error) {
 	const op = "storage.sqlite.IsAdmin"
 
-	stmt, err := s.db.Prepare("SELECT admin FROM users WHERE id = ?")
-	if err != nil {
-		return false, fmt.Errorf("%s: %w", op, err)
-	}
+	ctx, span := startSpan(ctx, op)
+	defer span.End()
 
-	row := stmt.QueryRowContext(ctx, userID)
+	row := s.stmts.isAdmin.QueryRowContext(ctx, userID)
 
 	var isAdmin bool
 
-	err = row.Scan(&isAdmin)
+	err := row.Scan(&isAdmin)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return false, fmt.Errorf("%s: %w", op, storage.ErrAppNotFound)
+			return false, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
 		}
 
 		return false, fmt.Errorf("%s: %w", op, err)
@@ -136,15 +292,16 @@ func (s *Storage) IsAdmin(ctx context.Context, userID int64) (bool, error) {
 func (s *Storage) App(ctx context.Context, id int) (models.App, error) {
 	const op = "storage.sqlite.App"
 
-	stmt, err := s.db.Prepare("SELECT id, name, secret FROM apps WHERE id = ?")
-	if err != nil {
-		return models.App{}, fmt.Errorf("%s: %w", op, err)
-	}
+	ctx, span := startSpan(ctx, op)
+	defer span.End()
 
-	row := stmt.QueryRowContext(ctx, id)
+	row := s.stmts.app.QueryRowContext(ctx, id)
 
-	var app models.App
-	err = row.Scan(&app.ID, &app.Name, &app.Secret)
+	var (
+		app    models.App
+		scopes string
+	)
+	err := row.Scan(&app.ID, &app.Name, &app.Secret, &scopes)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return models.App{}, fmt.Errorf("%s: %w", op, storage.ErrAppNotFound)
@@ -153,5 +310,1289 @@ func (s *Storage) App(ctx context.Context, id int) (models.App, error) {
 		return models.App{}, fmt.Errorf("%s: %w", op, err)
 	}
 
+	// Scopes are stored space-separated, as in OAuth scope parameters.
+	app.AllowedScopes = strings.Fields(scopes)
+
 	return app, nil
 }
+
+// startSpan starts a client span for a single database call.
+func startSpan(ctx context.Context, op string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, op,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("db.system", "sqlite")),
+	)
+}
+
+// RedirectURIs returns redirect URIs registered for the app.
+func (s *Storage) RedirectURIs(ctx context.Context, appID int) ([]string, error) {
+	const op = "storage.sqlite.RedirectURIs"
+
+	ctx, span := startSpan(ctx, op)
+	defer span.End()
+
+	rows, err := s.stmts.redirectURIs.QueryContext(ctx, appID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var uris []string
+	for rows.Next() {
+		var uri string
+		if err := rows.Scan(&uri); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		uris = append(uris, uri)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return uris, nil
+}
+
+// SaveAuthCode saves an authorization code.
+func (s *Storage) SaveAuthCode(ctx context.Context, code models.AuthCode) error {
+	const op = "storage.sqlite.SaveAuthCode"
+
+	ctx, span := startSpan(ctx, op)
+	defer span.End()
+
+	_, err := s.stmts.saveAuthCode.ExecContext(ctx, code.CodeHash, code.AppID, code.UserID,
+		code.RedirectURI, code.Scope, code.CodeChallenge,
+		code.CodeChallengeMethod, code.Nonce, code.AuthTime.Unix(),
+		code.ExpiresAt.Unix())
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// ConsumeAuthCode deletes the authorization code with the given hash and
+// returns it, so every code can be exchanged at most once. Expired codes
+// are never returned.
+func (s *Storage) ConsumeAuthCode(ctx context.Context,
+	codeHash []byte) (models.AuthCode, error) {
+	const op = "storage.sqlite.ConsumeAuthCode"
+
+	ctx, span := startSpan(ctx, op)
+	defer span.End()
+
+	code := models.AuthCode{CodeHash: codeHash}
+
+	var authTime, expiresAt int64
+
+	err := s.stmts.consumeAuthCode.QueryRowContext(ctx, codeHash).Scan(&code.AppID,
+		&code.UserID, &code.RedirectURI, &code.Scope, &code.CodeChallenge,
+		&code.CodeChallengeMethod, &code.Nonce, &authTime, &expiresAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.AuthCode{}, fmt.Errorf("%s: %w", op, storage.ErrCodeNotFound)
+		}
+
+		return models.AuthCode{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	code.AuthTime = time.Unix(authTime, 0)
+	code.ExpiresAt = time.Unix(expiresAt, 0)
+	if time.Now().After(code.ExpiresAt) {
+		return models.AuthCode{}, fmt.Errorf("%s: %w", op, storage.ErrCodeNotFound)
+	}
+
+	return code, nil
+}
+
+// AuthCodes returns the authorization codes issued on behalf of the user
+// that haven't been exchanged yet, including expired ones. Code hashes are
+// not loaded.
+func (s *Storage) AuthCodes(ctx context.Context, userID int64) ([]models.AuthCode, error) {
+	const op = "storage.sqlite.AuthCodes"
+
+	ctx, span := startSpan(ctx, op)
+	defer span.End()
+
+	rows, err := s.stmts.authCodes.QueryContext(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var codes []models.AuthCode
+	for rows.Next() {
+		code := models.AuthCode{UserID: userID}
+
+		var authTime, expiresAt int64
+
+		err := rows.Scan(&code.AppID, &code.RedirectURI, &code.Scope,
+			&code.CodeChallenge, &code.CodeChallengeMethod, &code.Nonce, &authTime,
+			&expiresAt)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		code.AuthTime = time.Unix(authTime, 0)
+		code.ExpiresAt = time.Unix(expiresAt, 0)
+
+		codes = append(codes, code)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return codes, nil
+}
+
+// UserByIdentity returns the user linked to the upstream identity.
+func (s *Storage) UserByIdentity(ctx context.Context,
+	provider string, subject string) (models.User, error) {
+	const op = "storage.sqlite.UserByIdentity"
+
+	ctx, span := startSpan(ctx, op)
+	defer span.End()
+
+	row := s.stmts.userByIdentity.QueryRowContext(ctx, provider, subject)
+
+	user, err := scanUser(row.Scan, false)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.User{}, fmt.Errorf("%s: %w", op, storage.ErrIdentityNotFound)
+		}
+
+		return models.User{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return user, nil
+}
+
+// SaveFederatedUser creates a user without a password and links it to the
+// upstream identity, atomically.
+func (s *Storage) SaveFederatedUser(ctx context.Context,
+	email string, username string, identity models.Identity) (int64, error) {
+	const op = "storage.sqlite.SaveFederatedUser"
+
+	ctx, span := startSpan(ctx, op)
+	defer span.End()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	// An empty hash never matches a password, so the user can only sign
+	// in through the identity provider.
+	res, err := tx.ExecContext(ctx, `INSERT INTO users(email, email_key, pass_hash,
+		username, username_key) VALUES(?, ?, ?, ?, ?)`,
+		email, identifier.Normalize(email), []byte{}, username,
+		identifier.Normalize(username))
+	if err != nil {
+		var sqliteErr sqlite3.Error
+		if errors.As(err, &sqliteErr) &&
+			sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+			return 0, fmt.Errorf("%s: %w", op, storage.ErrUserExists)
+		}
+
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO identities(provider, subject,
+		user_id, email, created_at) VALUES(?, ?, ?, ?, ?)`,
+		identity.Provider, identity.Subject, id, identity.Email, time.Now().Unix())
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return id, nil
+}
+
+// Identities returns the upstream identities linked to the user, in order
+// of linking.
+func (s *Storage) Identities(ctx context.Context, userID int64) ([]models.Identity, error) {
+	const op = "storage.sqlite.Identities"
+
+	ctx, span := startSpan(ctx, op)
+	defer span.End()
+
+	rows, err := s.stmts.identities.QueryContext(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var identities []models.Identity
+	for rows.Next() {
+		identity := models.Identity{UserID: userID}
+
+		var createdAt int64
+
+		err := rows.Scan(&identity.Provider, &identity.Subject, &identity.Email,
+			&createdAt)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		identity.CreatedAt = time.Unix(createdAt, 0)
+
+		identities = append(identities, identity)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return identities, nil
+}
+
+// SaveFederationState saves a login in progress at an upstream provider.
+func (s *Storage) SaveFederationState(ctx context.Context,
+	state models.FederationState) error {
+	const op = "storage.sqlite.SaveFederationState"
+
+	ctx, span := startSpan(ctx, op)
+	defer span.End()
+
+	_, err := s.stmts.saveFederationState.ExecContext(ctx, state.StateHash,
+		state.Provider, state.Nonce, state.CodeVerifier, state.AppID,
+		state.RedirectURI, state.Scope, state.ClientState, state.CodeChallenge,
+		state.CodeChallengeMethod, state.ClientNonce, state.ExpiresAt.Unix())
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// ConsumeFederationState deletes the state with the given hash and returns
+// it, so every upstream response can be used at most once. Expired states
+// are never returned.
+func (s *Storage) ConsumeFederationState(ctx context.Context,
+	stateHash []byte) (models.FederationState, error) {
+	const op = "storage.sqlite.ConsumeFederationState"
+
+	ctx, span := startSpan(ctx, op)
+	defer span.End()
+
+	state := models.FederationState{StateHash: stateHash}
+
+	var expiresAt int64
+
+	row := s.stmts.consumeFederationState.QueryRowContext(ctx, stateHash)
+
+	err := row.Scan(&state.Provider, &state.Nonce, &state.CodeVerifier,
+		&state.AppID, &state.RedirectURI, &state.Scope, &state.ClientState,
+		&state.CodeChallenge, &state.CodeChallengeMethod, &state.ClientNonce,
+		&expiresAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.FederationState{}, fmt.Errorf("%s: %w", op, storage.ErrStateNotFound)
+		}
+
+		return models.FederationState{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	state.ExpiresAt = time.Unix(expiresAt, 0)
+	if time.Now().After(state.ExpiresAt) {
+		return models.FederationState{}, fmt.Errorf("%s: %w", op, storage.ErrStateNotFound)
+	}
+
+	return state, nil
+}
+
+// SaveAPIKey saves an API key and returns its ID.
+func (s *Storage) SaveAPIKey(ctx context.Context, key models.APIKey) (int64, error) {
+	const op = "storage.sqlite.SaveAPIKey"
+
+	ctx, span := startSpan(ctx, op)
+	defer span.End()
+
+	res, err := s.stmts.saveAPIKey.ExecContext(ctx, key.UserID, key.Name, key.Prefix,
+		key.KeyHash, strings.Join(key.Scopes, " "), key.CreatedAt.Unix(),
+		key.ExpiresAt.Unix())
+	if err != nil {
+		var sqliteErr sqlite3.Error
+		if errors.As(err, &sqliteErr) &&
+			sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+			return 0, fmt.Errorf("%s: %w", op, storage.ErrAPIKeyExists)
+		}
+
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return id, nil
+}
+
+// APIKeys returns the API keys of the user, including expired ones, in
+// order of creation. Key hashes are not loaded.
+func (s *Storage) APIKeys(ctx context.Context, userID int64) ([]models.APIKey, error) {
+	const op = "storage.sqlite.APIKeys"
+
+	ctx, span := startSpan(ctx, op)
+	defer span.End()
+
+	rows, err := s.stmts.apiKeys.QueryContext(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var keys []models.APIKey
+	for rows.Next() {
+		key, err := scanAPIKey(rows.Scan, false)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		keys = append(keys, key)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return keys, nil
+}
+
+// APIKeyByPrefix returns the API key with the given prefix.
+func (s *Storage) APIKeyByPrefix(ctx context.Context, prefix string) (models.APIKey, error) {
+	const op = "storage.sqlite.APIKeyByPrefix"
+
+	ctx, span := startSpan(ctx, op)
+	defer span.End()
+
+	key, err := scanAPIKey(s.stmts.apiKeyByPrefix.QueryRowContext(ctx, prefix).Scan, true)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.APIKey{}, fmt.Errorf("%s: %w", op, storage.ErrAPIKeyNotFound)
+		}
+
+		return models.APIKey{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return key, nil
+}
+
+// DeleteAPIKey deletes the user's API key with the given ID.
+func (s *Storage) DeleteAPIKey(ctx context.Context, userID int64, id int64) error {
+	const op = "storage.sqlite.DeleteAPIKey"
+
+	ctx, span := startSpan(ctx, op)
+	defer span.End()
+
+	res, err := s.stmts.deleteAPIKey.ExecContext(ctx, id, userID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if n == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrAPIKeyNotFound)
+	}
+
+	return nil
+}
+
+func scanAPIKey(scan func(dest ...any) error, withHash bool) (models.APIKey, error) {
+	var (
+		key                  models.APIKey
+		scopes               string
+		createdAt, expiresAt int64
+	)
+
+	dest := []any{&key.ID, &key.UserID, &key.Name, &key.Prefix, &scopes,
+		&createdAt, &expiresAt}
+	if withHash {
+		dest = append(dest, &key.KeyHash)
+	}
+
+	if err := scan(dest...); err != nil {
+		return models.APIKey{}, err
+	}
+
+	key.Scopes = strings.Fields(scopes)
+	key.CreatedAt = time.Unix(createdAt, 0)
+	key.ExpiresAt = time.Unix(expiresAt, 0)
+
+	return key, nil
+}
+
+// SaveOrg creates an organization with the user as its owner, atomically,
+// and returns its ID.
+func (s *Storage) SaveOrg(ctx context.Context, name string, ownerID int64) (int64, error) {
+	const op = "storage.sqlite.SaveOrg"
+
+	ctx, span := startSpan(ctx, op)
+	defer span.End()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	now := time.Now().Unix()
+
+	res, err := tx.ExecContext(ctx,
+		"INSERT INTO organizations(name, created_at) VALUES(?, ?)", name, now)
+	if err != nil {
+		var sqliteErr sqlite3.Error
+		if errors.As(err, &sqliteErr) &&
+			sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+			return 0, fmt.Errorf("%s: %w", op, storage.ErrOrgExists)
+		}
+
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO org_members(org_id, user_id, role,
+		created_at) VALUES(?, ?, ?, ?)`, id, ownerID, models.RoleOwner, now)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return id, nil
+}
+
+// Org returns the organization with the given ID.
+func (s *Storage) Org(ctx context.Context, id int64) (models.Organization, error) {
+	const op = "storage.sqlite.Org"
+
+	ctx, span := startSpan(ctx, op)
+	defer span.End()
+
+	var (
+		org       models.Organization
+		createdAt int64
+	)
+
+	err := s.stmts.org.QueryRowContext(ctx, id).Scan(&org.ID, &org.Name, &createdAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.Organization{}, fmt.Errorf("%s: %w", op, storage.ErrOrgNotFound)
+		}
+
+		return models.Organization{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	org.CreatedAt = time.Unix(createdAt, 0)
+
+	return org, nil
+}
+
+// Memberships returns the organizations the user is a member of, in order
+// of creation, with OrgName set.
+func (s *Storage) Memberships(ctx context.Context, userID int64) ([]models.Membership, error) {
+	const op = "storage.sqlite.Memberships"
+
+	ctx, span := startSpan(ctx, op)
+	defer span.End()
+
+	members, err := queryMemberships(ctx, s.stmts.memberships, userID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return members, nil
+}
+
+// Members returns the members of the organization, in order of joining,
+// with Email and Username set.
+func (s *Storage) Members(ctx context.Context, orgID int64) ([]models.Membership, error) {
+	const op = "storage.sqlite.Members"
+
+	ctx, span := startSpan(ctx, op)
+	defer span.End()
+
+	members, err := queryMemberships(ctx, s.stmts.members, orgID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return members, nil
+}
+
+// Member returns the user's membership in the organization.
+func (s *Storage) Member(ctx context.Context, orgID int64, userID int64) (models.Membership, error) {
+	const op = "storage.sqlite.Member"
+
+	ctx, span := startSpan(ctx, op)
+	defer span.End()
+
+	member, err := scanMembership(s.stmts.member.QueryRowContext(ctx, orgID, userID).Scan)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.Membership{}, fmt.Errorf("%s: %w", op, storage.ErrMemberNotFound)
+		}
+
+		return models.Membership{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return member, nil
+}
+
+// OwnerCount returns the number of owners of the organization.
+func (s *Storage) OwnerCount(ctx context.Context, orgID int64) (int, error) {
+	const op = "storage.sqlite.OwnerCount"
+
+	ctx, span := startSpan(ctx, op)
+	defer span.End()
+
+	var n int
+	row := s.stmts.ownerCount.QueryRowContext(ctx, orgID, models.RoleOwner)
+	if err := row.Scan(&n); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return n, nil
+}
+
+// UpdateMemberRole changes the role of a member of the organization.
+func (s *Storage) UpdateMemberRole(ctx context.Context,
+	orgID int64, userID int64, role models.Role) error {
+	const op = "storage.sqlite.UpdateMemberRole"
+
+	ctx, span := startSpan(ctx, op)
+	defer span.End()
+
+	res, err := s.stmts.updateMemberRole.ExecContext(ctx, role, orgID, userID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return memberAffected(op, res)
+}
+
+// DeleteMember removes the user from the organization.
+func (s *Storage) DeleteMember(ctx context.Context, orgID int64, userID int64) error {
+	const op = "storage.sqlite.DeleteMember"
+
+	ctx, span := startSpan(ctx, op)
+	defer span.End()
+
+	res, err := s.stmts.deleteMember.ExecContext(ctx, orgID, userID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return memberAffected(op, res)
+}
+
+// SaveInvitation saves an invitation to an organization and returns its ID.
+func (s *Storage) SaveInvitation(ctx context.Context, inv models.Invitation) (int64, error) {
+	const op = "storage.sqlite.SaveInvitation"
+
+	ctx, span := startSpan(ctx, op)
+	defer span.End()
+
+	res, err := s.stmts.saveInvitation.ExecContext(ctx, inv.OrgID, inv.Email, inv.Role,
+		inv.TokenHash, inv.InvitedBy, inv.CreatedAt.Unix(), inv.ExpiresAt.Unix())
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return id, nil
+}
+
+// Invitation returns the invitation with the given token hash. Expired
+// invitations are never returned.
+func (s *Storage) Invitation(ctx context.Context, tokenHash []byte) (models.Invitation, error) {
+	const op = "storage.sqlite.Invitation"
+
+	ctx, span := startSpan(ctx, op)
+	defer span.End()
+
+	inv := models.Invitation{TokenHash: tokenHash}
+
+	var createdAt, expiresAt int64
+
+	err := s.stmts.invitation.QueryRowContext(ctx, tokenHash).Scan(&inv.ID, &inv.OrgID,
+		&inv.Email, &inv.Role, &inv.InvitedBy, &createdAt, &expiresAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.Invitation{}, fmt.Errorf("%s: %w", op, storage.ErrInvitationNotFound)
+		}
+
+		return models.Invitation{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	inv.CreatedAt = time.Unix(createdAt, 0)
+	inv.ExpiresAt = time.Unix(expiresAt, 0)
+	if time.Now().After(inv.ExpiresAt) {
+		return models.Invitation{}, fmt.Errorf("%s: %w", op, storage.ErrInvitationNotFound)
+	}
+
+	return inv, nil
+}
+
+// InvitationsByEmail returns the invitations sent to the email address,
+// including expired ones, in order of creation. Token hashes are not
+// loaded.
+func (s *Storage) InvitationsByEmail(ctx context.Context,
+	email string) ([]models.Invitation, error) {
+	const op = "storage.sqlite.InvitationsByEmail"
+
+	ctx, span := startSpan(ctx, op)
+	defer span.End()
+
+	rows, err := s.stmts.invitationsByEmail.QueryContext(ctx, email)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var invitations []models.Invitation
+	for rows.Next() {
+		var (
+			inv                  models.Invitation
+			createdAt, expiresAt int64
+		)
+
+		err := rows.Scan(&inv.ID, &inv.OrgID, &inv.Email, &inv.Role, &inv.InvitedBy,
+			&createdAt, &expiresAt)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		inv.CreatedAt = time.Unix(createdAt, 0)
+		inv.ExpiresAt = time.Unix(expiresAt, 0)
+
+		invitations = append(invitations, inv)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return invitations, nil
+}
+
+// AcceptInvitation deletes the invitation and adds the user to its
+// organization with the invited role, atomically, so every invitation can
+// be used at most once.
+func (s *Storage) AcceptInvitation(ctx context.Context,
+	inv models.Invitation, userID int64) error {
+	const op = "storage.sqlite.AcceptInvitation"
+
+	ctx, span := startSpan(ctx, op)
+	defer span.End()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	res, err := tx.ExecContext(ctx, "DELETE FROM org_invitations WHERE id = ?", inv.ID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if n == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrInvitationNotFound)
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO org_members(org_id, user_id, role,
+		created_at) VALUES(?, ?, ?, ?)`, inv.OrgID, userID, inv.Role, time.Now().Unix())
+	if err != nil {
+		var sqliteErr sqlite3.Error
+		if errors.As(err, &sqliteErr) &&
+			(sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey ||
+				sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique) {
+			return fmt.Errorf("%s: %w", op, storage.ErrMemberExists)
+		}
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func queryMemberships(ctx context.Context, stmt *sql.Stmt, arg int64) ([]models.Membership, error) {
+	rows, err := stmt.QueryContext(ctx, arg)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var members []models.Membership
+	for rows.Next() {
+		member, err := scanMembership(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+
+		members = append(members, member)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return members, nil
+}
+
+func scanMembership(scan func(dest ...any) error) (models.Membership, error) {
+	var (
+		member    models.Membership
+		createdAt int64
+	)
+
+	err := scan(&member.OrgID, &member.OrgName, &member.UserID, &member.Email,
+		&member.Username, &member.Role, &createdAt)
+	if err != nil {
+		return models.Membership{}, err
+	}
+
+	member.CreatedAt = time.Unix(createdAt, 0)
+
+	return member, nil
+}
+
+func memberAffected(op string, res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if n == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrMemberNotFound)
+	}
+
+	return nil
+}
+
+// scanUser scans the columns selected for users, with pass_hash and
+// pass_hash_algo after email if withHash is set.
+func scanUser(scan func(dest ...any) error, withHash bool) (models.User, error) {
+	var (
+		user                   models.User
+		suspendedAt, revokedAt sql.NullInt64
+	)
+
+	dest := []any{&user.ID, &user.Email}
+	if withHash {
+		dest = append(dest, &user.PassHash, &user.PassHashAlgo)
+	}
+	dest = append(dest, &user.Username, &user.IsAdmin, &suspendedAt, &revokedAt)
+
+	if err := scan(dest...); err != nil {
+		return models.User{}, err
+	}
+
+	user.SuspendedAt = nullUnix(suspendedAt)
+	user.SessionsRevokedAt = nullUnix(revokedAt)
+
+	return user, nil
+}
+
+// nullUnix converts a nullable Unix timestamp column, NULL being the zero
+// time.
+func nullUnix(v sql.NullInt64) time.Time {
+	if !v.Valid {
+		return time.Time{}
+	}
+
+	return time.Unix(v.Int64, 0)
+}
+
+// SetUserSuspended suspends the user at the given time, or lifts the
+// suspension if it's zero.
+func (s *Storage) SetUserSuspended(ctx context.Context,
+	userID int64, suspendedAt time.Time) error {
+	const op = "storage.sqlite.SetUserSuspended"
+
+	ctx, span := startSpan(ctx, op)
+	defer span.End()
+
+	var at sql.NullInt64
+	if !suspendedAt.IsZero() {
+		at = sql.NullInt64{Int64: suspendedAt.Unix(), Valid: true}
+	}
+
+	return updateUser(ctx, op, s.stmts.setUserSuspended, at, userID)
+}
+
+// SetAdmin grants or revokes the user's admin rights.
+func (s *Storage) SetAdmin(ctx context.Context, userID int64, isAdmin bool) error {
+	const op = "storage.sqlite.SetAdmin"
+
+	ctx, span := startSpan(ctx, op)
+	defer span.End()
+
+	return updateUser(ctx, op, s.stmts.setAdmin, isAdmin, userID)
+}
+
+// RevokeSessions invalidates the access tokens issued to the user until at.
+func (s *Storage) RevokeSessions(ctx context.Context, userID int64, at time.Time) error {
+	const op = "storage.sqlite.RevokeSessions"
+
+	ctx, span := startSpan(ctx, op)
+	defer span.End()
+
+	return updateUser(ctx, op, s.stmts.revokeSessions, at.Unix(), userID)
+}
+
+func updateUser(ctx context.Context, op string, stmt *sql.Stmt, args ...any) error {
+	res, err := stmt.ExecContext(ctx, args...)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if n == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+
+	return nil
+}
+
+// SetPassHash replaces the user's password hash.
+func (s *Storage) SetPassHash(ctx context.Context,
+	userID int64, passHash []byte, algo string) error {
+	const op = "storage.sqlite.SetPassHash"
+
+	ctx, span := startSpan(ctx, op)
+	defer span.End()
+
+	return updateUser(ctx, op, s.stmts.setPassHash, passHash, algo, userID)
+}
+
+// ImportUsers saves users with their email, username and password hash in
+// one transaction. It returns the ID of each user, or 0 for users skipped
+// because their email or username is taken. With dryRun, the transaction
+// is rolled back.
+func (s *Storage) ImportUsers(ctx context.Context,
+	users []models.User, dryRun bool) ([]int64, error) {
+	const op = "storage.sqlite.ImportUsers"
+
+	ctx, span := startSpan(ctx, op)
+	defer span.End()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	stmt := tx.StmtContext(ctx, s.stmts.importUser)
+	defer stmt.Close()
+
+	ids := make([]int64, len(users))
+	for i, user := range users {
+		res, err := stmt.ExecContext(ctx, user.Email, identifier.Normalize(user.Email),
+			user.PassHash, user.PassHashAlgo, user.Username,
+			identifier.Normalize(user.Username))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		if n, err := res.RowsAffected(); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		} else if n == 0 {
+			continue
+		}
+
+		if ids[i], err = res.LastInsertId(); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	if dryRun {
+		return ids, nil
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return ids, nil
+}
+
+// BackfillLoginKeys sets the canonical email and username of users saved
+// before they were stored, in order of registration. If a canonical form
+// is already taken, the key is left unset and a collision is returned: the
+// user can then only sign in with that email or username spelled exactly
+// as it is stored. Keys are tried again on every call, so collisions go
+// away once the email or username is changed.
+func (s *Storage) BackfillLoginKeys(ctx context.Context) ([]models.LoginCollision, error) {
+	const op = "storage.sqlite.BackfillLoginKeys"
+
+	ctx, span := startSpan(ctx, op)
+	defer span.End()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	type login struct {
+		userID int64
+		field  string
+		value  string
+	}
+
+	rows, err := tx.QueryContext(ctx, `SELECT id, 'email', email FROM users
+		WHERE email_key IS NULL
+		UNION ALL
+		SELECT id, 'username', username FROM users WHERE username_key IS NULL
+		ORDER BY 1, 2`)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	var logins []login
+	for rows.Next() {
+		var l login
+		if err := rows.Scan(&l.userID, &l.field, &l.value); err != nil {
+			rows.Close()
+
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		logins = append(logins, l)
+	}
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	var collisions []models.LoginCollision
+	for _, l := range logins {
+		key := identifier.Normalize(l.value)
+
+		var otherID int64
+		err := tx.QueryRowContext(ctx,
+			fmt.Sprintf("SELECT id FROM users WHERE %s_key = ?", l.field), key).
+			Scan(&otherID)
+		switch {
+		case err == nil:
+			collisions = append(collisions, models.LoginCollision{
+				UserID:  l.userID,
+				Field:   l.field,
+				OtherID: otherID,
+			})
+
+			continue
+		case !errors.Is(err, sql.ErrNoRows):
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		_, err = tx.ExecContext(ctx,
+			fmt.Sprintf("UPDATE users SET %s_key = ? WHERE id = ?", l.field),
+			key, l.userID)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return collisions, nil
+}
+
+// EraseUser deletes the user and everything linked to them, atomically:
+// identities, API keys, pending authorization codes, memberships,
+// invitations they sent or received, and organizations they were the only
+// member of. Audit events are kept, but the user's ID in them is replaced
+// with pseudonym, in the "user" or "actor" detail.
+//
+// It fails with storage.ErrSoleOwner if the user is the only owner of an
+// organization with other members.
+func (s *Storage) EraseUser(ctx context.Context, userID int64, pseudonym string) error {
+	const op = "storage.sqlite.EraseUser"
+
+	ctx, span := startSpan(ctx, op)
+	defer span.End()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var email string
+	err = tx.QueryRowContext(ctx, "SELECT email FROM users WHERE id = ?", userID).
+		Scan(&email)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+		}
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	var soleOwner bool
+	err = tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM org_members m
+		WHERE m.user_id = ? AND m.role = 'owner'
+		AND NOT EXISTS (SELECT 1 FROM org_members o
+			WHERE o.org_id = m.org_id AND o.user_id != m.user_id AND o.role = 'owner')
+		AND EXISTS (SELECT 1 FROM org_members o
+			WHERE o.org_id = m.org_id AND o.user_id != m.user_id))`, userID).
+		Scan(&soleOwner)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if soleOwner {
+		return fmt.Errorf("%s: %w", op, storage.ErrSoleOwner)
+	}
+
+	// Foreign keys may not be enforced (see Options.ForeignKeys), so every
+	// table referring to users is cleaned up here rather than left to ON
+	// DELETE CASCADE. Rows go before the ones they refer to, so that no
+	// statement breaks a constraint where they are.
+	queries := []struct {
+		query string
+		args  []any
+	}{
+		{`DELETE FROM organizations WHERE id IN (SELECT org_id FROM org_members
+			WHERE user_id = ?) AND NOT EXISTS (SELECT 1 FROM org_members o
+			WHERE o.org_id = organizations.id AND o.user_id != ?)`,
+			[]any{userID, userID}},
+		{`DELETE FROM org_invitations WHERE org_id NOT IN
+			(SELECT id FROM organizations)`, nil},
+		{"DELETE FROM org_invitations WHERE invited_by = ? OR email = ? COLLATE NOCASE",
+			[]any{userID, email}},
+		{"DELETE FROM org_members WHERE user_id = ?", []any{userID}},
+		{"DELETE FROM identities WHERE user_id = ?", []any{userID}},
+		{"DELETE FROM api_keys WHERE user_id = ?", []any{userID}},
+		{"DELETE FROM auth_codes WHERE user_id = ?", []any{userID}},
+		{`UPDATE audit_events SET user_id = NULL,
+			details = json_set(details, '$.user', ?) WHERE user_id = ?`,
+			[]any{pseudonym, userID}},
+		{`UPDATE audit_events SET actor_id = NULL,
+			details = json_set(details, '$.actor', ?) WHERE actor_id = ?`,
+			[]any{pseudonym, userID}},
+		{"DELETE FROM users WHERE id = ?", []any{userID}},
+	}
+
+	for _, q := range queries {
+		if _, err := tx.ExecContext(ctx, q.query, q.args...); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// SaveApp saves an app with its redirect URIs and returns its ID.
+func (s *Storage) SaveApp(ctx context.Context,
+	app models.App, redirectURIs []string) (int, error) {
+	const op = "storage.sqlite.SaveApp"
+
+	ctx, span := startSpan(ctx, op)
+	defer span.End()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	res, err := tx.ExecContext(ctx,
+		"INSERT INTO apps(name, secret, allowed_scopes) VALUES(?, ?, ?)",
+		app.Name, app.Secret, strings.Join(app.AllowedScopes, " "))
+	if err != nil {
+		var sqliteErr sqlite3.Error
+		if errors.As(err, &sqliteErr) &&
+			sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+			return 0, fmt.Errorf("%s: %w", op, storage.ErrAppExists)
+		}
+
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	for _, uri := range redirectURIs {
+		_, err := tx.ExecContext(ctx,
+			"INSERT INTO app_redirect_uris(app_id, uri) VALUES(?, ?)", id, uri)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return int(id), nil
+}
+
+// Apps returns all apps, ordered by ID.
+func (s *Storage) Apps(ctx context.Context) ([]models.App, error) {
+	const op = "storage.sqlite.Apps"
+
+	ctx, span := startSpan(ctx, op)
+	defer span.End()
+
+	rows, err := s.stmts.apps.QueryContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var apps []models.App
+	for rows.Next() {
+		var (
+			app    models.App
+			scopes string
+		)
+		if err := rows.Scan(&app.ID, &app.Name, &app.Secret, &scopes); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		app.AllowedScopes = strings.Fields(scopes)
+		apps = append(apps, app)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return apps, nil
+}
+
+// SaveAuditEvent appends an event to the audit log.
+func (s *Storage) SaveAuditEvent(ctx context.Context, event models.AuditEvent) error {
+	const op = "storage.sqlite.SaveAuditEvent"
+
+	ctx, span := startSpan(ctx, op)
+	defer span.End()
+
+	details, err := json.Marshal(event.Details)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if event.Details == nil {
+		details = []byte("{}")
+	}
+
+	_, err = s.stmts.saveAuditEvent.ExecContext(ctx, event.CreatedAt.Unix(), event.Action,
+		nullID(event.ActorID), nullID(event.UserID), nullID(int64(event.AppID)),
+		string(details))
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// AuditEvents returns the audit events matching filter, oldest first.
+func (s *Storage) AuditEvents(ctx context.Context,
+	filter models.AuditFilter) ([]models.AuditEvent, error) {
+	const op = "storage.sqlite.AuditEvents"
+
+	ctx, span := startSpan(ctx, op)
+	defer span.End()
+
+	stmt := s.stmts.auditEvents
+	if filter.Newest {
+		stmt = s.stmts.auditEventsNewest
+	}
+
+	rows, err := stmt.QueryContext(ctx, filter.AfterID, filter.UserID, filter.UserID,
+		filter.Limit)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var events []models.AuditEvent
+	for rows.Next() {
+		var (
+			event                  models.AuditEvent
+			createdAt              int64
+			actorID, userID, appID sql.NullInt64
+			details                string
+		)
+
+		err := rows.Scan(&event.ID, &createdAt, &event.Action, &actorID, &userID,
+			&appID, &details)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		if err := json.Unmarshal([]byte(details), &event.Details); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		event.CreatedAt = time.Unix(createdAt, 0)
+		event.ActorID = actorID.Int64
+		event.UserID = userID.Int64
+		event.AppID = int(appID.Int64)
+
+		events = append(events, event)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return events, nil
+}
+
+// nullID stores zero IDs as NULL.
+func nullID(id int64) sql.NullInt64 {
+	return sql.NullInt64{Int64: id, Valid: id != 0}
+}
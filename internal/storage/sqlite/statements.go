@@ -0,0 +1,177 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+// statements are the storage's queries, prepared once when it's opened
+// rather than on every call, and closed with it.
+type statements struct {
+	saveUser               *sql.Stmt
+	userByEmail            *sql.Stmt
+	userByUsername         *sql.Stmt
+	userByID               *sql.Stmt
+	isAdmin                *sql.Stmt
+	setUserSuspended       *sql.Stmt
+	setAdmin               *sql.Stmt
+	revokeSessions         *sql.Stmt
+	setPassHash            *sql.Stmt
+	importUser             *sql.Stmt
+	app                    *sql.Stmt
+	redirectURIs           *sql.Stmt
+	saveAuthCode           *sql.Stmt
+	consumeAuthCode        *sql.Stmt
+	authCodes              *sql.Stmt
+	userByIdentity         *sql.Stmt
+	identities             *sql.Stmt
+	saveFederationState    *sql.Stmt
+	consumeFederationState *sql.Stmt
+	saveAPIKey             *sql.Stmt
+	apiKeys                *sql.Stmt
+	apiKeyByPrefix         *sql.Stmt
+	deleteAPIKey           *sql.Stmt
+	org                    *sql.Stmt
+	memberships            *sql.Stmt
+	members                *sql.Stmt
+	member                 *sql.Stmt
+	ownerCount             *sql.Stmt
+	updateMemberRole       *sql.Stmt
+	deleteMember           *sql.Stmt
+	saveInvitation         *sql.Stmt
+	invitation             *sql.Stmt
+	invitationsByEmail     *sql.Stmt
+	apps                   *sql.Stmt
+	saveAuditEvent         *sql.Stmt
+	auditEvents            *sql.Stmt
+	auditEventsNewest      *sql.Stmt
+}
+
+// prepare prepares every statement. If one fails, those already prepared
+// are closed.
+func (s *Storage) prepare(ctx context.Context) error {
+	queries := []struct {
+		stmt  **sql.Stmt
+		query string
+	}{
+		{&s.stmts.saveUser, `INSERT INTO users(email, email_key, pass_hash,
+			username, username_key) VALUES(?, ?, ?, ?, ?)`},
+		{&s.stmts.userByEmail, `SELECT id, email, pass_hash,
+			pass_hash_algo, username, is_admin, suspended_at, sessions_revoked_at
+			FROM users WHERE email_key = ?1 OR (email_key IS NULL AND email = ?2)
+			ORDER BY email = ?2 DESC LIMIT 1`},
+		{&s.stmts.userByUsername, `SELECT id, email, pass_hash,
+			pass_hash_algo, username, is_admin, suspended_at, sessions_revoked_at
+			FROM users
+			WHERE username_key = ?1 OR (username_key IS NULL AND username = ?2)
+			ORDER BY username = ?2 DESC LIMIT 1`},
+		{&s.stmts.userByID, `SELECT id, email, username, is_admin, suspended_at,
+			sessions_revoked_at FROM users WHERE id = ?`},
+		{&s.stmts.isAdmin, "SELECT is_admin FROM users WHERE id = ?"},
+		{&s.stmts.setUserSuspended, "UPDATE users SET suspended_at = ? WHERE id = ?"},
+		{&s.stmts.setAdmin, "UPDATE users SET is_admin = ? WHERE id = ?"},
+		{&s.stmts.revokeSessions, "UPDATE users SET sessions_revoked_at = ? WHERE id = ?"},
+		{&s.stmts.setPassHash,
+			"UPDATE users SET pass_hash = ?, pass_hash_algo = ? WHERE id = ?"},
+		{&s.stmts.importUser, `INSERT INTO users(email, email_key,
+			pass_hash, pass_hash_algo, username, username_key)
+			VALUES(?, ?, ?, ?, ?, ?) ON CONFLICT DO NOTHING`},
+		{&s.stmts.app, "SELECT id, name, secret, allowed_scopes FROM apps WHERE id = ?"},
+		{&s.stmts.redirectURIs, "SELECT uri FROM app_redirect_uris WHERE app_id = ?"},
+		{&s.stmts.saveAuthCode, `INSERT INTO auth_codes(code_hash, app_id, user_id,
+			redirect_uri, scope, code_challenge, code_challenge_method, nonce,
+			auth_time, expires_at)
+			VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`},
+		{&s.stmts.consumeAuthCode, `DELETE FROM auth_codes WHERE code_hash = ?
+			RETURNING app_id, user_id, redirect_uri, scope, code_challenge,
+			code_challenge_method, nonce, auth_time, expires_at`},
+		{&s.stmts.authCodes, `SELECT app_id, redirect_uri, scope,
+			code_challenge, code_challenge_method, nonce, auth_time, expires_at
+			FROM auth_codes WHERE user_id = ? ORDER BY auth_time`},
+		{&s.stmts.userByIdentity, `SELECT u.id, u.email, u.username, u.is_admin,
+			u.suspended_at, u.sessions_revoked_at
+			FROM identities i JOIN users u ON u.id = i.user_id
+			WHERE i.provider = ? AND i.subject = ?`},
+		{&s.stmts.identities, `SELECT provider, subject, email, created_at
+			FROM identities WHERE user_id = ? ORDER BY created_at, provider`},
+		{&s.stmts.saveFederationState, `INSERT INTO federation_states(state_hash,
+			provider, nonce, code_verifier, app_id, redirect_uri, scope,
+			client_state, code_challenge, code_challenge_method, client_nonce,
+			expires_at)
+			VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`},
+		{&s.stmts.consumeFederationState, `DELETE FROM federation_states
+			WHERE state_hash = ?
+			RETURNING provider, nonce, code_verifier, app_id, redirect_uri, scope,
+			client_state, code_challenge, code_challenge_method, client_nonce,
+			expires_at`},
+		{&s.stmts.saveAPIKey, `INSERT INTO api_keys(user_id, name, prefix,
+			key_hash, scopes, created_at, expires_at) VALUES(?, ?, ?, ?, ?, ?, ?)`},
+		{&s.stmts.apiKeys, `SELECT id, user_id, name, prefix, scopes,
+			created_at, expires_at FROM api_keys WHERE user_id = ? ORDER BY id`},
+		{&s.stmts.apiKeyByPrefix, `SELECT id, user_id, name, prefix, scopes,
+			created_at, expires_at, key_hash FROM api_keys WHERE prefix = ?`},
+		{&s.stmts.deleteAPIKey, "DELETE FROM api_keys WHERE id = ? AND user_id = ?"},
+		{&s.stmts.org, "SELECT id, name, created_at FROM organizations WHERE id = ?"},
+		{&s.stmts.memberships, `SELECT m.org_id, o.name, m.user_id, '', '',
+			m.role, m.created_at
+			FROM org_members m JOIN organizations o ON o.id = m.org_id
+			WHERE m.user_id = ? ORDER BY m.org_id`},
+		{&s.stmts.members, `SELECT m.org_id, '', m.user_id, u.email,
+			u.username, m.role, m.created_at
+			FROM org_members m JOIN users u ON u.id = m.user_id
+			WHERE m.org_id = ? ORDER BY m.created_at, m.user_id`},
+		{&s.stmts.member, `SELECT m.org_id, o.name, m.user_id, u.email,
+			u.username, m.role, m.created_at
+			FROM org_members m
+			JOIN organizations o ON o.id = m.org_id
+			JOIN users u ON u.id = m.user_id
+			WHERE m.org_id = ? AND m.user_id = ?`},
+		{&s.stmts.ownerCount,
+			"SELECT COUNT(*) FROM org_members WHERE org_id = ? AND role = ?"},
+		{&s.stmts.updateMemberRole,
+			"UPDATE org_members SET role = ? WHERE org_id = ? AND user_id = ?"},
+		{&s.stmts.deleteMember, "DELETE FROM org_members WHERE org_id = ? AND user_id = ?"},
+		{&s.stmts.saveInvitation, `INSERT INTO org_invitations(org_id, email, role,
+			token_hash, invited_by, created_at, expires_at)
+			VALUES(?, ?, ?, ?, ?, ?, ?)`},
+		{&s.stmts.invitation, `SELECT id, org_id, email, role, invited_by,
+			created_at, expires_at FROM org_invitations WHERE token_hash = ?`},
+		{&s.stmts.invitationsByEmail, `SELECT id, org_id, email, role, invited_by,
+			created_at, expires_at FROM org_invitations
+			WHERE email = ? COLLATE NOCASE ORDER BY id`},
+		{&s.stmts.apps, "SELECT id, name, secret, allowed_scopes FROM apps ORDER BY id"},
+		{&s.stmts.saveAuditEvent, `INSERT INTO audit_events(created_at, action,
+			actor_id, user_id, app_id, details) VALUES(?, ?, ?, ?, ?, ?)`},
+		{&s.stmts.auditEvents, `SELECT id, created_at, action, actor_id, user_id,
+			app_id, details FROM audit_events
+			WHERE id > ? AND (? = 0 OR user_id = ?)
+			ORDER BY id LIMIT ?`},
+		{&s.stmts.auditEventsNewest, `SELECT * FROM (SELECT id, created_at, action,
+			actor_id, user_id, app_id, details FROM audit_events
+			WHERE id > ? AND (? = 0 OR user_id = ?)
+			ORDER BY id DESC LIMIT ?) ORDER BY id`},
+	}
+
+	for _, q := range queries {
+		stmt, err := s.db.PrepareContext(ctx, q.query)
+		if err != nil {
+			return errors.Join(err, s.closeStatements())
+		}
+
+		*q.stmt = stmt
+		s.prepared = append(s.prepared, stmt)
+	}
+
+	return nil
+}
+
+func (s *Storage) closeStatements() error {
+	var errs []error
+	for _, stmt := range s.prepared {
+		errs = append(errs, stmt.Close())
+	}
+	s.prepared = nil
+
+	return errors.Join(errs...)
+}
@@ -6,4 +6,21 @@ var (
 	ErrUserExists   = errors.New("user already exists")
 	ErrUserNotFound = errors.New("user not found")
 	ErrAppNotFound  = errors.New("app not found")
+	ErrAppExists    = errors.New("app already exists")
+	ErrCodeNotFound = errors.New("authorization code not found")
+
+	ErrIdentityNotFound = errors.New("identity not found")
+	ErrStateNotFound    = errors.New("federation state not found")
+
+	ErrAPIKeyExists   = errors.New("api key already exists")
+	ErrAPIKeyNotFound = errors.New("api key not found")
+
+	ErrOrgExists          = errors.New("organization already exists")
+	ErrOrgNotFound        = errors.New("organization not found")
+	ErrMemberExists       = errors.New("user is already a member")
+	ErrMemberNotFound     = errors.New("member not found")
+	ErrInvitationNotFound = errors.New("invitation not found")
+	ErrSoleOwner          = errors.New("user is the only owner of an organization")
+
+	ErrCorrupt = errors.New("database is corrupt")
 )
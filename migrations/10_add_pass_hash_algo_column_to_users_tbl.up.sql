@@ -0,0 +1,6 @@
+BEGIN;
+
+ALTER TABLE users
+    ADD COLUMN pass_hash_algo TEXT NOT NULL DEFAULT 'bcrypt';
+
+COMMIT;
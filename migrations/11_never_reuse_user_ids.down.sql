@@ -0,0 +1,40 @@
+-- See the up migration for why foreign keys are turned off.
+PRAGMA foreign_keys = OFF;
+
+BEGIN;
+
+CREATE TABLE users_old
+(
+    id                  INTEGER PRIMARY KEY,
+    email               TEXT    NOT NULL UNIQUE,
+    pass_hash           BLOB    NOT NULL,
+    username            TEXT    NOT NULL UNIQUE,
+    is_admin            BOOLEAN NOT NULL DEFAULT FALSE,
+    suspended_at        INTEGER,
+    sessions_revoked_at INTEGER,
+    pass_hash_algo      TEXT    NOT NULL DEFAULT 'bcrypt'
+);
+
+INSERT INTO users_old(id, email, pass_hash, username, is_admin, suspended_at,
+                      sessions_revoked_at, pass_hash_algo)
+SELECT id, email, pass_hash, username, is_admin, suspended_at,
+       sessions_revoked_at, pass_hash_algo
+FROM users;
+
+DROP TABLE users;
+ALTER TABLE users_old RENAME TO users;
+
+CREATE INDEX IF NOT EXISTS idx_email ON users (email);
+CREATE INDEX IF NOT EXISTS idx_username ON users (username);
+
+CREATE TEMP TABLE users_fk_check
+(
+    violations INTEGER NOT NULL CHECK (violations = 0)
+);
+INSERT INTO users_fk_check
+SELECT count(*)
+FROM pragma_foreign_key_check
+WHERE parent = 'users';
+DROP TABLE users_fk_check;
+
+COMMIT;
@@ -0,0 +1,51 @@
+-- Erased users are deleted. Without AUTOINCREMENT, the ID of the last user
+-- would be handed out again, and tokens of the erased user would be valid
+-- for the new one.
+--
+-- The table is rebuilt as https://www.sqlite.org/lang_altertable.html#otheralter
+-- says: with foreign keys enforced, dropping the old table would delete
+-- every row referencing a user, and enforcement can only be turned off
+-- outside a transaction, which the migrator leaves to the migrations.
+-- Enforcement isn't turned back on: the connection is the migrator's, and
+-- only changes the schema.
+PRAGMA foreign_keys = OFF;
+
+BEGIN;
+
+CREATE TABLE users_new
+(
+    id                  INTEGER PRIMARY KEY AUTOINCREMENT,
+    email               TEXT    NOT NULL UNIQUE,
+    pass_hash           BLOB    NOT NULL,
+    username            TEXT    NOT NULL UNIQUE,
+    is_admin            BOOLEAN NOT NULL DEFAULT FALSE,
+    suspended_at        INTEGER,
+    sessions_revoked_at INTEGER,
+    pass_hash_algo      TEXT    NOT NULL DEFAULT 'bcrypt'
+);
+
+INSERT INTO users_new(id, email, pass_hash, username, is_admin, suspended_at,
+                      sessions_revoked_at, pass_hash_algo)
+SELECT id, email, pass_hash, username, is_admin, suspended_at,
+       sessions_revoked_at, pass_hash_algo
+FROM users;
+
+DROP TABLE users;
+ALTER TABLE users_new RENAME TO users;
+
+CREATE INDEX IF NOT EXISTS idx_email ON users (email);
+CREATE INDEX IF NOT EXISTS idx_username ON users (username);
+
+-- PRAGMA foreign_key_check only reports broken references; the CHECK turns
+-- any into an error, failing the migration before it commits.
+CREATE TEMP TABLE users_fk_check
+(
+    violations INTEGER NOT NULL CHECK (violations = 0)
+);
+INSERT INTO users_fk_check
+SELECT count(*)
+FROM pragma_foreign_key_check
+WHERE parent = 'users';
+DROP TABLE users_fk_check;
+
+COMMIT;
@@ -0,0 +1,8 @@
+BEGIN;
+
+DROP INDEX IF EXISTS idx_users_username_key;
+DROP INDEX IF EXISTS idx_users_email_key;
+ALTER TABLE users DROP COLUMN username_key;
+ALTER TABLE users DROP COLUMN email_key;
+
+COMMIT;
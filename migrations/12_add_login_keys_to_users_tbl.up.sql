@@ -0,0 +1,14 @@
+-- Canonical forms of email and username (see package identifier), which
+-- users are looked up by. They are computed by the service on start, which
+-- reports users that can't be told apart and leaves their keys NULL.
+
+BEGIN;
+
+ALTER TABLE users
+    ADD COLUMN email_key TEXT;
+ALTER TABLE users
+    ADD COLUMN username_key TEXT;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email_key ON users (email_key);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_username_key ON users (username_key);
+
+COMMIT;
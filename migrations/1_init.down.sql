@@ -1,2 +1,6 @@
+BEGIN;
+
 DROP TABLE IF EXISTS users;
-DROP TABLE IF EXISTS apps;
\ No newline at end of file
+DROP TABLE IF EXISTS apps;
+
+COMMIT;
@@ -1,3 +1,5 @@
+BEGIN;
+
 CREATE TABLE IF NOT EXISTS users
 (
     id         INTEGER PRIMARY KEY,
@@ -14,3 +16,5 @@ CREATE TABLE IF NOT EXISTS apps
     name TEXT NOT NULL UNIQUE,
     secret TEXT NOT NULL UNIQUE
 );
+
+COMMIT;
@@ -1 +1,5 @@
-ALTER TABLE users DROP COLUMN is_admin;
\ No newline at end of file
+BEGIN;
+
+ALTER TABLE users DROP COLUMN is_admin;
+
+COMMIT;
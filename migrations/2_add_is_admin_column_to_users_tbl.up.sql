@@ -1,2 +1,6 @@
+BEGIN;
+
 ALTER TABLE users
-    ADD COLUMN is_admin BOOLEAN NOT NULL DEFAULT FALSE;
\ No newline at end of file
+    ADD COLUMN is_admin BOOLEAN NOT NULL DEFAULT FALSE;
+
+COMMIT;
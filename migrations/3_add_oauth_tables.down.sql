@@ -0,0 +1,6 @@
+BEGIN;
+
+DROP TABLE IF EXISTS auth_codes;
+DROP TABLE IF EXISTS app_redirect_uris;
+
+COMMIT;
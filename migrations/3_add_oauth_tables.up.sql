@@ -0,0 +1,23 @@
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS app_redirect_uris
+(
+    app_id INTEGER NOT NULL REFERENCES apps (id) ON DELETE CASCADE,
+    uri    TEXT    NOT NULL,
+    PRIMARY KEY (app_id, uri)
+);
+
+CREATE TABLE IF NOT EXISTS auth_codes
+(
+    code_hash             BLOB    PRIMARY KEY,
+    app_id                INTEGER NOT NULL REFERENCES apps (id) ON DELETE CASCADE,
+    user_id               INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    redirect_uri          TEXT    NOT NULL,
+    scope                 TEXT    NOT NULL DEFAULT '',
+    code_challenge        TEXT    NOT NULL,
+    code_challenge_method TEXT    NOT NULL,
+    expires_at            INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_auth_codes_expires_at ON auth_codes (expires_at);
+
+COMMIT;
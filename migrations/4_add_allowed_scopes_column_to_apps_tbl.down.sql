@@ -0,0 +1,5 @@
+BEGIN;
+
+ALTER TABLE apps DROP COLUMN allowed_scopes;
+
+COMMIT;
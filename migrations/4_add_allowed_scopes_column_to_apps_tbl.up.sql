@@ -0,0 +1,6 @@
+BEGIN;
+
+ALTER TABLE apps
+    ADD COLUMN allowed_scopes TEXT NOT NULL DEFAULT '';
+
+COMMIT;
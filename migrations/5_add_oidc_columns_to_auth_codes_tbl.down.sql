@@ -0,0 +1,6 @@
+BEGIN;
+
+ALTER TABLE auth_codes DROP COLUMN auth_time;
+ALTER TABLE auth_codes DROP COLUMN nonce;
+
+COMMIT;
@@ -0,0 +1,8 @@
+BEGIN;
+
+ALTER TABLE auth_codes
+    ADD COLUMN nonce TEXT NOT NULL DEFAULT '';
+ALTER TABLE auth_codes
+    ADD COLUMN auth_time INTEGER NOT NULL DEFAULT 0;
+
+COMMIT;
@@ -0,0 +1,6 @@
+BEGIN;
+
+DROP TABLE IF EXISTS federation_states;
+DROP TABLE IF EXISTS identities;
+
+COMMIT;
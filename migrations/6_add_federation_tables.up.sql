@@ -0,0 +1,31 @@
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS identities
+(
+    provider   TEXT    NOT NULL,
+    subject    TEXT    NOT NULL,
+    user_id    INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    email      TEXT    NOT NULL DEFAULT '',
+    created_at INTEGER NOT NULL,
+    PRIMARY KEY (provider, subject)
+);
+CREATE INDEX IF NOT EXISTS idx_identities_user_id ON identities (user_id);
+
+CREATE TABLE IF NOT EXISTS federation_states
+(
+    state_hash            BLOB    PRIMARY KEY,
+    provider              TEXT    NOT NULL,
+    nonce                 TEXT    NOT NULL,
+    code_verifier         TEXT    NOT NULL,
+    app_id                INTEGER NOT NULL REFERENCES apps (id) ON DELETE CASCADE,
+    redirect_uri          TEXT    NOT NULL,
+    scope                 TEXT    NOT NULL DEFAULT '',
+    client_state          TEXT    NOT NULL DEFAULT '',
+    code_challenge        TEXT    NOT NULL,
+    code_challenge_method TEXT    NOT NULL,
+    client_nonce          TEXT    NOT NULL DEFAULT '',
+    expires_at            INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_federation_states_expires_at ON federation_states (expires_at);
+
+COMMIT;
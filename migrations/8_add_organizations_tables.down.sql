@@ -0,0 +1,7 @@
+BEGIN;
+
+DROP TABLE IF EXISTS org_invitations;
+DROP TABLE IF EXISTS org_members;
+DROP TABLE IF EXISTS organizations;
+
+COMMIT;
@@ -0,0 +1,7 @@
+BEGIN;
+
+DROP TABLE IF EXISTS audit_events;
+ALTER TABLE users DROP COLUMN sessions_revoked_at;
+ALTER TABLE users DROP COLUMN suspended_at;
+
+COMMIT;
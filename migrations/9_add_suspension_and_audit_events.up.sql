@@ -0,0 +1,20 @@
+BEGIN;
+
+ALTER TABLE users
+    ADD COLUMN suspended_at INTEGER;
+ALTER TABLE users
+    ADD COLUMN sessions_revoked_at INTEGER;
+
+CREATE TABLE IF NOT EXISTS audit_events
+(
+    id         INTEGER PRIMARY KEY AUTOINCREMENT,
+    created_at INTEGER NOT NULL,
+    action     TEXT    NOT NULL,
+    actor_id   INTEGER,
+    user_id    INTEGER,
+    app_id     INTEGER,
+    details    TEXT    NOT NULL DEFAULT '{}'
+);
+CREATE INDEX IF NOT EXISTS idx_audit_events_user_id ON audit_events (user_id);
+
+COMMIT;
@@ -0,0 +1,8 @@
+// Package migrations embeds the SQL migrations, so binaries can migrate the
+// database without the directory being shipped next to them.
+package migrations
+
+import "embed"
+
+//go:embed *.sql
+var FS embed.FS
@@ -0,0 +1,183 @@
+package tests
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"xauth/internal/domain/models"
+	accountgrpc "xauth/internal/grpc/account"
+	admingrpc "xauth/internal/grpc/admin"
+	"xauth/internal/services/account"
+	"xauth/internal/services/apikeys"
+	"xauth/internal/services/auth"
+	"xauth/internal/services/oauth"
+	"xauth/internal/services/orgs"
+	"xauth/internal/storage"
+	"xauth/tests/suite"
+
+	"github.com/brianvoe/gofakeit/v6"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// TestAccount_ExportAndErase exports a user's data and erases them, then
+// checks that nothing but pseudonymized audit events is left and that
+// their ID isn't given to the next user.
+func TestAccount_ExportAndErase(t *testing.T) {
+	ctx := context.Background()
+	store, authService, oauthService, adminAPI := newOffline(t)
+
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	api := accountgrpc.NewServerAPI(account.New(log, store))
+	orgsService := orgs.New(log, store, store, store, time.Hour, time.Hour, testIssuer,
+		signingKey(t))
+	keys := apikeys.New(log, store, time.Hour, time.Hour)
+
+	app, err := adminAPI.CreateApp(ctx, &admingrpc.CreateAppRequest{Name: "app"})
+	require.NoError(t, err)
+
+	bobID, err := authService.RegisterNewUser(ctx, "bob@example.com", randomFakePassword(), "bob")
+	require.NoError(t, err)
+
+	pass := randomFakePassword()
+	aliceID, err := authService.RegisterNewUser(ctx, "alice@example.com", pass, "alice")
+	require.NoError(t, err)
+
+	token, err := authService.Login(ctx, "alice@example.com", pass, app.App.ID, "")
+	require.NoError(t, err)
+
+	_, _, err = keys.Create(ctx, aliceID, "ci", nil, 0)
+	require.NoError(t, err)
+
+	shared, err := orgsService.Create(ctx, aliceID, "shared")
+	require.NoError(t, err)
+	solo, err := orgsService.Create(ctx, aliceID, "solo")
+	require.NoError(t, err)
+
+	_, invitation, err := orgsService.Invite(ctx, aliceID, shared.ID, "bob@example.com",
+		models.RoleMember)
+	require.NoError(t, err)
+	_, err = orgsService.Accept(ctx, bobID, invitation)
+	require.NoError(t, err)
+
+	export, err := api.ExportMyData(ctx, &accountgrpc.ExportMyDataRequest{UserID: aliceID})
+	require.NoError(t, err)
+	assert.Equal(t, "alice@example.com", export.Profile.Email)
+	assert.Len(t, export.Roles.Organizations, 2)
+	assert.Len(t, export.Sessions.APIKeys, 1)
+	require.NotEmpty(t, export.AuditEvents)
+	assert.Equal(t, models.AuditUserRegistered, export.AuditEvents[0].Action)
+
+	_, err = api.EraseAccount(ctx, &accountgrpc.EraseAccountRequest{})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.Equal(t, []string{"user_id"}, fieldViolations(err))
+
+	_, err = api.EraseAccount(ctx, &accountgrpc.EraseAccountRequest{UserID: aliceID})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	assert.Equal(t, accountgrpc.ReasonSoleOwner, errorReason(t, err))
+
+	require.NoError(t, orgsService.SetRole(ctx, aliceID, shared.ID, bobID, models.RoleOwner))
+
+	_, err = api.EraseAccount(ctx, &accountgrpc.EraseAccountRequest{UserID: aliceID})
+	require.NoError(t, err)
+
+	_, err = api.ExportMyData(ctx, &accountgrpc.ExportMyDataRequest{UserID: aliceID})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	assert.Equal(t, accountgrpc.ReasonUserNotFound, errorReason(t, err))
+	_, err = oauthService.ValidateToken(ctx, token)
+	assert.ErrorIs(t, err, oauth.ErrInvalidToken)
+	_, err = authService.Login(ctx, "alice@example.com", pass, app.App.ID, "")
+	assert.ErrorIs(t, err, auth.ErrInvalidCredentials)
+
+	_, err = store.Org(ctx, solo.ID)
+	assert.ErrorIs(t, err, storage.ErrOrgNotFound)
+	members, err := store.Members(ctx, shared.ID)
+	require.NoError(t, err)
+	require.Len(t, members, 1)
+	assert.Equal(t, bobID, members[0].UserID)
+
+	events, err := adminAPI.ListAuditEvents(ctx, &admingrpc.ListAuditEventsRequest{Limit: 1000})
+	require.NoError(t, err)
+
+	erased := events.Events[len(events.Events)-1]
+	require.Equal(t, models.AuditUserErased, erased.Action)
+	pseudonym := erased.Details["user"]
+	require.NotEmpty(t, pseudonym)
+
+	var pseudonymized int
+	for _, e := range events.Events {
+		assert.NotEqual(t, aliceID, e.UserID)
+		assert.NotEqual(t, aliceID, e.ActorID)
+
+		if e.Details["user"] == pseudonym {
+			pseudonymized++
+		}
+	}
+	assert.Equal(t, len(export.AuditEvents)+2, pseudonymized,
+		"the export and the erasure are recorded too")
+
+	// Tokens name users by ID, which must never be reused.
+	carolID, err := authService.RegisterNewUser(ctx, "alice@example.com", pass, "carol")
+	require.NoError(t, err)
+	assert.Greater(t, carolID, aliceID)
+	_, err = oauthService.ValidateToken(ctx, token)
+	assert.ErrorIs(t, err, oauth.ErrInvalidToken)
+}
+
+func TestAccount_SelfOrAdmin(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	userID, token := registerAndLogin(t, ctx, st, gofakeit.Username())
+	otherID, otherToken := registerAndLogin(t, ctx, st, gofakeit.Username())
+
+	export, err := st.AccountClient.ExportMyData(withBearer(ctx, token),
+		&accountgrpc.ExportMyDataRequest{UserID: userID})
+	require.NoError(t, err)
+	assert.Equal(t, userID, export.Profile.UserID)
+
+	_, err = st.AccountClient.ExportMyData(withBearer(ctx, token),
+		&accountgrpc.ExportMyDataRequest{UserID: otherID})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	_, err = st.AccountClient.EraseAccount(withBearer(ctx, otherToken),
+		&accountgrpc.EraseAccountRequest{UserID: userID})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	// API keys and organization tokens don't act for the user here.
+	var key apiKeyResult
+	resp := apiKeysRequest(t, st, http.MethodPost, "", token,
+		map[string]any{"name": "ci"}, &key)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	var org orgResult
+	resp = jsonRequest(t, st, http.MethodPost, "/v1/orgs", token,
+		map[string]any{"name": gofakeit.Company() + " " + gofakeit.LetterN(8)}, &org)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	var orgToken struct {
+		Token string `json:"token"`
+	}
+	resp = jsonRequest(t, st, http.MethodPost,
+		"/v1/orgs/"+strconv.FormatInt(org.ID, 10)+"/token", token, nil, &orgToken)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	for _, other := range []string{key.Key, orgToken.Token} {
+		_, err = st.AccountClient.EraseAccount(withBearer(ctx, other),
+			&accountgrpc.EraseAccountRequest{UserID: userID})
+		assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	}
+
+	_, err = st.AccountClient.EraseAccount(withBearer(ctx, token),
+		&accountgrpc.EraseAccountRequest{UserID: userID})
+	require.NoError(t, err)
+
+	_, err = st.AccountClient.ExportMyData(withBearer(ctx, token),
+		&accountgrpc.ExportMyDataRequest{UserID: userID})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
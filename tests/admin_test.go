@@ -0,0 +1,244 @@
+package tests
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"xauth/internal/domain/models"
+	admingrpc "xauth/internal/grpc/admin"
+	"xauth/internal/lib/hashpool"
+	"xauth/internal/services/admin"
+	"xauth/internal/services/apikeys"
+	"xauth/internal/services/auth"
+	"xauth/internal/services/oauth"
+	"xauth/internal/storage/migrator"
+	"xauth/internal/storage/sqlite"
+	"xauth/migrations"
+	"xauth/tests/suite"
+
+	"github.com/brianvoe/gofakeit/v6"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// TestAdmin_Offline runs the admin handlers in-process on a fresh
+// database, as xauthctl -offline does, and checks that suspending a user
+// and revoking their sessions take effect.
+func TestAdmin_Offline(t *testing.T) {
+	ctx := context.Background()
+	_, authService, oauthService, api := newOffline(t)
+
+	created, err := api.CreateApp(ctx, &admingrpc.CreateAppRequest{
+		Name:         "billing",
+		RedirectURIs: []string{"https://billing.example.com/callback"},
+	})
+	require.NoError(t, err)
+	assert.NotEmpty(t, created.App.Secret)
+
+	_, err = api.CreateApp(ctx, &admingrpc.CreateAppRequest{Name: "billing"})
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+
+	apps, err := api.ListApps(ctx, &admingrpc.ListAppsRequest{})
+	require.NoError(t, err)
+	require.Len(t, apps.Apps, 1)
+	assert.Empty(t, apps.Apps[0].Secret)
+
+	email, username, pass := gofakeit.Email(), gofakeit.Username(), randomFakePassword()
+	userID, err := authService.RegisterNewUser(ctx, email, pass, username)
+	require.NoError(t, err)
+
+	token, err := authService.Login(ctx, email, pass, created.App.ID, username)
+	require.NoError(t, err)
+	_, err = oauthService.ValidateToken(ctx, token)
+	require.NoError(t, err)
+
+	_, err = api.SetSuspended(ctx, &admingrpc.SetSuspendedRequest{UserID: userID, Suspended: true})
+	require.NoError(t, err)
+
+	user, err := api.GetUser(ctx, &admingrpc.GetUserRequest{Login: username})
+	require.NoError(t, err)
+	assert.Equal(t, userID, user.User.ID)
+	assert.NotNil(t, user.User.SuspendedAt)
+
+	_, err = authService.Login(ctx, email, pass, created.App.ID, username)
+	assert.ErrorIs(t, err, auth.ErrUserSuspended)
+	_, err = oauthService.ValidateToken(ctx, token)
+	assert.ErrorIs(t, err, oauth.ErrInvalidToken)
+
+	_, err = api.SetSuspended(ctx, &admingrpc.SetSuspendedRequest{UserID: userID})
+	require.NoError(t, err)
+	_, err = oauthService.ValidateToken(ctx, token)
+	require.NoError(t, err)
+
+	_, err = api.RevokeSessions(ctx, &admingrpc.RevokeSessionsRequest{UserID: userID})
+	require.NoError(t, err)
+	_, err = oauthService.ValidateToken(ctx, token)
+	assert.ErrorIs(t, err, oauth.ErrInvalidToken)
+
+	_, err = api.SetAdmin(ctx, &admingrpc.SetAdminRequest{UserID: userID + 1, IsAdmin: true})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	events, err := api.ListAuditEvents(ctx, &admingrpc.ListAuditEventsRequest{UserID: userID})
+	require.NoError(t, err)
+
+	var actions []string
+	for _, e := range events.Events {
+		actions = append(actions, e.Action)
+	}
+	assert.Equal(t, []string{
+		models.AuditUserRegistered,
+		models.AuditUserLogin,
+		models.AuditUserSuspended,
+		models.AuditUserLoginFailed,
+		models.AuditUserUnsuspended,
+		models.AuditUserSessionsRevoked,
+	}, actions)
+
+	last, err := api.ListAuditEvents(ctx, &admingrpc.ListAuditEventsRequest{
+		UserID: userID,
+		Limit:  2,
+		Newest: true,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, events.Events[len(events.Events)-2:], last.Events)
+}
+
+// TestAdmin_ImportUsers imports users with legacy hashes and checks that
+// they can sign in, after which their hashes are bcrypt ones.
+func TestAdmin_ImportUsers(t *testing.T) {
+	ctx := context.Background()
+	storage, authService, _, api := newOffline(t)
+
+	_, err := api.CreateApp(ctx, &admingrpc.CreateAppRequest{Name: "legacy"})
+	require.NoError(t, err)
+
+	users := []admingrpc.ImportUser{
+		{
+			Email:    "pbkdf2@example.com",
+			Username: "pbkdf2",
+			PasswordHash: "$pbkdf2-sha256$i=1000$MDEyMzQ1Njc4OWFiY2RlZg$" +
+				"cBg8D2DungRB9k76szThf5ehfyBz991ay6PT8Srwk4M",
+		},
+		{
+			Email:        "ldap@example.com",
+			Username:     "ldap",
+			PasswordHash: "{SSHA}J1/8cWVgczTunyJ5hA+/nqlHwrswMTIzNDU2Nzg5YWJjZGVm",
+		},
+		{Email: "invalid", Username: "invalid", PasswordHash: "{SSHA}J1/8cWVgczTunyJ5hA"},
+		{Email: "plain@example.com", Username: "plain", PasswordHash: "hunter2"},
+		{Email: "pbkdf2@example.com", Username: "pbkdf2-again", PasswordHash: "{SSHA}" +
+			"J1/8cWVgczTunyJ5hA+/nqlHwrswMTIzNDU2Nzg5YWJjZGVm"},
+		// 1 GiB per login attempt.
+		{Email: "scrypt@example.com", Username: "scrypt",
+			PasswordHash: "$scrypt$ln=20,r=8,p=1$c2FsdA$aGFzaA"},
+	}
+
+	resp, err := api.ImportUsers(ctx, &admingrpc.ImportUsersRequest{Users: users, DryRun: true})
+	require.NoError(t, err)
+	assert.Equal(t, 2, resp.Imported)
+
+	_, err = storage.UserByEmail(ctx, "pbkdf2@example.com")
+	require.Error(t, err, "dry run must not save users")
+
+	resp, err = api.ImportUsers(ctx, &admingrpc.ImportUsersRequest{Users: users})
+	require.NoError(t, err)
+	assert.Equal(t, 2, resp.Imported)
+	assert.Equal(t, []int{4}, resp.Existing)
+	require.Len(t, resp.Rejected, 3)
+	assert.Equal(t, 2, resp.Rejected[0].Index)
+	assert.Equal(t, 3, resp.Rejected[1].Index)
+	assert.Equal(t, 5, resp.Rejected[2].Index)
+
+	for _, format := range []string{"alg=md5", "alg=scrypt,ln=14,r=8,p=4"} {
+		_, err = api.ImportUsers(ctx, &admingrpc.ImportUsersRequest{Users: users, HashFormat: format})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err), format)
+	}
+
+	_, err = authService.Login(ctx, "pbkdf2@example.com", "wrong", 1, "")
+	assert.ErrorIs(t, err, auth.ErrInvalidCredentials)
+
+	user, err := storage.UserByEmail(ctx, "pbkdf2@example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "pbkdf2-sha256", user.PassHashAlgo)
+
+	for range 2 {
+		_, err = authService.Login(ctx, "pbkdf2@example.com", legacyPassword, 1, "")
+		require.NoError(t, err)
+
+		// Hashes are upgraded after the login has been answered.
+		require.Eventually(t, func() bool {
+			user, err = storage.UserByEmail(ctx, "pbkdf2@example.com")
+			require.NoError(t, err)
+
+			return user.PassHashAlgo == "bcrypt"
+		}, 5*time.Second, 10*time.Millisecond)
+		assert.NoError(t, bcrypt.CompareHashAndPassword(user.PassHash, []byte(legacyPassword)))
+	}
+
+	_, err = authService.Login(ctx, "", legacyPassword, 1, "ldap")
+	require.NoError(t, err)
+}
+
+func TestAdmin_RequiresAdmin(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	_, err := st.AdminClient.ListApps(ctx, &admingrpc.ListAppsRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	userID, token := registerAndLogin(t, ctx, st, gofakeit.Username())
+
+	_, err = st.AdminClient.SetAdmin(withBearer(ctx, token), &admingrpc.SetAdminRequest{
+		UserID:  userID,
+		IsAdmin: true,
+	})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+// newOffline returns the services on a fresh, fully migrated database.
+func newOffline(t *testing.T) (*sqlite.Storage, *auth.Auth, *oauth.OAuth,
+	admingrpc.AdminServer) {
+	t.Helper()
+
+	storage, err := sqlite.New(newDB(t), sqlite.Options{
+		JournalMode: "wal",
+		ForeignKeys: true,
+		BusyTimeout: 5 * time.Second,
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { assert.NoError(t, storage.Close()) })
+
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	hashPool := hashpool.New(2, 64)
+	t.Cleanup(hashPool.Close)
+
+	authService := auth.New(log, storage, storage, storage, storage, time.Hour,
+		testIssuer, signingKey(t), false, nil, hashPool)
+	oauthService := oauth.New(log, authService, storage, storage, storage,
+		time.Minute, time.Hour, time.Hour, testIssuer, signingKey(t),
+		apikeys.New(log, storage, time.Hour, time.Hour))
+
+	return storage, authService, oauthService,
+		admingrpc.NewServerAPI(admin.New(log, storage, nil))
+}
+
+// newDB returns the path of a fresh, fully migrated database.
+func newDB(tb testing.TB) string {
+	tb.Helper()
+
+	path := filepath.Join(tb.TempDir(), "sso.db")
+
+	m, err := migrator.NewFS(migrations.FS, path, "migrations", nil)
+	require.NoError(tb, err)
+	_, err = m.UpTo(m.Latest())
+	require.NoError(tb, err)
+	require.NoError(tb, m.Close())
+
+	return path
+}
@@ -0,0 +1,292 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"xauth/internal/lib/pkce"
+	"xauth/tests/suite"
+
+	"github.com/brianvoe/gofakeit/v6"
+	ssov1 "github.com/memxire/protobuf/gen/go/sso"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type apiKeyResult struct {
+	ID     int64    `json:"id"`
+	Name   string   `json:"name"`
+	Prefix string   `json:"prefix"`
+	Scopes []string `json:"scopes"`
+	Key    string   `json:"key"`
+}
+
+type introspectionResult struct {
+	Active    bool   `json:"active"`
+	Sub       string `json:"sub"`
+	Scope     string `json:"scope"`
+	Username  string `json:"username"`
+	TokenType string `json:"token_type"`
+	OrgID     int64  `json:"org_id"`
+	OrgRole   string `json:"org_role"`
+}
+
+func TestAPIKeys_Lifecycle(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	username := gofakeit.Username()
+	userID, accessToken := registerAndLogin(t, ctx, st, username)
+
+	var created apiKeyResult
+	resp := apiKeysRequest(t, st, http.MethodPost, "", accessToken, map[string]any{
+		"name":       "ci",
+		"scopes":     []string{"users:read"},
+		"expires_in": 3600,
+	}, &created)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	require.True(t, strings.HasPrefix(created.Key, "xak_"+created.Prefix+"_"))
+	assert.Equal(t, []string{"users:read"}, created.Scopes)
+
+	// Names are unique per user.
+	resp = apiKeysRequest(t, st, http.MethodPost, "", accessToken,
+		map[string]any{"name": "ci"}, nil)
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+
+	var list struct {
+		APIKeys []apiKeyResult `json:"api_keys"`
+	}
+	resp = apiKeysRequest(t, st, http.MethodGet, "", accessToken, nil, &list)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Len(t, list.APIKeys, 1)
+	assert.Equal(t, created.Prefix, list.APIKeys[0].Prefix)
+	assert.Empty(t, list.APIKeys[0].Key, "keys are shown only once")
+
+	// The key works wherever an access token does.
+	info := introspect(t, st, created.Key)
+	assert.True(t, info.Active)
+	assert.Equal(t, strconv.FormatInt(userID, 10), info.Sub)
+	assert.Equal(t, "users:read", info.Scope)
+	assert.Equal(t, username, info.Username)
+	assert.Equal(t, "api_key", info.TokenType)
+
+	req, err := http.NewRequest(http.MethodGet, st.HTTPURL+"/userinfo", nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+created.Key)
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// Keys can't be used to manage keys.
+	resp = apiKeysRequest(t, st, http.MethodGet, "", created.Key, nil, nil)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	resp = apiKeysRequest(t, st, http.MethodDelete, "/"+strconv.FormatInt(created.ID, 10),
+		accessToken, nil, nil)
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	assert.False(t, introspect(t, st, created.Key).Active)
+}
+
+func TestAPIKeys_FailCases(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	_, accessToken := registerAndLogin(t, ctx, st, gofakeit.Username())
+	_, otherToken := registerAndLogin(t, ctx, st, gofakeit.Username())
+
+	var created apiKeyResult
+	resp := apiKeysRequest(t, st, http.MethodPost, "", accessToken,
+		map[string]any{"name": "deploy"}, &created)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	// A token an app got by having the user sign in to it.
+	email, pass := gofakeit.Email(), randomFakePassword()
+	_, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+		Email:    email,
+		Password: pass,
+		Username: gofakeit.Username(),
+	})
+	require.NoError(t, err)
+
+	verifier := randomVerifier()
+	code := authorize(t, st.HTTPURL, authorizeParams(pkce.ChallengeS256(verifier)), email, pass)
+	codeToken := exchangeCode(t, st.HTTPURL, code, verifier)
+	require.Equal(t, http.StatusOK, codeToken.status)
+
+	tests := []struct {
+		name         string
+		method       string
+		path         string
+		token        string
+		body         any
+		expectedCode int
+	}{
+		{
+			name:         "No Access Token",
+			method:       http.MethodGet,
+			expectedCode: http.StatusUnauthorized,
+		},
+		{
+			name:         "Invalid Access Token",
+			method:       http.MethodGet,
+			token:        "not-a-jwt",
+			expectedCode: http.StatusUnauthorized,
+		},
+		{
+			name:         "Authorization Code Token",
+			method:       http.MethodPost,
+			token:        codeToken.AccessToken,
+			body:         map[string]any{"name": "x"},
+			expectedCode: http.StatusUnauthorized,
+		},
+		{
+			name:         "Empty Name",
+			method:       http.MethodPost,
+			token:        accessToken,
+			body:         map[string]any{"name": ""},
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "Scope With Spaces",
+			method:       http.MethodPost,
+			token:        accessToken,
+			body:         map[string]any{"name": "x", "scopes": []string{"a b"}},
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "Lifetime Over Maximum",
+			method:       http.MethodPost,
+			token:        accessToken,
+			body:         map[string]any{"name": "x", "expires_in": 10 * 365 * 24 * 3600},
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "Revoke Other User's Key",
+			method:       http.MethodDelete,
+			path:         "/" + strconv.FormatInt(created.ID, 10),
+			token:        otherToken,
+			expectedCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := apiKeysRequest(t, st, tt.method, tt.path, tt.token, tt.body, nil)
+			assert.Equal(t, tt.expectedCode, resp.StatusCode)
+		})
+	}
+
+	assert.True(t, introspect(t, st, created.Key).Active)
+}
+
+func TestIntrospect_FailCases(t *testing.T) {
+	_, st := suite.New(t)
+
+	assert.False(t, introspect(t, st, "xak_000000000000_forged").Active)
+	assert.False(t, introspect(t, st, "not-a-jwt").Active)
+
+	resp, err := http.PostForm(st.HTTPURL+"/introspect", url.Values{
+		"token":         {"not-a-jwt"},
+		"client_id":     {strconv.Itoa(appID)},
+		"client_secret": {"wrong-secret"},
+	})
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+func registerAndLogin(t *testing.T, ctx context.Context, st *suite.Suite, username string) (int64, string) {
+	t.Helper()
+
+	email := gofakeit.Email()
+	pass := randomFakePassword()
+
+	respReg, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+		Email:    email,
+		Password: pass,
+		Username: username,
+	})
+	require.NoError(t, err)
+
+	respLogin, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{
+		Email:    email,
+		Password: pass,
+		AppId:    appID,
+		Username: username,
+	})
+	require.NoError(t, err)
+
+	return respReg.GetUserId(), respLogin.GetToken()
+}
+
+func apiKeysRequest(
+	t *testing.T,
+	st *suite.Suite,
+	method string,
+	path string,
+	token string,
+	body any,
+	res any,
+) *http.Response {
+	t.Helper()
+
+	return jsonRequest(t, st, method, "/v1/api-keys"+path, token, body, res)
+}
+
+// jsonRequest calls an endpoint of the JSON API as the bearer of token and
+// decodes a successful response into res.
+func jsonRequest(
+	t *testing.T,
+	st *suite.Suite,
+	method string,
+	path string,
+	token string,
+	body any,
+	res any,
+) *http.Response {
+	t.Helper()
+
+	var reqBody bytes.Buffer
+	if body != nil {
+		require.NoError(t, json.NewEncoder(&reqBody).Encode(body))
+	}
+
+	req, err := http.NewRequest(method, st.HTTPURL+path, &reqBody)
+	require.NoError(t, err)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	if res != nil && resp.StatusCode < http.StatusBadRequest {
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(res))
+	}
+
+	return resp
+}
+
+func introspect(t *testing.T, st *suite.Suite, token string) introspectionResult {
+	t.Helper()
+
+	resp, err := http.PostForm(st.HTTPURL+"/introspect", url.Values{
+		"token":         {token},
+		"client_id":     {strconv.Itoa(appID)},
+		"client_secret": {appSecret},
+	})
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var res introspectionResult
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&res))
+
+	return res
+}
@@ -0,0 +1,137 @@
+package tests
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"xauth/internal/domain/models"
+	"xauth/internal/services/auth"
+	"xauth/internal/storage"
+	"xauth/internal/storage/migrator"
+	"xauth/internal/storage/sqlite"
+	"xauth/migrations"
+	"xauth/tests/suite"
+
+	ssov1 "github.com/memxire/protobuf/gen/go/sso"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// TestAuth_Identifier checks that emails and usernames are compared in
+// canonical form, and that a login names a single user.
+func TestAuth_Identifier(t *testing.T) {
+	ctx := context.Background()
+	_, authService, _, _ := newOffline(t)
+
+	pass := randomFakePassword()
+	aliceID, err := authService.RegisterNewUser(ctx, "Alice@Example.com", pass, "Alice")
+	require.NoError(t, err)
+	bobID, err := authService.RegisterNewUser(ctx, "bob@example.com", pass, "bob")
+	require.NoError(t, err)
+
+	_, err = authService.RegisterNewUser(ctx, " alice@example.COM", pass, "alice2")
+	assert.ErrorIs(t, err, auth.ErrUserExists)
+	// Fullwidth letters are the same as ASCII ones after NFKC.
+	_, err = authService.RegisterNewUser(ctx, "carol@example.com", pass, "ＡＬＩＣＥ")
+	assert.ErrorIs(t, err, auth.ErrUserExists)
+
+	for _, login := range []struct{ email, username string }{
+		{"ALICE@example.com", ""},
+		{"", "alice"},
+		{"alice@example.com", "alice@example.com"},
+		{"aLiCe", "aLiCe"},
+		{"alice@example.com", "ALICE"},
+	} {
+		user, err := authService.Authenticate(ctx, login.email, pass, login.username)
+		require.NoError(t, err, login)
+		assert.Equal(t, aliceID, user.ID, login)
+	}
+
+	user, err := authService.Authenticate(ctx, "bob", pass, "bob")
+	require.NoError(t, err)
+	assert.Equal(t, bobID, user.ID)
+
+	// Alice's email with Bob's username must not sign in either of them.
+	_, err = authService.Authenticate(ctx, "alice@example.com", pass, "bob")
+	assert.ErrorIs(t, err, auth.ErrInvalidCredentials)
+	_, err = authService.Authenticate(ctx, "", pass, "")
+	assert.ErrorIs(t, err, auth.ErrInvalidCredentials)
+}
+
+// TestAuth_IdentifierCollisions saves users whose emails differ only in
+// case before the canonical forms were stored, and checks that the
+// backfill reports them and that both can still sign in.
+func TestAuth_IdentifierCollisions(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "sso.db")
+
+	m, err := migrator.NewFS(migrations.FS, path, "migrations", nil)
+	require.NoError(t, err)
+	_, err = m.UpTo(11)
+	require.NoError(t, err)
+
+	db, err := sql.Open("sqlite3", path)
+	require.NoError(t, err)
+	_, err = db.Exec(`INSERT INTO users (id, email, pass_hash, username) VALUES
+		(1, 'dave@example.com', 'x', 'dave'),
+		(2, 'Dave@Example.com', 'x', 'dave2'),
+		(3, 'erin@example.com', 'x', 'DAVE')`)
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	_, err = m.UpTo(m.Latest())
+	require.NoError(t, err)
+	require.NoError(t, m.Close())
+
+	st, err := sqlite.New(path, sqlite.Options{})
+	require.NoError(t, err)
+	t.Cleanup(func() { assert.NoError(t, st.Close()) })
+
+	collisions, err := st.BackfillLoginKeys(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []models.LoginCollision{
+		{UserID: 2, Field: "email", OtherID: 1},
+		{UserID: 3, Field: "username", OtherID: 1},
+	}, collisions)
+
+	collisions, err = st.BackfillLoginKeys(ctx)
+	require.NoError(t, err)
+	assert.Len(t, collisions, 2, "collisions are reported until resolved")
+
+	for _, tt := range []struct {
+		email    string
+		username string
+		userID   int64
+	}{
+		{email: "DAVE@example.com", userID: 1},
+		{email: "Dave@Example.com", userID: 2},
+		{username: "Dave", userID: 1},
+		{username: "DAVE", userID: 3},
+		{username: "dave2", userID: 2},
+	} {
+		user, err := st.UserByEmail(ctx, tt.email)
+		if tt.username != "" {
+			user, err = st.UserByUsername(ctx, tt.username)
+		}
+		require.NoError(t, err, tt)
+		assert.Equal(t, tt.userID, user.ID, tt)
+	}
+
+	_, err = st.UserByEmail(ctx, "nobody@example.com")
+	assert.ErrorIs(t, err, storage.ErrUserNotFound)
+}
+
+func TestRegister_UsernameWithAt(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	_, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+		Email:    "frank@example.com",
+		Password: randomFakePassword(),
+		Username: "frank@example.com",
+	})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
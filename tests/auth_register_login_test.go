@@ -48,9 +48,7 @@ func TestRegisterLogin_Login_HappyPath(t *testing.T) {
 	token := respLogin.GetToken()
 	require.NotEmpty(t, token)
 
-	tokenParsed, err := jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
-		return []byte(appSecret), nil
-	})
+	tokenParsed, err := jwt.Parse(token, jwksKeyfunc(t, st))
 	require.NoError(t, err)
 
 	claims, ok := tokenParsed.Claims.(jwt.MapClaims)
@@ -0,0 +1,209 @@
+package tests
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"xauth/internal/domain/models"
+	"xauth/internal/lib/hashpool"
+	"xauth/internal/lib/passhash"
+	"xauth/internal/services/auth"
+
+	"github.com/brianvoe/gofakeit/v6"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// timingRounds is how many times each path is timed. Medians of
+// interleaved rounds are compared, so that other load affects both alike.
+const timingRounds = 15
+
+// TestAuth_LoginTiming checks that signing in as an unknown user takes as
+// long as signing in with a wrong password, whatever the user's hash, and
+// with both an email and a username.
+func TestAuth_LoginTiming(t *testing.T) {
+	if testing.Short() {
+		t.Skip("compares bcrypt timings")
+	}
+
+	ctx := context.Background()
+	storage, authService, _, _ := newOffline(t)
+
+	email, username := gofakeit.Email(), gofakeit.Username()
+	_, err := authService.RegisterNewUser(ctx, email, randomFakePassword(), username)
+	require.NoError(t, err)
+
+	lowCost, err := bcrypt.GenerateFromPassword([]byte(legacyPassword), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	legacy := models.User{Email: gofakeit.Email(), Username: gofakeit.Username(),
+		PassHashAlgo: passhash.PBKDF2SHA256, PassHash: []byte("$pbkdf2-sha256$i=1000$" +
+			"MDEyMzQ1Njc4OWFiY2RlZg$cBg8D2DungRB9k76szThf5ehfyBz991ay6PT8Srwk4M")}
+	cheap := models.User{Email: gofakeit.Email(), Username: gofakeit.Username(),
+		PassHashAlgo: passhash.Bcrypt, PassHash: lowCost}
+	_, err = storage.ImportUsers(ctx, []models.User{legacy, cheap}, false)
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		name            string
+		email, username string
+	}{
+		{"bcrypt", email, ""},
+		{"legacy hash", legacy.Email, ""},
+		{"low-cost bcrypt", cheap.Email, ""},
+		{"email and username", email, username},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			unknownUsername := ""
+			if tc.username != "" {
+				unknownUsername = gofakeit.Username()
+			}
+
+			wrongPassword, unknownUser := compareTimings(
+				func() {
+					_, err := authService.Authenticate(ctx, tc.email, "wrong", tc.username)
+					assert.ErrorIs(t, err, auth.ErrInvalidCredentials)
+				},
+				func() {
+					_, err := authService.Authenticate(ctx, gofakeit.Email(), "wrong",
+						unknownUsername)
+					assert.ErrorIs(t, err, auth.ErrInvalidCredentials)
+				},
+			)
+			assertSimilar(t, wrongPassword, unknownUser)
+		})
+	}
+}
+
+// TestAuth_EnumerationSafeRegistration checks that in enumeration-safe
+// mode registering with a taken email succeeds, as long as registering a
+// new user, and notifies the owner. Audit events are slowed down, so that
+// waiting for the one only new users get would show.
+func TestAuth_EnumerationSafeRegistration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("compares bcrypt timings")
+	}
+
+	ctx := context.Background()
+	storage, _, _, _ := newOffline(t)
+
+	hashPool := hashpool.New(2, 64)
+	t.Cleanup(hashPool.Close)
+
+	notifier := &recordingNotifier{}
+	authService := auth.New(slog.New(slog.NewTextHandler(io.Discard, nil)),
+		storage, storage, storage, slowAuditLog{storage, 100 * time.Millisecond}, time.Hour,
+		testIssuer, signingKey(t), true, notifier, hashPool)
+
+	email, pass := gofakeit.Email(), randomFakePassword()
+	id, err := authService.RegisterNewUser(ctx, email, pass, "owner")
+	require.NoError(t, err)
+	assert.Zero(t, id, "the ID would tell new users from existing ones")
+
+	owner, err := authService.Authenticate(ctx, email, pass, "")
+	require.NoError(t, err)
+
+	newUser, takenEmail := compareTimings(
+		func() {
+			id, err := authService.RegisterNewUser(ctx, gofakeit.Email(), pass, gofakeit.Username())
+			assert.NoError(t, err)
+			assert.Zero(t, id)
+		},
+		func() {
+			id, err := authService.RegisterNewUser(ctx, email, pass, gofakeit.Username())
+			assert.NoError(t, err)
+			assert.Zero(t, id)
+		},
+	)
+	assertSimilar(t, newUser, takenEmail)
+
+	// Taking both the email and the username notifies the owner once.
+	_, err = authService.RegisterNewUser(ctx, email, pass, "OWNER")
+	require.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		return len(notifier.notified()) == timingRounds+1
+	}, 5*time.Second, 10*time.Millisecond)
+	for _, n := range notifier.notified() {
+		assert.Equal(t, owner.ID, n.userID)
+		assert.Equal(t, "email", n.field)
+	}
+
+	_, err = authService.Authenticate(ctx, email, pass, "")
+	require.NoError(t, err, "the owner's password must not change")
+}
+
+// compareTimings runs a and b timingRounds times each, alternately, and
+// returns the median duration of each.
+func compareTimings(a, b func()) (time.Duration, time.Duration) {
+	var as, bs []time.Duration
+	for range timingRounds {
+		start := time.Now()
+		a()
+		as = append(as, time.Since(start))
+
+		start = time.Now()
+		b()
+		bs = append(bs, time.Since(start))
+	}
+
+	slices.Sort(as)
+	slices.Sort(bs)
+
+	return as[timingRounds/2], bs[timingRounds/2]
+}
+
+// assertSimilar checks that a and b differ by less than a quarter of the
+// longer one, which is well within bcrypt's cost of roughly 50ms but far
+// less than the difference made by skipping it.
+func assertSimilar(t *testing.T, a, b time.Duration) {
+	t.Helper()
+
+	diff := (a - b).Abs()
+	assert.Less(t, diff, max(a, b)/4, "medians %s and %s are distinguishable", a, b)
+}
+
+// slowAuditLog is an audit log that takes delay to save an event.
+type slowAuditLog struct {
+	auth.AuditLog
+	delay time.Duration
+}
+
+func (l slowAuditLog) SaveAuditEvent(ctx context.Context, event models.AuditEvent) error {
+	time.Sleep(l.delay)
+
+	return l.AuditLog.SaveAuditEvent(ctx, event)
+}
+
+type notification struct {
+	userID int64
+	field  string
+}
+
+type recordingNotifier struct {
+	mu  sync.Mutex
+	log []notification
+}
+
+func (n *recordingNotifier) RegistrationAttempted(_ context.Context, user models.User,
+	field string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.log = append(n.log, notification{userID: user.ID, field: field})
+
+	return nil
+}
+
+func (n *recordingNotifier) notified() []notification {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return slices.Clone(n.log)
+}
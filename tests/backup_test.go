@@ -0,0 +1,257 @@
+package tests
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"xauth/internal/domain/models"
+	admingrpc "xauth/internal/grpc/admin"
+	"xauth/internal/lib/archive"
+	"xauth/internal/services/admin"
+	"xauth/internal/services/backup"
+	"xauth/internal/storage"
+	"xauth/internal/storage/sqlite"
+
+	"github.com/brianvoe/gofakeit/v6"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// TestBackup_RoundTrip backs up a database in every format, writes to it
+// afterwards, and checks that restoring the backup undoes the write.
+func TestBackup_RoundTrip(t *testing.T) {
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		name     string
+		compress bool
+		encrypt  bool
+	}{
+		{"plain", false, false},
+		{"gzip", true, false},
+		{"encrypted", false, true},
+		{"gzip encrypted", true, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var key []byte
+			if tc.encrypt {
+				key = newBackupKey(t)
+			}
+
+			path := newDB(t)
+			db := openBackupDB(t, path)
+
+			before := gofakeit.Email()
+			_, err := db.SaveUser(ctx, before, []byte("hash"), gofakeit.Username())
+			require.NoError(t, err)
+
+			b, err := backup.New(discardLog(), db, t.TempDir(), tc.compress, key, 0).Create(ctx)
+			require.NoError(t, err)
+			assert.Positive(t, b.Size)
+
+			after := gofakeit.Email()
+			_, err = db.SaveUser(ctx, after, []byte("hash"), gofakeit.Username())
+			require.NoError(t, err)
+			require.NoError(t, db.Close())
+
+			restored, err := backup.Restore(ctx, b.Path, path, key, "migrations")
+			require.NoError(t, err)
+			assert.Equal(t, restored.Latest, restored.SchemaVersion)
+			assert.FileExists(t, restored.Previous)
+
+			// Checking the backup must leave it as it was.
+			if !tc.compress && !tc.encrypt {
+				want, err := os.ReadFile(b.Path)
+				require.NoError(t, err)
+				got, err := os.ReadFile(path)
+				require.NoError(t, err)
+				assert.Equal(t, want, got, "database changed")
+			}
+
+			entries, err := os.ReadDir(filepath.Dir(path))
+			require.NoError(t, err)
+			for _, e := range entries {
+				assert.NotContains(t, e.Name(), ".restore-", "files left behind")
+			}
+
+			db = openBackupDB(t, path)
+
+			_, err = db.UserByEmail(ctx, before)
+			assert.NoError(t, err)
+			_, err = db.UserByEmail(ctx, after)
+			assert.ErrorIs(t, err, storage.ErrUserNotFound)
+		})
+	}
+}
+
+// TestBackup_RestoreRejected checks that backups that can't be read or
+// have an unusable schema leave the database in place.
+func TestBackup_RestoreRejected(t *testing.T) {
+	ctx := context.Background()
+	key := newBackupKey(t)
+
+	encrypted := newBackupFile(t, key)
+
+	// Cut after the header and the first chunk, as an interrupted copy
+	// would if the file was written in chunks.
+	const firstChunkEnd = 16 + 64<<10 + 16
+
+	truncated := filepath.Join(t.TempDir(), "truncated.db.enc")
+	data, err := os.ReadFile(encrypted)
+	require.NoError(t, err)
+	require.Greater(t, len(data), firstChunkEnd)
+	require.NoError(t, os.WriteFile(truncated, data[:firstChunkEnd], 0o600))
+
+	notDB := filepath.Join(t.TempDir(), "notes.txt")
+	require.NoError(t, os.WriteFile(notDB, []byte("not a database"), 0o600))
+
+	unmigrated := filepath.Join(t.TempDir(), "empty.db")
+	execSQL(t, unmigrated, "CREATE TABLE t (id INTEGER)")
+
+	dirty := newBackupFile(t, nil)
+	execSQL(t, dirty, "UPDATE migrations SET dirty = 1")
+
+	tooNew := newBackupFile(t, nil)
+	execSQL(t, tooNew, "UPDATE migrations SET version = 9999")
+
+	for _, tc := range []struct {
+		name string
+		path string
+		key  []byte
+		err  error
+	}{
+		{"no key", encrypted, nil, archive.ErrKeyRequired},
+		{"wrong key", encrypted, newBackupKey(t), archive.ErrDecrypt},
+		{"truncated", truncated, key, archive.ErrTruncated},
+		{"not a backup", notDB, nil, archive.ErrUnknownFormat},
+		{"no migrations", unmigrated, nil, backup.ErrNoSchema},
+		{"dirty", dirty, nil, backup.ErrSchemaDirty},
+		{"too new", tooNew, nil, backup.ErrSchemaTooNew},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			path := newDB(t)
+			want, err := os.ReadFile(path)
+			require.NoError(t, err)
+			files, err := os.ReadDir(filepath.Dir(path))
+			require.NoError(t, err)
+
+			_, err = backup.Restore(ctx, tc.path, path, tc.key, "migrations")
+			assert.ErrorIs(t, err, tc.err)
+
+			got, err := os.ReadFile(path)
+			require.NoError(t, err)
+			assert.Equal(t, want, got, "database changed")
+
+			entries, err := os.ReadDir(filepath.Dir(path))
+			require.NoError(t, err)
+			assert.Len(t, entries, len(files), "files left behind")
+		})
+	}
+}
+
+// TestBackup_Retention checks that only the newest backups are kept and
+// that other files in the directory are left alone.
+func TestBackup_Retention(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	other := filepath.Join(dir, "notes.txt")
+	require.NoError(t, os.WriteFile(other, nil, 0o600))
+
+	backups := backup.New(discardLog(), openBackupDB(t, newDB(t)), dir, true, nil, 2)
+
+	var paths []string
+	for range 3 {
+		b, err := backups.Create(ctx)
+		require.NoError(t, err)
+
+		paths = append(paths, b.Path)
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	assert.NoFileExists(t, paths[0])
+	assert.FileExists(t, paths[1])
+	assert.FileExists(t, paths[2])
+	assert.FileExists(t, other)
+}
+
+// TestAdmin_CreateBackup calls the CreateBackup RPC in-process and checks
+// that it's audited, and that it fails where backups aren't configured.
+func TestAdmin_CreateBackup(t *testing.T) {
+	ctx := context.Background()
+
+	db, _, _, disabled := newOffline(t)
+
+	_, err := disabled.CreateBackup(ctx, &admingrpc.CreateBackupRequest{})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	log := discardLog()
+	api := admingrpc.NewServerAPI(admin.New(log, db,
+		backup.New(log, db, t.TempDir(), true, nil, 0)))
+
+	resp, err := api.CreateBackup(ctx, &admingrpc.CreateBackupRequest{})
+	require.NoError(t, err)
+	assert.FileExists(t, resp.Backup.Path)
+
+	events, err := db.AuditEvents(ctx, models.AuditFilter{Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, models.AuditBackupCreated, events[0].Action)
+	assert.Equal(t, filepath.Base(resp.Backup.Path), events[0].Details["file"])
+}
+
+func openBackupDB(t *testing.T, path string) *sqlite.Storage {
+	t.Helper()
+
+	db, err := sqlite.New(path, sqlite.Options{JournalMode: "wal"})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+
+	return db
+}
+
+// newBackupFile returns a backup of a fresh database, encrypted with key
+// if it isn't nil.
+func newBackupFile(t *testing.T, key []byte) string {
+	t.Helper()
+
+	b, err := backup.New(discardLog(), openBackupDB(t, newDB(t)), t.TempDir(), false,
+		key, 0).Create(context.Background())
+	require.NoError(t, err)
+
+	return b.Path
+}
+
+func newBackupKey(t *testing.T) []byte {
+	t.Helper()
+
+	key := make([]byte, archive.KeySize)
+	_, err := rand.Read(key)
+	require.NoError(t, err)
+
+	return key
+}
+
+func execSQL(t *testing.T, path, query string) {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", path)
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, err = db.Exec(query)
+	require.NoError(t, err)
+}
+
+func discardLog() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
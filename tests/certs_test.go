@@ -0,0 +1,124 @@
+package tests
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"xauth/internal/lib/certs"
+	"xauth/internal/lib/logger/handlers/slogdiscard"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestReloader_PicksUpNewCertificate checks that a certificate replaced on
+// disk is served to new handshakes without a restart.
+func TestReloader_PicksUpNewCertificate(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "server.crt")
+	keyFile := filepath.Join(dir, "server.key")
+
+	writeSelfSigned(t, certFile, keyFile, "first")
+
+	r, err := certs.NewReloader(slogdiscard.NewDiscardLogger(), certFile, keyFile, "")
+	require.NoError(t, err)
+	assert.False(t, r.MutualTLS())
+	assert.Equal(t, "first", servedCommonName(t, r))
+
+	writeSelfSigned(t, certFile, keyFile, "second")
+	// Make sure the modification time differs even on coarse filesystems.
+	later := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(certFile, later, later))
+
+	done := make(chan struct{})
+	defer close(done)
+
+	go r.Watch(10*time.Millisecond, done)
+
+	assert.Eventually(t, func() bool {
+		return servedCommonName(t, r) == "second"
+	}, time.Second, 10*time.Millisecond)
+}
+
+// TestReloader_KeepsOldCertificateOnError checks that a broken file on
+// disk doesn't replace a working certificate.
+func TestReloader_KeepsOldCertificateOnError(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "server.crt")
+	keyFile := filepath.Join(dir, "server.key")
+
+	writeSelfSigned(t, certFile, keyFile, "first")
+
+	r, err := certs.NewReloader(slogdiscard.NewDiscardLogger(), certFile, keyFile, "")
+	require.NoError(t, err)
+
+	require.NoError(t, os.WriteFile(certFile, []byte("garbage"), 0o600))
+	require.Error(t, r.Reload())
+
+	assert.Equal(t, "first", servedCommonName(t, r))
+}
+
+// TestIdentities checks which certificate fields identify a client.
+func TestIdentities(t *testing.T) {
+	uri, err := url.Parse("spiffe://example.org/billing")
+	require.NoError(t, err)
+
+	cert := &x509.Certificate{
+		Subject:  pkix.Name{CommonName: "billing"},
+		DNSNames: []string{"billing.internal"},
+		URIs:     []*url.URL{uri},
+	}
+
+	assert.Equal(t,
+		[]string{"billing", "billing.internal", "spiffe://example.org/billing"},
+		certs.Identities(cert))
+}
+
+func servedCommonName(t *testing.T, r *certs.Reloader) string {
+	t.Helper()
+
+	cfg, err := r.TLSConfig().GetConfigForClient(nil)
+	require.NoError(t, err)
+	require.Len(t, cfg.Certificates, 1)
+
+	leaf, err := x509.ParseCertificate(cfg.Certificates[0].Certificate[0])
+	require.NoError(t, err)
+
+	return leaf.Subject.CommonName
+}
+
+func writeSelfSigned(t *testing.T, certFile, keyFile, commonName string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"localhost"},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	require.NoError(t, os.WriteFile(certFile,
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile,
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+}
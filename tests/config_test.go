@@ -0,0 +1,134 @@
+package tests
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"xauth/internal/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const minimalConfig = `
+env: "local"
+storage_path: "./storage/sso.db"
+token_ttl: 1h
+grpc:
+  port: 50051
+  timeout: 5s
+health:
+  schema_version: 1
+oidc:
+  upstreams:
+    corp-idp:
+      issuer: "https://idp.example.com"
+      client_id: "xauth"
+`
+
+// TestConfigLoad_EnvAndSecretFiles checks that environment variables
+// override the file and that secrets are read from their *_file variants.
+func TestConfigLoad_EnvAndSecretFiles(t *testing.T) {
+	dir := t.TempDir()
+	path := writeFile(t, dir, "config.yaml", minimalConfig)
+	dsn := writeFile(t, dir, "dsn", "/var/lib/xauth/sso.db\n")
+	secret := writeFile(t, dir, "client_secret", "s3cret\n")
+
+	t.Setenv("XAUTH_STORAGE_PATH", "")
+	t.Setenv("XAUTH_STORAGE_PATH_FILE", dsn)
+	t.Setenv("XAUTH_GRPC_PORT", "6000")
+	t.Setenv("XAUTH_HTTP_READ_TIMEOUT", "3s")
+	t.Setenv("XAUTH_OIDC_UPSTREAMS_CORP_IDP_CLIENT_SECRET_FILE", secret)
+
+	cfg, err := config.Load(path)
+	require.NoError(t, err)
+
+	assert.Equal(t, config.Secret("/var/lib/xauth/sso.db"), cfg.StoragePath)
+	assert.Equal(t, 6000, cfg.GRPC.Port)
+	assert.Equal(t, 3*time.Second, cfg.HTTP.ReadTimeout)
+	assert.Equal(t, config.Secret("s3cret"), cfg.OIDC.Upstreams["corp-idp"].ClientSecret)
+
+	// Secrets must not leak into logs of the whole config.
+	b, err := json.Marshal(cfg)
+	require.NoError(t, err)
+	assert.NotContains(t, string(b), "s3cret")
+	assert.NotContains(t, string(b), "/var/lib/xauth")
+}
+
+// TestConfigLoad_SecretAndFile checks that setting a secret both directly
+// and through a file is rejected.
+func TestConfigLoad_SecretAndFile(t *testing.T) {
+	dir := t.TempDir()
+	path := writeFile(t, dir, "config.yaml", minimalConfig)
+
+	t.Setenv("XAUTH_STORAGE_PATH_FILE", writeFile(t, dir, "dsn", "sso.db"))
+
+	_, err := config.Load(path)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "set either storage_path or storage_path_file")
+}
+
+// TestConfigValidate_Aggregated checks that all validation errors are
+// reported together.
+func TestConfigValidate_Aggregated(t *testing.T) {
+	dir := t.TempDir()
+	path := writeFile(t, dir, "config.yaml", minimalConfig)
+
+	t.Setenv("XAUTH_ENV", "staging")
+	t.Setenv("XAUTH_GRPC_PORT", "70000")
+	t.Setenv("XAUTH_GRPC_TIMEOUT", "-1s")
+	t.Setenv("XAUTH_API_KEYS_MAX_TTL", "1h")
+	t.Setenv("XAUTH_OIDC_UPSTREAMS_CORP_IDP_ISSUER", "idp.example.com")
+	t.Setenv("XAUTH_BACKUP_KEEP", "-1")
+	t.Setenv("XAUTH_BACKUP_ENCRYPTION_KEY", "c2hvcnQ=")
+
+	_, err := config.Load(path)
+	require.Error(t, err)
+
+	for _, msg := range []string{
+		`env: must be one of [local dev prod], got "staging"`,
+		"grpc.port: must be between 1 and 65535, got 70000",
+		"grpc.timeout: must be positive, got -1s",
+		"api_keys.max_ttl: must not be less than api_keys.default_ttl",
+		`oidc.upstreams.corp-idp.issuer: must be an absolute http(s) URL, got "idp.example.com"`,
+		"backup.keep: must not be negative, got -1",
+		"backup.encryption_key: must be 32 bytes, base64-encoded",
+	} {
+		assert.Contains(t, err.Error(), msg)
+	}
+}
+
+// TestConfigDiff checks that changed fields are reported by their YAML
+// paths and classified as reloadable or not.
+func TestConfigDiff(t *testing.T) {
+	path := writeFile(t, t.TempDir(), "config.yaml", minimalConfig)
+
+	old, err := config.Load(path)
+	require.NoError(t, err)
+
+	cfg, err := config.Load(path)
+	require.NoError(t, err)
+	assert.Empty(t, config.Diff(old, cfg))
+
+	cfg.TokenTTL = 2 * time.Hour
+	cfg.GRPC.Port = 6000
+	cfg.OIDC.Upstreams["corp-idp"] = config.UpstreamConfig{Issuer: "https://other.example.com"}
+
+	changed := config.Diff(old, cfg)
+	assert.Equal(t, []string{"token_ttl", "grpc.port", "oidc.upstreams"}, changed)
+
+	assert.True(t, config.Reloadable("token_ttl"))
+	assert.False(t, config.Reloadable("grpc.port"))
+}
+
+func writeFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+
+	path := filepath.Join(dir, name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+
+	return path
+}
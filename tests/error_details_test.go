@@ -0,0 +1,190 @@
+package tests
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	admingrpc "xauth/internal/grpc/admin"
+	"xauth/tests/suite"
+
+	"github.com/brianvoe/gofakeit/v6"
+	ssov1 "github.com/memxire/protobuf/gen/go/sso"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestErrorDetails(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email := gofakeit.Email()
+	pass := randomFakePassword()
+	username := gofakeit.Username()
+
+	_, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+		Email:    email,
+		Password: pass,
+		Username: username,
+	})
+	require.NoError(t, err)
+
+	t.Run("Field Violations", func(t *testing.T) {
+		_, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{})
+
+		s := status.Convert(err)
+		require.Equal(t, codes.InvalidArgument, s.Code())
+
+		var fields []string
+		for _, d := range s.Details() {
+			if br, ok := d.(*errdetails.BadRequest); ok {
+				for _, fv := range br.GetFieldViolations() {
+					fields = append(fields, fv.GetField())
+				}
+			}
+		}
+		assert.Equal(t, []string{"email", "username", "password"}, fields)
+	})
+
+	tests := []struct {
+		name           string
+		call           func() error
+		expectedCode   codes.Code
+		expectedReason string
+	}{
+		{
+			name: "User Exists",
+			call: func() error {
+				_, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+					Email:    email,
+					Password: pass,
+					Username: username,
+				})
+				return err
+			},
+			expectedCode:   codes.AlreadyExists,
+			expectedReason: "USER_EXISTS",
+		},
+		{
+			name: "Wrong Password",
+			call: func() error {
+				_, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{
+					Email:    email,
+					Password: randomFakePassword(),
+					AppId:    appID,
+					Username: username,
+				})
+				return err
+			},
+			expectedCode:   codes.InvalidArgument,
+			expectedReason: "INVALID_CREDENTIALS",
+		},
+		{
+			name: "Unknown App",
+			call: func() error {
+				_, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{
+					Email:    email,
+					Password: pass,
+					AppId:    notExists,
+					Username: username,
+				})
+				return err
+			},
+			expectedCode:   codes.InvalidArgument,
+			expectedReason: "INVALID_APP_ID",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := status.Convert(tt.call())
+			require.Equal(t, tt.expectedCode, s.Code())
+
+			var reason string
+			for _, d := range s.Details() {
+				if info, ok := d.(*errdetails.ErrorInfo); ok {
+					assert.Equal(t, "xauth", info.GetDomain())
+					reason = info.GetReason()
+				}
+			}
+			assert.Equal(t, tt.expectedReason, reason)
+		})
+	}
+
+	t.Run("HTTP Gateway", func(t *testing.T) {
+		var body struct {
+			Error struct {
+				Status          string `json:"status"`
+				FieldViolations []struct {
+					Field string `json:"field"`
+				} `json:"field_violations"`
+			} `json:"error"`
+		}
+		code := doJSON(ctx, t, http.MethodPost, st.HTTPURL+"/v1/auth/login",
+			map[string]any{"email": email}, &body)
+		require.Equal(t, http.StatusBadRequest, code)
+		assert.Equal(t, "INVALID_ARGUMENT", body.Error.Status)
+		require.Len(t, body.Error.FieldViolations, 2)
+		assert.Equal(t, "password", body.Error.FieldViolations[0].Field)
+		assert.Equal(t, "app_id", body.Error.FieldViolations[1].Field)
+	})
+}
+
+// TestErrorDetails_Admin checks that the Admin service reports errors with
+// the same details as the Auth service.
+func TestErrorDetails_Admin(t *testing.T) {
+	ctx := context.Background()
+	_, _, _, adminAPI := newOffline(t)
+
+	_, err := adminAPI.CreateApp(ctx, &admingrpc.CreateAppRequest{Name: "app"})
+	require.NoError(t, err)
+
+	_, err = adminAPI.CreateApp(ctx, &admingrpc.CreateAppRequest{})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.Equal(t, []string{"name"}, fieldViolations(err))
+
+	_, err = adminAPI.CreateApp(ctx, &admingrpc.CreateAppRequest{Name: "app"})
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+	assert.Equal(t, admingrpc.ReasonAppExists, errorReason(t, err))
+
+	_, err = adminAPI.GetUser(ctx, &admingrpc.GetUserRequest{UserID: notExists})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	assert.Equal(t, admingrpc.ReasonUserNotFound, errorReason(t, err))
+
+	_, err = adminAPI.ListAuditEvents(ctx, &admingrpc.ListAuditEventsRequest{
+		AfterID: -1,
+		Limit:   -1,
+	})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.Equal(t, []string{"after_id", "limit"}, fieldViolations(err))
+}
+
+// errorReason returns the reason of the google.rpc.ErrorInfo of err.
+func errorReason(t *testing.T, err error) string {
+	t.Helper()
+
+	for _, d := range status.Convert(err).Details() {
+		if info, ok := d.(*errdetails.ErrorInfo); ok {
+			assert.Equal(t, "xauth", info.GetDomain())
+
+			return info.GetReason()
+		}
+	}
+
+	return ""
+}
+
+// fieldViolations returns the fields of the google.rpc.BadRequest of err.
+func fieldViolations(err error) []string {
+	var fields []string
+	for _, d := range status.Convert(err).Details() {
+		if br, ok := d.(*errdetails.BadRequest); ok {
+			for _, fv := range br.GetFieldViolations() {
+				fields = append(fields, fv.GetField())
+			}
+		}
+	}
+
+	return fields
+}
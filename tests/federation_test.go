@@ -0,0 +1,330 @@
+package tests
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+	"xauth/internal/lib/jwk"
+	"xauth/internal/lib/pkce"
+	"xauth/tests/suite"
+
+	"github.com/brianvoe/gofakeit/v6"
+	"github.com/golang-jwt/jwt/v5"
+	ssov1 "github.com/memxire/protobuf/gen/go/sso"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const stubProvider = "stub"
+
+func TestFederation(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	upstream := st.Cfg.OIDC.Upstreams[stubProvider]
+	idp := startStubIdP(t, upstream.Issuer, upstream.ClientID, string(upstream.ClientSecret))
+
+	t.Run("Signup And Repeated Login", func(t *testing.T) {
+		identity := stubIdentity{
+			Subject:       gofakeit.UUID(),
+			Email:         gofakeit.Email(),
+			EmailVerified: true,
+			Username:      gofakeit.Username(),
+		}
+
+		claims := federatedLogin(t, st, idp, identity)
+		assert.Equal(t, identity.Email, claims["email"])
+		assert.Equal(t, identity.Username, claims["username"])
+
+		// The same upstream subject maps to the same local user, even if
+		// its email changed upstream.
+		identity.Email = gofakeit.Email()
+		again := federatedLogin(t, st, idp, identity)
+		assert.Equal(t, claims["uid"], again["uid"])
+	})
+
+	t.Run("Unverified Email", func(t *testing.T) {
+		loc := federatedLoginRedirect(t, st, idp, stubIdentity{
+			Subject: gofakeit.UUID(),
+			Email:   gofakeit.Email(),
+		})
+		assert.Equal(t, "access_denied", loc.Query().Get("error"))
+	})
+
+	t.Run("Existing Local User", func(t *testing.T) {
+		email := gofakeit.Email()
+
+		_, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+			Email:    email,
+			Password: randomFakePassword(),
+			Username: gofakeit.Username(),
+		})
+		require.NoError(t, err)
+
+		loc := federatedLoginRedirect(t, st, idp, stubIdentity{
+			Subject:       gofakeit.UUID(),
+			Email:         email,
+			EmailVerified: true,
+		})
+		assert.Equal(t, "access_denied", loc.Query().Get("error"))
+	})
+
+	t.Run("Denied Upstream", func(t *testing.T) {
+		loc := federatedLoginRedirect(t, st, idp, stubIdentity{Deny: true})
+		assert.Equal(t, "access_denied", loc.Query().Get("error"))
+	})
+
+	t.Run("Callback Without State Cookie", func(t *testing.T) {
+		params := authorizeParams(pkce.ChallengeS256(randomVerifier()))
+		upstreamURL, _ := startFederatedLogin(t, st, params)
+
+		callback := idp.approve(t, upstreamURL, stubIdentity{Subject: gofakeit.UUID()})
+
+		resp, err := noRedirectClient.Get(callback.String())
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+}
+
+// federatedLogin signs identity in through the stub provider and returns
+// the claims of the access token the app gets in the end.
+func federatedLogin(t *testing.T, st *suite.Suite, idp *stubIdP, identity stubIdentity) jwt.MapClaims {
+	t.Helper()
+
+	verifier := randomVerifier()
+	params := authorizeParams(pkce.ChallengeS256(verifier))
+
+	loc := federatedLoginWith(t, st, idp, params, identity)
+	require.Empty(t, loc.Query().Get("error"), loc.Query().Get("error_description"))
+	require.Equal(t, params.Get("state"), loc.Query().Get("state"))
+
+	token := exchangeCode(t, st.HTTPURL, loc.Query().Get("code"), verifier)
+	require.Equal(t, http.StatusOK, token.status)
+
+	return tokenClaims(t, st, token.AccessToken)
+}
+
+// federatedLoginRedirect returns where the app's redirect URI is called
+// with after signing identity in through the stub provider.
+func federatedLoginRedirect(t *testing.T, st *suite.Suite, idp *stubIdP, identity stubIdentity) *url.URL {
+	t.Helper()
+
+	return federatedLoginWith(t, st, idp,
+		authorizeParams(pkce.ChallengeS256(randomVerifier())), identity)
+}
+
+func federatedLoginWith(
+	t *testing.T,
+	st *suite.Suite,
+	idp *stubIdP,
+	params url.Values,
+	identity stubIdentity,
+) *url.URL {
+	t.Helper()
+
+	upstreamURL, cookie := startFederatedLogin(t, st, params)
+	callback := idp.approve(t, upstreamURL, identity)
+
+	req, err := http.NewRequest(http.MethodGet, callback.String(), nil)
+	require.NoError(t, err)
+	req.AddCookie(cookie)
+
+	resp, err := noRedirectClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusFound, resp.StatusCode)
+
+	loc, err := url.Parse(resp.Header.Get("Location"))
+	require.NoError(t, err)
+	require.Equal(t, redirectURI, loc.Scheme+"://"+loc.Host+loc.Path)
+
+	return loc
+}
+
+// startFederatedLogin returns the upstream URL the user agent is sent to
+// and the cookie binding the login to it.
+func startFederatedLogin(t *testing.T, st *suite.Suite, params url.Values) (*url.URL, *http.Cookie) {
+	t.Helper()
+
+	resp, err := noRedirectClient.Get(st.HTTPURL + "/federation/" + stubProvider +
+		"/login?" + params.Encode())
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusFound, resp.StatusCode)
+
+	upstreamURL, err := url.Parse(resp.Header.Get("Location"))
+	require.NoError(t, err)
+
+	cookies := resp.Cookies()
+	require.Len(t, cookies, 1)
+
+	return upstreamURL, cookies[0]
+}
+
+type stubIdentity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Username      string
+	// Deny makes the provider redirect back with access_denied.
+	Deny bool
+}
+
+type stubCode struct {
+	identity      stubIdentity
+	nonce         string
+	codeChallenge string
+	redirectURI   string
+}
+
+// stubIdP is a minimal OpenID Connect provider that signs in whoever the
+// test tells it to, without user interaction.
+type stubIdP struct {
+	issuer       string
+	clientID     string
+	clientSecret string
+	key          *jwk.Key
+
+	mu    sync.Mutex
+	codes map[string]stubCode
+}
+
+func startStubIdP(t *testing.T, issuer, clientID, clientSecret string) *stubIdP {
+	t.Helper()
+
+	key, err := jwk.Generate()
+	require.NoError(t, err)
+
+	idp := &stubIdP{
+		issuer:       issuer,
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		key:          key,
+		codes:        make(map[string]stubCode),
+	}
+
+	u, err := url.Parse(issuer)
+	require.NoError(t, err)
+
+	l, err := net.Listen("tcp", u.Host)
+	require.NoError(t, err)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", idp.discovery)
+	mux.HandleFunc("GET /jwks", idp.jwks)
+	mux.HandleFunc("POST /token", idp.token)
+
+	srv := httptest.NewUnstartedServer(mux)
+	srv.Listener = l
+	srv.Start()
+	t.Cleanup(srv.Close)
+
+	return idp
+}
+
+// approve plays the provider's authorization endpoint: it checks the
+// request and returns the callback URL the user agent would be sent to.
+func (idp *stubIdP) approve(t *testing.T, authURL *url.URL, identity stubIdentity) *url.URL {
+	t.Helper()
+
+	q := authURL.Query()
+	require.Equal(t, idp.issuer+"/authorize", authURL.Scheme+"://"+authURL.Host+authURL.Path)
+	require.Equal(t, idp.clientID, q.Get("client_id"))
+	require.Equal(t, "code", q.Get("response_type"))
+	require.Equal(t, pkce.MethodS256, q.Get("code_challenge_method"))
+
+	callback, err := url.Parse(q.Get("redirect_uri"))
+	require.NoError(t, err)
+
+	res := url.Values{"state": {q.Get("state")}}
+
+	if identity.Deny {
+		res.Set("error", "access_denied")
+	} else {
+		code := gofakeit.LetterN(32)
+
+		idp.mu.Lock()
+		idp.codes[code] = stubCode{
+			identity:      identity,
+			nonce:         q.Get("nonce"),
+			codeChallenge: q.Get("code_challenge"),
+			redirectURI:   q.Get("redirect_uri"),
+		}
+		idp.mu.Unlock()
+
+		res.Set("code", code)
+	}
+
+	callback.RawQuery = res.Encode()
+
+	return callback
+}
+
+func (idp *stubIdP) discovery(w http.ResponseWriter, _ *http.Request) {
+	_ = json.NewEncoder(w).Encode(map[string]string{
+		"issuer":                 idp.issuer,
+		"authorization_endpoint": idp.issuer + "/authorize",
+		"token_endpoint":         idp.issuer + "/token",
+		"jwks_uri":               idp.issuer + "/jwks",
+	})
+}
+
+func (idp *stubIdP) jwks(w http.ResponseWriter, _ *http.Request) {
+	_ = json.NewEncoder(w).Encode(jwk.Set{Keys: []jwk.JWK{idp.key.Public()}})
+}
+
+func (idp *stubIdP) token(w http.ResponseWriter, r *http.Request) {
+	clientID, clientSecret, ok := r.BasicAuth()
+	if !ok || clientID != idp.clientID || clientSecret != idp.clientSecret {
+		http.Error(w, `{"error":"invalid_client"}`, http.StatusUnauthorized)
+
+		return
+	}
+
+	idp.mu.Lock()
+	code, ok := idp.codes[r.PostFormValue("code")]
+	delete(idp.codes, r.PostFormValue("code"))
+	idp.mu.Unlock()
+
+	if !ok || code.redirectURI != r.PostFormValue("redirect_uri") ||
+		!pkce.Verify(code.codeChallenge, pkce.MethodS256, r.PostFormValue("code_verifier")) {
+		http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+
+		return
+	}
+
+	now := time.Now()
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":                idp.issuer,
+		"sub":                code.identity.Subject,
+		"aud":                idp.clientID,
+		"iat":                now.Unix(),
+		"exp":                now.Add(time.Minute).Unix(),
+		"nonce":              code.nonce,
+		"email":              code.identity.Email,
+		"email_verified":     code.identity.EmailVerified,
+		"preferred_username": code.identity.Username,
+	})
+	token.Header["kid"] = idp.key.ID
+
+	idToken, err := token.SignedString(idp.key.Private)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"access_token": gofakeit.LetterN(32),
+		"token_type":   "Bearer",
+		"id_token":     idToken,
+		"expires_in":   60,
+	})
+}
@@ -1,6 +1,8 @@
 package tests
 
 import (
+	"context"
+	"net/http"
 	"testing"
 	"xauth/tests/suite"
 
@@ -8,6 +10,9 @@ import (
 	ssov1 "github.com/memxire/protobuf/gen/go/sso"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 )
 
 const (
@@ -19,36 +24,118 @@ const (
 func TestGetUserByID_HappyPath(t *testing.T) {
 	ctx, st := suite.New(t)
 
-	respReg, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{
-		Email:    gofakeit.Email(),
-		Password: randomFakePassword(),
-		Username: gofakeit.Username(),
-	})
-	require.NoError(t, err)
-	userID := respReg.GetUserId()
+	userID, token := registerAndLogin(t, ctx, st, gofakeit.Username())
 	require.NotEmpty(t, userID)
 
-	respGet, err := st.AuthClient.GetUser(ctx, &ssov1.GetUserRequest{
+	respGet, err := st.AuthClient.GetUser(withBearer(ctx, token), &ssov1.GetUserRequest{
 		UserId: userID,
 	})
 	require.NoError(t, err)
 	assert.Equal(t, userID, respGet.GetUserId())
 	assert.NotEmpty(t, respGet.GetEmail())
 	assert.NotEmpty(t, respGet.GetUsername())
+
+	respIsAdmin, err := st.AuthClient.IsAdmin(withBearer(ctx, token), &ssov1.IsAdminRequest{
+		UserId: userID,
+	})
+	require.NoError(t, err)
+	assert.False(t, respIsAdmin.GetIsAdmin())
 }
 
 func TestGetUserByID_FailCases(t *testing.T) {
 	ctx, st := suite.New(t)
 
-	respGet, err := st.AuthClient.GetUser(ctx, &ssov1.GetUserRequest{
+	_, token := registerAndLogin(t, ctx, st, gofakeit.Username())
+
+	respGet, err := st.AuthClient.GetUser(withBearer(ctx, token), &ssov1.GetUserRequest{
 		UserId: emptyValue,
 	})
 	require.Error(t, err)
 	assert.Empty(t, respGet.GetUserId())
 
-	respGet, err = st.AuthClient.GetUser(ctx, &ssov1.GetUserRequest{
+	respGet, err = st.AuthClient.GetUser(withBearer(ctx, token), &ssov1.GetUserRequest{
 		UserId: notExists,
 	})
 	require.Error(t, err)
 	assert.Empty(t, respGet.GetUserId())
 }
+
+func TestGetUserByID_Authorization(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	userID, token := registerAndLogin(t, ctx, st, gofakeit.Username())
+	otherID, _ := registerAndLogin(t, ctx, st, gofakeit.Username())
+
+	// API keys can only do what their scopes allow.
+	newKey := func(scopes ...string) string {
+		var key apiKeyResult
+		resp := apiKeysRequest(t, st, http.MethodPost, "", token,
+			map[string]any{"name": gofakeit.LetterN(8), "scopes": scopes}, &key)
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+		return key.Key
+	}
+
+	tests := []struct {
+		name         string
+		token        string
+		userID       int64
+		expectedCode codes.Code
+	}{
+		{
+			name:         "No Token",
+			userID:       userID,
+			expectedCode: codes.Unauthenticated,
+		},
+		{
+			name:         "Invalid Token",
+			token:        "not-a-jwt",
+			userID:       userID,
+			expectedCode: codes.Unauthenticated,
+		},
+		{
+			name:         "Other User",
+			token:        token,
+			userID:       otherID,
+			expectedCode: codes.PermissionDenied,
+		},
+		{
+			name:         "API Key With Scope",
+			token:        newKey("users:read"),
+			userID:       userID,
+			expectedCode: codes.OK,
+		},
+		{
+			name:         "API Key Without Scope",
+			token:        newKey("users:write"),
+			userID:       userID,
+			expectedCode: codes.PermissionDenied,
+		},
+		{
+			name:         "Client Token",
+			token:        clientCredentialsToken(t, st.HTTPURL),
+			userID:       userID,
+			expectedCode: codes.PermissionDenied,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := ctx
+			if tt.token != "" {
+				ctx = withBearer(ctx, tt.token)
+			}
+
+			_, err := st.AuthClient.GetUser(ctx, &ssov1.GetUserRequest{UserId: tt.userID})
+			assert.Equal(t, tt.expectedCode, status.Code(err))
+
+			_, err = st.AuthClient.IsAdmin(ctx, &ssov1.IsAdminRequest{UserId: tt.userID})
+			assert.Equal(t, tt.expectedCode, status.Code(err))
+		})
+	}
+}
+
+// withBearer makes calls made with ctx carry token as the bearer token.
+func withBearer(ctx context.Context, token string) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+token)
+}
@@ -0,0 +1,248 @@
+package tests
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	grpcapp "xauth/internal/app/grpc"
+	accountgrpc "xauth/internal/grpc/account"
+	admingrpc "xauth/internal/grpc/admin"
+	jwtlib "xauth/internal/lib/jwt"
+	"xauth/internal/lib/pkce"
+	"xauth/internal/services/apikeys"
+	"xauth/internal/services/oauth"
+
+	"github.com/brianvoe/gofakeit/v6"
+	"github.com/golang-jwt/jwt/v5"
+	ssov1 "github.com/memxire/protobuf/gen/go/sso"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// TestGRPCAuth_ForgedAdminToken checks that a token signed with an app's
+// secret, which the app knows, doesn't pass for an admin's.
+func TestGRPCAuth_ForgedAdminToken(t *testing.T) {
+	ctx := context.Background()
+	storage, authService, oauthService, adminAPI := newOffline(t)
+
+	app, err := adminAPI.CreateApp(ctx, &admingrpc.CreateAppRequest{Name: "app"})
+	require.NoError(t, err)
+
+	email, username, pass := gofakeit.Email(), gofakeit.Username(), randomFakePassword()
+	adminID, err := authService.RegisterNewUser(ctx, email, pass, username)
+	require.NoError(t, err)
+	require.NoError(t, storage.SetAdmin(ctx, adminID, true))
+
+	forged, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"uid":      adminID,
+		"email":    email,
+		"username": username,
+		"app_id":   app.App.ID,
+		"exp":      time.Now().Add(time.Hour).Unix(),
+	}).SignedString([]byte(app.App.Secret))
+	require.NoError(t, err)
+
+	genuine, err := authService.Login(ctx, email, pass, app.App.ID, username)
+	require.NoError(t, err)
+
+	interceptor := grpcapp.New(slog.New(slog.NewTextHandler(io.Discard, nil)),
+		nil, nil, nil, oauthService, storage, nil, 0, 0, nil, 0, nil).Interceptor()
+
+	call := func(token string) error {
+		ctx := metadata.NewIncomingContext(ctx,
+			metadata.Pairs("authorization", "Bearer "+token))
+
+		_, err := interceptor(ctx, &admingrpc.SetAdminRequest{UserID: adminID, IsAdmin: true},
+			&grpc.UnaryServerInfo{FullMethod: admingrpc.SetAdminFullMethodName},
+			func(context.Context, any) (any, error) { return nil, nil })
+
+		return err
+	}
+
+	assert.Equal(t, codes.Unauthenticated, status.Code(call(forged)))
+	assert.NoError(t, call(genuine))
+}
+
+// TestGRPCAuth_AdminCredentials checks that acting as an admin takes the
+// admin's own credentials: a token from Login or an API key with the admin
+// scope, not a token the admin gave to an app or a key with other scopes.
+func TestGRPCAuth_AdminCredentials(t *testing.T) {
+	ctx := context.Background()
+	storage, authService, oauthService, adminAPI := newOffline(t)
+	keys := apikeys.New(discardLog(), storage, time.Hour, time.Hour)
+
+	const redirectURI = "https://app.example.com/callback"
+
+	app, err := adminAPI.CreateApp(ctx, &admingrpc.CreateAppRequest{
+		Name:         "app",
+		RedirectURIs: []string{redirectURI},
+	})
+	require.NoError(t, err)
+
+	email, username, pass := gofakeit.Email(), gofakeit.Username(), randomFakePassword()
+	adminID, err := authService.RegisterNewUser(ctx, email, pass, username)
+	require.NoError(t, err)
+	require.NoError(t, storage.SetAdmin(ctx, adminID, true))
+
+	otherID, err := authService.RegisterNewUser(ctx, gofakeit.Email(),
+		randomFakePassword(), gofakeit.Username())
+	require.NoError(t, err)
+
+	login, err := authService.Login(ctx, email, pass, app.App.ID, username)
+	require.NoError(t, err)
+
+	verifier := randomVerifier()
+	code, err := oauthService.AuthorizeUser(ctx, oauth.AuthorizeRequest{
+		AppID:               app.App.ID,
+		RedirectURI:         redirectURI,
+		Scope:               "openid admin",
+		CodeChallenge:       pkce.ChallengeS256(verifier),
+		CodeChallengeMethod: pkce.MethodS256,
+	}, adminID)
+	require.NoError(t, err)
+
+	codeToken, err := oauthService.Exchange(ctx, oauth.TokenRequest{
+		AppID:        app.App.ID,
+		ClientSecret: app.App.Secret,
+		Code:         code,
+		RedirectURI:  redirectURI,
+		CodeVerifier: verifier,
+	})
+	require.NoError(t, err)
+
+	newKey := func(scopes ...string) string {
+		_, key, err := keys.Create(ctx, adminID, gofakeit.LetterN(8), scopes, 0)
+		require.NoError(t, err)
+
+		return key
+	}
+
+	interceptor := grpcapp.New(discardLog(), nil, nil, nil, oauthService, storage, nil,
+		0, 0, nil, 0, nil).Interceptor()
+
+	// Another user's data and an admin RPC take the same credentials.
+	calls := map[string]any{
+		ssov1.Auth_GetUser_FullMethodName: &ssov1.GetUserRequest{UserId: otherID},
+		admingrpc.SetAdminFullMethodName:  &admingrpc.SetAdminRequest{UserID: otherID},
+	}
+
+	for _, tt := range []struct {
+		name     string
+		token    string
+		expected codes.Code
+	}{
+		{"Login Token", login, codes.OK},
+		{"API Key With Admin Scope", newKey("admin"), codes.OK},
+		{"API Key Without Scopes", newKey(), codes.PermissionDenied},
+		{"API Key Without Admin Scope", newKey("users:read"), codes.PermissionDenied},
+		{"Authorization Code Token", codeToken.AccessToken, codes.PermissionDenied},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := metadata.NewIncomingContext(ctx,
+				metadata.Pairs("authorization", "Bearer "+tt.token))
+
+			for method, req := range calls {
+				_, err := interceptor(ctx, req, &grpc.UnaryServerInfo{FullMethod: method},
+					func(context.Context, any) (any, error) { return nil, nil })
+				assert.Equal(t, tt.expected, status.Code(err), method)
+			}
+		})
+	}
+}
+
+// TestGRPCAuth_AccountCredentials checks that the Account service only acts
+// for a user with a token from Login, and that erasing the account also
+// takes a recent one.
+func TestGRPCAuth_AccountCredentials(t *testing.T) {
+	ctx := context.Background()
+	storage, authService, oauthService, adminAPI := newOffline(t)
+
+	const redirectURI = "https://app.example.com/callback"
+
+	app, err := adminAPI.CreateApp(ctx, &admingrpc.CreateAppRequest{
+		Name:         "app",
+		RedirectURIs: []string{redirectURI},
+	})
+	require.NoError(t, err)
+
+	email, username, pass := gofakeit.Email(), gofakeit.Username(), randomFakePassword()
+	userID, err := authService.RegisterNewUser(ctx, email, pass, username)
+	require.NoError(t, err)
+
+	login, err := authService.Login(ctx, email, pass, app.App.ID, username)
+	require.NoError(t, err)
+
+	// A token from Login an hour ago, still valid.
+	old := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":      testIssuer,
+		"aud":      testIssuer,
+		"gty":      jwtlib.GrantPassword,
+		"iat":      time.Now().Add(-time.Hour).Unix(),
+		"exp":      time.Now().Add(time.Hour).Unix(),
+		"uid":      userID,
+		"email":    email,
+		"username": username,
+		"app_id":   app.App.ID,
+	})
+	old.Header["kid"] = signingKey(t).ID
+	old.Header["typ"] = "at+jwt"
+	oldLogin, err := old.SignedString(signingKey(t).Private)
+	require.NoError(t, err)
+
+	verifier := randomVerifier()
+	code, err := oauthService.AuthorizeUser(ctx, oauth.AuthorizeRequest{
+		AppID:               app.App.ID,
+		RedirectURI:         redirectURI,
+		Scope:               "openid",
+		CodeChallenge:       pkce.ChallengeS256(verifier),
+		CodeChallengeMethod: pkce.MethodS256,
+	}, userID)
+	require.NoError(t, err)
+
+	codeToken, err := oauthService.Exchange(ctx, oauth.TokenRequest{
+		AppID:        app.App.ID,
+		ClientSecret: app.App.Secret,
+		Code:         code,
+		RedirectURI:  redirectURI,
+		CodeVerifier: verifier,
+	})
+	require.NoError(t, err)
+
+	interceptor := grpcapp.New(discardLog(), nil, nil, nil, oauthService, storage, nil,
+		0, 0, nil, 0, nil).Interceptor()
+
+	call := func(token, method string, req any) codes.Code {
+		ctx := metadata.NewIncomingContext(ctx,
+			metadata.Pairs("authorization", "Bearer "+token))
+
+		_, err := interceptor(ctx, req, &grpc.UnaryServerInfo{FullMethod: method},
+			func(context.Context, any) (any, error) { return nil, nil })
+
+		return status.Code(err)
+	}
+
+	export := func(token string) codes.Code {
+		return call(token, accountgrpc.ExportMyDataFullMethodName,
+			&accountgrpc.ExportMyDataRequest{UserID: userID})
+	}
+	erase := func(token string) codes.Code {
+		return call(token, accountgrpc.EraseAccountFullMethodName,
+			&accountgrpc.EraseAccountRequest{UserID: userID})
+	}
+
+	assert.Equal(t, codes.OK, export(login))
+	assert.Equal(t, codes.OK, erase(login))
+
+	assert.Equal(t, codes.OK, export(oldLogin))
+	assert.Equal(t, codes.Unauthenticated, erase(oldLogin))
+
+	assert.Equal(t, codes.PermissionDenied, export(codeToken.AccessToken))
+	assert.Equal(t, codes.PermissionDenied, erase(codeToken.AccessToken))
+}
@@ -0,0 +1,126 @@
+package tests
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	authgrpc "xauth/internal/grpc/auth"
+	"xauth/internal/lib/hashpool"
+	"xauth/internal/services/auth"
+
+	ssov1 "github.com/memxire/protobuf/gen/go/sso"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestHashPool_Shedding(t *testing.T) {
+	ctx := context.Background()
+	pool := hashpool.New(1, 1)
+	t.Cleanup(pool.Close)
+
+	release := make(chan struct{})
+	running := make(chan struct{})
+	first := make(chan error, 1)
+	go func() {
+		first <- pool.Do(ctx, func() {
+			close(running)
+			<-release
+		})
+	}()
+	<-running
+
+	queued := make(chan error, 1)
+	go func() { queued <- pool.Do(ctx, func() {}) }()
+	require.Eventually(t, func() bool { return pool.Stats().Queued == 1 },
+		time.Second, time.Millisecond)
+
+	assert.ErrorIs(t, pool.Do(ctx, func() {}), hashpool.ErrQueueFull)
+
+	close(release)
+	require.NoError(t, <-first)
+	require.NoError(t, <-queued)
+
+	// A job that gives up while queued doesn't run.
+	release = make(chan struct{})
+	go func() { _ = pool.Do(ctx, func() { <-release }) }()
+	require.Eventually(t, func() bool { return pool.Stats().Busy == 1 },
+		time.Second, time.Millisecond)
+
+	cancelCtx, cancel := context.WithCancel(ctx)
+	ran := false
+	go func() {
+		require.Eventually(t, func() bool { return pool.Stats().Queued == 1 },
+			time.Second, time.Millisecond)
+		cancel()
+	}()
+	assert.ErrorIs(t, pool.Do(cancelCtx, func() { ran = true }), context.Canceled)
+	close(release)
+	assert.False(t, ran)
+
+	stats := pool.Stats()
+	assert.Equal(t, 1, stats.Workers)
+	assert.Equal(t, 1, stats.QueueDepth)
+	assert.Equal(t, uint64(1), stats.RejectedFull)
+	assert.Eventually(t, func() bool { return pool.Stats().Completed == 3 },
+		time.Second, time.Millisecond)
+}
+
+func TestHashPool_Deadline(t *testing.T) {
+	ctx := context.Background()
+	pool := hashpool.New(1, 8)
+	t.Cleanup(pool.Close)
+
+	require.NoError(t, pool.Do(ctx, func() { time.Sleep(50 * time.Millisecond) }))
+
+	// Too little time for a job as long as the last one.
+	short, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, pool.Do(short, func() {}), hashpool.ErrNoTime)
+
+	long, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	assert.NoError(t, pool.Do(long, func() {}))
+
+	assert.Equal(t, uint64(1), pool.Stats().RejectedDeadline)
+}
+
+// TestHashPool_Unavailable checks that logins are rejected with
+// Unavailable rather than queued when the pool is saturated.
+func TestHashPool_Unavailable(t *testing.T) {
+	ctx := context.Background()
+	storage, _, _, _ := newOffline(t)
+
+	pool := hashpool.New(1, 0)
+	t.Cleanup(pool.Close)
+
+	authService := auth.New(slog.New(slog.NewTextHandler(io.Discard, nil)),
+		storage, storage, storage, storage, time.Hour, testIssuer, signingKey(t), false, nil,
+		pool)
+	api := authgrpc.NewServerAPI(authService)
+
+	release := make(chan struct{})
+	running := make(chan struct{})
+	go func() {
+		_ = pool.Do(ctx, func() {
+			close(running)
+			<-release
+		})
+	}()
+	<-running
+	defer close(release)
+
+	_, err := authService.Authenticate(ctx, "nobody@example.com", "secret", "")
+	assert.ErrorIs(t, err, auth.ErrOverloaded)
+
+	_, err = api.Login(ctx, &ssov1.LoginRequest{
+		Email:    "nobody@example.com",
+		Password: "secret",
+		AppId:    1,
+	})
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+}
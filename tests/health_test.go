@@ -0,0 +1,46 @@
+package tests
+
+import (
+	"testing"
+	"xauth/tests/suite"
+
+	ssov1 "github.com/memxire/protobuf/gen/go/sso"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestHealth_Serving(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	for _, service := range []string{"", ssov1.Auth_ServiceDesc.ServiceName} {
+		resp, err := st.HealthClient.Check(ctx, &healthpb.HealthCheckRequest{
+			Service: service,
+		})
+		require.NoError(t, err)
+		assert.Equal(t, healthpb.HealthCheckResponse_SERVING, resp.GetStatus())
+	}
+}
+
+func TestHealth_UnknownService(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	_, err := st.HealthClient.Check(ctx, &healthpb.HealthCheckRequest{
+		Service: "unknown.Service",
+	})
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "unknown service")
+}
+
+// TestHealth_Watch checks that the streaming Watch method is served to
+// callers without a token, like Check.
+func TestHealth_Watch(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	stream, err := st.HealthClient.Watch(ctx, &healthpb.HealthCheckRequest{})
+	require.NoError(t, err)
+
+	resp, err := stream.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, resp.GetStatus())
+}
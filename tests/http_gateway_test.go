@@ -0,0 +1,191 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	grpcapp "xauth/internal/app/grpc"
+	admingrpc "xauth/internal/grpc/admin"
+	authgrpc "xauth/internal/grpc/auth"
+	authhttp "xauth/internal/http/auth"
+	"xauth/tests/suite"
+
+	"github.com/brianvoe/gofakeit/v6"
+	ssov1 "github.com/memxire/protobuf/gen/go/sso"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type gatewayError struct {
+	Error struct {
+		Code    int    `json:"code"`
+		Status  string `json:"status"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func TestHTTPGateway_RegisterLoginGetUser(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email := gofakeit.Email()
+	pass := randomFakePassword()
+	username := gofakeit.Username()
+
+	var reg struct {
+		UserID int64 `json:"user_id"`
+	}
+	code := doJSON(ctx, t, http.MethodPost, st.HTTPURL+"/v1/auth/register",
+		map[string]any{"email": email, "password": pass, "username": username}, &reg)
+	require.Equal(t, http.StatusOK, code)
+	require.NotEmpty(t, reg.UserID)
+
+	var login struct {
+		Token string `json:"token"`
+	}
+	code = doJSON(ctx, t, http.MethodPost, st.HTTPURL+"/v1/auth/login",
+		map[string]any{"email": email, "password": pass, "app_id": appID}, &login)
+	require.Equal(t, http.StatusOK, code)
+	assert.NotEmpty(t, login.Token)
+
+	var byIdentifier struct {
+		Token string `json:"token"`
+	}
+	code = doJSON(ctx, t, http.MethodPost, st.HTTPURL+"/v1/auth/login",
+		map[string]any{"identifier": strings.ToUpper(username), "password": pass,
+			"app_id": appID}, &byIdentifier)
+	require.Equal(t, http.StatusOK, code)
+	assert.NotEmpty(t, byIdentifier.Token)
+
+	var user struct {
+		UserID   int64  `json:"user_id"`
+		Email    string `json:"email"`
+		Username string `json:"username"`
+	}
+	code = doJSON(bearerContext(ctx, login.Token), t, http.MethodGet,
+		fmt.Sprintf("%s/v1/users/%d", st.HTTPURL, reg.UserID), nil, &user)
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, reg.UserID, user.UserID)
+	assert.Equal(t, email, user.Email)
+	assert.Equal(t, username, user.Username)
+
+	var errBody gatewayError
+	code = doJSON(ctx, t, http.MethodGet,
+		fmt.Sprintf("%s/v1/users/%d", st.HTTPURL, reg.UserID), nil, &errBody)
+	require.Equal(t, http.StatusUnauthorized, code)
+	assert.Equal(t, "UNAUTHENTICATED", errBody.Error.Status)
+}
+
+func TestHTTPGateway_ErrorBody(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	tests := []struct {
+		name           string
+		body           any
+		expectedCode   int
+		expectedStatus string
+	}{
+		{
+			name:           "Login with Empty Password",
+			body:           map[string]any{"email": gofakeit.Email(), "app_id": appID},
+			expectedCode:   http.StatusBadRequest,
+			expectedStatus: "INVALID_ARGUMENT",
+		},
+		{
+			name:           "Login with Unknown Field",
+			body:           map[string]any{"unknown": true},
+			expectedCode:   http.StatusBadRequest,
+			expectedStatus: "INVALID_ARGUMENT",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var errBody gatewayError
+			code := doJSON(ctx, t, http.MethodPost, st.HTTPURL+"/v1/auth/login",
+				tt.body, &errBody)
+			require.Equal(t, tt.expectedCode, code)
+			assert.Equal(t, tt.expectedCode, errBody.Error.Code)
+			assert.Equal(t, tt.expectedStatus, errBody.Error.Status)
+			assert.NotEmpty(t, errBody.Error.Message)
+		})
+	}
+}
+
+// TestHTTPGateway_RestrictedMethod checks that methods restricted to some
+// client certificates aren't served by the gateway, which has none.
+func TestHTTPGateway_RestrictedMethod(t *testing.T) {
+	ctx := context.Background()
+	storage, authService, oauthService, adminAPI := newOffline(t)
+
+	app, err := adminAPI.CreateApp(ctx, &admingrpc.CreateAppRequest{Name: "app"})
+	require.NoError(t, err)
+
+	email, username, pass := gofakeit.Email(), gofakeit.Username(), randomFakePassword()
+	userID, err := authService.RegisterNewUser(ctx, email, pass, username)
+	require.NoError(t, err)
+
+	token, err := authService.Login(ctx, email, pass, app.App.ID, username)
+	require.NoError(t, err)
+
+	grpcApp := grpcapp.New(discardLog(), nil, nil, nil, oauthService, storage, nil, 0, 0,
+		nil, 0, map[string][]string{ssov1.Auth_IsAdmin_FullMethodName: {"admin-service"}})
+
+	mux := http.NewServeMux()
+	authhttp.Register(mux, discardLog(), authgrpc.NewServerAPI(authService),
+		grpcApp.Interceptor())
+
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	ctx = bearerContext(ctx, token)
+
+	var user map[string]any
+	code := doJSON(ctx, t, http.MethodGet,
+		fmt.Sprintf("%s/v1/users/%d", srv.URL, userID), nil, &user)
+	assert.Equal(t, http.StatusOK, code)
+
+	var errBody gatewayError
+	code = doJSON(ctx, t, http.MethodGet,
+		fmt.Sprintf("%s/v1/users/%d/is-admin", srv.URL, userID), nil, &errBody)
+	assert.Equal(t, http.StatusForbidden, code)
+	assert.Equal(t, "PERMISSION_DENIED", errBody.Error.Status)
+}
+
+type bearerKey struct{}
+
+// bearerContext makes doJSON send token as the bearer token.
+func bearerContext(ctx context.Context, token string) context.Context {
+	return context.WithValue(ctx, bearerKey{}, token)
+}
+
+// doJSON sends body as JSON and decodes the response into out.
+// It returns the HTTP status code.
+func doJSON(ctx context.Context, t *testing.T, method, url string, body, out any) int {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if body != nil {
+		require.NoError(t, json.NewEncoder(&buf).Encode(body))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, &buf)
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	if token, ok := ctx.Value(bearerKey{}).(string); ok {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(out))
+
+	return resp.StatusCode
+}
@@ -1,10 +1,12 @@
 package tests
 
 import (
+	"sync"
 	"testing"
 	"time"
 
 	"xauth/internal/domain/models"
+	"xauth/internal/lib/jwk"
 	jwtlib "xauth/internal/lib/jwt"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -28,17 +30,21 @@ func TestNewToken_HappyPath(t *testing.T) {
 	}
 	duration := time.Hour
 
+	key := signingKey(t)
+
 	// Call the token creation method
-	tokenString, err := jwtlib.NewToken(user, app, duration)
+	tokenString, err := jwtlib.NewToken(user, app, key, testIssuer, duration)
 	require.NoError(t, err)
 	require.NotEmpty(t, tokenString)
 
-	// Parsing a token using a secret key
+	// Parsing a token using the public key
 	parsedToken, err := jwt.Parse(tokenString, func(token *jwt.Token) (any,
 		error) {
-		return []byte(app.Secret), nil
-	})
+		return &key.Private.PublicKey, nil
+	}, jwt.WithValidMethods([]string{"RS256"}))
 	require.NoError(t, err)
+	assert.Equal(t, key.ID, parsedToken.Header["kid"])
+	assert.Equal(t, "at+jwt", parsedToken.Header["typ"])
 
 	claims, ok := parsedToken.Claims.(jwt.MapClaims)
 	require.True(t, ok, "claims must be of type jwt.MapClaims")
@@ -48,6 +54,9 @@ func TestNewToken_HappyPath(t *testing.T) {
 	assert.Equal(t, user.Email, claims["email"].(string))
 	assert.Equal(t, float64(app.ID), claims["app_id"].(float64))
 	assert.Equal(t, user.Username, claims["username"].(string))
+	assert.Equal(t, testIssuer, claims["iss"])
+	assert.Equal(t, testIssuer, claims["aud"])
+	assert.Equal(t, jwtlib.GrantPassword, claims["gty"])
 
 	// Checking the token expiration time (exp)
 	exp := int64(claims["exp"].(float64))
@@ -69,15 +78,16 @@ func TestNewToken_NegativeDuration(t *testing.T) {
 		Secret: "test-secret",
 	}
 	duration := -time.Minute // Negative duration
+	key := signingKey(t)
 
-	tokenString, err := jwtlib.NewToken(user, app, duration)
+	tokenString, err := jwtlib.NewToken(user, app, key, testIssuer, duration)
 	require.NoError(t, err)
 	require.NotEmpty(t, tokenString)
 
 	// Disable claims validation to avoid the "token is expired" error
 	parsedToken, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{},
 		error) {
-		return []byte(app.Secret), nil
+		return &key.Private.PublicKey, nil
 	}, jwt.WithoutClaimsValidation())
 	require.NoError(t, err)
 
@@ -89,7 +99,8 @@ func TestNewToken_NegativeDuration(t *testing.T) {
 		"The expiration time must be in the past")
 }
 
-// TestNewToken_EmptySecret tests the function's behavior when the secret is empty.
+// TestNewToken_EmptySecret checks that tokens don't depend on the app
+// secret, which may be empty.
 func TestNewToken_EmptySecret(t *testing.T) {
 	user := models.User{
 		ID:    12345,
@@ -100,14 +111,15 @@ func TestNewToken_EmptySecret(t *testing.T) {
 		Secret: "", // empty secret
 	}
 	duration := time.Hour
+	key := signingKey(t)
 
-	tokenString, err := jwtlib.NewToken(user, app, duration)
+	tokenString, err := jwtlib.NewToken(user, app, key, testIssuer, duration)
 	require.NoError(t, err)
 	require.NotEmpty(t, tokenString)
 
 	parsedToken, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{},
 		error) {
-		return []byte(app.Secret), nil
+		return &key.Private.PublicKey, nil
 	})
 	require.NoError(t, err)
 
@@ -119,3 +131,123 @@ func TestNewToken_EmptySecret(t *testing.T) {
 	assert.Equal(t, user.Email, claims["email"].(string))
 	assert.Equal(t, float64(app.ID), claims["app_id"].(float64))
 }
+
+// TestNewClientToken_HappyPath checks that client tokens identify the app
+// and carry no user claims.
+func TestNewClientToken_HappyPath(t *testing.T) {
+	app := models.App{
+		ID:     12345,
+		Secret: "test-secret",
+	}
+	scopes := []string{"users:read", "users:write"}
+	key := signingKey(t)
+
+	tokenString, err := jwtlib.NewClientToken(app, scopes, key, testIssuer, time.Hour)
+	require.NoError(t, err)
+
+	parsedToken, err := jwt.Parse(tokenString, func(token *jwt.Token) (any,
+		error) {
+		return &key.Private.PublicKey, nil
+	})
+	require.NoError(t, err)
+
+	claims, ok := parsedToken.Claims.(jwt.MapClaims)
+	require.True(t, ok)
+
+	assert.Equal(t, "12345", claims["sub"])
+	assert.Equal(t, "12345", claims["client_id"])
+	assert.Equal(t, float64(app.ID), claims["app_id"].(float64))
+	assert.Equal(t, "users:read users:write", claims["scope"])
+	assert.NotContains(t, claims, "uid")
+	assert.NotContains(t, claims, "email")
+	assert.NotContains(t, claims, "username")
+}
+
+// TestParse checks that Parse accepts tokens signed with the server's key
+// and rejects expired ones, ones signed with anything else, and ID tokens.
+func TestParse(t *testing.T) {
+	user := models.User{
+		ID:       1,
+		Email:    "user@example.com",
+		Username: "user",
+	}
+	app := models.App{
+		ID:     12345,
+		Secret: "test-secret",
+	}
+	key := signingKey(t)
+
+	valid, err := jwtlib.NewToken(user, app, key, testIssuer, time.Hour)
+	require.NoError(t, err)
+
+	claims, err := jwtlib.Parse(valid, key, testIssuer)
+	require.NoError(t, err)
+	assert.Equal(t, user.ID, claims.UserID)
+	assert.Equal(t, app.ID, claims.AppID)
+	assert.Equal(t, user.Email, claims.Email)
+	assert.Equal(t, user.Username, claims.Username)
+	assert.Equal(t, jwtlib.GrantPassword, claims.Grant)
+
+	codeToken, err := jwtlib.NewCodeToken(user, app, "openid profile", key, testIssuer,
+		time.Hour)
+	require.NoError(t, err)
+
+	claims, err = jwtlib.Parse(codeToken, key, testIssuer)
+	require.NoError(t, err)
+	assert.Equal(t, user.ID, claims.UserID)
+	assert.Equal(t, "openid profile", claims.Scope)
+	assert.Equal(t, jwtlib.GrantAuthorizationCode, claims.Grant)
+
+	_, err = jwtlib.Parse(valid, key, "https://other.example.com")
+	assert.ErrorIs(t, err, jwtlib.ErrInvalidToken)
+
+	expired, err := jwtlib.NewToken(user, app, key, testIssuer, -time.Hour)
+	require.NoError(t, err)
+
+	_, err = jwtlib.Parse(expired, key, testIssuer)
+	assert.ErrorIs(t, err, jwtlib.ErrInvalidToken)
+
+	other, err := jwk.Generate()
+	require.NoError(t, err)
+	other.ID = key.ID
+
+	forged, err := jwtlib.NewToken(user, app, other, testIssuer, time.Hour)
+	require.NoError(t, err)
+
+	_, err = jwtlib.Parse(forged, key, testIssuer)
+	assert.ErrorIs(t, err, jwtlib.ErrInvalidToken)
+
+	// A token signed with the app secret, as tokens used to be.
+	hmac, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"uid":    user.ID,
+		"app_id": app.ID,
+		"iss":    testIssuer,
+		"aud":    testIssuer,
+		"exp":    time.Now().Add(time.Hour).Unix(),
+	}).SignedString([]byte(app.Secret))
+	require.NoError(t, err)
+
+	_, err = jwtlib.Parse(hmac, key, testIssuer)
+	assert.ErrorIs(t, err, jwtlib.ErrInvalidToken)
+
+	idToken, err := jwtlib.NewIDToken(user, app, key, testIssuer, "", time.Now(), time.Hour)
+	require.NoError(t, err)
+
+	_, err = jwtlib.Parse(idToken, key, testIssuer)
+	assert.ErrorIs(t, err, jwtlib.ErrInvalidToken)
+}
+
+const testIssuer = "http://localhost"
+
+var generateKey = sync.OnceValues(jwk.Generate)
+
+// signingKey returns the key tokens are signed with in the tests that run
+// without a server. Generating RSA keys is slow, so they share one.
+func signingKey(tb testing.TB) *jwk.Key {
+	tb.Helper()
+
+	key, err := generateKey()
+	require.NoError(tb, err)
+
+	return key
+}
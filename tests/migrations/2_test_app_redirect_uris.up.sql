@@ -0,0 +1,3 @@
+INSERT INTO app_redirect_uris (app_id, uri)
+VALUES (1, 'http://localhost:8081/callback')
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,3 @@
+UPDATE apps
+SET allowed_scopes = 'users:read users:write'
+WHERE id = 1;
@@ -0,0 +1,208 @@
+package tests
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"xauth/internal/storage/migrator"
+	"xauth/migrations"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestMigrator checks planning and applying migrations in both directions
+// against the real migrations.
+func TestMigrator(t *testing.T) {
+	m, err := migrator.New("file://../migrations",
+		filepath.Join(t.TempDir(), "sso.db"), "migrations", nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = m.Close() })
+
+	latest := m.Latest()
+	require.Greater(t, latest, uint(2))
+
+	steps, err := m.PlanUp(2)
+	require.NoError(t, err)
+	require.Len(t, steps, 2)
+	assert.Equal(t, migrator.Step{Version: 1, Name: "init"}, steps[0])
+	require.NoError(t, m.Apply(steps))
+
+	steps, err = m.PlanUp(0)
+	require.NoError(t, err)
+	assert.Len(t, steps, int(latest)-2)
+	require.NoError(t, m.Apply(steps))
+
+	steps, err = m.PlanUp(0)
+	require.NoError(t, err)
+	assert.Empty(t, steps)
+
+	_, err = m.PlanDown(int(latest) + 1)
+	assert.ErrorIs(t, err, migrator.ErrTooManySteps)
+
+	steps, err = m.PlanGoto(1)
+	require.NoError(t, err)
+	require.Len(t, steps, int(latest)-1)
+	assert.True(t, steps[0].Down)
+	assert.Equal(t, latest, steps[0].Version)
+	require.NoError(t, m.Apply(steps))
+
+	version, dirty, err := m.Version()
+	require.NoError(t, err)
+	assert.Equal(t, uint(1), version)
+	assert.False(t, dirty)
+
+	_, err = m.PlanGoto(latest + 1)
+	assert.ErrorIs(t, err, migrator.ErrUnknownVersion)
+
+	status, err := m.Status()
+	require.NoError(t, err)
+	require.Len(t, status.Migrations, int(latest))
+	assert.True(t, status.Migrations[0].Applied)
+	assert.False(t, status.Migrations[1].Applied)
+	assert.Equal(t, "add_is_admin_column_to_users_tbl", status.Migrations[1].Name)
+}
+
+// TestMigrator_Embedded checks migrating with the embedded migrations and
+// that a plan made before another process migrated is not applied.
+func TestMigrator_Embedded(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sso.db")
+
+	m, err := migrator.NewFS(migrations.FS, path, "migrations", nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = m.Close() })
+
+	other, err := migrator.NewFS(migrations.FS, path, "migrations", nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = other.Close() })
+
+	steps, err := m.UpTo(2)
+	require.NoError(t, err)
+	assert.Len(t, steps, 2)
+
+	steps, err = m.UpTo(2)
+	require.NoError(t, err)
+	assert.Empty(t, steps)
+
+	plan, err := m.PlanUp(1)
+	require.NoError(t, err)
+
+	_, err = other.UpTo(3)
+	require.NoError(t, err)
+
+	assert.ErrorIs(t, m.Apply(plan), migrator.ErrChanged)
+
+	version, _, err := m.Version()
+	require.NoError(t, err)
+	assert.Equal(t, uint(3), version)
+}
+
+// TestMigrator_ConnectionParams checks migrating a database whose path has
+// connection parameters, and that the lock file is named after the
+// database file alone.
+func TestMigrator_ConnectionParams(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sso.db")
+
+	m, err := migrator.NewFS(migrations.FS, path+"?_busy_timeout=5000", "migrations", nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = m.Close() })
+
+	_, err = m.UpTo(m.Latest())
+	require.NoError(t, err)
+
+	version, dirty, err := m.Version()
+	require.NoError(t, err)
+	assert.Equal(t, m.Latest(), version)
+	assert.False(t, dirty)
+
+	db, err := sql.Open("sqlite3", path)
+	require.NoError(t, err)
+	defer db.Close()
+
+	var recorded uint
+	require.NoError(t, db.QueryRow("SELECT version FROM migrations").Scan(&recorded))
+	assert.Equal(t, version, recorded)
+
+	assert.FileExists(t, path+".migrate.lock")
+}
+
+// TestMigrator_RebuildKeepsReferences checks that rebuilding the users
+// table, with foreign keys enforced, keeps the rows that reference users in
+// both directions, and that a broken reference fails the migration
+// instead.
+func TestMigrator_RebuildKeepsReferences(t *testing.T) {
+	const rebuild = 11
+
+	path := filepath.Join(t.TempDir(), "sso.db") + "?_foreign_keys=on"
+
+	m, err := migrator.NewFS(migrations.FS, path, "migrations", nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = m.Close() })
+
+	_, err = m.UpTo(rebuild - 1)
+	require.NoError(t, err)
+
+	db, err := sql.Open("sqlite3", path)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+
+	for _, query := range []string{
+		`INSERT INTO users (id, email, pass_hash, username) VALUES (1, 'a@example.com', x'00', 'a')`,
+		`INSERT INTO apps (id, name, secret) VALUES (1, 'app', 'secret')`,
+		`INSERT INTO auth_codes (code_hash, app_id, user_id, redirect_uri, code_challenge,
+			code_challenge_method, expires_at) VALUES (x'01', 1, 1, 'https://app', 'c', 'S256', 0)`,
+		`INSERT INTO identities (provider, subject, user_id, created_at) VALUES ('idp', 's', 1, 0)`,
+		`INSERT INTO api_keys (user_id, name, prefix, key_hash, created_at, expires_at)
+			VALUES (1, 'ci', 'p', x'02', 0, 0)`,
+		`INSERT INTO organizations (id, name, created_at) VALUES (1, 'org', 0)`,
+		`INSERT INTO org_members (org_id, user_id, role, created_at) VALUES (1, 1, 'owner', 0)`,
+		`INSERT INTO org_invitations (org_id, email, role, token_hash, invited_by, created_at,
+			expires_at) VALUES (1, 'b@example.com', 'member', x'03', 1, 0, 0)`,
+	} {
+		_, err := db.Exec(query)
+		require.NoError(t, err, query)
+	}
+
+	children := []string{"auth_codes", "identities", "api_keys", "org_members", "org_invitations"}
+
+	assertKept := func() {
+		t.Helper()
+
+		for _, table := range children {
+			var n int
+			require.NoError(t, db.QueryRow("SELECT count(*) FROM "+table).Scan(&n))
+			assert.Equal(t, 1, n, table)
+		}
+	}
+
+	_, err = m.UpTo(rebuild)
+	require.NoError(t, err)
+	assertKept()
+
+	var schema string
+	require.NoError(t, db.QueryRow(
+		"SELECT sql FROM sqlite_schema WHERE name = 'users'").Scan(&schema))
+	assert.Contains(t, schema, "AUTOINCREMENT")
+
+	steps, err := m.PlanDown(1)
+	require.NoError(t, err)
+	require.NoError(t, m.Apply(steps))
+	assertKept()
+
+	// A key of a user who doesn't exist, left by a connection that didn't
+	// enforce foreign keys.
+	_, err = db.Exec("PRAGMA foreign_keys = OFF")
+	require.NoError(t, err)
+	_, err = db.Exec(`INSERT INTO api_keys (user_id, name, prefix, key_hash, created_at,
+		expires_at) VALUES (2, 'ci', 'q', x'04', 0, 0)`)
+	require.NoError(t, err)
+
+	_, err = m.UpTo(rebuild)
+	require.Error(t, err)
+	require.NoError(t, m.Close())
+
+	require.NoError(t, db.QueryRow(
+		"SELECT sql FROM sqlite_schema WHERE name = 'users'").Scan(&schema))
+	assert.NotContains(t, schema, "AUTOINCREMENT", "the failed rebuild is rolled back")
+}
@@ -0,0 +1,467 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"xauth/internal/lib/jwk"
+	"xauth/internal/lib/pkce"
+	"xauth/tests/suite"
+
+	"github.com/brianvoe/gofakeit/v6"
+	"github.com/golang-jwt/jwt/v5"
+	ssov1 "github.com/memxire/protobuf/gen/go/sso"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const redirectURI = "http://localhost:8081/callback"
+
+// noRedirectClient returns redirects to the test instead of following them.
+var noRedirectClient = &http.Client{
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+func TestOAuth_AuthorizationCodeFlow_HappyPath(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email := gofakeit.Email()
+	pass := randomFakePassword()
+
+	respReg, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+		Email:    email,
+		Password: pass,
+		Username: gofakeit.Username(),
+	})
+	require.NoError(t, err)
+
+	verifier := randomVerifier()
+	params := authorizeParams(pkce.ChallengeS256(verifier))
+
+	// The login form is shown for a valid request.
+	resp, err := http.Get(st.HTTPURL + "/authorize?" + params.Encode())
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	code := authorize(t, st.HTTPURL, params, email, pass)
+
+	// The app has a secret, so the code and verifier alone aren't enough.
+	for _, secret := range []string{"", "wrong-secret"} {
+		token := exchangeCodeWithSecret(t, st.HTTPURL, secret, code, verifier)
+		assert.Equal(t, http.StatusUnauthorized, token.status)
+		assert.Equal(t, "invalid_client", token.Error)
+	}
+
+	token := exchangeCode(t, st.HTTPURL, code, verifier)
+	require.Equal(t, http.StatusOK, token.status)
+	assert.Equal(t, "Bearer", token.TokenType)
+	assert.Equal(t, int64(st.Cfg.TokenTTL.Seconds()), token.ExpiresIn)
+
+	claims := tokenClaims(t, st, token.AccessToken)
+	assert.Equal(t, respReg.GetUserId(), int64(claims["uid"].(float64)))
+	assert.Equal(t, email, claims["email"].(string))
+
+	// Codes are single use.
+	replay := exchangeCode(t, st.HTTPURL, code, verifier)
+	assert.Equal(t, http.StatusBadRequest, replay.status)
+	assert.Equal(t, "invalid_grant", replay.Error)
+}
+
+func TestOAuth_WrongCodeVerifier(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email := gofakeit.Email()
+	pass := randomFakePassword()
+
+	_, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+		Email:    email,
+		Password: pass,
+		Username: gofakeit.Username(),
+	})
+	require.NoError(t, err)
+
+	code := authorize(t, st.HTTPURL,
+		authorizeParams(pkce.ChallengeS256(randomVerifier())), email, pass)
+
+	token := exchangeCode(t, st.HTTPURL, code, randomVerifier())
+	assert.Equal(t, http.StatusBadRequest, token.status)
+	assert.Equal(t, "invalid_grant", token.Error)
+}
+
+func TestOAuth_Authorize_FailCases(t *testing.T) {
+	_, st := suite.New(t)
+
+	tests := []struct {
+		name          string
+		modify        func(url.Values)
+		expectedCode  int
+		expectedError string
+	}{
+		{
+			name:         "Unregistered Redirect URI",
+			modify:       func(v url.Values) { v.Set("redirect_uri", "https://evil.example/cb") },
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "Unknown Client",
+			modify:       func(v url.Values) { v.Set("client_id", "99999") },
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:          "Missing Code Challenge",
+			modify:        func(v url.Values) { v.Del("code_challenge") },
+			expectedCode:  http.StatusFound,
+			expectedError: "invalid_request",
+		},
+		{
+			name:          "Plain Code Challenge Method",
+			modify:        func(v url.Values) { v.Set("code_challenge_method", "plain") },
+			expectedCode:  http.StatusFound,
+			expectedError: "invalid_request",
+		},
+		{
+			name:          "Implicit Flow",
+			modify:        func(v url.Values) { v.Set("response_type", "token") },
+			expectedCode:  http.StatusFound,
+			expectedError: "unsupported_response_type",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := authorizeParams(pkce.ChallengeS256(randomVerifier()))
+			tt.modify(params)
+
+			resp, err := noRedirectClient.Get(st.HTTPURL + "/authorize?" + params.Encode())
+			require.NoError(t, err)
+			resp.Body.Close()
+			require.Equal(t, tt.expectedCode, resp.StatusCode)
+
+			if tt.expectedError != "" {
+				loc, err := url.Parse(resp.Header.Get("Location"))
+				require.NoError(t, err)
+				assert.Equal(t, tt.expectedError, loc.Query().Get("error"))
+				assert.Equal(t, params.Get("state"), loc.Query().Get("state"))
+			}
+		})
+	}
+}
+
+func TestOAuth_ClientCredentials(t *testing.T) {
+	_, st := suite.New(t)
+
+	tests := []struct {
+		name          string
+		secret        string
+		scope         string
+		expectedCode  int
+		expectedScope string
+		expectedError string
+	}{
+		{
+			name:          "All Allowed Scopes",
+			secret:        appSecret,
+			expectedCode:  http.StatusOK,
+			expectedScope: "users:read users:write",
+		},
+		{
+			name:          "Subset of Allowed Scopes",
+			secret:        appSecret,
+			scope:         "users:read",
+			expectedCode:  http.StatusOK,
+			expectedScope: "users:read",
+		},
+		{
+			name:          "Scope Not Allowed",
+			secret:        appSecret,
+			scope:         "users:read admin",
+			expectedCode:  http.StatusBadRequest,
+			expectedError: "invalid_scope",
+		},
+		{
+			name:          "Wrong Secret",
+			secret:        "wrong-secret",
+			expectedCode:  http.StatusUnauthorized,
+			expectedError: "invalid_client",
+		},
+		{
+			name:          "Missing Secret",
+			expectedCode:  http.StatusUnauthorized,
+			expectedError: "invalid_client",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{
+				"grant_type":    {"client_credentials"},
+				"client_id":     {strconv.Itoa(appID)},
+				"client_secret": {tt.secret},
+			}
+			if tt.scope != "" {
+				form.Set("scope", tt.scope)
+			}
+
+			resp, err := http.PostForm(st.HTTPURL+"/token", form)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			var res struct {
+				AccessToken string `json:"access_token"`
+				Scope       string `json:"scope"`
+				Error       string `json:"error"`
+			}
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&res))
+			require.Equal(t, tt.expectedCode, resp.StatusCode)
+			assert.Equal(t, tt.expectedError, res.Error)
+
+			if tt.expectedCode != http.StatusOK {
+				return
+			}
+
+			assert.Equal(t, tt.expectedScope, res.Scope)
+
+			claims := tokenClaims(t, st, res.AccessToken)
+			assert.Equal(t, strconv.Itoa(appID), claims["sub"])
+			assert.Equal(t, tt.expectedScope, claims["scope"])
+			assert.NotContains(t, claims, "uid")
+		})
+	}
+}
+
+func TestOAuth_OpenIDConnect(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email := gofakeit.Email()
+	pass := randomFakePassword()
+	username := gofakeit.Username()
+
+	respReg, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+		Email:    email,
+		Password: pass,
+		Username: username,
+	})
+	require.NoError(t, err)
+
+	var discovery struct {
+		Issuer           string `json:"issuer"`
+		TokenEndpoint    string `json:"token_endpoint"`
+		UserInfoEndpoint string `json:"userinfo_endpoint"`
+		JWKSURI          string `json:"jwks_uri"`
+	}
+	getJSON(t, st.HTTPURL+"/.well-known/openid-configuration", &discovery)
+	assert.Equal(t, st.Cfg.OIDC.Issuer, discovery.Issuer)
+	assert.Equal(t, st.Cfg.OIDC.Issuer+"/token", discovery.TokenEndpoint)
+
+	var keys jwk.Set
+	getJSON(t, st.HTTPURL+"/.well-known/jwks.json", &keys)
+	require.Len(t, keys.Keys, 1)
+
+	verifier := randomVerifier()
+	nonce := gofakeit.LetterN(16)
+	params := authorizeParams(pkce.ChallengeS256(verifier))
+	params.Set("scope", "openid email profile")
+	params.Set("nonce", nonce)
+
+	loginTime := time.Now()
+	code := authorize(t, st.HTTPURL, params, username, pass)
+
+	token := exchangeCode(t, st.HTTPURL, code, verifier)
+	require.Equal(t, http.StatusOK, token.status)
+	require.NotEmpty(t, token.IDToken)
+
+	parsed, err := jwt.Parse(token.IDToken, func(token *jwt.Token) (any, error) {
+		require.Equal(t, keys.Keys[0].Kid, token.Header["kid"])
+
+		return keys.Keys[0].PublicKey()
+	}, jwt.WithValidMethods([]string{"RS256"}))
+	require.NoError(t, err)
+
+	claims := parsed.Claims.(jwt.MapClaims)
+	assert.Equal(t, st.Cfg.OIDC.Issuer, claims["iss"])
+	assert.Equal(t, strconv.FormatInt(respReg.GetUserId(), 10), claims["sub"])
+	assert.Equal(t, strconv.Itoa(appID), claims["aud"])
+	assert.Equal(t, nonce, claims["nonce"])
+	assert.Equal(t, email, claims["email"])
+	assert.Equal(t, false, claims["email_verified"])
+	assert.Equal(t, username, claims["preferred_username"])
+	assert.InDelta(t, loginTime.Unix(), claims["auth_time"], 2)
+
+	req, err := http.NewRequest(http.MethodGet, st.HTTPURL+"/userinfo", nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var userInfo map[string]any
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&userInfo))
+	assert.Equal(t, claims["sub"], userInfo["sub"])
+	assert.Equal(t, email, userInfo["email"])
+	assert.Equal(t, username, userInfo["preferred_username"])
+}
+
+func TestOAuth_UserInfo_InvalidToken(t *testing.T) {
+	_, st := suite.New(t)
+
+	clientToken := clientCredentialsToken(t, st.HTTPURL)
+
+	for name, header := range map[string]string{
+		"Missing Token": "",
+		"Garbage Token": "Bearer not-a-jwt",
+		"Client Token":  "Bearer " + clientToken,
+	} {
+		t.Run(name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, st.HTTPURL+"/userinfo", nil)
+			require.NoError(t, err)
+			if header != "" {
+				req.Header.Set("Authorization", header)
+			}
+
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			resp.Body.Close()
+
+			assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+			assert.True(t, strings.HasPrefix(resp.Header.Get("WWW-Authenticate"), "Bearer"))
+		})
+	}
+}
+
+// TestPKCE_Verify checks the S256 transformation against the example in
+// RFC 7636, appendix B.
+func TestPKCE_Verify(t *testing.T) {
+	const (
+		verifier  = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+		challenge = "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+	)
+
+	assert.Equal(t, challenge, pkce.ChallengeS256(verifier))
+	assert.True(t, pkce.Verify(challenge, pkce.MethodS256, verifier))
+	assert.False(t, pkce.Verify(challenge, "plain", verifier))
+	assert.False(t, pkce.Verify(challenge, pkce.MethodS256, verifier+"x"))
+	assert.False(t, pkce.Verify(verifier, pkce.MethodS256, "short"))
+}
+
+func authorizeParams(challenge string) url.Values {
+	return url.Values{
+		"response_type":         {"code"},
+		"client_id":             {strconv.Itoa(appID)},
+		"redirect_uri":          {redirectURI},
+		"scope":                 {"profile"},
+		"state":                 {gofakeit.LetterN(16)},
+		"code_challenge":        {challenge},
+		"code_challenge_method": {pkce.MethodS256},
+	}
+}
+
+// authorize submits the hosted login form and returns the issued code.
+func authorize(t *testing.T, baseURL string, params url.Values, login, pass string) string {
+	t.Helper()
+
+	form := url.Values{}
+	for k, v := range params {
+		form[k] = v
+	}
+	form.Set("login", login)
+	form.Set("password", pass)
+
+	resp, err := noRedirectClient.PostForm(baseURL+"/authorize", form)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusFound, resp.StatusCode)
+
+	loc, err := url.Parse(resp.Header.Get("Location"))
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(loc.String(), redirectURI))
+	require.Equal(t, params.Get("state"), loc.Query().Get("state"))
+
+	code := loc.Query().Get("code")
+	require.NotEmpty(t, code)
+
+	return code
+}
+
+type tokenResult struct {
+	status      int
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+	IDToken     string `json:"id_token"`
+	Error       string `json:"error"`
+}
+
+func exchangeCode(t *testing.T, baseURL, code, verifier string) tokenResult {
+	t.Helper()
+
+	return exchangeCodeWithSecret(t, baseURL, appSecret, code, verifier)
+}
+
+// exchangeCodeWithSecret is exchangeCode with the client authenticating
+// with secret, or not at all if it's empty.
+func exchangeCodeWithSecret(t *testing.T, baseURL, secret, code, verifier string) tokenResult {
+	t.Helper()
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"client_id":     {strconv.Itoa(appID)},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"code_verifier": {verifier},
+	}
+	if secret != "" {
+		form.Set("client_secret", secret)
+	}
+
+	resp, err := http.PostForm(baseURL+"/token", form)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	var res tokenResult
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&res))
+	res.status = resp.StatusCode
+
+	return res
+}
+
+func randomVerifier() string {
+	return gofakeit.LetterN(64)
+}
+
+func clientCredentialsToken(t *testing.T, baseURL string) string {
+	t.Helper()
+
+	resp, err := http.PostForm(baseURL+"/token", url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {strconv.Itoa(appID)},
+		"client_secret": {appSecret},
+	})
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	var res tokenResult
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&res))
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	return res.AccessToken
+}
+
+func getJSON(t *testing.T, url string, v any) {
+	t.Helper()
+
+	resp, err := http.Get(url)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(v))
+}
@@ -0,0 +1,205 @@
+package tests
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"xauth/internal/lib/jwk"
+	"xauth/tests/suite"
+
+	"github.com/brianvoe/gofakeit/v6"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type orgResult struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+}
+
+type invitationResult struct {
+	Role  string `json:"role"`
+	Token string `json:"token"`
+}
+
+type membershipResult struct {
+	OrgID int64  `json:"org_id"`
+	Role  string `json:"role"`
+}
+
+func TestOrgs(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	ownerID, ownerToken := registerAndLogin(t, ctx, st, gofakeit.Username())
+	adminID, adminToken := registerAndLogin(t, ctx, st, gofakeit.Username())
+	_, strangerToken := registerAndLogin(t, ctx, st, gofakeit.Username())
+
+	name := gofakeit.Company() + " " + gofakeit.LetterN(8)
+
+	var org orgResult
+	resp := jsonRequest(t, st, http.MethodPost, "/v1/orgs", ownerToken,
+		map[string]any{"name": name}, &org)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Equal(t, name, org.Name)
+
+	resp = jsonRequest(t, st, http.MethodPost, "/v1/orgs", strangerToken,
+		map[string]any{"name": name}, nil)
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+
+	orgPath := "/v1/orgs/" + strconv.FormatInt(org.ID, 10)
+
+	var inv invitationResult
+	resp = jsonRequest(t, st, http.MethodPost, orgPath+"/invitations", ownerToken,
+		map[string]any{"email": tokenClaims(t, st, adminToken)["email"], "role": "admin"}, &inv)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	require.NotEmpty(t, inv.Token)
+
+	// Invitations only work for the email they were sent to.
+	resp = jsonRequest(t, st, http.MethodPost, "/v1/invitations/accept", strangerToken,
+		map[string]any{"token": inv.Token}, nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	var joined membershipResult
+	resp = jsonRequest(t, st, http.MethodPost, "/v1/invitations/accept", adminToken,
+		map[string]any{"token": inv.Token}, &joined)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, membershipResult{OrgID: org.ID, Role: "admin"}, joined)
+
+	resp = jsonRequest(t, st, http.MethodPost, "/v1/invitations/accept", adminToken,
+		map[string]any{"token": inv.Token}, nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "invitations are single-use")
+
+	var members struct {
+		Members []struct {
+			UserID int64  `json:"user_id"`
+			Role   string `json:"role"`
+		} `json:"members"`
+	}
+	resp = jsonRequest(t, st, http.MethodGet, orgPath+"/members", adminToken, nil, &members)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Len(t, members.Members, 2)
+	assert.Equal(t, ownerID, members.Members[0].UserID)
+	assert.Equal(t, "owner", members.Members[0].Role)
+
+	// The org-scoped token carries the membership.
+	var orgToken struct {
+		Token string `json:"token"`
+	}
+	resp = jsonRequest(t, st, http.MethodPost, orgPath+"/token", adminToken, nil, &orgToken)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	claims := tokenClaims(t, st, orgToken.Token)
+	assert.Equal(t, float64(org.ID), claims["org_id"])
+	assert.Equal(t, "admin", claims["org_role"])
+	assert.Equal(t, float64(adminID), claims["uid"])
+
+	info := introspect(t, st, orgToken.Token)
+	assert.True(t, info.Active)
+	assert.Equal(t, org.ID, info.OrgID)
+	assert.Equal(t, "admin", info.OrgRole)
+
+	adminPath := orgPath + "/members/" + strconv.FormatInt(adminID, 10)
+	ownerPath := orgPath + "/members/" + strconv.FormatInt(ownerID, 10)
+
+	tests := []struct {
+		name         string
+		method       string
+		path         string
+		token        string
+		body         any
+		expectedCode int
+	}{
+		{
+			name:         "Outsider Lists Members",
+			method:       http.MethodGet,
+			path:         orgPath + "/members",
+			token:        strangerToken,
+			expectedCode: http.StatusNotFound,
+		},
+		{
+			name:         "Outsider Gets Org Token",
+			method:       http.MethodPost,
+			path:         orgPath + "/token",
+			token:        strangerToken,
+			expectedCode: http.StatusNotFound,
+		},
+		{
+			name:         "Admin Demotes Owner",
+			method:       http.MethodPatch,
+			path:         ownerPath,
+			token:        adminToken,
+			body:         map[string]any{"role": "member"},
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			name:         "Admin Invites Owner",
+			method:       http.MethodPost,
+			path:         orgPath + "/invitations",
+			token:        adminToken,
+			body:         map[string]any{"email": gofakeit.Email(), "role": "owner"},
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			name:         "Unknown Role",
+			method:       http.MethodPatch,
+			path:         adminPath,
+			token:        ownerToken,
+			body:         map[string]any{"role": "superuser"},
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "Last Owner Leaves",
+			method:       http.MethodDelete,
+			path:         ownerPath,
+			token:        ownerToken,
+			expectedCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := jsonRequest(t, st, tt.method, tt.path, tt.token, tt.body, nil)
+			assert.Equal(t, tt.expectedCode, resp.StatusCode)
+		})
+	}
+
+	resp = jsonRequest(t, st, http.MethodPatch, adminPath, ownerToken,
+		map[string]any{"role": "member"}, nil)
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	resp = jsonRequest(t, st, http.MethodDelete, adminPath, ownerToken, nil, nil)
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	resp = jsonRequest(t, st, http.MethodPost, orgPath+"/token", adminToken, nil, nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+// tokenClaims verifies an access token with the key from the JWK set and
+// returns its claims.
+func tokenClaims(t *testing.T, st *suite.Suite, token string) jwt.MapClaims {
+	t.Helper()
+
+	parsed, err := jwt.Parse(token, jwksKeyfunc(t, st), jwt.WithValidMethods([]string{"RS256"}),
+		jwt.WithIssuer(st.Cfg.OIDC.Issuer), jwt.WithAudience(st.Cfg.OIDC.Issuer))
+	require.NoError(t, err)
+
+	return parsed.Claims.(jwt.MapClaims)
+}
+
+// jwksKeyfunc returns a jwt.Keyfunc giving the key access tokens are signed
+// with, as published in the server's JWK set.
+func jwksKeyfunc(t *testing.T, st *suite.Suite) jwt.Keyfunc {
+	t.Helper()
+
+	var keys jwk.Set
+	getJSON(t, st.HTTPURL+"/.well-known/jwks.json", &keys)
+	require.Len(t, keys.Keys, 1)
+
+	return func(token *jwt.Token) (any, error) {
+		require.Equal(t, keys.Keys[0].Kid, token.Header["kid"])
+		require.Equal(t, "at+jwt", token.Header["typ"])
+
+		return keys.Keys[0].PublicKey()
+	}
+}
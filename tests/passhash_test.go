@@ -0,0 +1,126 @@
+package tests
+
+import (
+	"testing"
+
+	"xauth/internal/lib/passhash"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// The hashes below were made with Python's hashlib, for "correct horse".
+const legacyPassword = "correct horse"
+
+func TestPasshash_Parse(t *testing.T) {
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte(legacyPassword), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name      string
+		hash      string
+		algorithm string
+	}{
+		{
+			name:      "bcrypt",
+			hash:      string(bcryptHash),
+			algorithm: passhash.Bcrypt,
+		},
+		{
+			name:      "PBKDF2-SHA256 PHC",
+			hash:      "$pbkdf2-sha256$i=1000$MDEyMzQ1Njc4OWFiY2RlZg$cBg8D2DungRB9k76szThf5ehfyBz991ay6PT8Srwk4M",
+			algorithm: passhash.PBKDF2SHA256,
+		},
+		{
+			name:      "PBKDF2-SHA256 passlib",
+			hash:      "$pbkdf2-sha256$1000$MDEyMzQ1Njc4OWFiY2RlZg$cBg8D2DungRB9k76szThf5ehfyBz991ay6PT8Srwk4M",
+			algorithm: passhash.PBKDF2SHA256,
+		},
+		{
+			name: "PBKDF2-SHA512 PHC",
+			hash: "$pbkdf2-sha512$i=1000$MDEyMzQ1Njc4OWFiY2RlZg$OM0FAoIqCVK1sWtxDiffVlBejtLa+ks4TP71" +
+				"JiecwuSZCG8iLbnlIEPOMoVX+i2B2wkSxjQ8CRGR9OkNGuIPMQ",
+			algorithm: passhash.PBKDF2SHA512,
+		},
+		{
+			name:      "PBKDF2-SHA256 Django",
+			hash:      "pbkdf2_sha256$1000$djangosalt$ZVlGakcDeKb2taHzKsfPLaM2y3lH/BJxu2wUEIFP3Og=",
+			algorithm: passhash.PBKDF2SHA256,
+		},
+		{
+			name:      "scrypt",
+			hash:      "$scrypt$ln=10,r=8,p=1$MDEyMzQ1Njc4OWFiY2RlZg$6g3umF+uVrJsObaTZhIbbTlgrvOEFcCItdwSjtPF67M",
+			algorithm: passhash.Scrypt,
+		},
+		{
+			name:      "LDAP SSHA",
+			hash:      "{SSHA}J1/8cWVgczTunyJ5hA+/nqlHwrswMTIzNDU2Nzg5YWJjZGVm",
+			algorithm: passhash.SSHA1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, err := passhash.Parse(tt.hash)
+			require.NoError(t, err)
+			assert.Equal(t, tt.algorithm, h.Algorithm)
+			assert.True(t, h.Verify(legacyPassword))
+			assert.False(t, h.Verify(legacyPassword+"!"))
+			assert.True(t, h.NeedsRehash())
+
+			// What is stored must parse back to the same hash.
+			stored, err := passhash.Parse(h.String())
+			require.NoError(t, err)
+			assert.True(t, stored.Verify(legacyPassword))
+		})
+	}
+}
+
+func TestPasshash_ParseFails(t *testing.T) {
+	tests := []struct {
+		name string
+		hash string
+		err  error
+	}{
+		{"Plain Text", "hunter2", passhash.ErrUnsupported},
+		{"Unknown Algorithm", "$argon2id$v=19$m=65536,t=3,p=4$c2FsdA$aGFzaA", passhash.ErrUnsupported},
+		{"Missing Iterations", "$pbkdf2-sha256$$c2FsdA$aGFzaA", passhash.ErrMalformed},
+		{"Too Many Iterations", "$pbkdf2-sha256$i=100000000$c2FsdA$aGFzaA", passhash.ErrMalformed},
+		{"Scrypt Too Costly", "$scrypt$ln=30,r=8,p=1$c2FsdA$aGFzaA", passhash.ErrMalformed},
+		{"Scrypt Too Parallel", "$scrypt$ln=14,r=8,p=4$c2FsdA$aGFzaA", passhash.ErrMalformed},
+		{"Scrypt Too Much Memory", "$scrypt$ln=20,r=8,p=1$c2FsdA$aGFzaA", passhash.ErrMalformed},
+		{"Bad Base64", "$pbkdf2-sha256$i=1000$c2FsdA$!!!", passhash.ErrMalformed},
+		{"Short SHA Digest", "$ssha256$$c2FsdA$aGFzaA", passhash.ErrMalformed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := passhash.Parse(tt.hash)
+			assert.ErrorIs(t, err, tt.err)
+		})
+	}
+}
+
+func TestPasshash_Format(t *testing.T) {
+	f, err := passhash.ParseFormat("alg=ssha256,enc=hex,salt=prefix")
+	require.NoError(t, err)
+
+	h, err := f.Hash("8fdec59c819cf0201c30f3c0e774c324e15e619e01cea381a5e9b24e48263e7c",
+		"30313233343536373839616263646566")
+	require.NoError(t, err)
+	assert.True(t, h.Verify(legacyPassword))
+	assert.False(t, h.Verify("correct"))
+
+	stored, err := passhash.Parse(h.String())
+	require.NoError(t, err)
+	assert.True(t, stored.Verify(legacyPassword))
+
+	for _, spec := range []string{
+		"", "enc=hex", "alg=md5", "alg=pbkdf2-sha256", "alg=ssha1,enc=b32",
+		"alg=scrypt,ln=14,r=8,p=4", "alg=scrypt,ln=20,r=8,p=1",
+	} {
+		_, err := passhash.ParseFormat(spec)
+		assert.ErrorIs(t, err, passhash.ErrInvalidFormat, spec)
+	}
+}
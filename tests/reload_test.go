@@ -0,0 +1,131 @@
+package tests
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"xauth/internal/app"
+	"xauth/internal/config"
+	"xauth/internal/storage/migrator"
+	"xauth/migrations"
+
+	"github.com/brianvoe/gofakeit/v6"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestApp_Reload checks that a reload whose certificates can't be read
+// changes nothing, and that a later one applies the settings and the
+// certificates together.
+func TestApp_Reload(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "server.crt")
+	keyFile := filepath.Join(dir, "server.key")
+	dbFile := filepath.Join(dir, "sso.db")
+
+	writeSelfSigned(t, certFile, keyFile, "first")
+
+	latest, err := migrator.LatestFS(migrations.FS)
+	require.NoError(t, err)
+
+	httpPort := freePort(t)
+	path := writeFile(t, dir, "config.yaml", fmt.Sprintf(`
+env: "prod"
+log_level: "error"
+storage_path: %q
+auto_migrate: true
+token_ttl: 1h
+grpc:
+  port: %d
+  timeout: 5s
+  tls:
+    enabled: true
+    cert_file: %q
+    key_file: %q
+http:
+  enabled: true
+  port: %d
+oidc:
+  issuer: "http://localhost"
+health:
+  schema_version: %d
+`, dbFile, freePort(t), certFile, keyFile, httpPort, latest))
+
+	cfg, err := config.Load(path)
+	require.NoError(t, err)
+
+	application := app.New(discardLog(), cfg)
+	go application.HTTPSrv.MustRun()
+	t.Cleanup(func() {
+		application.HTTPSrv.Stop()
+		_ = application.Close()
+	})
+
+	db, err := sql.Open("sqlite3", dbFile)
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Exec("INSERT INTO apps (id, name, secret) VALUES (1, 'app', 'secret')")
+	require.NoError(t, err)
+
+	baseURL := "http://localhost:" + strconv.Itoa(httpPort)
+	email, pass := gofakeit.Email(), randomFakePassword()
+
+	require.Eventually(t, func() bool {
+		conn, err := net.Dial("tcp", net.JoinHostPort("localhost", strconv.Itoa(httpPort)))
+		if err != nil {
+			return false
+		}
+
+		return conn.Close() == nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	var reg struct{}
+	code := doJSON(ctx, t, http.MethodPost, baseURL+"/v1/auth/register",
+		map[string]any{"email": email, "password": pass, "username": gofakeit.Username()},
+		&reg)
+	require.Equal(t, http.StatusOK, code)
+
+	tokenTTL := func() time.Duration {
+		t.Helper()
+
+		var login struct {
+			Token string `json:"token"`
+		}
+		code := doJSON(ctx, t, http.MethodPost, baseURL+"/v1/auth/login",
+			map[string]any{"email": email, "password": pass, "app_id": 1}, &login)
+		require.Equal(t, http.StatusOK, code)
+
+		claims := jwt.MapClaims{}
+		_, _, err := jwt.NewParser().ParseUnverified(login.Token, claims)
+		require.NoError(t, err)
+		iat, err := claims.GetIssuedAt()
+		require.NoError(t, err)
+		exp, err := claims.GetExpirationTime()
+		require.NoError(t, err)
+
+		return exp.Sub(iat.Time)
+	}
+
+	require.Equal(t, time.Hour, tokenTTL())
+
+	reloaded := *cfg
+	reloaded.TokenTTL = 2 * time.Hour
+
+	require.NoError(t, os.WriteFile(certFile, []byte("garbage"), 0o600))
+	require.Error(t, application.Reload(&reloaded))
+	assert.Equal(t, time.Hour, tokenTTL(), "nothing changes if the certificates can't be read")
+
+	writeSelfSigned(t, certFile, keyFile, "second")
+	require.NoError(t, application.Reload(&reloaded))
+	assert.Equal(t, 2*time.Hour, tokenTTL())
+}
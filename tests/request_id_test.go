@@ -0,0 +1,62 @@
+package tests
+
+import (
+	"net/http"
+	"testing"
+	"xauth/tests/suite"
+
+	"github.com/brianvoe/gofakeit/v6"
+	ssov1 "github.com/memxire/protobuf/gen/go/sso"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestRequestID(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	register := func(md metadata.MD) metadata.MD {
+		t.Helper()
+
+		var header metadata.MD
+		_, err := st.AuthClient.Register(metadata.NewOutgoingContext(ctx, md),
+			&ssov1.RegisterRequest{
+				Email:    gofakeit.Email(),
+				Password: randomFakePassword(),
+				Username: gofakeit.Username(),
+			}, grpc.Header(&header))
+		require.NoError(t, err)
+
+		return header
+	}
+
+	t.Run("Propagated", func(t *testing.T) {
+		header := register(metadata.Pairs("x-request-id", "caller-123"))
+		assert.Equal(t, []string{"caller-123"}, header.Get("x-request-id"))
+	})
+
+	t.Run("Generated", func(t *testing.T) {
+		header := register(metadata.MD{})
+		require.Len(t, header.Get("x-request-id"), 1)
+		assert.Len(t, header.Get("x-request-id")[0], 32)
+	})
+
+	t.Run("Invalid Replaced", func(t *testing.T) {
+		header := register(metadata.Pairs("x-request-id", "bad id <script>"))
+		require.Len(t, header.Get("x-request-id"), 1)
+		assert.Len(t, header.Get("x-request-id")[0], 32)
+	})
+
+	t.Run("HTTP Gateway", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, st.HTTPURL+"/v1/users/1", nil)
+		require.NoError(t, err)
+		req.Header.Set("X-Request-Id", "caller-456")
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+
+		assert.Equal(t, "caller-456", resp.Header.Get("X-Request-Id"))
+	})
+}
@@ -0,0 +1,235 @@
+package tests
+
+import (
+	"context"
+	"database/sql"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"xauth/internal/domain/models"
+	"xauth/internal/storage/sqlite"
+
+	"github.com/brianvoe/gofakeit/v6"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSQLite_ForeignKeys(t *testing.T) {
+	ctx := context.Background()
+
+	key := models.APIKey{
+		UserID:    999,
+		Name:      "orphan",
+		Prefix:    gofakeit.LetterN(8),
+		KeyHash:   []byte("hash"),
+		CreatedAt: time.Now(),
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+
+	enforced, err := sqlite.New(newDB(t), sqlite.Options{ForeignKeys: true})
+	require.NoError(t, err)
+	defer enforced.Close()
+
+	_, err = enforced.SaveAPIKey(ctx, key)
+	assert.ErrorContains(t, err, "FOREIGN KEY constraint failed")
+
+	unenforced, err := sqlite.New(newDB(t), sqlite.Options{})
+	require.NoError(t, err)
+	defer unenforced.Close()
+
+	_, err = unenforced.SaveAPIKey(ctx, key)
+	assert.NoError(t, err)
+}
+
+func TestSQLite_Close(t *testing.T) {
+	ctx := context.Background()
+
+	storage, err := sqlite.New(newDB(t), sqlite.Options{JournalMode: "wal"})
+	require.NoError(t, err)
+
+	id, err := storage.SaveUser(ctx, gofakeit.Email(), []byte("hash"), gofakeit.Username())
+	require.NoError(t, err)
+	_, err = storage.UserByID(ctx, id)
+	require.NoError(t, err)
+
+	require.NoError(t, storage.Close())
+
+	_, err = storage.UserByID(ctx, id)
+	assert.Error(t, err)
+}
+
+// BenchmarkSQLite_UserByID compares the prepared statement against
+// preparing it on every call, as the storage did before.
+func BenchmarkSQLite_UserByID(b *testing.B) {
+	ctx := context.Background()
+	path := newDB(b)
+
+	storage, err := sqlite.New(path, sqlite.Options{JournalMode: "wal"})
+	require.NoError(b, err)
+	defer storage.Close()
+
+	id, err := storage.SaveUser(ctx, gofakeit.Email(), []byte("hash"), gofakeit.Username())
+	require.NoError(b, err)
+
+	b.Run("prepared", func(b *testing.B) {
+		for range b.N {
+			if _, err := storage.UserByID(ctx, id); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("prepare per call", func(b *testing.B) {
+		db, err := sql.Open("sqlite3", path)
+		require.NoError(b, err)
+		defer db.Close()
+
+		for range b.N {
+			stmt, err := db.Prepare(`SELECT id, email, username, is_admin, suspended_at,
+				sessions_revoked_at FROM users WHERE id = ?`)
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			var user models.User
+			var suspendedAt, revokedAt sql.NullInt64
+			err = stmt.QueryRowContext(ctx, id).Scan(&user.ID, &user.Email,
+				&user.Username, &user.IsAdmin, &suspendedAt, &revokedAt)
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			// The storage never closed them, which only made it worse.
+			_ = stmt.Close()
+		}
+	})
+}
+
+// BenchmarkSQLite_ReadsDuringWrites runs lookups from many goroutines
+// while one in ten of them writes, in the rollback journal and WAL modes.
+func BenchmarkSQLite_ReadsDuringWrites(b *testing.B) {
+	ctx := context.Background()
+
+	for _, mode := range []string{"delete", "wal"} {
+		b.Run(mode, func(b *testing.B) {
+			storage, err := sqlite.New(newDB(b), sqlite.Options{
+				JournalMode:  mode,
+				BusyTimeout:  5 * time.Second,
+				MaxOpenConns: 16,
+				MaxIdleConns: 16,
+			})
+			require.NoError(b, err)
+			defer storage.Close()
+
+			id, err := storage.SaveUser(ctx, gofakeit.Email(), []byte("hash"),
+				gofakeit.Username())
+			require.NoError(b, err)
+
+			var n atomic.Int64
+
+			b.SetParallelism(4)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					var err error
+					if n.Add(1)%10 == 0 {
+						err = storage.SaveAuditEvent(ctx, models.AuditEvent{
+							CreatedAt: time.Now(),
+							Action:    models.AuditUserLogin,
+							UserID:    id,
+						})
+					} else {
+						_, err = storage.UserByID(ctx, id)
+					}
+					if err != nil {
+						b.Error(err)
+
+						return
+					}
+				}
+			})
+		})
+	}
+}
+
+// TestSQLite_EraseUser erases a user with foreign keys enforced and checks
+// that nothing referring to them is left and that no constraint is broken.
+func TestSQLite_EraseUser(t *testing.T) {
+	ctx := context.Background()
+	path := newDB(t)
+
+	s, err := sqlite.New(path, sqlite.Options{ForeignKeys: true})
+	require.NoError(t, err)
+	defer s.Close()
+
+	email := gofakeit.Email()
+	userID, err := s.SaveFederatedUser(ctx, email, gofakeit.Username(),
+		models.Identity{Provider: "stub", Subject: gofakeit.UUID()})
+	require.NoError(t, err)
+	otherID, err := s.SaveUser(ctx, gofakeit.Email(), []byte("hash"), gofakeit.Username())
+	require.NoError(t, err)
+
+	appID, err := s.SaveApp(ctx, models.App{Name: gofakeit.LetterN(8), Secret: "secret"}, nil)
+	require.NoError(t, err)
+
+	_, err = s.SaveAPIKey(ctx, models.APIKey{UserID: userID, Name: "ci",
+		Prefix: gofakeit.LetterN(8), KeyHash: []byte("hash"), CreatedAt: time.Now(),
+		ExpiresAt: time.Now().Add(time.Hour)})
+	require.NoError(t, err)
+	require.NoError(t, s.SaveAuthCode(ctx, models.AuthCode{CodeHash: []byte("code"),
+		AppID: appID, UserID: userID, AuthTime: time.Now(), ExpiresAt: time.Now().Add(time.Hour)}))
+
+	// The user is the only member of one organization, which goes with
+	// them, and a member of another, which stays.
+	soloID, err := s.SaveOrg(ctx, gofakeit.LetterN(8), userID)
+	require.NoError(t, err)
+	sharedID, err := s.SaveOrg(ctx, gofakeit.LetterN(8), otherID)
+	require.NoError(t, err)
+
+	invite := func(orgID, by int64, email string) models.Invitation {
+		inv := models.Invitation{OrgID: orgID, Email: email, Role: models.RoleMember,
+			TokenHash: []byte(gofakeit.UUID()), InvitedBy: by, CreatedAt: time.Now(),
+			ExpiresAt: time.Now().Add(time.Hour)}
+		id, err := s.SaveInvitation(ctx, inv)
+		require.NoError(t, err)
+		inv.ID = id
+
+		return inv
+	}
+
+	require.NoError(t, s.AcceptInvitation(ctx, invite(sharedID, otherID, email), userID))
+	invite(soloID, userID, gofakeit.Email())
+	invite(sharedID, otherID, email)
+
+	require.NoError(t, s.SaveAuditEvent(ctx, models.AuditEvent{CreatedAt: time.Now(),
+		Action: models.AuditUserLogin, UserID: userID}))
+
+	require.NoError(t, s.EraseUser(ctx, userID, "erased"))
+
+	db, err := sql.Open("sqlite3", path)
+	require.NoError(t, err)
+	defer db.Close()
+
+	count := func(query string, args ...any) int {
+		var n int
+		require.NoError(t, db.QueryRow(query, args...).Scan(&n))
+
+		return n
+	}
+
+	for _, table := range []string{"identities", "api_keys", "auth_codes", "org_members"} {
+		assert.Zero(t, count("SELECT COUNT(*) FROM "+table+" WHERE user_id = ?", userID), table)
+	}
+	assert.Zero(t, count("SELECT COUNT(*) FROM users WHERE id = ?", userID))
+	assert.Zero(t, count("SELECT COUNT(*) FROM org_invitations"))
+	assert.Zero(t, count("SELECT COUNT(*) FROM organizations WHERE id = ?", soloID))
+	assert.Equal(t, 1, count("SELECT COUNT(*) FROM org_members WHERE org_id = ?", sharedID))
+	assert.Equal(t, 1, count("SELECT COUNT(*) FROM audit_events WHERE user_id IS NULL AND "+
+		"details ->> '$.user' = 'erased'"))
+
+	rows, err := db.Query("PRAGMA foreign_key_check")
+	require.NoError(t, err)
+	defer rows.Close()
+	assert.False(t, rows.Next(), "foreign keys are violated")
+}
@@ -7,16 +7,25 @@ import (
 	"testing"
 
 	"xauth/internal/config"
+	accountgrpc "xauth/internal/grpc/account"
+	admingrpc "xauth/internal/grpc/admin"
 
 	ssov1 "github.com/memxire/protobuf/gen/go/sso"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 )
 
 type Suite struct {
 	*testing.T
-	Cfg        *config.Config
-	AuthClient ssov1.AuthClient
+	Cfg           *config.Config
+	AuthClient    ssov1.AuthClient
+	AdminClient   *admingrpc.Client
+	AccountClient *accountgrpc.Client
+	HealthClient  healthpb.HealthClient
+	// HTTPURL is the base URL of the HTTP gateway.
+	HTTPURL string
 }
 
 const (
@@ -36,20 +45,43 @@ func New(t *testing.T) (context.Context, *Suite) {
 		cancelCtx()
 	})
 
+	creds := insecure.NewCredentials()
+	if cfg.GRPC.TLS.Enabled {
+		tlsCreds, err := credentials.NewClientTLSFromFile(cfg.GRPC.TLS.CertFile, "")
+		if err != nil {
+			t.Fatalf("failed to load server certificate: %v", err)
+		}
+
+		creds = tlsCreds
+	}
+
 	cc, err := grpc.DialContext(context.Background(),
 		grpcAddress(cfg),
-		grpc.WithTransportCredentials(insecure.NewCredentials()))
+		grpc.WithTransportCredentials(creds))
 	if err != nil {
 		t.Fatalf("grpc server connection failed: %v", err)
 	}
 
 	return ctx, &Suite{
-		T:          t,
-		Cfg:        cfg,
-		AuthClient: ssov1.NewAuthClient(cc),
+		T:             t,
+		Cfg:           cfg,
+		AuthClient:    ssov1.NewAuthClient(cc),
+		AdminClient:   admingrpc.NewClient(cc),
+		AccountClient: accountgrpc.NewClient(cc),
+		HealthClient:  healthpb.NewHealthClient(cc),
+		HTTPURL:       httpURL(cfg),
 	}
 }
 
 func grpcAddress(cfg *config.Config) string {
 	return net.JoinHostPort(grpcHost, strconv.Itoa(cfg.GRPC.Port))
 }
+
+func httpURL(cfg *config.Config) string {
+	scheme := "http"
+	if cfg.HTTP.TLS {
+		scheme = "https"
+	}
+
+	return scheme + "://" + net.JoinHostPort(grpcHost, strconv.Itoa(cfg.HTTP.Port))
+}
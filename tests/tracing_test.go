@@ -0,0 +1,144 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	grpcapp "xauth/internal/app/grpc"
+	"xauth/internal/config"
+	"xauth/internal/lib/tracing"
+
+	"github.com/brianvoe/gofakeit/v6"
+	ssov1 "github.com/memxire/protobuf/gen/go/sso"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+)
+
+// TestTracing_TraceContext calls Register with W3C trace-context metadata
+// and checks, in the file exporter's output, that the spans of the RPC, the
+// service and the storage join the caller's trace, each a child of the one
+// before.
+func TestTracing_TraceContext(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "traces.json")
+
+	shutdown, err := tracing.New(ctx, config.TracingConfig{
+		Enabled:     true,
+		ServiceName: "xauth",
+		Exporter:    "file",
+		FilePath:    path,
+		SampleRatio: 1,
+	})
+	require.NoError(t, err)
+
+	storage, authService, oauthService, _ := newOffline(t)
+
+	port := freePort(t)
+	app := grpcapp.New(discardLog(), authService, nil, nil, oauthService, storage,
+		readyChecker{}, port, time.Hour, nil, 0, nil)
+	go func() { _ = app.Run() }()
+	t.Cleanup(app.Stop)
+
+	conn, err := grpc.NewClient(net.JoinHostPort("localhost", strconv.Itoa(port)),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	const traceID, parentID = "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7"
+
+	callCtx := metadata.AppendToOutgoingContext(ctx,
+		"traceparent", "00-"+traceID+"-"+parentID+"-01")
+
+	_, err = ssov1.NewAuthClient(conn).Register(callCtx, &ssov1.RegisterRequest{
+		Email:    gofakeit.Email(),
+		Password: randomFakePassword(),
+		Username: gofakeit.Username(),
+	}, grpc.WaitForReady(true))
+	require.NoError(t, err)
+
+	// Shutting down flushes the batched spans to the file.
+	require.NoError(t, shutdown(ctx))
+
+	spans := readSpans(t, path)
+
+	rpc := spans[ssov1.Auth_Register_FullMethodName]
+	require.NotNil(t, rpc)
+	assert.Equal(t, traceID, rpc.SpanContext.TraceID)
+	assert.Equal(t, parentID, rpc.Parent.SpanID)
+	assert.True(t, rpc.Parent.Remote)
+
+	parent := rpc
+	for _, name := range []string{"auth.RegisterNewUser", "storage.sqlite.SaveUser"} {
+		span := spans[name]
+		require.NotNil(t, span, name)
+		assert.Equal(t, traceID, span.SpanContext.TraceID, name)
+		assert.Equal(t, parent.SpanContext.SpanID, span.Parent.SpanID, name)
+
+		parent = span
+	}
+}
+
+// exportedSpan is the part of a span, as written by the stdout and file
+// exporters, that tells where it is in its trace.
+type exportedSpan struct {
+	Name        string
+	SpanContext spanContext
+	Parent      spanContext
+}
+
+type spanContext struct {
+	TraceID string
+	SpanID  string
+	Remote  bool
+}
+
+// readSpans reads the spans in the file exporter's output at path, by name.
+func readSpans(t *testing.T, path string) map[string]*exportedSpan {
+	t.Helper()
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	spans := make(map[string]*exportedSpan)
+
+	for dec := json.NewDecoder(f); ; {
+		var span exportedSpan
+		err := dec.Decode(&span)
+		if errors.Is(err, io.EOF) {
+			return spans
+		}
+		require.NoError(t, err)
+
+		spans[span.Name] = &span
+	}
+}
+
+// readyChecker reports a server as always ready.
+type readyChecker struct{}
+
+func (readyChecker) Ready(context.Context) error {
+	return nil
+}
+
+// freePort returns a TCP port that was free a moment ago.
+func freePort(t *testing.T) int {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	return l.Addr().(*net.TCPAddr).Port
+}